@@ -0,0 +1,92 @@
+package kvstore
+
+import (
+	"errors"
+	"net"
+	"sort"
+)
+
+// AccessRole is the set of commands a client IP is allowed to send
+type AccessRole int
+
+const (
+	// AccessRoleNone denies all commands
+	AccessRoleNone AccessRole = iota
+	// AccessRoleReadOnly allows LGET, LGETW, DEBUG and STATS
+	AccessRoleReadOnly
+	// AccessRoleReadWrite allows all commands
+	AccessRoleReadWrite
+)
+
+// errPermissionDenied is replied for each command that is not allowed by the role of the client IP
+var errPermissionDenied = errors.New("permission denied")
+
+type cidrAccessRule struct {
+	network *net.IPNet
+	role    AccessRole
+}
+
+// accessControl classifies client IPs into access roles by CIDR rules
+type accessControl struct {
+	rules       []cidrAccessRule // sorted by prefix length, the most specific first
+	defaultRole AccessRole
+	enabled     bool
+}
+
+func newAccessControl(rules map[string]AccessRole, defaultRole AccessRole) (*accessControl, error) {
+	acl := &accessControl{
+		defaultRole: defaultRole,
+		enabled:     len(rules) > 0 || defaultRole != AccessRoleReadWrite,
+	}
+
+	for cidr, role := range rules {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		acl.rules = append(acl.rules, cidrAccessRule{network: network, role: role})
+	}
+	sort.Slice(acl.rules, func(i, j int) bool {
+		a, _ := acl.rules[i].network.Mask.Size()
+		b, _ := acl.rules[j].network.Mask.Size()
+		return a > b
+	})
+	return acl, nil
+}
+
+// roleOf returns the role of the client, clients of Unix sockets are not restricted.
+// TCP clients of IPv6 are denied, since the rules only match IPv4 addresses
+func (acl *accessControl) roleOf(addr ClientAddr) AccessRole {
+	if !acl.enabled || addr.Unix {
+		return AccessRoleReadWrite
+	}
+	if !addr.hasIPv4() {
+		return AccessRoleNone
+	}
+
+	ip := net.IPv4(addr.IP[0], addr.IP[1], addr.IP[2], addr.IP[3])
+	for _, rule := range acl.rules {
+		if rule.network.Contains(ip) {
+			return rule.role
+		}
+	}
+	return acl.defaultRole
+}
+
+// clientPolicies are the rate limits and the access rules of client IPs
+type clientPolicies struct {
+	limiter *rateLimiter
+	acl     *accessControl
+}
+
+func newClientPolicies(opts kvstoreOptions) (*clientPolicies, error) {
+	limiter, err := newRateLimiter(opts.rateLimit, opts.cidrRateLimits)
+	if err != nil {
+		return nil, err
+	}
+	acl, err := newAccessControl(opts.accessRules, opts.defaultAccessRole)
+	if err != nil {
+		return nil, err
+	}
+	return &clientPolicies{limiter: limiter, acl: acl}, nil
+}
@@ -0,0 +1,45 @@
+package kvstore
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestAccessControl_RoleOf(t *testing.T) {
+	acl, err := newAccessControl(map[string]AccessRole{
+		"10.0.0.0/8":     AccessRoleReadOnly,
+		"10.20.0.0/16":   AccessRoleReadWrite,
+		"10.20.30.40/32": AccessRoleNone,
+	}, AccessRoleNone)
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, AccessRoleNone, acl.roleOf(NewUDPClientAddr(newIPAddr(192, 168, 1, 10), 7000)))
+	assert.Equal(t, AccessRoleReadOnly, acl.roleOf(NewUDPClientAddr(newIPAddr(10, 1, 2, 3), 7000)))
+	assert.Equal(t, AccessRoleReadWrite, acl.roleOf(NewUDPClientAddr(newIPAddr(10, 20, 2, 3), 7000)))
+	assert.Equal(t, AccessRoleNone, acl.roleOf(NewUDPClientAddr(newIPAddr(10, 20, 30, 40), 7000)))
+
+	tcpAddr := ClientAddr{Transport: TransportStream, IP: newIPAddr(10, 1, 2, 3), ID: 1}
+	assert.Equal(t, AccessRoleReadOnly, acl.roleOf(tcpAddr))
+
+	unixAddr := newStreamClientAddr(&net.UnixAddr{Name: "/tmp/kvstore.sock", Net: "unix"}, 2)
+	assert.Equal(t, ClientAddr{Transport: TransportStream, ID: 2, Unix: true}, unixAddr)
+	assert.Equal(t, AccessRoleReadWrite, acl.roleOf(unixAddr))
+
+	mappedAddr := newStreamClientAddr(&net.TCPAddr{IP: net.ParseIP("::ffff:10.1.2.3"), Port: 7000}, 3)
+	assert.Equal(t, ClientAddr{Transport: TransportStream, IP: newIPAddr(10, 1, 2, 3), ID: 3}, mappedAddr)
+	assert.Equal(t, AccessRoleReadOnly, acl.roleOf(mappedAddr))
+
+	ipv6Addr := newStreamClientAddr(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 7000}, 4)
+	assert.Equal(t, ClientAddr{Transport: TransportStream, ID: 4}, ipv6Addr)
+	assert.Equal(t, AccessRoleNone, acl.roleOf(ipv6Addr))
+}
+
+func TestAccessControl_Disabled(t *testing.T) {
+	acl, err := newAccessControl(nil, AccessRoleReadWrite)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, AccessRoleReadWrite, acl.roleOf(NewUDPClientAddr(newIPAddr(192, 168, 1, 10), 7000)))
+
+	_, err = newAccessControl(map[string]AccessRole{"10.0.0.1": AccessRoleReadOnly}, AccessRoleReadWrite)
+	assert.NotEqual(t, nil, err)
+}
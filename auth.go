@@ -0,0 +1,100 @@
+package kvstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"sync"
+)
+
+const frameKeyIDSize = 4
+const frameTagSize = sha256.Size
+const frameAuthSize = frameKeyIDSize + frameTagSize
+
+// frameKeys contains the keys for authenticating and encrypting frames,
+// shared by the server, the receiver and the processors
+type frameKeys struct {
+	auth       authKeyring
+	encryption encryptionKeyring
+}
+
+func newFrameKeys(opts kvstoreOptions) (*frameKeys, error) {
+	keys := &frameKeys{}
+	keys.auth.setKeys(opts.authKeys)
+	err := keys.encryption.setKeys(opts.encryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// authKeyring contains the active keys for verifying frames, more than one key
+// can be active at the same time for rotating keys without downtime
+type authKeyring struct {
+	mut  sync.RWMutex
+	keys map[uint32][]byte
+}
+
+func newAuthKeyring(keys map[uint32][]byte) *authKeyring {
+	r := &authKeyring{}
+	r.setKeys(keys)
+	return r
+}
+
+func (r *authKeyring) setKeys(keys map[uint32][]byte) {
+	copied := make(map[uint32][]byte, len(keys))
+	for id, key := range keys {
+		copied[id] = cloneBytes(key)
+	}
+
+	r.mut.Lock()
+	r.keys = copied
+	r.mut.Unlock()
+}
+
+// enabled returns true if frames must be authenticated
+func (r *authKeyring) enabled() bool {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return len(r.keys) > 0
+}
+
+// verify returns true if the frame is signed by one of the active keys
+func (r *authKeyring) verify(frame []byte, header dataFrameHeader) bool {
+	if !header.auth {
+		return false
+	}
+
+	r.mut.RLock()
+	key, ok := r.keys[header.keyID]
+	r.mut.RUnlock()
+	if !ok {
+		return false
+	}
+	return hmac.Equal(computeFrameTag(frame, header, key), header.tag)
+}
+
+// computeFrameTag returns the HMAC-SHA256 of the frame, excluding the frame checksum
+// and the tag fields, since the frame checksum is computed after signing
+func computeFrameTag(frame []byte, header dataFrameHeader, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+
+	offset := 0
+	if header.checksum {
+		offset = frameChecksumOffset(header)
+		mac.Write(frame[:offset])
+		offset += frameChecksumSize
+	}
+
+	tagOffset := frameAuthOffset(header) + frameKeyIDSize
+	mac.Write(frame[offset:tagOffset])
+	mac.Write(frame[tagOffset+frameTagSize:])
+	return mac.Sum(nil)
+}
+
+func signFrame(frame []byte, header dataFrameHeader) {
+	if !header.auth || header.version == frameVersion0 {
+		return
+	}
+	tagOffset := frameAuthOffset(header) + frameKeyIDSize
+	copy(frame[tagOffset:], computeFrameTag(frame, header, header.authKey))
+}
@@ -0,0 +1,96 @@
+package kvstore
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func buildSignedFrame(header dataFrameHeader, data string) []byte {
+	var result []byte
+	_ = buildDataFrames(make([]byte, 1000), header, []byte(data), func(frame []byte) {
+		result = cloneBytes(frame)
+	})
+	return result
+}
+
+func TestAuthKeyring_Verify(t *testing.T) {
+	keyring := newAuthKeyring(map[uint32][]byte{
+		1: []byte("key-01"),
+		2: []byte("key-02"),
+	})
+	assert.Equal(t, true, keyring.enabled())
+
+	for _, checksum := range []bool{false, true} {
+		frame := buildSignedFrame(dataFrameHeader{
+			version:  frameVersion1,
+			batchID:  10,
+			checksum: checksum,
+			auth:     true,
+			keyID:    2,
+			authKey:  []byte("key-02"),
+		}, "some-data")
+
+		header, offset := parseDataFrameHeader(frame)
+		assert.Equal(t, uint32(2), header.keyID)
+		assert.Equal(t, frameTagSize, len(header.tag))
+		assert.Equal(t, "some-data", string(frame[offset:]))
+		assert.Equal(t, true, validFrameChecksum(frame, header))
+		assert.Equal(t, true, keyring.verify(frame, header))
+
+		frame[len(frame)-1] ^= 0x01
+		assert.Equal(t, false, keyring.verify(frame, header))
+	}
+}
+
+func TestAuthKeyring_Verify_Wrong_Key(t *testing.T) {
+	keyring := newAuthKeyring(map[uint32][]byte{
+		1: []byte("key-01"),
+	})
+
+	frame := buildSignedFrame(dataFrameHeader{
+		version: frameVersion1,
+		batchID: 10,
+		auth:    true,
+		keyID:   1,
+		authKey: []byte("another-key"),
+	}, "some-data")
+	header, _ := parseDataFrameHeader(frame)
+	assert.Equal(t, false, keyring.verify(frame, header))
+
+	frame = buildSignedFrame(dataFrameHeader{
+		version: frameVersion1,
+		batchID: 10,
+		auth:    true,
+		keyID:   3,
+		authKey: []byte("key-01"),
+	}, "some-data")
+	header, _ = parseDataFrameHeader(frame)
+	assert.Equal(t, false, keyring.verify(frame, header))
+
+	frame = buildSignedFrame(dataFrameHeader{version: frameVersion1, batchID: 10}, "some-data")
+	header, _ = parseDataFrameHeader(frame)
+	assert.Equal(t, false, keyring.verify(frame, header))
+}
+
+func TestAuthKeyring_Rotation(t *testing.T) {
+	keyring := newAuthKeyring(nil)
+	assert.Equal(t, false, keyring.enabled())
+
+	oldFrame := buildSignedFrame(dataFrameHeader{
+		version: frameVersion1, auth: true, keyID: 1, authKey: []byte("key-01"),
+	}, "data")
+	oldHeader, _ := parseDataFrameHeader(oldFrame)
+
+	newFrame := buildSignedFrame(dataFrameHeader{
+		version: frameVersion1, auth: true, keyID: 2, authKey: []byte("key-02"),
+	}, "data")
+	newHeader, _ := parseDataFrameHeader(newFrame)
+
+	keyring.setKeys(map[uint32][]byte{1: []byte("key-01"), 2: []byte("key-02")})
+	assert.Equal(t, true, keyring.verify(oldFrame, oldHeader))
+	assert.Equal(t, true, keyring.verify(newFrame, newHeader))
+
+	keyring.setKeys(map[uint32][]byte{2: []byte("key-02")})
+	assert.Equal(t, false, keyring.verify(oldFrame, oldHeader))
+	assert.Equal(t, true, keyring.verify(newFrame, newHeader))
+}
@@ -1,9 +1,12 @@
 package bigcmd
 
 import (
+	"hash/crc32"
 	"unsafe"
 )
 
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
 // Store ...
 type Store struct {
 	batches map[uint64]batchInfo
@@ -12,12 +15,31 @@ type Store struct {
 
 	first int
 	size  int
+
+	stats Stats
+}
+
+// Stats is the statistics of a Store
+type Stats struct {
+	// Fragments is the number of fragments put to the store
+	Fragments uint64
+	// Completed is the number of batches that are completely reassembled
+	Completed uint64
+	// Evicted is the number of incomplete batches evicted to reclaim space
+	Evicted uint64
+	// Invalid is the number of fragments rejected because of inconsistent length or offset
+	Invalid uint64
+	// ChecksumFailures is the number of reassembled batches dropped because of checksum mismatch
+	ChecksumFailures uint64
 }
 
 type batchInfo struct {
 	index     int
 	length    uint32
 	collected uint32
+
+	checked  bool
+	checksum uint32
 }
 
 type batchHeader struct {
@@ -72,6 +94,9 @@ func (s *Store) deleteLeastRecent(length uint32) {
 		s.readAt(batchHeaderData[:], s.first)
 		header := (*batchHeader)(unsafe.Pointer(&batchHeaderData[0]))
 		info := s.batches[header.batchID]
+		if info.collected < info.length {
+			s.stats.Evicted++
+		}
 		delete(s.batches, header.batchID)
 		s.reclaim(batchHeaderSize + int(info.length))
 	}
@@ -81,7 +106,25 @@ func (s *Store) deleteLeastRecent(length uint32) {
 func (s *Store) Put(
 	batchID uint64, length uint32, offset uint32, data []byte,
 ) bool {
+	return s.putFragment(batchID, length, offset, data, false, 0)
+}
+
+// PutChecked is the same as Put, but the reassembled batch is dropped
+// if its CRC32C (Castagnoli) is not equal to checksum
+func (s *Store) PutChecked(
+	batchID uint64, length uint32, offset uint32, checksum uint32, data []byte,
+) bool {
+	return s.putFragment(batchID, length, offset, data, true, checksum)
+}
+
+//revive:disable-next-line:flag-parameter
+func (s *Store) putFragment(
+	batchID uint64, length uint32, offset uint32, data []byte,
+	checked bool, checksum uint32,
+) bool {
+	s.stats.Fragments++
 	if offset+uint32(len(data)) > length {
+		s.stats.Invalid++
 		delete(s.batches, batchID)
 		return false
 	}
@@ -94,6 +137,8 @@ func (s *Store) Put(
 			index:     s.first,
 			length:    length,
 			collected: 0,
+			checked:   checked,
+			checksum:  checksum,
 		}
 
 		var batchHeaderData [batchHeaderSize]byte
@@ -102,7 +147,8 @@ func (s *Store) Put(
 		s.writeAt(info.index, batchHeaderData[:])
 
 		s.size += batchHeaderSize + int(length)
-	} else if info.length != length {
+	} else if info.length != length || info.checked != checked || info.checksum != checksum {
+		s.stats.Invalid++
 		delete(s.batches, batchID)
 		return false
 	}
@@ -112,7 +158,21 @@ func (s *Store) Put(
 	info.collected += uint32(len(data))
 	s.batches[batchID] = info
 
-	return info.collected == info.length
+	if info.collected != info.length {
+		return false
+	}
+	if info.checked && crc32.Checksum(s.Get(batchID), castagnoliTable) != info.checksum {
+		s.stats.ChecksumFailures++
+		delete(s.batches, batchID)
+		return false
+	}
+	s.stats.Completed++
+	return true
+}
+
+// GetStats returns the statistics of the store
+func (s *Store) GetStats() Stats {
+	return s.stats
 }
 
 // Get ...
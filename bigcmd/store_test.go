@@ -2,6 +2,7 @@ package bigcmd
 
 import (
 	"github.com/stretchr/testify/assert"
+	"hash/crc32"
 	"strings"
 	"testing"
 )
@@ -105,3 +106,39 @@ func TestStore_Put_Wrap_Around(t *testing.T) {
 	data = s.Get(51)
 	assert.Equal(t, []byte(strings.Repeat("C", 7)+strings.Repeat("D", 6)), data)
 }
+
+func TestStore_GetStats(t *testing.T) {
+	s := newStore(28)
+
+	data := []byte(strings.Repeat("A", 10))
+	s.Put(10, 20, 0, data)
+	s.Put(10, 20, 10, data)
+	assert.Equal(t, Stats{Fragments: 2, Completed: 1}, s.GetStats())
+
+	s.Put(11, 20, 15, data)
+	assert.Equal(t, Stats{Fragments: 3, Completed: 1, Invalid: 1}, s.GetStats())
+
+	s.Put(12, 20, 0, data)
+	s.Put(13, 20, 0, data)
+	assert.Equal(t, Stats{Fragments: 5, Completed: 1, Evicted: 1, Invalid: 1}, s.GetStats())
+}
+
+func TestStore_PutChecked(t *testing.T) {
+	s := newStore(100)
+
+	data := []byte(strings.Repeat("A", 10) + strings.Repeat("B", 10))
+	checksum := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+
+	filled := s.PutChecked(10, 20, 0, checksum, data[:10])
+	assert.Equal(t, false, filled)
+	filled = s.PutChecked(10, 20, 10, checksum, data[10:])
+	assert.Equal(t, true, filled)
+	assert.Equal(t, data, s.Get(10))
+
+	s.PutChecked(11, 20, 0, checksum, data[:10])
+	filled = s.PutChecked(11, 20, 10, checksum, []byte(strings.Repeat("C", 10)))
+	assert.Equal(t, false, filled)
+	assert.Equal(t, []byte(nil), s.Get(11))
+
+	assert.Equal(t, Stats{Fragments: 4, Completed: 1, ChecksumFailures: 1}, s.GetStats())
+}
@@ -1,23 +1,151 @@
 package kvstore
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/cipher"
+	"errors"
 	"fmt"
+	"github.com/QuangTung97/kvstore/bigcmd"
+	"github.com/QuangTung97/kvstore/lease"
+	"github.com/QuangTung97/kvstore/parser"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const clientMaxPackageSize = 1 << 15 // 32KB
+const clientDefaultTimeout = 5 * time.Second
+
+// ErrInvalidResponse ...
+var ErrInvalidResponse = errors.New("invalid response")
+
+// ErrFrameRejected is returned when the server replies an error frame, e.g. for an unsupported frame version
+var ErrFrameRejected = errors.New("frame rejected by server")
+
 // Client ...
 type Client struct {
-	conn *net.UDPConn
+	conn net.Conn
+
+	// reader is only used by the stream transports, in which frames are prefixed by length
+	reader     *bufio.Reader
+	streamData []byte
+
+	// localPath is the socket path of a Unix datagram client, removed on shutdown
+	localPath string
+
+	binaryCommands bool
+	checksums      bool
+	authKeyID      uint32
+	authKey        []byte
+
+	encryptionKeyID uint32
+	aead            cipher.AEAD
+
+	checksumFailures uint64 // accessed atomically
+
+	mut           sync.Mutex
+	nextRequestID uint64
+	nextBatchID   uint64
+
+	sendFrame []byte
+	recvFrame []byte
+	store     bigcmd.Store
 }
 
 // Pipeline ...
 type Pipeline struct {
-	conn *net.UDPConn
+	client *Client
+	ctx    context.Context
+
+	data      []byte
+	pending   []uint64
+	responses map[uint64][]byte
+	err       error
+}
+
+// LGetResult is the result of LGET command
+type LGetResult struct {
+	Status  lease.GetStatus
+	LeaseID uint64
+	Value   []byte
+}
+
+type clientOptions struct {
+	binaryCommands bool
+	checksums      bool
+	authKeyID      uint32
+	authKey        []byte
+
+	encryptionKeyID uint32
+	encryptionKey   []byte
+	aead            cipher.AEAD
+}
+
+// ClientOption ...
+type ClientOption func(opts *clientOptions)
+
+// WithBinaryCommands sends the commands in the binary encoding instead of the text grammar,
+// the responses are unchanged. Keys longer than parser.MaxBinaryKeyLength fail with parser.ErrKeyTooLong
+func WithBinaryCommands() ClientOption {
+	return func(opts *clientOptions) {
+		opts.binaryCommands = true
+	}
+}
+
+// WithChecksums adds CRC32C checksums to the frames sent by the client,
+// the server then adds checksums to its responses
+func WithChecksums() ClientOption {
+	return func(opts *clientOptions) {
+		opts.checksums = true
+	}
+}
+
+// WithAuthKey signs the frames sent by the client with HMAC-SHA256 using the key
+func WithAuthKey(keyID uint32, key []byte) ClientOption {
+	return func(opts *clientOptions) {
+		opts.authKeyID = keyID
+		opts.authKey = key
+	}
+}
+
+// WithEncryptionKey encrypts the frames sent by the client with AES-GCM using the key,
+// which must be 16, 24 or 32 bytes, the server then encrypts its responses with the same key
+func WithEncryptionKey(keyID uint32, key []byte) ClientOption {
+	return func(opts *clientOptions) {
+		opts.encryptionKeyID = keyID
+		opts.encryptionKey = key
+	}
+}
+
+func computeClientOptions(options ...ClientOption) (clientOptions, error) {
+	opts := clientOptions{}
+	for _, o := range options {
+		o(&opts)
+	}
+
+	if opts.encryptionKey != nil {
+		aead, err := newFrameAEAD(opts.encryptionKey)
+		if err != nil {
+			return clientOptions{}, err
+		}
+		opts.aead = aead
+	}
+	return opts, nil
 }
 
 // NewClient ...
-func NewClient(addr string) (*Client, error) {
+func NewClient(addr string, options ...ClientOption) (*Client, error) {
+	opts, err := computeClientOptions(options...)
+	if err != nil {
+		return nil, err
+	}
+
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return nil, err
@@ -26,27 +154,617 @@ func NewClient(addr string) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Client{
-		conn: conn,
-	}, nil
+	return newClientWithConn(conn, opts), nil
 }
 
-// Pipelined ...
-func (c *Client) Pipelined(_ context.Context, fn func(pipeline *Pipeline) error) error {
-	return fn(&Pipeline{conn: c.conn})
+// NewTCPClient creates a client using the TCP transport of the native protocol
+func NewTCPClient(addr string, options ...ClientOption) (*Client, error) {
+	return newStreamClient("tcp", addr, options...)
+}
+
+// NewUnixClient creates a client connecting to the Unix stream socket of the server
+func NewUnixClient(path string, options ...ClientOption) (*Client, error) {
+	return newStreamClient("unix", path, options...)
+}
+
+func newStreamClient(network string, addr string, options ...ClientOption) (*Client, error) {
+	opts, err := computeClientOptions(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newClientWithConn(conn, opts)
+	c.reader = bufio.NewReader(conn)
+	return c, nil
+}
+
+var unixgramClientSequence uint64
+
+// NewUnixgramClient creates a client sending to the Unix datagram socket of the server,
+// the client binds its socket to a temporary path for receiving the responses
+func NewUnixgramClient(path string, options ...ClientOption) (*Client, error) {
+	opts, err := computeClientOptions(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	seq := atomic.AddUint64(&unixgramClientSequence, 1)
+	localPath := filepath.Join(os.TempDir(), fmt.Sprintf("kvstore-client-%d-%d.sock", os.Getpid(), seq))
+
+	conn, err := net.DialUnix("unixgram",
+		&net.UnixAddr{Name: localPath, Net: "unixgram"},
+		&net.UnixAddr{Name: path, Net: "unixgram"},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newClientWithConn(conn, opts)
+	c.localPath = localPath
+	return c, nil
+}
+
+func newClientWithConn(conn net.Conn, opts clientOptions) *Client {
+	c := &Client{
+		conn:           conn,
+		binaryCommands: opts.binaryCommands,
+		checksums:      opts.checksums,
+		authKeyID:      opts.authKeyID,
+		authKey:        opts.authKey,
+
+		encryptionKeyID: opts.encryptionKeyID,
+		aead:            opts.aead,
+		sendFrame:       make([]byte, clientMaxPackageSize),
+		recvFrame:       make([]byte, 1<<16),
+	}
+	bigcmd.InitStore(&c.store, 8<<20, 1<<20)
+	return c
+}
+
+// Pipelined executes all commands issued inside fn in batches
+func (c *Client) Pipelined(ctx context.Context, fn func(pipeline *Pipeline) error) error {
+	p := &Pipeline{
+		client:    c,
+		ctx:       ctx,
+		responses: map[uint64][]byte{},
+	}
+	err := fn(p)
+	if err != nil {
+		return err
+	}
+	p.execute()
+	return p.err
 }
 
 // Shutdown ...
 func (c *Client) Shutdown() error {
-	return c.conn.Close()
+	err := c.conn.Close()
+	if c.localPath != "" {
+		_ = os.Remove(c.localPath)
+	}
+	return err
+}
+
+func (p *Pipeline) appendCommand(cmd []byte) uint64 {
+	p.client.mut.Lock()
+	p.client.nextRequestID++
+	requestID := p.client.nextRequestID
+	p.client.mut.Unlock()
+
+	offset := len(p.data)
+	p.data = append(p.data, make([]byte, entryDataOffset)...)
+	buildDataFrameEntryHeader(p.data[offset:], requestID, len(cmd))
+	p.data = append(p.data, cmd...)
+
+	p.pending = append(p.pending, requestID)
+	return requestID
+}
+
+func (p *Pipeline) execute() {
+	if len(p.pending) == 0 {
+		return
+	}
+	if p.err == nil {
+		p.err = p.client.roundTrip(p.ctx, p.data, p.pending, p.responses)
+	}
+	p.data = p.data[:0]
+	p.pending = p.pending[:0]
+}
+
+func (p *Pipeline) getResponse(requestID uint64) ([]byte, error) {
+	p.execute()
+	if p.err != nil {
+		return nil, p.err
+	}
+	resp, ok := p.responses[requestID]
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+	return resp, nil
+}
+
+func (c *Client) roundTrip(
+	ctx context.Context, data []byte,
+	requestIDs []uint64, responses map[uint64][]byte,
+) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(clientDefaultTimeout)
+	}
+	err := c.conn.SetReadDeadline(deadline)
+	if err != nil {
+		return err
+	}
+
+	c.nextBatchID++
+	header := dataFrameHeader{
+		version:  frameVersionLatest,
+		batchID:  c.nextBatchID,
+		binary:   c.binaryCommands,
+		checksum: c.checksums,
+		auth:     c.authKey != nil,
+		keyID:    c.authKeyID,
+		authKey:  c.authKey,
+
+		encrypted:       c.aead != nil,
+		encryptionKeyID: c.encryptionKeyID,
+		aead:            c.aead,
+	}
+	buildErr := buildDataFrames(c.sendFrame, header, data, func(frame []byte) {
+		if err != nil {
+			return
+		}
+		err = c.writeFrame(frame)
+	})
+	if buildErr != nil {
+		return buildErr
+	}
+	if err != nil {
+		return err
+	}
+
+	waiting := make(map[uint64]struct{}, len(requestIDs))
+	for _, id := range requestIDs {
+		waiting[id] = struct{}{}
+	}
+
+	for len(waiting) > 0 {
+		entries, err := c.readEntries()
+		if err != nil {
+			return err
+		}
+		collectResponses(entries, waiting, responses)
+	}
+	return nil
+}
+
+func (c *Client) writeFrame(frame []byte) error {
+	if c.reader == nil {
+		_, err := c.conn.Write(frame)
+		return err
+	}
+
+	c.streamData = appendStreamFrame(c.streamData[:0], frame)
+	_, err := c.conn.Write(c.streamData)
+	return err
+}
+
+func (c *Client) readFrame() ([]byte, error) {
+	if c.reader != nil {
+		return readStreamFrame(c.reader, c.recvFrame)
+	}
+
+	n, err := c.conn.Read(c.recvFrame)
+	if err != nil {
+		return nil, err
+	}
+	return c.recvFrame[:n], nil
+}
+
+func (c *Client) readEntries() ([]byte, error) {
+	for {
+		frame, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		data, completed, err := c.handleFrame(frame)
+		if err != nil {
+			return nil, err
+		}
+		if completed {
+			return data, nil
+		}
+	}
+}
+
+// handleFrame returns the entry list of the batch if the frame completes a batch,
+// invalid frames are dropped
+func (c *Client) handleFrame(frame []byte) ([]byte, bool, error) {
+	header, offset := parseDataFrameHeader(frame)
+	if offset == 0 {
+		return nil, false, nil
+	}
+	if !validFrameChecksum(frame, header) {
+		atomic.AddUint64(&c.checksumFailures, 1)
+		return nil, false, nil
+	}
+	if header.rejected {
+		return nil, false, fmt.Errorf("%w: %s", ErrFrameRejected, frame[offset:])
+	}
+
+	data, err := c.framePayload(frame, header, offset)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	if !header.fragmented {
+		return data, true, nil
+	}
+	filled := c.putFragment(header, data)
+	if filled {
+		return c.store.Get(header.batchID), true, nil
+	}
+	return nil, false, nil
+}
+
+// framePayload returns the entry list of the frame, the responses must be encrypted
+// if the client is configured with WithEncryptionKey
+func (c *Client) framePayload(frame []byte, header dataFrameHeader, payloadOffset int) ([]byte, error) {
+	if c.aead == nil {
+		return frame[payloadOffset:], nil
+	}
+	if !header.encrypted || header.encryptionKeyID != c.encryptionKeyID {
+		return nil, errDecryptFailed
+	}
+	return decryptFramePayload(frame, header, payloadOffset, c.aead)
+}
+
+func (c *Client) putFragment(header dataFrameHeader, data []byte) bool {
+	if !header.checksum {
+		return c.store.Put(header.batchID, header.length, header.offset, data)
+	}
+
+	prev := c.store.GetStats().ChecksumFailures
+	filled := c.store.PutChecked(header.batchID, header.length, header.offset, header.batchChecksum, data)
+	if c.store.GetStats().ChecksumFailures != prev {
+		atomic.AddUint64(&c.checksumFailures, 1)
+	}
+	return filled
+}
+
+// ChecksumFailures returns the number of frames and reassembled batches
+// dropped by the client because of checksum mismatch
+func (c *Client) ChecksumFailures() uint64 {
+	return atomic.LoadUint64(&c.checksumFailures)
+}
+
+func collectResponses(
+	data []byte, waiting map[uint64]struct{}, responses map[uint64][]byte,
+) {
+	for len(data) > 0 {
+		requestID, content, nextOffset := parseDataFrameEntry(data)
+		if nextOffset == 0 {
+			return
+		}
+		data = data[nextOffset:]
+
+		_, ok := waiting[requestID]
+		if !ok {
+			continue
+		}
+		delete(waiting, requestID)
+		responses[requestID] = cloneBytes(content)
+	}
+}
+
+// LGet gets the value or a lease for the key
+func (p *Pipeline) LGet(key string) func() (LGetResult, error) {
+	cmd, err := p.buildCommand(parser.BinaryCommand{
+		Opcode: parser.BinaryOpcodeLGET,
+		Key:    []byte(key),
+	}, "LGET", key)
+	if err != nil {
+		return func() (LGetResult, error) {
+			return LGetResult{}, err
+		}
+	}
+
+	id := p.appendCommand(cmd)
+	return func() (LGetResult, error) {
+		resp, err := p.getResponse(id)
+		if err != nil {
+			return LGetResult{}, err
+		}
+		return parseLGetResponse(resp)
+	}
+}
+
+// LSet sets the value for the key if the lease is still valid
+func (p *Pipeline) LSet(key string, leaseID uint64, value []byte) func() (bool, error) {
+	if p.client.binaryCommands {
+		return p.affectedCommand(appendBinaryCommand(parser.BinaryCommand{
+			Opcode: parser.BinaryOpcodeLSET,
+			Key:    []byte(key),
+			Lease:  leaseID,
+			Value:  value,
+		}))
+	}
+
+	cmd := buildCommand("LSET", key, formatUint(leaseID), formatUint(uint64(len(value))))
+	cmd = append(cmd, value...)
+	cmd = append(cmd, crlfResponse...)
+	return p.affectedCommand(cmd, nil)
+}
+
+// LSetWithTags is the same as LSet, the value is invalidated when one of the tags is deleted by DelTag.
+// At most parser.MaxTags tags of at most parser.MaxTagLength bytes are allowed
+func (p *Pipeline) LSetWithTags(key string, leaseID uint64, value []byte, tags ...string) func() (bool, error) {
+	binaryTags := make([][]byte, 0, len(tags))
+	for _, tag := range tags {
+		binaryTags = append(binaryTags, []byte(tag))
+	}
+	if err := parser.CheckTags(binaryTags); err != nil {
+		return errorResult(err)
+	}
+
+	if p.client.binaryCommands {
+		return p.affectedCommand(appendBinaryCommand(parser.BinaryCommand{
+			Opcode: parser.BinaryOpcodeLSETTags,
+			Key:    []byte(key),
+			Lease:  leaseID,
+			Value:  value,
+			Tags:   binaryTags,
+		}))
+	}
+
+	args := append([]string{key, formatUint(leaseID), formatUint(uint64(len(value)))}, tags...)
+	cmd := buildCommand("LSET", args...)
+	cmd = append(cmd, value...)
+	cmd = append(cmd, crlfResponse...)
+	return p.affectedCommand(cmd, nil)
+}
+
+// DelTag invalidates all the values set with the tag and rejects the leases granted before it
+func (p *Pipeline) DelTag(tag string) func() (bool, error) {
+	if len(tag) > parser.MaxTagLength {
+		return errorResult(parser.ErrTagTooLong)
+	}
+	return p.affectedCommand(p.buildCommand(parser.BinaryCommand{
+		Opcode: parser.BinaryOpcodeDELTAG,
+		Key:    []byte(tag),
+	}, "DELTAG", tag))
 }
 
-// DoSomething ...
-func (p *Pipeline) DoSomething() {
-	size, err := p.conn.Write([]byte("Ta Quang Tung"))
-	fmt.Println("Pipeline Write", size, err)
+// Del invalidates the key
+func (p *Pipeline) Del(key string) func() (bool, error) {
+	return p.affectedCommand(p.buildCommand(parser.BinaryCommand{
+		Opcode: parser.BinaryOpcodeDEL,
+		Key:    []byte(key),
+	}, "DEL", key))
+}
+
+// LRelease gives back a lease without setting a value
+func (p *Pipeline) LRelease(key string, leaseID uint64) func() (bool, error) {
+	return p.affectedCommand(p.buildCommand(parser.BinaryCommand{
+		Opcode: parser.BinaryOpcodeLRELEASE,
+		Key:    []byte(key),
+		Lease:  leaseID,
+	}, "LRELEASE", key, formatUint(leaseID)))
+}
+
+// LExtend makes the lease expire after the duration, in second, from now,
+// not affected if the lease would expire earlier than before
+func (p *Pipeline) LExtend(key string, leaseID uint64, seconds uint32) func() (bool, error) {
+	return p.affectedCommand(p.buildCommand(parser.BinaryCommand{
+		Opcode: parser.BinaryOpcodeLEXTEND,
+		Key:    []byte(key),
+		Lease:  leaseID,
+		Number: seconds,
+	}, "LEXTEND", key, formatUint(leaseID), formatUint(uint64(seconds))))
+}
+
+// Flush removes all keys and leases of the namespace, returns false if the namespace does not exist
+func (p *Pipeline) Flush(namespace string) func() (bool, error) {
+	return p.affectedCommand(p.buildCommand(parser.BinaryCommand{
+		Opcode: parser.BinaryOpcodeFLUSH,
+		Key:    []byte(namespace),
+	}, "FLUSH", namespace))
+}
 
-	data := make([]byte, 1<<15)
-	size, err = p.conn.Read(data)
-	fmt.Println("Pipeline Read:", size, err, string(data[:size]))
+// Snapshot starts writing a snapshot of the server, returns false if a snapshot is being written
+func (p *Pipeline) Snapshot() func() (bool, error) {
+	return p.affectedCommand(p.buildCommand(parser.BinaryCommand{
+		Opcode: parser.BinaryOpcodeSNAPSHOT,
+	}, "SNAPSHOT"))
+}
+
+// buildCommand uses the binary command if the client is configured with WithBinaryCommands,
+// otherwise the text command built from name and args
+func (p *Pipeline) buildCommand(cmd parser.BinaryCommand, name string, args ...string) ([]byte, error) {
+	if p.client.binaryCommands {
+		return appendBinaryCommand(cmd)
+	}
+	return buildCommand(name, args...), nil
+}
+
+// appendBinaryCommand returns the binary encoding of the command, an error if the key is too long
+func appendBinaryCommand(cmd parser.BinaryCommand) ([]byte, error) {
+	if err := parser.CheckBinaryKey(cmd.Key); err != nil {
+		return nil, err
+	}
+	return parser.AppendBinaryCommand(nil, cmd), nil
+}
+
+// affectedCommand sends the command, or returns the error without sending if the command could not be built
+func (p *Pipeline) affectedCommand(cmd []byte, err error) func() (bool, error) {
+	if err != nil {
+		return errorResult(err)
+	}
+	id := p.appendCommand(cmd)
+	return func() (bool, error) {
+		resp, err := p.getResponse(id)
+		if err != nil {
+			return false, err
+		}
+		return parseAffectedResponse(resp)
+	}
+}
+
+// errorResult returns the result of a command not sent because of the error
+func errorResult(err error) func() (bool, error) {
+	return func() (bool, error) {
+		return false, err
+	}
+}
+
+func formatUint(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+func buildCommand(name string, args ...string) []byte {
+	cmd := []byte(name)
+	for _, arg := range args {
+		cmd = append(cmd, ' ')
+		cmd = append(cmd, arg...)
+	}
+	return append(cmd, crlfResponse...)
+}
+
+// splitResponseLine returns the fields of the first line and the remaining data
+func splitResponseLine(resp []byte) ([][]byte, []byte, error) {
+	index := bytes.Index(resp, crlfResponse)
+	if index < 0 {
+		return nil, nil, ErrInvalidResponse
+	}
+	return bytes.Fields(resp[:index]), resp[index+len(crlfResponse):], nil
+}
+
+func parseResponseUint(data []byte) (uint64, error) {
+	n, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidResponse
+	}
+	return n, nil
+}
+
+func checkErrorResponse(resp []byte) error {
+	if bytes.HasPrefix(resp, errorResponse) {
+		msg := bytes.TrimSuffix(resp[len(errorResponse):], crlfResponse)
+		return errors.New(string(msg))
+	}
+	return nil
+}
+
+func parseAffectedResponse(resp []byte) (bool, error) {
+	if err := checkErrorResponse(resp); err != nil {
+		return false, err
+	}
+
+	fields, _, err := splitResponseLine(resp)
+	if err != nil {
+		return false, err
+	}
+	if len(fields) != 2 || !bytes.Equal(fields[0], okResponse[:2]) {
+		return false, ErrInvalidResponse
+	}
+	n, err := parseResponseUint(fields[1])
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func parseResponseValue(data []byte, sizeField []byte) ([]byte, error) {
+	size, err := parseResponseUint(sizeField)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) < size+uint64(len(crlfResponse)) {
+		return nil, ErrInvalidResponse
+	}
+	return data[:size], nil
+}
+
+func parseLGetResponse(resp []byte) (LGetResult, error) {
+	if err := checkErrorResponse(resp); err != nil {
+		return LGetResult{}, err
+	}
+
+	fields, remaining, err := splitResponseLine(resp)
+	if err != nil {
+		return LGetResult{}, err
+	}
+	if len(fields) == 0 {
+		return LGetResult{}, ErrInvalidResponse
+	}
+
+	switch string(fields[0]) {
+	case "OK":
+		return parseFoundResponse(fields, remaining)
+
+	case "GRANTED":
+		return parseGrantedResponse(fields)
+
+	case "REJECTED":
+		return LGetResult{Status: lease.GetStatusLeaseRejected}, nil
+
+	case "STALE":
+		return parseStaleResponse(fields, remaining)
+
+	default:
+		return LGetResult{}, ErrInvalidResponse
+	}
+}
+
+func parseFoundResponse(fields [][]byte, remaining []byte) (LGetResult, error) {
+	if len(fields) != 2 {
+		return LGetResult{}, ErrInvalidResponse
+	}
+	value, err := parseResponseValue(remaining, fields[1])
+	if err != nil {
+		return LGetResult{}, err
+	}
+	return LGetResult{Status: lease.GetStatusFound, Value: value}, nil
+}
+
+func parseGrantedResponse(fields [][]byte) (LGetResult, error) {
+	if len(fields) != 2 {
+		return LGetResult{}, ErrInvalidResponse
+	}
+	leaseID, err := parseResponseUint(fields[1])
+	if err != nil {
+		return LGetResult{}, err
+	}
+	return LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: leaseID}, nil
+}
+
+func parseStaleResponse(fields [][]byte, remaining []byte) (LGetResult, error) {
+	if len(fields) != 3 {
+		return LGetResult{}, ErrInvalidResponse
+	}
+	value, err := parseResponseValue(remaining, fields[1])
+	if err != nil {
+		return LGetResult{}, err
+	}
+	leaseID, err := parseResponseUint(fields[2])
+	if err != nil {
+		return LGetResult{}, err
+	}
+	if leaseID == 0 {
+		return LGetResult{Status: lease.GetStatusStaleLeaseRejected, Value: value}, nil
+	}
+	return LGetResult{
+		Status:  lease.GetStatusStaleLeaseGranted,
+		LeaseID: leaseID,
+		Value:   value,
+	}, nil
 }
@@ -3,41 +3,570 @@ package kvstore
 import (
 	"context"
 	"fmt"
+	"github.com/QuangTung97/kvstore/lease"
+	"github.com/QuangTung97/kvstore/parser"
 	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 )
 
-func TestClient(t *testing.T) {
-	server := NewServer()
+// waitForListening waits until the server accepts connections on the stream listener
+func waitForListening(t *testing.T, network string, addr string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			_ = conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server is not listening on %s %s", network, addr)
+}
+
+// testServer is a server running until stop is called or the test ends
+type testServer struct {
+	*Server
+	t    *testing.T
+	wg   sync.WaitGroup
+	once sync.Once
+}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
+// startTestServer runs a server with the options and waits for its listeners,
+// the server is stopped at the end of the test
+func startTestServer(t *testing.T, options ...Option) *testServer {
+	s := &testServer{Server: NewServer(options...), t: t}
+	s.wg.Add(1)
 	go func() {
-		defer wg.Done()
+		defer s.wg.Done()
 
-		err := server.Run()
+		err := s.Run()
 		fmt.Println("RUN:", err)
 	}()
+	t.Cleanup(s.stop)
+
+	opts := computeOptions(options...)
+	for _, addr := range []string{opts.tcpAddress, opts.warmUpAddress} {
+		if addr != "" {
+			waitForListening(t, "tcp", addr)
+		}
+	}
+	if opts.unixAddress != "" {
+		waitForListening(t, "unix", opts.unixAddress)
+	}
+	s.waitForUDPListener()
+	return s
+}
+
+// waitForUDPListener waits until Run listens on the UDP address, after warming up
+func (s *testServer) waitForUDPListener() {
+	for i := 0; i < 500; i++ {
+		s.mut.Lock()
+		conn := s.conn
+		s.mut.Unlock()
+
+		if conn != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.t.Fatalf("server is not listening on %s", s.options.address)
+}
+
+// stop shuts down the server and waits for Run to return
+func (s *testServer) stop() {
+	s.once.Do(func() {
+		assert.Equal(s.t, nil, s.Shutdown())
+		s.wg.Wait()
+	})
+}
 
-	time.Sleep(10 * time.Millisecond)
+func TestClient(t *testing.T) {
+	startTestServer(t, WithAddress("localhost:7000"), WithLeaseOptions(lease.WithLeaseEpoch(0)))
 
 	client, err := NewClient("localhost:7000")
 	assert.Equal(t, nil, err)
 
 	ctx := context.Background()
+
+	var getFn func() (LGetResult, error)
+	err = client.Pipelined(ctx, func(p *Pipeline) error {
+		getFn = p.LGet("key01")
+		return nil
+	})
+	assert.Equal(t, nil, err)
+
+	getResult, err := getFn()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 1}, getResult)
+
 	err = client.Pipelined(ctx, func(p *Pipeline) error {
-		p.DoSomething()
+		releaseFn := p.LRelease("key01", getResult.LeaseID)
+		getFn := p.LGet("key01")
+
+		affected, err := releaseFn()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+
+		getResult, err = getFn()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 2}, getResult)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+
+	err = client.Pipelined(ctx, func(p *Pipeline) error {
+		extendFn := p.LExtend("key01", getResult.LeaseID, 60)
+		setFn := p.LSet("key01", getResult.LeaseID, []byte("some-value"))
+		getFn := p.LGet("key01")
+
+		affected, err := extendFn()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+
+		affected, err = setFn()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+
+		result, err := getFn()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusFound, Value: []byte("some-value")}, result)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+
+	err = client.Shutdown()
+	assert.Equal(t, nil, err)
+}
+
+func TestTCPClient(t *testing.T) {
+	startTestServer(t,
+		WithAddress("localhost:7010"),
+		WithTCPAddress("localhost:7011"),
+		WithLeaseOptions(lease.WithLeaseEpoch(0)),
+	)
+
+	client, err := NewTCPClient("localhost:7011")
+	assert.Equal(t, nil, err)
+
+	ctx := context.Background()
+	value := []byte(strings.Repeat("A", 40000))
+
+	err = client.Pipelined(ctx, func(p *Pipeline) error {
+		getResult, err := p.LGet("key01")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 1}, getResult)
+
+		affected, err := p.LSet("key01", getResult.LeaseID, value)()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+
+		getResult, err = p.LGet("key01")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusFound, Value: value}, getResult)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+
+	err = client.Shutdown()
+	assert.Equal(t, nil, err)
+}
+
+func TestTCPClient_Large_Value(t *testing.T) {
+	startTestServer(t,
+		WithAddress("localhost:7110"),
+		WithTCPAddress("localhost:7111"),
+		WithLeaseOptions(lease.WithLeaseEpoch(0)),
+	)
+
+	client, err := NewTCPClient("localhost:7111")
+	assert.Equal(t, nil, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	value := []byte(strings.Repeat("B", 100000))
+
+	err = client.Pipelined(ctx, func(p *Pipeline) error {
+		getResult, err := p.LGet("key01")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 1}, getResult)
+
+		affected, err := p.LSet("key01", getResult.LeaseID, value)()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+
+		getResult, err = p.LGet("key01")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusFound, Value: value}, getResult)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, nil, client.Shutdown())
+}
+
+func TestClient_Binary_Commands(t *testing.T) {
+	startTestServer(t,
+		WithAddress("localhost:7030"),
+		WithLeaseOptions(lease.WithLeaseEpoch(0)),
+	)
+
+	client, err := NewClient("localhost:7030", WithBinaryCommands())
+	assert.Equal(t, nil, err)
+
+	ctx := context.Background()
+	err = client.Pipelined(ctx, func(p *Pipeline) error {
+		getResult, err := p.LGet("key with spaces")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 1}, getResult)
+
+		affected, err := p.LSet("key with spaces", getResult.LeaseID, []byte("value\r\n01"))()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+
+		getResult, err = p.LGet("key with spaces")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusFound, Value: []byte("value\r\n01")}, getResult)
+
+		affected, err = p.Del("key with spaces")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+
+		longKey := strings.Repeat("K", parser.MaxBinaryKeyLength+1)
+		_, err = p.LGet(longKey)()
+		assert.Equal(t, parser.ErrKeyTooLong, err)
+		_, err = p.LSet(longKey, getResult.LeaseID, []byte("value"))()
+		assert.Equal(t, parser.ErrKeyTooLong, err)
+		_, err = p.Del(longKey)()
+		assert.Equal(t, parser.ErrKeyTooLong, err)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+
+	err = client.Shutdown()
+	assert.Equal(t, nil, err)
+}
+
+func TestClient_Checksums(t *testing.T) {
+	startTestServer(t,
+		WithAddress("localhost:7040"),
+		WithLeaseOptions(lease.WithLeaseEpoch(0)),
+	)
+
+	client, err := NewClient("localhost:7040", WithChecksums())
+	assert.Equal(t, nil, err)
+
+	ctx := context.Background()
+	value := []byte(strings.Repeat("A", 40000))
+
+	err = client.Pipelined(ctx, func(p *Pipeline) error {
+		getResult, err := p.LGet("key01")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 1}, getResult)
+
+		affected, err := p.LSet("key01", getResult.LeaseID, value)()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+
+		getResult, err = p.LGet("key01")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusFound, Value: value}, getResult)
 		return nil
 	})
 	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(0), client.ChecksumFailures())
+
+	err = client.Shutdown()
+	assert.Equal(t, nil, err)
+}
+
+func TestClient_Auth(t *testing.T) {
+	server := startTestServer(t,
+		WithAddress("localhost:7050"),
+		WithLeaseOptions(lease.WithLeaseEpoch(0)),
+		WithAuthKeys(map[uint32][]byte{1: []byte("key-01")}),
+	)
+
+	lget := func(client *Client) (LGetResult, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		var result LGetResult
+		err := client.Pipelined(ctx, func(p *Pipeline) error {
+			var err error
+			result, err = p.LGet("key01")()
+			return err
+		})
+		return result, err
+	}
+
+	unsigned, err := NewClient("localhost:7050")
+	assert.Equal(t, nil, err)
+	_, err = lget(unsigned)
+	assert.NotEqual(t, nil, err)
+
+	client, err := NewClient("localhost:7050", WithAuthKey(2, []byte("key-02")))
+	assert.Equal(t, nil, err)
+	_, err = lget(client)
+	assert.NotEqual(t, nil, err)
+
+	server.SetAuthKeys(map[uint32][]byte{1: []byte("key-01"), 2: []byte("key-02")})
+	result, err := lget(client)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 1}, result)
+
+	assert.Equal(t, nil, unsigned.Shutdown())
+	assert.Equal(t, nil, client.Shutdown())
+}
+
+func TestClient_Encryption(t *testing.T) {
+	startTestServer(t,
+		WithAddress("localhost:7060"),
+		WithLeaseOptions(lease.WithLeaseEpoch(0)),
+		WithEncryptionKeys(map[uint32][]byte{1: encryptionKeyForTest}),
+	)
+
+	_, err := NewClient("localhost:7060", WithEncryptionKey(1, []byte("short-key")))
+	assert.NotEqual(t, nil, err)
+
+	client, err := NewClient("localhost:7060", WithEncryptionKey(1, encryptionKeyForTest), WithChecksums())
+	assert.Equal(t, nil, err)
+
+	ctx := context.Background()
+	value := []byte(strings.Repeat("A", 40000))
+
+	err = client.Pipelined(ctx, func(p *Pipeline) error {
+		getResult, err := p.LGet("key01")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 1}, getResult)
 
-	time.Sleep(10 * time.Millisecond)
+		affected, err := p.LSet("key01", getResult.LeaseID, value)()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+
+		getResult, err = p.LGet("key01")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusFound, Value: value}, getResult)
+		return nil
+	})
+	assert.Equal(t, nil, err)
 
 	err = client.Shutdown()
 	assert.Equal(t, nil, err)
 
-	err = server.Shutdown()
+	err = NewServer(WithEncryptionKeys(map[uint32][]byte{1: []byte("short-key")})).Run()
+	assert.NotEqual(t, nil, err)
+
+	err = NewServer(
+		WithEncryptionKeys(map[uint32][]byte{1: []byte("0123456789abcdef")}),
+		WithRESPAddress("localhost:7100"),
+	).Run()
+	assert.Equal(t, errUnauthenticatedListener, err)
+
+	err = NewServer(
+		WithAuthKeys(map[uint32][]byte{1: []byte("secret")}),
+		WithMemcachedAddress("localhost:7100"),
+	).Run()
+	assert.Equal(t, errUnauthenticatedListener, err)
+}
+
+func TestUnixClient(t *testing.T) {
+	dir := t.TempDir()
+	streamPath := filepath.Join(dir, "kvstore.sock")
+	datagramPath := filepath.Join(dir, "kvstore-dgram.sock")
+
+	server := startTestServer(t,
+		WithAddress("localhost:7020"),
+		WithUnixAddress(streamPath),
+		WithUnixgramAddress(datagramPath),
+		WithLeaseOptions(lease.WithLeaseEpoch(0)),
+	)
+
+	streamClient, err := NewUnixClient(streamPath)
+	assert.Equal(t, nil, err)
+
+	datagramClient, err := NewUnixgramClient(datagramPath)
+	assert.Equal(t, nil, err)
+
+	ctx := context.Background()
+
+	err = streamClient.Pipelined(ctx, func(p *Pipeline) error {
+		getResult, err := p.LGet("key01")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 1}, getResult)
+
+		affected, err := p.LSet("key01", getResult.LeaseID, []byte("some-value"))()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+
+	err = datagramClient.Pipelined(ctx, func(p *Pipeline) error {
+		getResult, err := p.LGet("key01")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusFound, Value: []byte("some-value")}, getResult)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, nil, streamClient.Shutdown())
+	assert.Equal(t, nil, datagramClient.Shutdown())
+	server.stop()
+
+	_, err = os.Stat(datagramPath)
+	assert.Equal(t, true, os.IsNotExist(err))
+}
+
+func TestClient_Tags(t *testing.T) {
+	startTestServer(t,
+		WithAddress("localhost:7070"),
+		WithLeaseOptions(lease.WithLeaseEpoch(0)),
+	)
+
+	for _, options := range [][]ClientOption{nil, {WithBinaryCommands()}} {
+		client, err := NewClient("localhost:7070", options...)
+		assert.Equal(t, nil, err)
+
+		ctx := context.Background()
+		err = client.Pipelined(ctx, func(p *Pipeline) error {
+			getResult, err := p.LGet("key01")()
+			assert.Equal(t, nil, err)
+			assert.Equal(t, lease.GetStatusLeaseGranted, getResult.Status)
+
+			affected, err := p.LSetWithTags("key01", getResult.LeaseID, []byte("value01"), "user:1", "team:2")()
+			assert.Equal(t, nil, err)
+			assert.Equal(t, true, affected)
+
+			getResult, err = p.LGet("key01")()
+			assert.Equal(t, nil, err)
+			assert.Equal(t, LGetResult{Status: lease.GetStatusFound, Value: []byte("value01")}, getResult)
+
+			affected, err = p.DelTag("team:2")()
+			assert.Equal(t, nil, err)
+			assert.Equal(t, true, affected)
+
+			getResult, err = p.LGet("key01")()
+			assert.Equal(t, nil, err)
+			assert.Equal(t, lease.GetStatusLeaseGranted, getResult.Status)
+
+			affected, err = p.LRelease("key01", getResult.LeaseID)()
+			assert.Equal(t, nil, err)
+			assert.Equal(t, true, affected)
+
+			_, err = p.LSetWithTags("key01", getResult.LeaseID, []byte("value01"), strings.Repeat("A", 1025))()
+			assert.Equal(t, parser.ErrTagTooLong, err)
+			_, err = p.DelTag(strings.Repeat("A", 1025))()
+			assert.Equal(t, parser.ErrTagTooLong, err)
+			return nil
+		})
+		assert.Equal(t, nil, err)
+
+		err = client.Shutdown()
+		assert.Equal(t, nil, err)
+	}
+}
+
+func TestClient_Snapshot_Restore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+
+	server := startTestServer(t,
+		WithAddress("localhost:7080"),
+		WithLeaseOptions(lease.WithLeaseEpoch(0)),
+		WithSnapshotFile(path),
+	)
+
+	client, err := NewClient("localhost:7080")
+	assert.Equal(t, nil, err)
+
+	ctx := context.Background()
+	err = client.Pipelined(ctx, func(p *Pipeline) error {
+		getResult, err := p.LGet("key01")()
+		assert.Equal(t, nil, err)
+
+		affected, err := p.LSet("key01", getResult.LeaseID, []byte("value01"))()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+
+		affected, err = p.Snapshot()()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, affected)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, client.Shutdown())
+
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	server.stop()
+
+	startTestServer(t,
+		WithAddress("localhost:7080"),
+		WithSnapshotFile(path),
+		WithRestoreSnapshot(),
+	)
+
+	client, err = NewClient("localhost:7080")
+	assert.Equal(t, nil, err)
+
+	err = client.Pipelined(ctx, func(p *Pipeline) error {
+		getResult, err := p.LGet("key01")()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LGetResult{Status: lease.GetStatusFound, Value: []byte("value01")}, getResult)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, client.Shutdown())
+}
+
+func TestClient_Warm_Up(t *testing.T) {
+	ring := HashRing{Nodes: []string{"node-a", "node-b"}, VirtualNodes: 64}
+
+	donor := startTestServer(t,
+		WithAddress("localhost:7090"),
+		WithLeaseOptions(lease.WithLeaseEpoch(0)),
+		WithWarmUpAddress("localhost:7091"),
+	)
+
+	keys := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		keys = append(keys, "key"+strconv.Itoa(i))
+		donor.GetCache().Put([]byte(keys[i]), []byte("value"+strconv.Itoa(i)))
+	}
+
+	startTestServer(t,
+		WithAddress("localhost:7092"),
+		WithWarmUpFrom("localhost:7091", "node-b", ring),
+	)
+
+	client, err := NewClient("localhost:7092")
+	assert.Equal(t, nil, err)
+
+	owners := newHashRing(ring)
+	err = client.Pipelined(context.Background(), func(p *Pipeline) error {
+		for i, key := range keys {
+			getResult, err := p.LGet(key)()
+			assert.Equal(t, nil, err)
+			if owners.owner([]byte(key)) == "node-b" {
+				value := []byte("value" + strconv.Itoa(i))
+				assert.Equal(t, LGetResult{Status: lease.GetStatusFound, Value: value}, getResult)
+			} else {
+				assert.Equal(t, lease.GetStatusLeaseGranted, getResult.Status)
+			}
+		}
+		return nil
+	})
 	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, client.Shutdown())
 }
@@ -0,0 +1,106 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"github.com/QuangTung97/kvstore/lease"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestBuildCommand(t *testing.T) {
+	assert.Equal(t, []byte("LGET key01\r\n"), buildCommand("LGET", "key01"))
+	assert.Equal(t, []byte("LEXTEND key01 12 60\r\n"), buildCommand("LEXTEND", "key01", "12", "60"))
+}
+
+func TestParseLGetResponse(t *testing.T) {
+	result, err := parseLGetResponse([]byte("OK 10\r\nsome-value\r\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LGetResult{Status: lease.GetStatusFound, Value: []byte("some-value")}, result)
+
+	result, err = parseLGetResponse([]byte("GRANTED 12\r\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 12}, result)
+
+	result, err = parseLGetResponse([]byte("GRANTED 18446744069414584321\r\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 0xffffffff00000001}, result)
+
+	result, err = parseLGetResponse([]byte("REJECTED\r\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LGetResult{Status: lease.GetStatusLeaseRejected}, result)
+
+	result, err = parseLGetResponse([]byte("STALE 10 5\r\nsome-value\r\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LGetResult{
+		Status:  lease.GetStatusStaleLeaseGranted,
+		LeaseID: 5,
+		Value:   []byte("some-value"),
+	}, result)
+
+	result, err = parseLGetResponse([]byte("STALE 10 0\r\nsome-value\r\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LGetResult{Status: lease.GetStatusStaleLeaseRejected, Value: []byte("some-value")}, result)
+}
+
+func TestParseLGetResponse_Error(t *testing.T) {
+	_, err := parseLGetResponse([]byte("ERROR missing key\r\n"))
+	assert.Equal(t, errors.New("missing key"), err)
+
+	_, err = parseLGetResponse([]byte("OK 20\r\nsome-value\r\n"))
+	assert.Equal(t, ErrInvalidResponse, err)
+
+	_, err = parseLGetResponse([]byte("GRANTED"))
+	assert.Equal(t, ErrInvalidResponse, err)
+
+	_, err = parseLGetResponse([]byte("UNKNOWN\r\n"))
+	assert.Equal(t, ErrInvalidResponse, err)
+}
+
+func TestParseAffectedResponse(t *testing.T) {
+	affected, err := parseAffectedResponse([]byte("OK 1\r\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, affected)
+
+	affected, err = parseAffectedResponse([]byte("OK 0\r\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, affected)
+
+	_, err = parseAffectedResponse([]byte("ERROR invalid command\r\n"))
+	assert.Equal(t, errors.New("invalid command"), err)
+
+	_, err = parseAffectedResponse([]byte("GRANTED 1\r\n"))
+	assert.Equal(t, ErrInvalidResponse, err)
+}
+
+func TestClient_Frame_Rejected(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	client := newClientWithConn(clientConn, clientOptions{})
+
+	go func() {
+		request := make([]byte, 1000)
+		n, err := serverConn.Read(request)
+		assert.Equal(t, nil, err)
+
+		header, _ := parseDataFrameHeader(request[:n])
+		assert.Equal(t, frameVersionLatest, header.version)
+
+		frame := make([]byte, 100)
+		offset := buildDataFrameHeader(frame, dataFrameHeader{
+			version:  frameVersionLatest,
+			batchID:  header.batchID,
+			rejected: true,
+		})
+		offset += copy(frame[offset:], "unsupported frame version 1")
+		_, err = serverConn.Write(frame[:offset])
+		assert.Equal(t, nil, err)
+	}()
+
+	err := client.Pipelined(context.Background(), func(p *Pipeline) error {
+		_, err := p.LGet("key01")()
+		return err
+	})
+	assert.True(t, errors.Is(err, ErrFrameRejected))
+	assert.Equal(t, "frame rejected by server: unsupported frame version 1", err.Error())
+}
@@ -1,6 +1,7 @@
 package kvstore
 
 import (
+	"net"
 	"sync"
 	"sync/atomic"
 	"unsafe"
@@ -13,10 +14,80 @@ type atomicUint64 struct {
 // IPAddr only supported IPv4
 type IPAddr [4]byte
 
+// Transport is the transport that a client uses
+type Transport uint8
+
+const (
+	// TransportUDP for clients identified by the IP address and port of UDP packages
+	TransportUDP Transport = iota
+	// TransportStream for clients connected by TCP or Unix stream sockets, identified by connection ids
+	TransportStream
+	// TransportUnixgram for clients of Unix datagram sockets, identified by ids of their socket paths
+	TransportUnixgram
+)
+
+// ClientAddr is the address for replying to a client,
+// IP and Port are only used by UDP, while the other transports use ID.
+// IP is also set for TCP clients of IPv4, for rate limiting and access control.
+// Unix is true for clients of Unix sockets, which are not restricted
+type ClientAddr struct {
+	Transport Transport
+	Unix      bool
+	IP        IPAddr
+	Port      uint16
+	ID        uint32
+}
+
+// NewUDPClientAddr returns the address of a UDP client
+func NewUDPClientAddr(ip IPAddr, port uint16) ClientAddr {
+	return ClientAddr{
+		Transport: TransportUDP,
+		IP:        ip,
+		Port:      port,
+	}
+}
+
+// newStreamClientAddr returns the address of a TCP or Unix stream client, the IP is not set for TCP clients of IPv6
+func newStreamClientAddr(remote net.Addr, id uint32) ClientAddr {
+	addr := ClientAddr{
+		Transport: TransportStream,
+		ID:        id,
+	}
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		addr.Unix = true
+		return addr
+	}
+	if ip := tcpAddr.IP.To4(); ip != nil {
+		copy(addr.IP[:], ip)
+	}
+	return addr
+}
+
+// hasIPv4 returns false for the clients without IPv4 address, i.e. Unix sockets and TCP clients of IPv6
+func (a ClientAddr) hasIPv4() bool {
+	return a.Transport == TransportUDP || a.IP != (IPAddr{})
+}
+
+// commandFormat is the frame version and the command encoding of a command list,
+// responses are sent with the same frame version
+type commandFormat struct {
+	version  uint8
+	binary   bool
+	checksum bool // responses carry checksums
+
+	// responses are encrypted with the same key
+	encrypted       bool
+	encryptionKeyID uint32
+
+	// the commands are not executed, replied with rate limited errors
+	rateLimited bool
+}
+
 type rawCommandList struct {
-	ip   IPAddr
-	port uint16
-	data []byte
+	addr   ClientAddr
+	format commandFormat
+	data   []byte
 }
 
 func (a *atomicUint64) store(v uint64) {
@@ -27,10 +98,15 @@ func (a *atomicUint64) load() uint64 {
 	return atomic.LoadUint64(&a.value)
 }
 
+func (a *atomicUint64) add(delta uint64) {
+	atomic.AddUint64(&a.value, delta)
+}
+
 type commandListStore struct {
-	mut     sync.Mutex
-	cond    *sync.Cond
-	stopped bool
+	mut      sync.Mutex
+	cond     *sync.Cond
+	stopped  bool
+	notified bool
 
 	buffer     []byte
 	nextOffset uint64
@@ -40,16 +116,17 @@ type commandListStore struct {
 }
 
 type commandListHeader struct {
-	ip     [4]byte
-	port   uint16
-	length uint16
+	addr   ClientAddr
+	length uint32
+	format commandFormat
 }
 
 const commandListHeaderSize = uint64(unsafe.Sizeof(commandListHeader{}))
 
-func initCommandListStore(s *commandListStore, bufSize int) {
+// initCommandListStore allocates the ring buffer of bufSize bytes, a command list is at most maxListSize bytes
+func initCommandListStore(s *commandListStore, bufSize int, maxListSize int) {
 	s.buffer = make([]byte, bufSize)
-	s.currentCommandData = make([]byte, 1<<16) // 64KB
+	s.currentCommandData = make([]byte, maxListSize)
 	s.cond = sync.NewCond(&s.mut)
 }
 
@@ -75,16 +152,16 @@ func (s *commandListStore) readAt(data []byte, pos uint64) {
 	}
 }
 
-func (s *commandListStore) appendCommands(ip IPAddr, port uint16, data []byte) {
+func (s *commandListStore) appendCommands(addr ClientAddr, format commandFormat, data []byte) {
 	s.mut.Lock()
 
-	length := uint16(len(data))
+	length := uint32(len(data))
 
 	var headerData [commandListHeaderSize]byte
 	header := (*commandListHeader)(unsafe.Pointer(&headerData[0]))
-	header.ip = ip
-	header.port = port
+	header.addr = addr
 	header.length = length
+	header.format = format
 
 	s.appendBytes(headerData[:])
 	s.appendBytes(data)
@@ -103,9 +180,9 @@ func (s *commandListStore) getNextRawCommandList() (rawCommandList, uint64) {
 	s.readAt(s.currentCommandData[:header.length], begin+commandListHeaderSize)
 
 	return rawCommandList{
-		ip:   header.ip,
-		port: header.port,
-		data: s.currentCommandData[:header.length],
+		addr:   header.addr,
+		format: header.format,
+		data:   s.currentCommandData[:header.length],
 	}, begin + commandListHeaderSize + uint64(header.length)
 }
 
@@ -120,20 +197,48 @@ func (s *commandListStore) getCommitProcessed() uint64 {
 // when stopped, return false
 func (s *commandListStore) waitAvailable() bool {
 	s.mut.Lock()
-	for s.nextOffset <= s.processed.load() && !s.stopped {
+	for s.nextOffset <= s.processed.load() && !s.stopped && !s.notified {
 		s.cond.Wait()
 	}
+	s.notified = false
 	continued := s.nextOffset > s.processed.load() || !s.stopped
 	s.mut.Unlock()
 	return continued
 }
 
+func (s *commandListStore) isAvailable() bool {
+	s.mut.Lock()
+	available := s.nextOffset > s.processed.load()
+	s.mut.Unlock()
+	return available
+}
+
+// notify wakes up waitAvailable even if there is no new command
+func (s *commandListStore) notify() {
+	s.mut.Lock()
+	s.notified = true
+	s.mut.Unlock()
+
+	s.cond.Signal()
+}
+
 func (s *commandListStore) isCommandAppendable(dataSize int) bool {
+	if dataSize > len(s.currentCommandData) {
+		return false
+	}
 	max := uint64(len(s.buffer))
 	sizeWithHeader := uint64(dataSize) + commandListHeaderSize
 	return max+s.processed.load() >= s.nextOffset+sizeWithHeader
 }
 
+// queueSize returns the number of bytes of command lists that have not been processed
+func (s *commandListStore) queueSize() uint64 {
+	s.mut.Lock()
+	size := s.nextOffset - s.processed.load()
+	s.mut.Unlock()
+	return size
+}
+
 func (s *commandListStore) stopWait() {
 	s.mut.Lock()
 	s.stopped = true
@@ -12,13 +12,13 @@ import (
 
 func newCommandListStore() *commandListStore {
 	s := &commandListStore{}
-	initCommandListStore(s, 1024)
+	initCommandListStore(s, 1024, 1024)
 	return s
 }
 
 func newCommandListStoreBuffSize(buffSize int) *commandListStore {
 	s := &commandListStore{}
-	initCommandListStore(s, buffSize)
+	initCommandListStore(s, buffSize, buffSize)
 	return s
 }
 
@@ -31,27 +31,38 @@ func newIPAddr(a, b, c, d byte) IPAddr {
 
 func TestCommandListStore_AppendCommands_Single(t *testing.T) {
 	s := newCommandListStore()
-	s.appendCommands(newIPAddr(192, 168, 0, 1), 8100, []byte("some-data"))
+	s.appendCommands(NewUDPClientAddr(newIPAddr(192, 168, 0, 1), 8100), commandFormat{}, []byte("some-data"))
 
 	cmdList, _ := s.getNextRawCommandList()
 	assert.Equal(t, rawCommandList{
-		ip:   newIPAddr(192, 168, 0, 1),
-		port: 8100,
+		addr: NewUDPClientAddr(newIPAddr(192, 168, 0, 1), 8100),
 		data: []byte("some-data"),
 	}, cmdList)
 }
 
+func TestCommandListStore_AppendCommands_Binary(t *testing.T) {
+	s := newCommandListStore()
+	format := commandFormat{version: frameVersion1, binary: true}
+	s.appendCommands(NewUDPClientAddr(newIPAddr(192, 168, 0, 1), 8100), format, []byte("some-data"))
+
+	cmdList, _ := s.getNextRawCommandList()
+	assert.Equal(t, rawCommandList{
+		addr:   NewUDPClientAddr(newIPAddr(192, 168, 0, 1), 8100),
+		format: format,
+		data:   []byte("some-data"),
+	}, cmdList)
+}
+
 func TestCommandListStore_AppendCommands_Multiple(t *testing.T) {
 	s := newCommandListStore()
 
-	s.appendCommands(newIPAddr(192, 168, 0, 1), 8100, []byte("some-data"))
-	s.appendCommands(newIPAddr(123, 9, 2, 5), 7233, []byte("another-data"))
-	s.appendCommands(newIPAddr(89, 0, 3, 6), 7000, []byte("random-data"))
+	s.appendCommands(NewUDPClientAddr(newIPAddr(192, 168, 0, 1), 8100), commandFormat{}, []byte("some-data"))
+	s.appendCommands(NewUDPClientAddr(newIPAddr(123, 9, 2, 5), 7233), commandFormat{}, []byte("another-data"))
+	s.appendCommands(NewUDPClientAddr(newIPAddr(89, 0, 3, 6), 7000), commandFormat{}, []byte("random-data"))
 
 	cmdList, completedOffset := s.getNextRawCommandList()
 	assert.Equal(t, rawCommandList{
-		ip:   newIPAddr(192, 168, 0, 1),
-		port: 8100,
+		addr: NewUDPClientAddr(newIPAddr(192, 168, 0, 1), 8100),
 		data: []byte("some-data"),
 	}, cmdList)
 
@@ -59,8 +70,7 @@ func TestCommandListStore_AppendCommands_Multiple(t *testing.T) {
 
 	cmdList, completedOffset = s.getNextRawCommandList()
 	assert.Equal(t, rawCommandList{
-		ip:   newIPAddr(123, 9, 2, 5),
-		port: 7233,
+		addr: NewUDPClientAddr(newIPAddr(123, 9, 2, 5), 7233),
 		data: []byte("another-data"),
 	}, cmdList)
 
@@ -68,8 +78,7 @@ func TestCommandListStore_AppendCommands_Multiple(t *testing.T) {
 
 	cmdList, completedOffset = s.getNextRawCommandList()
 	assert.Equal(t, rawCommandList{
-		ip:   newIPAddr(89, 0, 3, 6),
-		port: 7000,
+		addr: NewUDPClientAddr(newIPAddr(89, 0, 3, 6), 7000),
 		data: []byte("random-data"),
 	}, cmdList)
 
@@ -79,7 +88,7 @@ func TestCommandListStore_AppendCommands_Multiple(t *testing.T) {
 
 func TestCommandListStore_WaitAvailable_Single_Command(t *testing.T) {
 	s := newCommandListStore()
-	s.appendCommands(newIPAddr(192, 168, 0, 1), 8100, []byte("some-data"))
+	s.appendCommands(NewUDPClientAddr(newIPAddr(192, 168, 0, 1), 8100), commandFormat{}, []byte("some-data"))
 	continued := s.waitAvailable()
 	assert.Equal(t, true, continued)
 }
@@ -117,8 +126,7 @@ func TestCommandListStore_Stress_Test(t *testing.T) {
 		for s.waitAvailable() {
 			cmdList, offset := s.getNextRawCommandList()
 
-			assert.Equal(t, newIPAddr(198, 168, 53, 1), cmdList.ip)
-			assert.Equal(t, uint16(8765), cmdList.port)
+			assert.Equal(t, NewUDPClientAddr(newIPAddr(198, 168, 53, 1), 8765), cmdList.addr)
 			assert.Equal(t, []byte("command-no-"), cmdList.data[:len("command-no-")])
 			s.commitProcessedOffset(offset)
 
@@ -133,7 +141,7 @@ func TestCommandListStore_Stress_Test(t *testing.T) {
 		for !s.isCommandAppendable(size) {
 			//revive:disable-next-line:empty-block
 		}
-		s.appendCommands(newIPAddr(198, 168, 53, 1), 8765, data)
+		s.appendCommands(NewUDPClientAddr(newIPAddr(198, 168, 53, 1), 8765), commandFormat{}, data)
 	}
 
 	for atomic.LoadUint32(&count) < numCommands {
@@ -0,0 +1,120 @@
+package kvstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"sync"
+)
+
+const frameNonceSize = 12
+const frameEncryptionSize = frameKeyIDSize + frameNonceSize
+
+var errDecryptFailed = errors.New("decrypt frame failed")
+
+// errEncryptFailed is replied in a rejected frame when the response can not be encrypted
+var errEncryptFailed = errors.New("encrypt response failed")
+
+// randRead fills the nonces of encrypted frames, replaced in tests
+var randRead = rand.Read
+
+// encryptionKeyring contains the AES-GCM ciphers of the active encryption keys,
+// more than one key can be active at the same time for rotating keys without downtime
+type encryptionKeyring struct {
+	mut   sync.RWMutex
+	aeads map[uint32]cipher.AEAD
+}
+
+func newFrameAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// setKeys replaces the active keys, the keys must be 16, 24 or 32 bytes
+// for AES-128, AES-192 or AES-256
+func (r *encryptionKeyring) setKeys(keys map[uint32][]byte) error {
+	aeads := make(map[uint32]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		aead, err := newFrameAEAD(key)
+		if err != nil {
+			return err
+		}
+		aeads[id] = aead
+	}
+
+	r.mut.Lock()
+	r.aeads = aeads
+	r.mut.Unlock()
+	return nil
+}
+
+// enabled returns true if frames must be encrypted
+func (r *encryptionKeyring) enabled() bool {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return len(r.aeads) > 0
+}
+
+func (r *encryptionKeyring) get(keyID uint32) (cipher.AEAD, bool) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	aead, ok := r.aeads[keyID]
+	return aead, ok
+}
+
+// decrypt decrypts the entry list of the frame in place
+func (r *encryptionKeyring) decrypt(frame []byte, header dataFrameHeader, payloadOffset int) ([]byte, error) {
+	aead, ok := r.get(header.encryptionKeyID)
+	if !ok {
+		return nil, errDecryptFailed
+	}
+	return decryptFramePayload(frame, header, payloadOffset, aead)
+}
+
+func frameEncryptionOffset(header dataFrameHeader) int {
+	offset := frameAuthOffset(header)
+	if header.auth {
+		return offset + frameAuthSize
+	}
+	return offset
+}
+
+func frameEncryptionOverhead(header dataFrameHeader) int {
+	if !header.encrypted {
+		return 0
+	}
+	return header.aead.Overhead()
+}
+
+// frameAdditionalData returns the part of the header that is authenticated by the AEAD,
+// which excludes the checksum and the auth fields since they are filled after encrypting
+func frameAdditionalData(frame []byte, header dataFrameHeader) []byte {
+	return frame[:frameChecksumOffset(header)]
+}
+
+// encryptFramePayload encrypts the entry list in place with a random nonce, returns the frame length
+func encryptFramePayload(frame []byte, header dataFrameHeader, payloadOffset int, dataLen int) (int, error) {
+	nonceOffset := frameEncryptionOffset(header) + frameKeyIDSize
+	nonce := frame[nonceOffset : nonceOffset+frameNonceSize]
+	_, err := randRead(nonce)
+	if err != nil {
+		return 0, err
+	}
+
+	payload := frame[payloadOffset : payloadOffset+dataLen]
+	sealed := header.aead.Seal(payload[:0], nonce, payload, frameAdditionalData(frame, header))
+	return payloadOffset + len(sealed), nil
+}
+
+func decryptFramePayload(frame []byte, header dataFrameHeader, payloadOffset int, aead cipher.AEAD) ([]byte, error) {
+	payload := frame[payloadOffset:]
+	plaintext, err := aead.Open(payload[:0], header.nonce, payload, frameAdditionalData(frame, header))
+	if err != nil {
+		return nil, errDecryptFailed
+	}
+	return plaintext, nil
+}
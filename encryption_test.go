@@ -0,0 +1,137 @@
+package kvstore
+
+import (
+	"crypto/rand"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func newEncryptionKeyringForTest(keys map[uint32][]byte) *encryptionKeyring {
+	r := &encryptionKeyring{}
+	err := r.setKeys(keys)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+var encryptionKeyForTest = []byte("0123456789abcdef0123456789abcdef")
+
+func TestEncryptionKeyring_Decrypt(t *testing.T) {
+	keyring := newEncryptionKeyringForTest(map[uint32][]byte{3: encryptionKeyForTest})
+	assert.Equal(t, true, keyring.enabled())
+
+	aead, ok := keyring.get(3)
+	assert.Equal(t, true, ok)
+
+	data := strings.Repeat("some-data-", 10)
+	header := dataFrameHeader{
+		version:         frameVersion1,
+		batchID:         10,
+		checksum:        true,
+		auth:            true,
+		keyID:           1,
+		authKey:         []byte("auth-key"),
+		encrypted:       true,
+		encryptionKeyID: 3,
+		aead:            aead,
+	}
+
+	var frames [][]byte
+	_ = buildDataFrames(make([]byte, 120), header, []byte(data), func(frame []byte) {
+		assert.LessOrEqual(t, len(frame), 120)
+		frames = append(frames, cloneBytes(frame))
+	})
+	assert.Equal(t, 5, len(frames))
+
+	var result []byte
+	for _, frame := range frames {
+		parsed, offset := parseDataFrameHeader(frame)
+		assert.Equal(t, true, parsed.encrypted)
+		assert.Equal(t, uint32(3), parsed.encryptionKeyID)
+		assert.Equal(t, true, validFrameChecksum(frame, parsed))
+		assert.Equal(t, false, strings.Contains(string(frame), "some-data"))
+
+		payload, err := keyring.decrypt(frame, parsed, offset)
+		assert.Equal(t, nil, err)
+		result = append(result, payload...)
+	}
+	assert.Equal(t, data, string(result))
+}
+
+func TestBuildDataFrames_Nonce_Error(t *testing.T) {
+	keyring := newEncryptionKeyringForTest(map[uint32][]byte{3: encryptionKeyForTest})
+	aead, _ := keyring.get(3)
+
+	randErr := errors.New("rand error")
+	randRead = func([]byte) (int, error) { return 0, randErr }
+	defer func() { randRead = rand.Read }()
+
+	header := dataFrameHeader{
+		version: frameVersion1, batchID: 10,
+		encrypted: true, encryptionKeyID: 3, aead: aead,
+	}
+	calls := 0
+	err := buildDataFrames(make([]byte, 1000), header, []byte("some-data"), func(frame []byte) {
+		calls++
+	})
+	assert.Equal(t, randErr, err)
+	assert.Equal(t, 0, calls)
+}
+
+func TestEncryptionKeyring_Decrypt_Error(t *testing.T) {
+	keyring := newEncryptionKeyringForTest(map[uint32][]byte{3: encryptionKeyForTest})
+	aead, _ := keyring.get(3)
+
+	build := func(keyID uint32) []byte {
+		var result []byte
+		header := dataFrameHeader{
+			version: frameVersion1, batchID: 10,
+			encrypted: true, encryptionKeyID: keyID, aead: aead,
+		}
+		_ = buildDataFrames(make([]byte, 1000), header, []byte("some-data"), func(frame []byte) {
+			result = cloneBytes(frame)
+		})
+		return result
+	}
+
+	frame := build(4)
+	header, offset := parseDataFrameHeader(frame)
+	_, err := keyring.decrypt(frame, header, offset)
+	assert.Equal(t, errDecryptFailed, err)
+
+	frame = build(3)
+	frame[len(frame)-1] ^= 0x01
+	header, offset = parseDataFrameHeader(frame)
+	_, err = keyring.decrypt(frame, header, offset)
+	assert.Equal(t, errDecryptFailed, err)
+
+	frame = build(3)
+	frame[frameBatchIDOffset] = 11
+	header, offset = parseDataFrameHeader(frame)
+	_, err = keyring.decrypt(frame, header, offset)
+	assert.Equal(t, errDecryptFailed, err)
+}
+
+func TestEncryptionKeyring_SetKeys(t *testing.T) {
+	keyring := &encryptionKeyring{}
+	assert.Equal(t, false, keyring.enabled())
+
+	err := keyring.setKeys(map[uint32][]byte{1: []byte("short-key")})
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, false, keyring.enabled())
+
+	err = keyring.setKeys(map[uint32][]byte{1: encryptionKeyForTest, 2: encryptionKeyForTest[:16]})
+	assert.Equal(t, nil, err)
+
+	_, ok := keyring.get(2)
+	assert.Equal(t, true, ok)
+
+	err = keyring.setKeys(map[uint32][]byte{2: encryptionKeyForTest[:16]})
+	assert.Equal(t, nil, err)
+
+	_, ok = keyring.get(1)
+	assert.Equal(t, false, ok)
+}
@@ -6,6 +6,7 @@ require (
 	github.com/QuangTung97/bigcache v0.1.0
 	github.com/matryer/moq v0.2.3
 	github.com/mgechev/revive v1.1.1
+	github.com/prometheus/client_golang v1.12.1
 	github.com/stretchr/testify v1.7.0
 	go.uber.org/zap v1.19.1
 )
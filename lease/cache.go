@@ -3,7 +3,10 @@ package lease
 import (
 	"github.com/QuangTung97/bigcache"
 	"github.com/QuangTung97/bigcache/memhash"
+	"math"
 	"math/bits"
+	"sync/atomic"
+	"time"
 )
 
 // Cache ...
@@ -11,6 +14,31 @@ type Cache struct {
 	leases []leaseList
 	mask   uint32
 	cache  *bigcache.Cache
+	clock  Clock
+	epoch  uint64 // the high 32 bits of lease IDs
+	tags   tagTable
+
+	trackKeys  bool
+	maxWaiters int
+
+	// the reads of peekRecord, excluded from the hit and access counts
+	peekAccesses uint64
+	peekHits     uint64
+
+	hashFunc func(data []byte) uint64
+}
+
+// Clock is used for computing lease timestamps
+type Clock interface {
+	// Now returns the monotonic time elapsed since an arbitrary point
+	Now() time.Duration
+}
+
+type monotonicClock struct {
+}
+
+func (monotonicClock) Now() time.Duration {
+	return time.Duration(memhash.NanoTime())
 }
 
 // New ...
@@ -19,22 +47,44 @@ func New(numSegments int, segmentSize int, options ...Option) *Cache {
 
 	leases := make([]leaseList, opts.numBuckets)
 	for i := range leases {
-		leases[i].init(opts.entryListSize, opts.leaseTimeout)
+		leases[i].init(opts.entryListSize, durationToMillis(opts.leaseTimeout), opts.overflowLimit)
 	}
 
 	return &Cache{
 		leases: leases,
 		cache:  bigcache.New(numSegments, segmentSize),
 		mask:   opts.numBuckets - 1,
+		clock:  opts.clock,
+		epoch:  uint64(opts.epoch) << 32,
+		tags:   tagTable{maxTags: opts.maxTags},
+
+		trackKeys:  opts.trackKeys,
+		maxWaiters: opts.maxWaiters,
+
+		hashFunc: memhash.Hash,
 	}
 }
 
-func hashFunc(data []byte) uint64 {
-	return memhash.Hash(data)
+// toLeaseID combines the epoch and the lease of a lease list
+func (c *Cache) toLeaseID(lease uint32) uint64 {
+	return c.epoch | uint64(lease)
 }
 
-func getNow() uint32 {
-	return uint32(memhash.NanoTime() / 1000000000)
+// toListLease returns the lease in the lease list, false if the lease ID belongs to another epoch
+func (c *Cache) toListLease(leaseID uint64) (uint32, bool) {
+	if leaseID&^uint64(math.MaxUint32) != c.epoch {
+		return 0, false
+	}
+	return uint32(leaseID), true
+}
+
+func durationToMillis(d time.Duration) uint64 {
+	return uint64(d / time.Millisecond)
+}
+
+// getNow returns the current time in millisecond
+func (c *Cache) getNow() uint64 {
+	return durationToMillis(c.clock.Now())
 }
 
 // GetStatus for cache Get
@@ -47,29 +97,36 @@ const (
 	GetStatusLeaseGranted
 	// GetStatusLeaseRejected when cache miss and lease is not granted
 	GetStatusLeaseRejected
+	// GetStatusStaleLeaseGranted when only a stale value is found and lease is granted
+	GetStatusStaleLeaseGranted
+	// GetStatusStaleLeaseRejected when only a stale value is found and lease is not granted
+	GetStatusStaleLeaseRejected
+	// GetStatusLeaseWaiting when cache miss, lease is not granted and the waiter is registered
+	GetStatusLeaseWaiting
 )
 
-// GetResult for result when calling Get
+// GetResult for result when calling Get.
+// LeaseID contains the epoch of the cache in the high 32 bits
 type GetResult struct {
 	Status    GetStatus
-	LeaseID   uint32
+	LeaseID   uint64
 	ValueSize int
 }
 
-func computeHashKeyAndIndex(hash uint64, mask uint32) (hashKey uint32, index uint32) {
-	return uint32(hash >> 32), uint32(hash) & mask
+func computeHashKeyAndIndex(hash uint64, mask uint32) (hashKey uint64, index uint32) {
+	return hash, uint32(hash) & mask
 }
 
-func (c *Cache) getLeaseList(key []byte) (uint32, *leaseList) {
-	hash := hashFunc(key)
+func (c *Cache) getLeaseList(key []byte) (uint64, *leaseList) {
+	hash := c.hashFunc(key)
 	hashKey, index := computeHashKeyAndIndex(hash, c.mask)
 	return hashKey, &c.leases[index]
 }
 
 // Get value from the cache
 func (c *Cache) Get(key []byte, value []byte) GetResult {
-	size, ok := c.cache.Get(key, value)
-	if ok {
+	size, recordType := c.readRecord(key, value)
+	if recordType == recordTypeValue {
 		return GetResult{
 			Status:    GetStatusFound,
 			ValueSize: size,
@@ -81,7 +138,40 @@ func (c *Cache) Get(key []byte, value []byte) GetResult {
 	l.mut.Lock()
 	defer l.mut.Unlock()
 
-	leaseID, ok := l.getLease(hashKey, getNow())
+	if recordType != recordTypeNone {
+		// the record may have been replaced before locking
+		size, recordType = c.readRecord(key, value)
+	}
+	return c.getWithLease(key, value, hashKey, l, size, recordType)
+}
+
+// getWithLease computes the result of Get from the record of the key read while holding the lock of the lease list
+func (c *Cache) getWithLease(
+	key []byte, value []byte, hashKey uint64, l *leaseList, size int, recordType byte,
+) GetResult {
+	if recordType == recordTypeValue {
+		return GetResult{
+			Status:    GetStatusFound,
+			ValueSize: size,
+		}
+	}
+
+	if recordType == recordTypeTagged {
+		taggedSize, found := c.readTagged(key, value, size)
+		if found {
+			return GetResult{
+				Status:    GetStatusFound,
+				ValueSize: taggedSize,
+			}
+		}
+	}
+
+	leaseID, ok := l.getLease(hashKey, c.getNow())
+
+	if recordType == recordTypeStale {
+		return newStaleGetResult(c.toLeaseID(leaseID), ok, size)
+	}
+
 	if !ok {
 		return GetResult{
 			Status: GetStatusLeaseRejected,
@@ -89,28 +179,185 @@ func (c *Cache) Get(key []byte, value []byte) GetResult {
 	}
 
 	return GetResult{
-		LeaseID: leaseID,
+		LeaseID: c.toLeaseID(leaseID),
 		Status:  GetStatusLeaseGranted,
 	}
 }
 
+//revive:disable-next-line:flag-parameter
+func newStaleGetResult(leaseID uint64, granted bool, size int) GetResult {
+	if !granted {
+		return GetResult{
+			Status:    GetStatusStaleLeaseRejected,
+			ValueSize: size,
+		}
+	}
+	return GetResult{
+		Status:    GetStatusStaleLeaseGranted,
+		LeaseID:   leaseID,
+		ValueSize: size,
+	}
+}
+
+// GetOrWait is the same as Get, except when the lease is not granted,
+// the waiter is registered to the outstanding lease and will be notified
+// when that lease is set, invalidated or expired. GetStatusLeaseRejected is returned without
+// registering the waiter when the lease bucket already has the waiters configured by WithMaxWaiters
+func (c *Cache) GetOrWait(key []byte, value []byte, w Waiter) GetResult {
+	size, recordType := c.readRecord(key, value)
+	if recordType == recordTypeValue {
+		return GetResult{
+			Status:    GetStatusFound,
+			ValueSize: size,
+		}
+	}
+
+	hashKey, l := c.getLeaseList(key)
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if recordType != recordTypeNone {
+		size, recordType = c.readRecord(key, value)
+	}
+	result := c.getWithLease(key, value, hashKey, l, size, recordType)
+	if result.Status != GetStatusLeaseRejected || l.countWaiters() >= c.maxWaiters {
+		return result
+	}
+
+	l.addWaiter(hashKey, l.findLease(hashKey), w)
+	return GetResult{
+		Status: GetStatusLeaseWaiting,
+	}
+}
+
+// CancelWait removes the waiter registered by GetOrWait,
+// returns false if the waiter has already been notified
+func (c *Cache) CancelWait(key []byte, w Waiter) bool {
+	_, l := c.getLeaseList(key)
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.removeWaiter(w)
+}
+
 // Set value to the cache
-func (c *Cache) Set(key []byte, leaseID uint32, value []byte) (affected bool) {
+func (c *Cache) Set(key []byte, leaseID uint64, value []byte) (affected bool) {
 	hashKey, l := c.getLeaseList(key)
 
 	l.mut.Lock()
 	defer l.mut.Unlock()
 
-	deleted := l.deleteLease(hashKey, leaseID)
+	lease, ok := c.toListLease(leaseID)
+	if !ok {
+		return false
+	}
+
+	deleted := l.deleteLease(hashKey, lease)
 	if !deleted {
 		return false
 	}
 
-	c.cache.Put(key, value)
+	c.putRecord(key, recordTypeValue, value)
+	c.addKey(key, l)
+
+	l.notifyWaiters(hashKey, lease, func(w Waiter) {
+		w.OnValue(value)
+	})
 	return true
 }
 
-// Invalidate an entry from the cache
+// Put sets the value to the cache without a lease,
+// the outstanding lease of the key is revoked and its waiters are released
+func (c *Cache) Put(key []byte, value []byte) {
+	hashKey, l := c.getLeaseList(key)
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.forceDelete(hashKey)
+
+	c.putRecord(key, recordTypeValue, value)
+	c.addKey(key, l)
+}
+
+// Lookup gets the value from the cache without granting any lease
+func (c *Cache) Lookup(key []byte, value []byte) (size int, ok bool) {
+	size, recordType := c.readRecord(key, value)
+	if recordType == recordTypeValue {
+		return size, true
+	}
+	if recordType != recordTypeTagged {
+		return 0, false
+	}
+
+	_, l := c.getLeaseList(key)
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	size, recordType = c.readRecord(key, value)
+	if recordType == recordTypeValue {
+		return size, true
+	}
+	if recordType != recordTypeTagged {
+		return 0, false
+	}
+	return c.readTagged(key, value, size)
+}
+
+// Release gives back a granted lease without setting a value,
+// so that another client can be granted a lease for the key
+func (c *Cache) Release(key []byte, leaseID uint64) (affected bool) {
+	hashKey, l := c.getLeaseList(key)
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	lease, ok := c.toListLease(leaseID)
+	if !ok {
+		return false
+	}
+
+	deleted := l.deleteLease(hashKey, lease)
+	if !deleted {
+		return false
+	}
+
+	l.notifyWaiters(hashKey, lease, func(w Waiter) {
+		w.OnLeaseReleased()
+	})
+	return true
+}
+
+// ReleaseAll gives back all the granted leases and calls OnLeaseReleased of their waiters,
+// e.g. before the cache is replaced by an empty one
+func (c *Cache) ReleaseAll() {
+	for i := range c.leases {
+		l := &c.leases[i]
+		l.mut.Lock()
+		l.releaseAll()
+		l.mut.Unlock()
+	}
+}
+
+// Extend makes a granted lease expire after the duration from now,
+// not affected if the lease would expire earlier than before
+func (c *Cache) Extend(key []byte, leaseID uint64, d time.Duration) (affected bool) {
+	hashKey, l := c.getLeaseList(key)
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	lease, ok := c.toListLease(leaseID)
+	if !ok {
+		return false
+	}
+	return l.extendLease(hashKey, lease, c.getNow(), durationToMillis(d))
+}
+
+// Invalidate an entry from the cache, including its stale value
 func (c *Cache) Invalidate(key []byte) (affected bool) {
 	hashKey, l := c.getLeaseList(key)
 
@@ -119,7 +366,159 @@ func (c *Cache) Invalidate(key []byte) (affected bool) {
 
 	l.forceDelete(hashKey)
 
-	return c.cache.Delete(key)
+	c.removeKey(key, l)
+
+	// a stale value alone is not counted as affected
+	_, recordType := c.readRecord(key, nil)
+	deleted := c.cache.Delete(key)
+	return deleted && (recordType == recordTypeValue || recordType == recordTypeTagged)
+}
+
+// MarkStale invalidates an entry but keeps its value as a stale value.
+// Until the next successful Set, Get returns the stale value together with a lease
+// for the first caller and the stale value alone for the others
+func (c *Cache) MarkStale(key []byte) (affected bool) {
+	hashKey, l := c.getLeaseList(key)
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.forceDelete(hashKey)
+
+	value, ok := c.getCurrentValue(key)
+	if !ok {
+		return false
+	}
+
+	c.putRecord(key, recordTypeStale, value)
+	c.removeKey(key, l)
+	return true
+}
+
+// getCurrentValue returns a copy of the value or the tagged value of the key.
+// Must be called while holding the lock of the lease list
+func (c *Cache) getCurrentValue(key []byte) ([]byte, bool) {
+	value, recordType := c.readValue(key)
+	switch recordType {
+	case recordTypeValue:
+		return value, true
+	case recordTypeTagged:
+		size, ok := c.readTagged(key, value, len(value))
+		return value[:size], ok
+	default:
+		return nil, false
+	}
+}
+
+// BucketStats is the lease table statistics of a lease bucket
+type BucketStats struct {
+	// ForcedEvictions is the number of live leases evicted because the lease list is full
+	ForcedEvictions uint64
+	// Overflowed is the number of leases stored in the overflow map
+	Overflowed uint64
+	// OverflowSize is the current number of leases in the overflow map
+	OverflowSize int
+	// Expired is the number of leases removed after their timeout, without being used by Set
+	Expired uint64
+}
+
+// GetBucketStats returns the lease table statistics of every bucket
+func (c *Cache) GetBucketStats() []BucketStats {
+	result := make([]BucketStats, len(c.leases))
+	for i := range c.leases {
+		l := &c.leases[i]
+
+		l.mut.Lock()
+		result[i].ForcedEvictions = l.evictions
+		result[i].OverflowSize = l.overflowSize()
+		if l.ext != nil {
+			result[i].Overflowed = l.ext.overflowed
+			result[i].Expired = l.ext.expired
+		}
+		l.mut.Unlock()
+	}
+	return result
+}
+
+// KeyInfo is the debugging information of a key
+type KeyInfo struct {
+	// Stored is true when the value of the key is in the cache
+	Stored    bool
+	ValueSize int
+
+	// Stale is true when only the stale value of the key is in the cache
+	Stale     bool
+	StaleSize int
+
+	// LeaseID is the outstanding lease of the key, zero if there is none
+	LeaseID  uint64
+	LeaseAge time.Duration
+
+	// BucketIndex is the index of the lease bucket of the key
+	BucketIndex uint32
+	// BucketLeases is the number of outstanding leases in the bucket, including the overflow map
+	BucketLeases int
+	// BucketSize is the number of lease entries of the bucket, not including the overflow map
+	BucketSize int
+}
+
+// Inspect returns the debugging information of a key without granting any lease,
+// the value is read without changing the hit and access counts
+func (c *Cache) Inspect(key []byte) KeyInfo {
+	hash := c.hashFunc(key)
+	hashKey, index := computeHashKeyAndIndex(hash, c.mask)
+	l := &c.leases[index]
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	info := KeyInfo{
+		BucketIndex: index,
+		BucketSize:  len(l.list),
+	}
+	c.inspectValue(key, &info)
+
+	now := c.getNow()
+	info.BucketLeases = l.countLeases(now)
+
+	e := l.findEntry(hashKey)
+	if e.lease > 0 && e.expireAt > now {
+		info.LeaseID = c.toLeaseID(e.lease)
+		info.LeaseAge = time.Duration(now-e.createdAt) * time.Millisecond
+	}
+	return info
+}
+
+// inspectValue sets the value fields of info from the record of the key.
+// Must be called while holding the lock of the lease list
+func (c *Cache) inspectValue(key []byte, info *KeyInfo) {
+	data, ok := c.peekRecord(key)
+	if !ok {
+		return
+	}
+	switch data[0] {
+	case recordTypeValue:
+		info.Stored, info.ValueSize = true, len(data)-recordHeaderSize
+	case recordTypeStale:
+		info.Stale, info.StaleSize = true, len(data)-recordHeaderSize
+	case recordTypeTagged:
+		offset, valid := c.tags.validate(data[recordHeaderSize:])
+		if valid {
+			info.Stored, info.ValueSize = true, len(data)-recordHeaderSize-offset
+		}
+	}
+}
+
+// GetHitCount returns the number of hits of bigcache, not including the reads of Inspect
+func (c *Cache) GetHitCount() uint64 {
+	peeks := atomic.LoadUint64(&c.peekHits)
+	return c.cache.GetHitCount() - peeks
+}
+
+// GetAccessCount returns the number of accesses of bigcache, not including the reads of Inspect
+func (c *Cache) GetAccessCount() uint64 {
+	peeks := atomic.LoadUint64(&c.peekAccesses)
+	return c.cache.GetAccessCount() - peeks
 }
 
 // GetUnsafeInnerCache returns the bigcache
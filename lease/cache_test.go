@@ -8,6 +8,18 @@ import (
 	"time"
 )
 
+type fakeClock struct {
+	now time.Duration
+}
+
+func (c *fakeClock) Now() time.Duration {
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now += d
+}
+
 func assertEqualBytes(t *testing.T, expected, actual []byte) {
 	t.Helper()
 	assert.Equal(t, expected, actual)
@@ -18,6 +30,12 @@ func assertEqualGetStatus(t *testing.T, expected, actual GetStatus) {
 	assert.Equal(t, expected, actual)
 }
 
+// assertEqualLeaseID checks the lease ID equals the lease in the lease list combined with the epoch of the cache
+func assertEqualLeaseID(t *testing.T, m *Cache, expected uint32, leaseID uint64) {
+	t.Helper()
+	assert.Equal(t, m.toLeaseID(expected), leaseID)
+}
+
 func TestCeilPowerOfTwo(t *testing.T) {
 	result := ceilPowerOfTwo(100)
 	assertEqualUint32(t, 128, result)
@@ -53,12 +71,12 @@ func TestCache_Get_Rejected(t *testing.T) {
 	result := m.Get(key1, data)
 
 	assert.Equal(t, 0, result.ValueSize)
-	assertEqualUint32(t, 1, result.LeaseID)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
 	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
 
 	result = m.Get(key1, data)
 	assert.Equal(t, 0, result.ValueSize)
-	assertEqualUint32(t, 0, result.LeaseID)
+	assert.Equal(t, uint64(0), result.LeaseID)
 	assertEqualGetStatus(t, GetStatusLeaseRejected, result.Status)
 
 	assert.Equal(t, uint64(2), m.GetUnsafeInnerCache().GetAccessCount())
@@ -73,7 +91,7 @@ func TestCache_Set_OK(t *testing.T) {
 	result := m.Get(key1, data)
 
 	assert.Equal(t, 0, result.ValueSize)
-	assertEqualUint32(t, 1, result.LeaseID)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
 	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
 
 	affected := m.Set(key1, result.LeaseID, []byte("value1"))
@@ -81,7 +99,7 @@ func TestCache_Set_OK(t *testing.T) {
 
 	result = m.Get(key1, data)
 	assertEqualBytes(t, []byte("value1"), data[:result.ValueSize])
-	assertEqualUint32(t, 0, result.LeaseID)
+	assert.Equal(t, uint64(0), result.LeaseID)
 	assertEqualGetStatus(t, GetStatusFound, result.Status)
 
 	assert.Equal(t, uint64(2), m.GetUnsafeInnerCache().GetAccessCount())
@@ -103,7 +121,7 @@ func TestCache_Set_Not_Affected_After_Invalidate(t *testing.T) {
 
 	result = m.Get(key1, data)
 	assert.Equal(t, 0, result.ValueSize)
-	assertEqualUint32(t, 2, result.LeaseID)
+	assertEqualLeaseID(t, m, 2, result.LeaseID)
 	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
 }
 
@@ -122,7 +140,7 @@ func TestCache_Invalidate_Affected(t *testing.T) {
 
 	result = m.Get(key1, data)
 	assert.Equal(t, 0, result.ValueSize)
-	assertEqualUint32(t, 2, result.LeaseID)
+	assertEqualLeaseID(t, m, 2, result.LeaseID)
 	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
 }
 
@@ -134,7 +152,7 @@ func TestCache_Double_Set_Not_OK(t *testing.T) {
 	result := m.Get(key1, data)
 
 	assert.Equal(t, 0, result.ValueSize)
-	assertEqualUint32(t, 1, result.LeaseID)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
 	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
 
 	affected := m.Set(key1, result.LeaseID, []byte("value1"))
@@ -145,57 +163,533 @@ func TestCache_Double_Set_Not_OK(t *testing.T) {
 
 	result = m.Get(key1, data)
 	assertEqualBytes(t, []byte("value1"), data[:result.ValueSize])
-	assertEqualUint32(t, 0, result.LeaseID)
+	assert.Equal(t, uint64(0), result.LeaseID)
 	assertEqualGetStatus(t, GetStatusFound, result.Status)
 }
 
 func TestCache_Get_Second_Times_After_Lease_Timeout(t *testing.T) {
-	m := New(8, 1<<20, WithLeaseTimeout(2))
+	clock := &fakeClock{}
+	m := New(8, 1<<20, WithLeaseTimeout(2), WithClock(clock))
 
 	key := []byte("key")
 	data := make([]byte, 1000)
 	result := m.Get(key, data)
 	assert.Equal(t, 0, result.ValueSize)
-	assertEqualUint32(t, 1, result.LeaseID)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
 	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
 
-	time.Sleep(3 * time.Second)
+	clock.advance(3 * time.Second)
 
 	result = m.Get(key, data)
 	assert.Equal(t, 0, result.ValueSize)
-	assertEqualUint32(t, 2, result.LeaseID)
+	assertEqualLeaseID(t, m, 2, result.LeaseID)
 	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
 }
 
 func TestCache_Get_Second_Times_Before_Lease_Timeout(t *testing.T) {
-	m := New(8, 1<<20, WithLeaseTimeout(2))
+	clock := &fakeClock{}
+	m := New(8, 1<<20, WithLeaseTimeout(2), WithClock(clock))
 
 	key := []byte("key")
 	data := make([]byte, 1000)
 	result := m.Get(key, data)
 	assert.Equal(t, 0, result.ValueSize)
-	assertEqualUint32(t, 1, result.LeaseID)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
 	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
 
-	time.Sleep(1 * time.Second)
+	clock.advance(1 * time.Second)
 
 	result = m.Get(key, data)
 	assert.Equal(t, 0, result.ValueSize)
-	assertEqualUint32(t, 0, result.LeaseID)
+	assert.Equal(t, uint64(0), result.LeaseID)
 	assertEqualGetStatus(t, GetStatusLeaseRejected, result.Status)
 }
 
+func TestCache_MarkStale_Get_Stale_With_Lease(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+
+	affected := m.Set(key1, result.LeaseID, []byte("value1"))
+	assert.True(t, affected)
+
+	affected = m.MarkStale(key1)
+	assert.True(t, affected)
+
+	result = m.Get(key1, data)
+	assertEqualBytes(t, []byte("value1"), data[:result.ValueSize])
+	assertEqualLeaseID(t, m, 2, result.LeaseID)
+	assertEqualGetStatus(t, GetStatusStaleLeaseGranted, result.Status)
+
+	data = make([]byte, 1000)
+	result = m.Get(key1, data)
+	assertEqualBytes(t, []byte("value1"), data[:result.ValueSize])
+	assert.Equal(t, uint64(0), result.LeaseID)
+	assertEqualGetStatus(t, GetStatusStaleLeaseRejected, result.Status)
+}
+
+func TestCache_MarkStale_Then_Set_Removes_Stale(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+	m.Set(key1, result.LeaseID, []byte("value1"))
+
+	m.MarkStale(key1)
+
+	result = m.Get(key1, data)
+	affected := m.Set(key1, result.LeaseID, []byte("value2"))
+	assert.True(t, affected)
+
+	result = m.Get(key1, data)
+	assertEqualBytes(t, []byte("value2"), data[:result.ValueSize])
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+
+	affected = m.Invalidate(key1)
+	assert.True(t, affected)
+
+	result = m.Get(key1, data)
+	assert.Equal(t, 0, result.ValueSize)
+	assertEqualLeaseID(t, m, 3, result.LeaseID)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+	assert.Equal(t, uint64(0), m.GetUnsafeInnerCache().GetTotal())
+}
+
+func TestCache_MarkStale_Revoke_Previous_Lease(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+	m.Set(key1, result.LeaseID, []byte("value1"))
+
+	m.MarkStale(key1)
+	result = m.Get(key1, data)
+	assertEqualGetStatus(t, GetStatusStaleLeaseGranted, result.Status)
+
+	m.MarkStale(key1)
+	affected := m.Set(key1, result.LeaseID, []byte("value2"))
+	assert.False(t, affected)
+
+	result = m.Get(key1, data)
+	assertEqualBytes(t, []byte("value1"), data[:result.ValueSize])
+	assertEqualGetStatus(t, GetStatusStaleLeaseGranted, result.Status)
+}
+
+func TestCache_MarkStale_Not_Found(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	affected := m.MarkStale(key1)
+	assert.False(t, affected)
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+}
+
+func TestCache_Invalidate_Removes_Stale(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+	m.Set(key1, result.LeaseID, []byte("value1"))
+
+	m.MarkStale(key1)
+
+	affected := m.Invalidate(key1)
+	assert.False(t, affected)
+
+	result = m.Get(key1, data)
+	assert.Equal(t, 0, result.ValueSize)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+}
+
+func TestCache_Release(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
+
+	affected := m.Release(key1, m.toLeaseID(2))
+	assert.False(t, affected)
+
+	affected = m.Release(key1, result.LeaseID)
+	assert.True(t, affected)
+
+	affected = m.Set(key1, result.LeaseID, []byte("value1"))
+	assert.False(t, affected)
+
+	result = m.Get(key1, data)
+	assertEqualLeaseID(t, m, 2, result.LeaseID)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+}
+
+func TestCache_Extend(t *testing.T) {
+	clock := &fakeClock{}
+	m := New(4, 1<<20, WithLeaseTimeout(1), WithClock(clock))
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
+
+	affected := m.Extend(key1, result.LeaseID, 5*time.Second)
+	assert.True(t, affected)
+
+	clock.advance(2 * time.Second)
+
+	result = m.Get(key1, data)
+	assertEqualGetStatus(t, GetStatusLeaseRejected, result.Status)
+
+	affected = m.Set(key1, m.toLeaseID(1), []byte("value1"))
+	assert.True(t, affected)
+}
+
+func TestCache_Extend_Not_Shorten(t *testing.T) {
+	clock := &fakeClock{}
+	m := New(4, 1<<20, WithLeaseTimeout(5), WithClock(clock))
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+
+	affected := m.Extend(key1, result.LeaseID, time.Second)
+	assert.False(t, affected)
+
+	clock.advance(2 * time.Second)
+	result = m.Get(key1, data)
+	assertEqualGetStatus(t, GetStatusLeaseRejected, result.Status)
+}
+
+type testWaiter struct {
+	values   [][]byte
+	released int
+}
+
+func (w *testWaiter) OnValue(value []byte) {
+	w.values = append(w.values, append([]byte(nil), value...))
+}
+
+func (w *testWaiter) OnLeaseReleased() {
+	w.released++
+}
+
+func TestCache_GetOrWait_Granted(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	w := &testWaiter{}
+	data := make([]byte, 1000)
+	result := m.GetOrWait(key1, data, w)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	affected := m.Set(key1, result.LeaseID, []byte("value1"))
+	assert.True(t, affected)
+	assert.Equal(t, 0, len(w.values))
+}
+
+func TestCache_GetOrWait_Notified_By_Set(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+
+	w1 := &testWaiter{}
+	w2 := &testWaiter{}
+
+	waitResult := m.GetOrWait(key1, data, w1)
+	assertEqualGetStatus(t, GetStatusLeaseWaiting, waitResult.Status)
+
+	waitResult = m.GetOrWait(key1, data, w2)
+	assertEqualGetStatus(t, GetStatusLeaseWaiting, waitResult.Status)
+
+	affected := m.Set(key1, result.LeaseID, []byte("value1"))
+	assert.True(t, affected)
+
+	assert.Equal(t, [][]byte{[]byte("value1")}, w1.values)
+	assert.Equal(t, [][]byte{[]byte("value1")}, w2.values)
+	assert.Equal(t, 0, w1.released)
+
+	result = m.GetOrWait(key1, data, w1)
+	assertEqualBytes(t, []byte("value1"), data[:result.ValueSize])
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+}
+
+func TestCache_GetOrWait_Released_By_Invalidate(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+
+	w := &testWaiter{}
+	m.GetOrWait(key1, data, w)
+
+	m.Invalidate(key1)
+	assert.Equal(t, 1, w.released)
+
+	affected := m.Set(key1, result.LeaseID, []byte("value1"))
+	assert.False(t, affected)
+	assert.Equal(t, 0, len(w.values))
+}
+
+func TestCache_GetOrWait_Released_By_Lease_Timeout(t *testing.T) {
+	clock := &fakeClock{}
+	m := New(4, 1<<20, WithLeaseTimeout(1), WithClock(clock))
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	m.Get(key1, data)
+
+	w := &testWaiter{}
+	m.GetOrWait(key1, data, w)
+
+	clock.advance(2 * time.Second)
+
+	result := m.Get(key1, data)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+	assert.Equal(t, 1, w.released)
+}
+
+func TestCache_GetOrWait_Released_By_Release(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+
+	w := &testWaiter{}
+	m.GetOrWait(key1, data, w)
+
+	m.Release(key1, result.LeaseID)
+	assert.Equal(t, 1, w.released)
+}
+
+func TestCache_ReleaseAll(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+	key2 := []byte("key2")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+	m.Get(key2, data)
+
+	w := &testWaiter{}
+	m.GetOrWait(key1, data, w)
+
+	m.ReleaseAll()
+	assert.Equal(t, 1, w.released)
+	assert.Equal(t, false, m.Set(key1, result.LeaseID, []byte("value")))
+	assertEqualGetStatus(t, GetStatusLeaseGranted, m.Get(key2, data).Status)
+}
+
+func TestCache_GetOrWait_Max_Waiters(t *testing.T) {
+	m := New(4, 1<<20, WithNumBuckets(1), WithMaxWaiters(2))
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+
+	w1 := &testWaiter{}
+	w2 := &testWaiter{}
+	w3 := &testWaiter{}
+	assertEqualGetStatus(t, GetStatusLeaseWaiting, m.GetOrWait(key1, data, w1).Status)
+	assertEqualGetStatus(t, GetStatusLeaseWaiting, m.GetOrWait(key1, data, w2).Status)
+	assertEqualGetStatus(t, GetStatusLeaseRejected, m.GetOrWait(key1, data, w3).Status)
+
+	m.Release(key1, result.LeaseID)
+	assert.Equal(t, 1, w1.released)
+	assert.Equal(t, 1, w2.released)
+	assert.Equal(t, 0, w3.released)
+}
+
+func TestCache_CancelWait(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+
+	w1 := &testWaiter{}
+	w2 := &testWaiter{}
+	m.GetOrWait(key1, data, w1)
+	m.GetOrWait(key1, data, w2)
+
+	assert.True(t, m.CancelWait(key1, w1))
+	assert.False(t, m.CancelWait(key1, w1))
+
+	m.Set(key1, result.LeaseID, []byte("value1"))
+	assert.Equal(t, 0, len(w1.values))
+	assert.Equal(t, 1, len(w2.values))
+
+	assert.False(t, m.CancelWait(key1, w2))
+}
+
+func TestCache_Lease_Timeout_Millisecond(t *testing.T) {
+	clock := &fakeClock{}
+	m := New(4, 1<<20, WithLeaseTimeoutDuration(150*time.Millisecond), WithClock(clock))
+
+	key := []byte("key")
+	data := make([]byte, 1000)
+	result := m.Get(key, data)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	clock.advance(149 * time.Millisecond)
+
+	result = m.Get(key, data)
+	assertEqualGetStatus(t, GetStatusLeaseRejected, result.Status)
+
+	clock.advance(1 * time.Millisecond)
+
+	result = m.Get(key, data)
+	assertEqualLeaseID(t, m, 2, result.LeaseID)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+}
+
+func TestCache_Extend_Millisecond(t *testing.T) {
+	clock := &fakeClock{}
+	m := New(4, 1<<20, WithLeaseTimeoutDuration(100*time.Millisecond), WithClock(clock))
+
+	key := []byte("key")
+	data := make([]byte, 1000)
+	result := m.Get(key, data)
+
+	clock.advance(50 * time.Millisecond)
+	affected := m.Extend(key, result.LeaseID, 80*time.Millisecond)
+	assert.True(t, affected)
+
+	clock.advance(79 * time.Millisecond)
+	result = m.Get(key, data)
+	assertEqualGetStatus(t, GetStatusLeaseRejected, result.Status)
+
+	clock.advance(1 * time.Millisecond)
+	result = m.Get(key, data)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+}
+
 func TestComputeHashAndIndex(t *testing.T) {
 	hash := uint64(0xaabbccdd11223344)
 	key, index := computeHashKeyAndIndex(hash, 0xff)
-	if key != 0xaabbccdd {
-		t.Error("expected 0xaabbccdd, actual:", key)
+	if key != 0xaabbccdd11223344 {
+		t.Error("expected 0xaabbccdd11223344, actual:", key)
 	}
 	if index != 0x44 {
 		t.Error("expected 0x44, actual:", index)
 	}
 }
 
+// newCacheWithHashes returns a cache that uses the hashes instead of the real hash of keys
+func newCacheWithHashes(hashes map[string]uint64) *Cache {
+	m := New(4, 1<<20, WithNumBuckets(16))
+	m.hashFunc = func(data []byte) uint64 {
+		return hashes[string(data)]
+	}
+	return m
+}
+
+func TestCache_Hash_Collision_Of_Upper_32_Bits_Not_Rejected(t *testing.T) {
+	key1 := []byte("key1")
+	key2 := []byte("key2")
+	m := newCacheWithHashes(map[string]uint64{
+		"key1": 0xaabbccdd00000013,
+		"key2": 0xaabbccdd00000123,
+	})
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	result = m.Get(key2, data)
+	assertEqualLeaseID(t, m, 2, result.LeaseID)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+}
+
+// keys with the same 64-bit hash share their leases, but never their values
+func TestCache_Hash_Collision_Of_Full_64_Bits(t *testing.T) {
+	key1 := []byte("key1")
+	key2 := []byte("key2")
+	m := newCacheWithHashes(map[string]uint64{
+		"key1": 0xaabbccdd00000013,
+		"key2": 0xaabbccdd00000013,
+	})
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	result = m.Get(key2, data)
+	assertEqualGetStatus(t, GetStatusLeaseRejected, result.Status)
+
+	affected := m.Set(key1, m.toLeaseID(1), []byte("value1"))
+	assert.True(t, affected)
+
+	result = m.Get(key2, data)
+	assertEqualLeaseID(t, m, 2, result.LeaseID)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	affected = m.Set(key2, result.LeaseID, []byte("value2"))
+	assert.True(t, affected)
+
+	result = m.Get(key1, data)
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+	assertEqualBytes(t, []byte("value1"), data[:result.ValueSize])
+
+	result = m.Get(key2, data)
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+	assertEqualBytes(t, []byte("value2"), data[:result.ValueSize])
+}
+
+func TestCache_Hash_Collision_Of_Upper_32_Bits_Set_Not_Consume_Other_Lease(t *testing.T) {
+	key1 := []byte("key1")
+	key2 := []byte("key2")
+	m := newCacheWithHashes(map[string]uint64{
+		"key1": 0xaabbccdd00000013,
+		"key2": 0xaabbccdd00000123,
+	})
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
+
+	affected := m.Set(key2, result.LeaseID, []byte("value2"))
+	assert.False(t, affected)
+
+	affected = m.Set(key1, result.LeaseID, []byte("value1"))
+	assert.True(t, affected)
+
+	result = m.Get(key2, data)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+}
+
+func TestCache_Hash_Collision_Of_Upper_32_Bits_Invalidate_Not_Revoke_Other_Lease(t *testing.T) {
+	key1 := []byte("key1")
+	key2 := []byte("key2")
+	m := newCacheWithHashes(map[string]uint64{
+		"key1": 0xaabbccdd00000013,
+		"key2": 0xaabbccdd00000123,
+	})
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+
+	m.Invalidate(key2)
+
+	affected := m.Set(key1, result.LeaseID, []byte("value1"))
+	assert.True(t, affected)
+}
+
 func BenchmarkGetSet(b *testing.B) {
 	b.StopTimer()
 
@@ -240,3 +734,211 @@ func BenchmarkParallelGetSet(b *testing.B) {
 		fmt.Println(noopCount)
 	})
 }
+
+func TestCache_GetBucketStats(t *testing.T) {
+	m := New(4, 1<<20, WithNumBuckets(1), WithLeaseListSize(2))
+
+	data := make([]byte, 1000)
+	m.Get([]byte("key1"), data)
+	m.Get([]byte("key2"), data)
+	m.Get([]byte("key3"), data)
+
+	assert.Equal(t, []BucketStats{{ForcedEvictions: 1}}, m.GetBucketStats())
+}
+
+func TestCache_GetBucketStats_With_Overflow(t *testing.T) {
+	m := New(4, 1<<20, WithNumBuckets(1), WithLeaseListSize(2), WithLeaseOverflow(4))
+
+	data := make([]byte, 1000)
+	m.Get([]byte("key1"), data)
+	m.Get([]byte("key2"), data)
+	result := m.Get([]byte("key3"), data)
+	assertEqualLeaseID(t, m, 3, result.LeaseID)
+
+	assert.Equal(t, []BucketStats{{Overflowed: 1, OverflowSize: 1}}, m.GetBucketStats())
+
+	affected := m.Set([]byte("key3"), result.LeaseID, []byte("value3"))
+	assert.True(t, affected)
+
+	assert.Equal(t, []BucketStats{{Overflowed: 1}}, m.GetBucketStats())
+}
+
+func TestCache_GetBucketStats_Expired(t *testing.T) {
+	clock := &fakeClock{}
+	m := New(4, 1<<20, WithNumBuckets(1), WithLeaseTimeout(2), WithClock(clock))
+
+	data := make([]byte, 1000)
+	result := m.Get([]byte("key1"), data)
+	m.Get([]byte("key2"), data)
+	m.Set([]byte("key1"), result.LeaseID, []byte("value1"))
+
+	clock.advance(3 * time.Second)
+	m.Get([]byte("key3"), data)
+
+	assert.Equal(t, []BucketStats{{Expired: 1}}, m.GetBucketStats())
+}
+
+func TestCache_LeaseID_Contains_Epoch(t *testing.T) {
+	m := New(4, 1<<20, WithLeaseEpoch(0x1234))
+
+	key1 := []byte("key1")
+	data := make([]byte, 1000)
+
+	result := m.Get(key1, data)
+	assert.Equal(t, uint64(0x1234)<<32|1, result.LeaseID)
+}
+
+func TestCache_Lease_Of_Previous_Epoch_Not_Accepted(t *testing.T) {
+	key1 := []byte("key1")
+	data := make([]byte, 1000)
+
+	m := New(4, 1<<20, WithLeaseEpoch(1))
+	previous := m.Get(key1, data)
+
+	m = New(4, 1<<20, WithLeaseEpoch(2))
+	result := m.Get(key1, data)
+	assert.Equal(t, uint64(2)<<32|1, result.LeaseID)
+
+	affected := m.Set(key1, previous.LeaseID, []byte("value1"))
+	assert.False(t, affected)
+
+	affected = m.Extend(key1, previous.LeaseID, 5*time.Second)
+	assert.False(t, affected)
+
+	affected = m.Release(key1, previous.LeaseID)
+	assert.False(t, affected)
+
+	affected = m.Set(key1, result.LeaseID, []byte("value1"))
+	assert.True(t, affected)
+}
+
+func TestCache_Default_Epochs_Are_Different(t *testing.T) {
+	m1 := New(4, 1<<20)
+	m2 := New(4, 1<<20)
+	assert.NotEqual(t, m1.epoch, m2.epoch)
+}
+
+func TestCache_Inspect(t *testing.T) {
+	clock := &fakeClock{now: time.Second}
+	m := New(4, 1<<20, WithNumBuckets(1), WithLeaseTimeout(2), WithClock(clock), WithLeaseEpoch(0))
+
+	key1 := []byte("key1")
+	key2 := []byte("key2")
+
+	assert.Equal(t, KeyInfo{BucketSize: 16}, m.Inspect(key1))
+
+	data := make([]byte, 1000)
+	m.Get(key1, data)
+	result := m.Get(key2, data)
+
+	clock.advance(300 * time.Millisecond)
+	assert.Equal(t, KeyInfo{
+		LeaseID:      1,
+		LeaseAge:     300 * time.Millisecond,
+		BucketLeases: 2,
+		BucketSize:   16,
+	}, m.Inspect(key1))
+
+	m.Set(key2, result.LeaseID, []byte("value2"))
+	hitCount, accessCount := m.GetHitCount(), m.GetAccessCount()
+	assert.Equal(t, KeyInfo{
+		Stored:       true,
+		ValueSize:    6,
+		BucketLeases: 1,
+		BucketSize:   16,
+	}, m.Inspect(key2))
+	assert.Equal(t, hitCount, m.GetHitCount())
+	assert.Equal(t, accessCount, m.GetAccessCount())
+
+	m.MarkStale(key2)
+	assert.Equal(t, KeyInfo{
+		Stale:        true,
+		StaleSize:    6,
+		BucketLeases: 1,
+		BucketSize:   16,
+	}, m.Inspect(key2))
+
+	clock.advance(2 * time.Second)
+	assert.Equal(t, KeyInfo{BucketSize: 16}, m.Inspect(key1))
+}
+
+func TestCache_Put_Revoke_Lease(t *testing.T) {
+	m := New(4, 1<<20)
+
+	key1 := []byte("key1")
+	data := make([]byte, 1000)
+
+	result := m.Get(key1, data)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	w := &testWaiter{}
+	waitResult := m.GetOrWait(key1, data, w)
+	assertEqualGetStatus(t, GetStatusLeaseWaiting, waitResult.Status)
+
+	m.Put(key1, []byte("value1"))
+	assert.Equal(t, 1, w.released)
+
+	size, ok := m.Lookup(key1, data)
+	assert.True(t, ok)
+	assertEqualBytes(t, []byte("value1"), data[:size])
+
+	affected := m.Set(key1, result.LeaseID, []byte("value2"))
+	assert.False(t, affected)
+}
+
+func TestCache_Lookup_Not_Grant_Lease(t *testing.T) {
+	m := New(4, 1<<20)
+
+	key1 := []byte("key1")
+	data := make([]byte, 1000)
+
+	_, ok := m.Lookup(key1, data)
+	assert.False(t, ok)
+
+	result := m.Get(key1, data)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+	assertEqualLeaseID(t, m, 1, result.LeaseID)
+}
+
+func TestCache_Stale_Value_Not_Reachable_By_Other_Keys(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+	staleAlias := []byte("key1\x00")
+
+	data := make([]byte, 1000)
+	result := m.Get(key1, data)
+	m.Set(key1, result.LeaseID, []byte("value1"))
+	m.MarkStale(key1)
+
+	result = m.Get(staleAlias, data)
+	assert.Equal(t, 0, result.ValueSize)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	affected := m.Set(staleAlias, result.LeaseID, []byte("forged"))
+	assert.True(t, affected)
+
+	result = m.Get(key1, data)
+	assertEqualBytes(t, []byte("value1"), data[:result.ValueSize])
+	assertEqualGetStatus(t, GetStatusStaleLeaseGranted, result.Status)
+}
+
+func TestCache_Get_Value_Of_Buffer_Size(t *testing.T) {
+	m := New(4, 1<<20)
+	key1 := []byte("key1")
+
+	m.Put(key1, []byte("value1"))
+
+	data := make([]byte, 6)
+	result := m.Get(key1, data)
+	assertEqualBytes(t, []byte("value1"), data[:result.ValueSize])
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+
+	data = make([]byte, 5)
+	result = m.Get(key1, data)
+	assert.Equal(t, 6, result.ValueSize)
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+
+	result = m.Get(key1, nil)
+	assert.Equal(t, 6, result.ValueSize)
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+}
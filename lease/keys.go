@@ -0,0 +1,112 @@
+package lease
+
+// rangeBatchSize is the maximum number of values copied while holding the lock of a lease list
+const rangeBatchSize = 256
+
+// minKeysPruneAt is the number of keys of a lease list before the evicted keys are removed for the first time
+const minKeysPruneAt = 64
+
+// addKey remembers the key in the lease list. Bigcache does not notify evictions, so the keys
+// whose values have been evicted are removed when the number of keys doubles since the last removal,
+// keeping the number of keys proportional to the number of values in the cache.
+// Must be called while holding the lock of the lease list
+func (c *Cache) addKey(key []byte, l *leaseList) {
+	if !c.trackKeys {
+		return
+	}
+	ext := l.getExt()
+	if ext.keys == nil {
+		ext.keys = map[string]struct{}{}
+		ext.keysPruneAt = minKeysPruneAt
+	}
+	ext.keys[string(key)] = struct{}{}
+
+	if len(ext.keys) >= ext.keysPruneAt {
+		c.pruneKeys(ext)
+	}
+}
+
+// pruneKeys removes the keys that no longer have a value
+func (c *Cache) pruneKeys(ext *leaseListExt) {
+	for key := range ext.keys {
+		if _, recordType := c.readRecord([]byte(key), nil); recordType != recordTypeValue {
+			delete(ext.keys, key)
+		}
+	}
+	ext.keysPruneAt = 2 * len(ext.keys)
+	if ext.keysPruneAt < minKeysPruneAt {
+		ext.keysPruneAt = minKeysPruneAt
+	}
+}
+
+func (c *Cache) removeKey(key []byte, l *leaseList) {
+	if !c.trackKeys || l.ext == nil {
+		return
+	}
+	delete(l.ext.keys, string(key))
+}
+
+// KeyValue is a value of the cache with its key
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// Range calls fn with the values set by Set and Put, stale and tagged values are not included.
+// Key tracking must be enabled by WithKeyTracking. The values are copied in small batches
+// while holding the lock of a lease list, fn is called without holding any lock.
+// Keys evicted from the cache are forgotten. Range stops when fn returns false
+func (c *Cache) Range(fn func(kv KeyValue) bool) {
+	if !c.trackKeys {
+		return
+	}
+	var batch []KeyValue
+	for i := range c.leases {
+		l := &c.leases[i]
+		keys := l.getKeys()
+		for len(keys) > 0 {
+			n := len(keys)
+			if n > rangeBatchSize {
+				n = rangeBatchSize
+			}
+			batch = c.copyValues(l, keys[:n], batch[:0])
+			keys = keys[n:]
+
+			for _, kv := range batch {
+				if !fn(kv) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *leaseList) getKeys() []string {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.ext == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(l.ext.keys))
+	for key := range l.ext.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (c *Cache) copyValues(l *leaseList, keys []string, batch []KeyValue) []KeyValue {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	for _, key := range keys {
+		k := []byte(key)
+		value, recordType := c.readValue(k)
+		if recordType != recordTypeValue {
+			delete(l.ext.keys, key)
+			continue
+		}
+		batch = append(batch, KeyValue{Key: k, Value: value})
+	}
+	return batch
+}
@@ -0,0 +1,98 @@
+package lease
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+func rangeAll(m *Cache) map[string]string {
+	result := map[string]string{}
+	m.Range(func(kv KeyValue) bool {
+		result[string(kv.Key)] = string(kv.Value)
+		return true
+	})
+	return result
+}
+
+func TestCache_Range(t *testing.T) {
+	m := New(8, 1<<20, WithKeyTracking())
+
+	m.Put([]byte("key01"), []byte("value01"))
+	m.Put([]byte("key02"), []byte("value02"))
+	m.Put([]byte("key02"), []byte("value02-new"))
+
+	result := m.Get([]byte("key03"), nil)
+	m.Set([]byte("key03"), result.LeaseID, []byte("value03"))
+
+	m.Put([]byte("key04"), []byte("value04"))
+	m.Invalidate([]byte("key04"))
+
+	m.Put([]byte("key05"), []byte("value05"))
+	m.MarkStale([]byte("key05"))
+
+	m.Put([]byte("key06"), []byte("value06"))
+	result = m.Get([]byte("key07"), nil)
+	m.SetWithTags([]byte("key07"), result.LeaseID, []byte("value07"), [][]byte{[]byte("tag01")})
+
+	assert.Equal(t, map[string]string{
+		"key01": "value01",
+		"key02": "value02-new",
+		"key03": "value03",
+		"key06": "value06",
+	}, rangeAll(m))
+}
+
+func TestCache_Range_Stop(t *testing.T) {
+	m := New(1, 1<<20, WithKeyTracking())
+	for _, key := range []string{"key01", "key02", "key03"} {
+		m.Put([]byte(key), []byte("value"))
+	}
+
+	var keys []string
+	m.Range(func(kv KeyValue) bool {
+		keys = append(keys, string(kv.Key))
+		return len(keys) < 2
+	})
+	assert.Equal(t, 2, len(keys))
+}
+
+func TestCache_Range_Forget_Evicted_Keys(t *testing.T) {
+	m := New(1, 1<<16, WithKeyTracking(), WithNumBuckets(1))
+
+	value := make([]byte, 1000)
+	for i := 0; i < 200; i++ {
+		m.Put([]byte{'k', byte(i)}, value)
+	}
+
+	result := rangeAll(m)
+	assert.True(t, len(result) < 200)
+	assert.Equal(t, len(result), len(m.leases[0].ext.keys))
+
+	keys := make([]string, 0, len(result))
+	for key := range result {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, string([]byte{'k', 199}), keys[len(keys)-1])
+}
+
+func TestCache_Keys_Pruned_Without_Range(t *testing.T) {
+	m := New(1, 1<<16, WithKeyTracking(), WithNumBuckets(1))
+
+	value := make([]byte, 1000)
+	for i := 0; i < 5000; i++ {
+		m.Put([]byte{'k', byte(i), byte(i >> 8)}, value)
+	}
+
+	ext := m.leases[0].ext
+	assert.True(t, len(ext.keys) < 200, len(ext.keys))
+	assert.True(t, len(ext.keys) < ext.keysPruneAt)
+	assert.True(t, len(rangeAll(m)) > 0)
+}
+
+func TestCache_Range_Without_Key_Tracking(t *testing.T) {
+	m := New(8, 1<<20)
+	m.Put([]byte("key01"), []byte("value01"))
+	assert.Equal(t, map[string]string{}, rangeAll(m))
+}
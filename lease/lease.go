@@ -1,24 +1,86 @@
 package lease
 
-import "sync"
+import (
+	"math"
+	"sync"
+)
 
+// hash is the full 64-bit hash of the key, so that keys in the same list
+// only share leases when their whole hashes are equal.
+// timestamps are in millisecond
 type leaseEntry struct {
-	hash      uint32
+	hash      uint64
+	createdAt uint64
+	expireAt  uint64
 	lease     uint32
-	createdAt uint32
 }
 
+// Waiter is notified when the lease it is waiting for is resolved.
+// Methods are called while holding the lock of the lease list,
+// implementations must not call back into the Cache
+type Waiter interface {
+	// OnValue is called when the lease holder has set the value
+	OnValue(value []byte)
+	// OnLeaseReleased is called when the lease is removed without a value
+	OnLeaseReleased()
+}
+
+type waiterEntry struct {
+	hash   uint64
+	lease  uint32
+	waiter Waiter
+}
+
+// leaseListExt contains the rarely used data of a lease list
+type leaseListExt struct {
+	waiters []waiterEntry
+
+	// leases that do not fit in the list, keyed by hash, disabled when overflowLimit is zero
+	overflow      map[uint64]leaseEntry
+	overflowLimit int
+	overflowed    uint64
+
+	expired uint64 // number of leases removed after their timeout
+
+	keys        map[string]struct{} // keys of the values, only when key tracking is enabled
+	keysPruneAt int                 // the evicted keys are removed when the number of keys reaches it
+}
+
+// the size of leaseList is exactly 64 bytes to eliminate cache line false sharing
 type leaseList struct {
 	mut       sync.Mutex
 	list      []leaseEntry
-	expire    uint32
+	expire    uint64 // in millisecond
 	nextLease uint32
-	_padding  [3]uint64 // to eliminate cache line false sharing
+
+	_         uint32        // padding
+	ext       *leaseListExt // lazily allocated
+	evictions uint64        // number of live leases evicted because the list is full
 }
 
-func (l *leaseList) init(size uint32, expire uint32) {
+func (l *leaseList) init(size uint32, expire uint64, overflowLimit int) {
 	l.list = make([]leaseEntry, size)
 	l.expire = expire
+	if overflowLimit > 0 {
+		l.ext = &leaseListExt{
+			overflow:      map[uint64]leaseEntry{},
+			overflowLimit: overflowLimit,
+		}
+	}
+}
+
+func (l *leaseList) getExt() *leaseListExt {
+	if l.ext == nil {
+		l.ext = &leaseListExt{}
+	}
+	return l.ext
+}
+
+func (l *leaseList) overflowSize() int {
+	if l.ext == nil {
+		return 0
+	}
+	return len(l.ext.overflow)
 }
 
 func (l *leaseList) increase() {
@@ -28,13 +90,49 @@ func (l *leaseList) increase() {
 	}
 }
 
-func (l *leaseList) getLease(hash uint32, now uint32) (uint32, bool) {
+func (l *leaseList) clearExpired(now uint64) {
+	l.removeLeases(now, true)
+}
+
+// releaseAll removes all leases, they are not counted as expired
+func (l *leaseList) releaseAll() {
+	l.removeLeases(math.MaxUint64, false)
+}
+
+// removeLeases removes the leases expiring at or before now and releases their waiters
+//
+//revive:disable-next-line:flag-parameter
+func (l *leaseList) removeLeases(now uint64, expired bool) {
 	for i, e := range l.list {
-		if e.createdAt+l.expire <= now {
+		if e.expireAt <= now {
+			l.countExpired(e, expired)
+			l.releaseWaiters(e)
 			l.list[i] = leaseEntry{}
 		}
 	}
 
+	if l.overflowSize() == 0 {
+		return
+	}
+	for hash, e := range l.ext.overflow {
+		if e.expireAt <= now {
+			l.countExpired(e, expired)
+			l.releaseWaiters(e)
+			delete(l.ext.overflow, hash)
+		}
+	}
+}
+
+//revive:disable-next-line:flag-parameter
+func (l *leaseList) countExpired(e leaseEntry, expired bool) {
+	if expired && e.lease > 0 {
+		l.getExt().expired++
+	}
+}
+
+func (l *leaseList) getLease(hash uint64, now uint64) (uint32, bool) {
+	l.clearExpired(now)
+
 	minLease := l.list[0].lease
 	minIndex := 0
 	for i, e := range l.list {
@@ -48,30 +146,196 @@ func (l *leaseList) getLease(hash uint32, now uint32) (uint32, bool) {
 		}
 	}
 
+	if l.overflowSize() > 0 {
+		if _, existed := l.ext.overflow[hash]; existed {
+			return 0, false
+		}
+	}
+
 	l.increase()
-	l.list[minIndex] = leaseEntry{
+	entry := leaseEntry{
 		hash:      hash,
 		lease:     l.nextLease,
 		createdAt: now,
+		expireAt:  now + l.expire,
+	}
+
+	if minLease > 0 {
+		if l.ext != nil && len(l.ext.overflow) < l.ext.overflowLimit {
+			l.ext.overflow[hash] = entry
+			l.ext.overflowed++
+			return l.nextLease, true
+		}
+		l.evictions++
 	}
 
+	l.releaseWaiters(l.list[minIndex])
+	l.list[minIndex] = entry
+
 	return l.nextLease, true
 }
 
-func (l *leaseList) deleteLease(hash uint32, lease uint32) bool {
+func (l *leaseList) deleteLease(hash uint64, lease uint32) bool {
 	for i, e := range l.list {
 		if e.hash == hash && e.lease == lease {
 			l.list[i] = leaseEntry{}
 			return true
 		}
 	}
+
+	if l.overflowSize() == 0 {
+		return false
+	}
+	e, ok := l.ext.overflow[hash]
+	if ok && e.lease == lease {
+		delete(l.ext.overflow, hash)
+		return true
+	}
 	return false
 }
 
-func (l *leaseList) forceDelete(hash uint32) {
+// extendLease makes the lease expire after the duration from now, a lease is never shortened
+func (l *leaseList) extendLease(hash uint64, lease uint32, now uint64, duration uint64) bool {
+	for i, e := range l.list {
+		if e.hash == hash && e.lease == lease && e.lease > 0 && e.expireAt > now {
+			return l.list[i].extend(now, now+duration)
+		}
+	}
+
+	if l.overflowSize() == 0 {
+		return false
+	}
+	e, ok := l.ext.overflow[hash]
+	if !ok || e.lease != lease {
+		return false
+	}
+	affected := e.extend(now, now+duration)
+	l.ext.overflow[hash] = e
+	return affected
+}
+
+func (e *leaseEntry) extend(now uint64, expireAt uint64) bool {
+	if e.expireAt <= now || expireAt < e.expireAt {
+		return false
+	}
+	e.expireAt = expireAt
+	return true
+}
+
+func (l *leaseList) forceDelete(hash uint64) {
 	for i, e := range l.list {
 		if e.hash == hash {
+			l.releaseWaiters(e)
 			l.list[i] = leaseEntry{}
 		}
 	}
+
+	if l.overflowSize() == 0 {
+		return
+	}
+	e, ok := l.ext.overflow[hash]
+	if ok {
+		l.releaseWaiters(e)
+		delete(l.ext.overflow, hash)
+	}
+}
+
+// findLease returns the currently granted lease of the hash, zero if not found
+func (l *leaseList) findLease(hash uint64) uint32 {
+	return l.findEntry(hash).lease
+}
+
+// findEntry returns the lease entry of the hash, an empty entry if not found
+func (l *leaseList) findEntry(hash uint64) leaseEntry {
+	for _, e := range l.list {
+		if e.hash == hash && e.lease > 0 {
+			return e
+		}
+	}
+
+	if l.overflowSize() == 0 {
+		return leaseEntry{}
+	}
+	return l.ext.overflow[hash]
+}
+
+// countLeases returns the number of leases that have not expired, including the overflow map
+func (l *leaseList) countLeases(now uint64) int {
+	count := 0
+	for _, e := range l.list {
+		if e.lease > 0 && e.expireAt > now {
+			count++
+		}
+	}
+
+	if l.overflowSize() == 0 {
+		return count
+	}
+	for _, e := range l.ext.overflow {
+		if e.expireAt > now {
+			count++
+		}
+	}
+	return count
+}
+
+func (l *leaseList) countWaiters() int {
+	if l.ext == nil {
+		return 0
+	}
+	return len(l.ext.waiters)
+}
+
+func (l *leaseList) addWaiter(hash uint64, lease uint32, w Waiter) {
+	ext := l.getExt()
+	ext.waiters = append(ext.waiters, waiterEntry{
+		hash:   hash,
+		lease:  lease,
+		waiter: w,
+	})
+}
+
+func (l *leaseList) removeWaiter(w Waiter) bool {
+	if l.ext == nil {
+		return false
+	}
+	entries := l.ext.waiters
+	for i, e := range entries {
+		if e.waiter == w {
+			last := len(entries) - 1
+			copy(entries[i:], entries[i+1:])
+			entries[last] = waiterEntry{}
+			l.ext.waiters = entries[:last]
+			return true
+		}
+	}
+	return false
+}
+
+// notifyWaiters calls fn for and removes every waiter of the lease
+func (l *leaseList) notifyWaiters(hash uint64, lease uint32, fn func(w Waiter)) {
+	if l.ext == nil || len(l.ext.waiters) == 0 || lease == 0 {
+		return
+	}
+
+	entries := l.ext.waiters
+	remaining := entries[:0]
+	for _, e := range entries {
+		if e.hash == hash && e.lease == lease {
+			fn(e.waiter)
+			continue
+		}
+		remaining = append(remaining, e)
+	}
+
+	for i := len(remaining); i < len(entries); i++ {
+		entries[i] = waiterEntry{}
+	}
+	l.ext.waiters = remaining
+}
+
+func (l *leaseList) releaseWaiters(e leaseEntry) {
+	l.notifyWaiters(e.hash, e.lease, func(w Waiter) {
+		w.OnLeaseReleased()
+	})
 }
@@ -16,7 +16,7 @@ func assertEqualUint32(t *testing.T, expected, value uint32) {
 
 func TestLeaseList_SameHash(t *testing.T) {
 	var l leaseList
-	l.init(4, 4000)
+	l.init(4, 4000, 0)
 
 	id, ok := l.getLease(1234, 1000)
 	assert.True(t, ok)
@@ -29,7 +29,7 @@ func TestLeaseList_SameHash(t *testing.T) {
 
 func TestLeaseList_SameHash_ReachExpireTime(t *testing.T) {
 	var l leaseList
-	l.init(4, 4000)
+	l.init(4, 4000, 0)
 
 	id, ok := l.getLease(1234, 1000)
 	assert.True(t, ok)
@@ -42,7 +42,7 @@ func TestLeaseList_SameHash_ReachExpireTime(t *testing.T) {
 
 func TestLeaseList_SecondLease(t *testing.T) {
 	var l leaseList
-	l.init(4, 4000)
+	l.init(4, 4000, 0)
 
 	l.getLease(1234, 1000)
 	id, ok := l.getLease(2200, 2000)
@@ -53,7 +53,7 @@ func TestLeaseList_SecondLease(t *testing.T) {
 
 func TestLeaseList_WithHashZero(t *testing.T) {
 	var l leaseList
-	l.init(4, 4000)
+	l.init(4, 4000, 0)
 
 	id, ok := l.getLease(0, 1000)
 	assert.True(t, ok)
@@ -66,7 +66,7 @@ func TestLeaseList_WithHashZero(t *testing.T) {
 
 func TestLeaseList_Delete(t *testing.T) {
 	var l leaseList
-	l.init(4, 4000)
+	l.init(4, 4000, 0)
 
 	l.getLease(100, 1000)
 	l.getLease(200, 2000)
@@ -82,7 +82,7 @@ func TestLeaseList_Delete(t *testing.T) {
 
 func TestLeaseList_Delete_WithDifferentLease(t *testing.T) {
 	var l leaseList
-	l.init(4, 4000)
+	l.init(4, 4000, 0)
 
 	l.getLease(100, 1000)
 	l.getLease(200, 2000)
@@ -98,7 +98,7 @@ func TestLeaseList_Delete_WithDifferentLease(t *testing.T) {
 
 func TestLeaseList_ForceDelete(t *testing.T) {
 	var l leaseList
-	l.init(4, 4000)
+	l.init(4, 4000, 0)
 
 	l.getLease(100, 1000)
 	l.getLease(200, 2000)
@@ -113,7 +113,7 @@ func TestLeaseList_ForceDelete(t *testing.T) {
 
 func TestLeaseList_GetLease_WhenFull(t *testing.T) {
 	var l leaseList
-	l.init(4, 4000)
+	l.init(4, 4000, 0)
 	l.getLease(100, 1000)
 	l.getLease(200, 1000)
 	l.getLease(300, 1000)
@@ -138,7 +138,7 @@ func TestLeaseList_GetLease_WhenFull(t *testing.T) {
 
 func TestLeaseList_GetLease_WhenFull_LeaseID_Check_Out_Of_Order(t *testing.T) {
 	var l leaseList
-	l.init(4, 4000)
+	l.init(4, 4000, 0)
 	l.getLease(100, 1000)
 	l.getLease(200, 1000)
 	l.getLease(300, 1000)
@@ -161,9 +161,104 @@ func TestLeaseList_GetLease_WhenFull_LeaseID_Check_Out_Of_Order(t *testing.T) {
 	assert.False(t, ok)
 }
 
+func TestLeaseList_GetLease_WhenFull_Count_Evictions(t *testing.T) {
+	var l leaseList
+	l.init(4, 4000, 0)
+	l.getLease(100, 1000)
+	l.getLease(200, 1000)
+	l.getLease(300, 1000)
+	l.getLease(400, 1000)
+	assert.Equal(t, uint64(0), l.evictions)
+
+	l.getLease(500, 1000)
+	l.getLease(600, 1000)
+	assert.Equal(t, uint64(2), l.evictions)
+
+	l.getLease(700, 5000)
+	assert.Equal(t, uint64(2), l.evictions)
+}
+
+func TestLeaseList_GetLease_WhenFull_With_Overflow(t *testing.T) {
+	var l leaseList
+	l.init(4, 4000, 2)
+	l.getLease(100, 1000)
+	l.getLease(200, 1000)
+	l.getLease(300, 1000)
+	l.getLease(400, 1000)
+
+	id, ok := l.getLease(500, 1000)
+	assert.True(t, ok)
+	assertEqualUint32(t, 5, id)
+
+	id, ok = l.getLease(500, 1000)
+	assert.False(t, ok)
+	assertEqualUint32(t, 0, id)
+
+	id, ok = l.getLease(100, 1000)
+	assert.False(t, ok)
+	assertEqualUint32(t, 0, id)
+
+	id, ok = l.getLease(600, 1000)
+	assert.True(t, ok)
+	assertEqualUint32(t, 6, id)
+
+	assert.Equal(t, uint64(0), l.evictions)
+	assert.Equal(t, uint64(2), l.ext.overflowed)
+	assert.Equal(t, 2, l.overflowSize())
+
+	// overflow map is full
+	id, ok = l.getLease(700, 1000)
+	assert.True(t, ok)
+	assertEqualUint32(t, 7, id)
+	assert.Equal(t, uint64(1), l.evictions)
+
+	id, ok = l.getLease(100, 1000)
+	assert.True(t, ok)
+	assertEqualUint32(t, 8, id)
+}
+
+func TestLeaseList_Overflow_Delete_Extend_Find(t *testing.T) {
+	var l leaseList
+	l.init(1, 4000, 2)
+	l.getLease(100, 1000)
+	l.getLease(200, 1000)
+	l.getLease(300, 1000)
+
+	assertEqualUint32(t, 2, l.findLease(200))
+	assertEqualUint32(t, 0, l.findLease(400))
+
+	affected := l.deleteLease(200, 3)
+	assert.False(t, affected)
+
+	affected = l.deleteLease(200, 2)
+	assert.True(t, affected)
+	assertEqualUint32(t, 0, l.findLease(200))
+
+	extended := l.extendLease(300, 3, 4500, 3000)
+	assert.True(t, extended)
+
+	l.forceDelete(100)
+	assert.Equal(t, 1, l.overflowSize())
+
+	// expired leases in the overflow map are cleared
+	id, ok := l.getLease(100, 7000)
+	assert.True(t, ok)
+	assertEqualUint32(t, 4, id)
+	assert.Equal(t, 1, l.overflowSize())
+
+	id, ok = l.getLease(300, 7000)
+	assert.False(t, ok)
+	assertEqualUint32(t, 0, id)
+
+	l.getLease(400, 7600)
+	assertEqualUint32(t, 0, l.findLease(300))
+	assertEqualUint32(t, 5, l.findLease(400))
+	assert.Equal(t, 1, l.overflowSize())
+}
+
 func TestLeaseList_SameHash_When_NextLease_Equal_Max(t *testing.T) {
 	var l leaseList
-	l.init(4, 4000)
+	l.init(4, 4000, 0)
 	l.nextLease = math.MaxUint32
 
 	id, ok := l.getLease(1234, 1000)
@@ -175,6 +270,45 @@ func TestLeaseList_SameHash_When_NextLease_Equal_Max(t *testing.T) {
 	assertEqualUint32(t, 0, id)
 }
 
+func TestLeaseList_ExtendLease(t *testing.T) {
+	var l leaseList
+	l.init(4, 4000, 0)
+
+	l.getLease(100, 1000)
+	l.getLease(200, 1000)
+
+	extended := l.extendLease(200, 2, 4500, 3000)
+	assert.True(t, extended)
+
+	id, ok := l.getLease(100, 5000)
+	assert.True(t, ok)
+	assertEqualUint32(t, 3, id)
+
+	id, ok = l.getLease(200, 7000)
+	assert.False(t, ok)
+	assertEqualUint32(t, 0, id)
+
+	id, ok = l.getLease(200, 7500)
+	assert.True(t, ok)
+	assertEqualUint32(t, 4, id)
+}
+
+func TestLeaseList_ExtendLease_Not_Found_Or_Expired(t *testing.T) {
+	var l leaseList
+	l.init(4, 4000, 0)
+
+	l.getLease(100, 1000)
+
+	extended := l.extendLease(100, 2, 2000, 3000)
+	assert.False(t, extended)
+
+	extended = l.extendLease(200, 1, 2000, 3000)
+	assert.False(t, extended)
+
+	extended = l.extendLease(100, 1, 5000, 3000)
+	assert.False(t, extended)
+}
+
 func TestLeaseListSize(t *testing.T) {
 	size := unsafe.Sizeof(leaseList{})
 	if size != 64 {
@@ -1,19 +1,46 @@
 package lease
 
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+)
+
 type cacheOptions struct {
 	numBuckets    uint32
 	entryListSize uint32
-	leaseTimeout  uint32
+	leaseTimeout  time.Duration
+	clock         Clock
+
+	overflowLimit int
+	epoch         uint32
+	trackKeys     bool
+	maxTags       int
+	maxWaiters    int
 }
 
 // Option ...
 type Option func(opts *cacheOptions)
 
+// newBootEpoch returns a random epoch, so that lease IDs are not reused after a restart
+func newBootEpoch() uint32 {
+	var data [4]byte
+	_, err := rand.Read(data[:])
+	if err != nil {
+		return uint32(time.Now().Unix())
+	}
+	return binary.LittleEndian.Uint32(data[:])
+}
+
 func computeOptions(options ...Option) cacheOptions {
 	result := cacheOptions{
 		numBuckets:    1024,
 		entryListSize: 16,
-		leaseTimeout:  30,
+		leaseTimeout:  30 * time.Second,
+		clock:         monotonicClock{},
+		epoch:         newBootEpoch(),
+		maxTags:       1 << 16,
+		maxWaiters:    1024,
 	}
 
 	for _, o := range options {
@@ -38,7 +65,58 @@ func WithLeaseListSize(n uint32) Option {
 
 // WithLeaseTimeout for duration of lease timeout, in second
 func WithLeaseTimeout(d uint32) Option {
+	return WithLeaseTimeoutDuration(time.Duration(d) * time.Second)
+}
+
+// WithLeaseTimeoutDuration for duration of lease timeout, in millisecond resolution
+func WithLeaseTimeoutDuration(d time.Duration) Option {
 	return func(opts *cacheOptions) {
 		opts.leaseTimeout = d
 	}
 }
+
+// WithClock configures the clock used for lease timestamps
+func WithClock(clock Clock) Option {
+	return func(opts *cacheOptions) {
+		opts.clock = clock
+	}
+}
+
+// WithLeaseOverflow keeps at most maxEntries leases per lease list in an overflow map
+// when the list is full, instead of evicting a live lease. Zero disables the overflow map
+func WithLeaseOverflow(maxEntries int) Option {
+	return func(opts *cacheOptions) {
+		opts.overflowLimit = maxEntries
+	}
+}
+
+// WithLeaseEpoch configures the high 32 bits of lease IDs, by default it is chosen randomly
+// when the cache is created, so that lease IDs granted before a restart are not valid after it
+func WithLeaseEpoch(epoch uint32) Option {
+	return func(opts *cacheOptions) {
+		opts.epoch = epoch
+	}
+}
+
+// WithKeyTracking remembers the keys of the values set by Set and Put, so that they can be
+// iterated by Range, at the cost of storing each key one more time
+func WithKeyTracking() Option {
+	return func(opts *cacheOptions) {
+		opts.trackKeys = true
+	}
+}
+
+// WithMaxDeletedTags configures the maximum number of deleted tags remembered by the cache,
+// when it is exceeded, the entries set before the older half of them are invalidated
+func WithMaxDeletedTags(n int) Option {
+	return func(opts *cacheOptions) {
+		opts.maxTags = n
+	}
+}
+
+// WithMaxWaiters configures the maximum number of waiters registered by GetOrWait in a lease bucket, default is 1024
+func WithMaxWaiters(n int) Option {
+	return func(opts *cacheOptions) {
+		opts.maxWaiters = n
+	}
+}
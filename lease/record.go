@@ -0,0 +1,118 @@
+package lease
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A key has at most one record in bigcache, stored under the key itself. The first byte of a record is its type,
+// so that the stale and tagged values of a key can not be read or overwritten through any other key
+const (
+	recordTypeNone byte = iota
+	recordTypeValue
+	recordTypeStale
+	recordTypeTagged
+)
+
+const recordHeaderSize = 1
+
+// recordPoolMaxSize is the maximum capacity of the buffers kept in recordPool
+const recordPoolMaxSize = 1 << 16
+
+var recordPool = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
+
+func (c *Cache) putRecord(key []byte, recordType byte, value []byte) {
+	buf := recordPool.Get().(*[]byte)
+	data := append((*buf)[:0], recordType)
+	data = append(data, value...)
+	c.cache.Put(key, data)
+
+	if cap(data) <= recordPoolMaxSize {
+		*buf = data
+		recordPool.Put(buf)
+	}
+}
+
+// readRecord copies the value of the record of the key into value, returns the size of the value
+// and the type of the record, recordTypeNone if the key has no record
+func (c *Cache) readRecord(key []byte, value []byte) (int, byte) {
+	if len(value) == 0 {
+		var header [recordHeaderSize]byte
+		return c.readRecordInto(key, header[:])
+	}
+
+	size, recordType := c.readRecordInto(key, value)
+	if recordType == recordTypeNone {
+		return 0, recordTypeNone
+	}
+	if size == len(value) {
+		// the value fits in the buffer but not together with the record type
+		data := make([]byte, recordHeaderSize+size)
+		size, recordType = c.readRecordInto(key, data)
+		if size <= len(value) {
+			copy(value, data[recordHeaderSize:recordHeaderSize+size])
+		}
+		return size, recordType
+	}
+
+	n := size + recordHeaderSize
+	if n > len(value) {
+		n = len(value)
+	}
+	copy(value, value[recordHeaderSize:n])
+	return size, recordType
+}
+
+// readRecordInto copies the record of the key into data, including the record type
+func (c *Cache) readRecordInto(key []byte, data []byte) (int, byte) {
+	size, ok := c.cache.Get(key, data)
+	if !ok || size < recordHeaderSize {
+		return 0, recordTypeNone
+	}
+	return size - recordHeaderSize, data[0]
+}
+
+// readValue returns a copy of the value of the record of the key
+func (c *Cache) readValue(key []byte) ([]byte, byte) {
+	size, recordType := c.readRecord(key, nil)
+	if recordType == recordTypeNone {
+		return nil, recordTypeNone
+	}
+	if size == 0 {
+		return []byte{}, recordType
+	}
+	value := make([]byte, size)
+	size, recordType = c.readRecord(key, value)
+	if size != len(value) {
+		return nil, recordTypeNone
+	}
+	return value, recordType
+}
+
+// peekRecord returns a copy of the record of the key, including the record type.
+// The reads are counted separately, so that GetHitCount and GetAccessCount do not include them
+func (c *Cache) peekRecord(key []byte) ([]byte, bool) {
+	size, ok := c.peekInto(key, nil)
+	if !ok || size < recordHeaderSize {
+		return nil, false
+	}
+	data := make([]byte, size)
+	n, ok := c.peekInto(key, data)
+	if !ok || n != size {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *Cache) peekInto(key []byte, data []byte) (int, bool) {
+	size, ok := c.cache.Get(key, data)
+	atomic.AddUint64(&c.peekAccesses, 1)
+	if ok {
+		atomic.AddUint64(&c.peekHits, 1)
+	}
+	return size, ok
+}
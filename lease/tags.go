@@ -0,0 +1,250 @@
+package lease
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+)
+
+const tagCountSize = 2
+const tagLengthSize = 2
+const tagGenerationSize = 8
+
+// tagState is the generation of the last DeleteTag of a tag and its time in millisecond
+type tagState struct {
+	generation    uint64
+	invalidatedAt uint64
+}
+
+// tagTable contains the states of the most recently deleted tags, at most maxTags of them.
+// Each DeleteTag takes the next generation of the table. A tagged value stores the generation of the table
+// when it is set, and is valid while none of its tags has been deleted with a greater generation.
+// When the table is full, the older half of the states is removed and merged into floor, which applies
+// to every tag, so that the values set before the removed states are invalidated
+type tagTable struct {
+	mut        sync.RWMutex
+	tags       map[string]tagState
+	floor      tagState
+	generation uint64
+	maxTags    int
+}
+
+func (t *tagTable) getGeneration() uint64 {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+	return t.generation
+}
+
+func (t *tagTable) invalidate(tag []byte, now uint64) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if t.tags == nil {
+		t.tags = map[string]tagState{}
+	}
+	if _, existed := t.tags[string(tag)]; !existed && len(t.tags) > 0 && len(t.tags) >= t.maxTags {
+		t.removeOldest()
+	}
+
+	t.generation++
+	t.tags[string(tag)] = tagState{
+		generation:    t.generation,
+		invalidatedAt: now,
+	}
+}
+
+// removeOldest removes the older half of the states and merges them into the floor
+func (t *tagTable) removeOldest() {
+	generations := make([]uint64, 0, len(t.tags))
+	for _, state := range t.tags {
+		generations = append(generations, state.generation)
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i] < generations[j] })
+	maxRemoved := generations[(len(generations)-1)/2]
+
+	for tag, state := range t.tags {
+		if state.generation > maxRemoved {
+			continue
+		}
+		delete(t.tags, tag)
+		t.floor.generation = maxUint64(t.floor.generation, state.generation)
+		t.floor.invalidatedAt = maxUint64(t.floor.invalidatedAt, state.invalidatedAt)
+	}
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// invalidatedSince returns true if one of the tags has been deleted at or after the timestamp
+func (t *tagTable) invalidatedSince(tags [][]byte, timestamp uint64) bool {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+
+	if t.floor.generation > 0 && t.floor.invalidatedAt >= timestamp {
+		return true
+	}
+	for _, tag := range tags {
+		state, ok := t.tags[string(tag)]
+		if ok && state.invalidatedAt >= timestamp {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeTagged returns the tagged record: the record type, the generation of the table before checking the tags,
+// the number of tags, the length and the content of each tag, then the value. Numbers are little endian
+func encodeTagged(generation uint64, tags [][]byte, value []byte) []byte {
+	size := recordHeaderSize + tagGenerationSize + tagCountSize + len(value)
+	for _, tag := range tags {
+		size += tagLengthSize + len(tag)
+	}
+
+	data := make([]byte, size)
+	data[0] = recordTypeTagged
+	offset := recordHeaderSize
+	binary.LittleEndian.PutUint64(data[offset:], generation)
+	offset += tagGenerationSize
+	binary.LittleEndian.PutUint16(data[offset:], uint16(len(tags)))
+	offset += tagCountSize
+	for _, tag := range tags {
+		binary.LittleEndian.PutUint16(data[offset:], uint16(len(tag)))
+		offset += tagLengthSize
+		offset += copy(data[offset:], tag)
+	}
+	copy(data[offset:], value)
+	return data
+}
+
+// validate returns the offset of the value in the tagged record without its record type,
+// false if the tagged record is malformed or one of its tags has been deleted
+func (t *tagTable) validate(data []byte) (int, bool) {
+	if len(data) < tagGenerationSize+tagCountSize {
+		return 0, false
+	}
+	generation := binary.LittleEndian.Uint64(data)
+	count := int(binary.LittleEndian.Uint16(data[tagGenerationSize:]))
+	offset := tagGenerationSize + tagCountSize
+
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+
+	if t.floor.generation > generation {
+		return 0, false
+	}
+	for i := 0; i < count; i++ {
+		if len(data) < offset+tagLengthSize {
+			return 0, false
+		}
+		tagLen := int(binary.LittleEndian.Uint16(data[offset:]))
+		offset += tagLengthSize
+
+		if len(data) < offset+tagLen {
+			return 0, false
+		}
+		state := t.tags[string(data[offset:offset+tagLen])]
+		offset += tagLen
+		if state.generation > generation {
+			return 0, false
+		}
+	}
+	return offset, true
+}
+
+// readTagged moves the value of the tagged record of the key to the beginning of value, the record
+// of size bytes must have been read into value by readRecord if it fits. The record is deleted
+// if one of its tags has been deleted. Must be called while holding the lock of the lease list
+func (c *Cache) readTagged(key []byte, value []byte, size int) (int, bool) {
+	var data []byte
+	if size <= len(value) {
+		data = value[:size]
+	} else {
+		var recordType byte
+		data, recordType = c.readValue(key)
+		if recordType != recordTypeTagged {
+			return 0, false
+		}
+	}
+
+	offset, valid := c.tags.validate(data)
+	if !valid {
+		c.cache.Delete(key)
+		return 0, false
+	}
+	copy(value, data[offset:])
+	return len(data) - offset, true
+}
+
+// SetWithTags is the same as Set, except the entry is invalidated when one of the tags is deleted by DeleteTag.
+// The lease is rejected if one of the tags has been deleted after the lease was granted,
+// or in the same millisecond, since the timestamps of leases are in millisecond.
+// The value is not set if there are more than 65535 tags or a tag is longer than 65535 bytes
+func (c *Cache) SetWithTags(key []byte, leaseID uint64, value []byte, tags [][]byte) (affected bool) {
+	if len(tags) == 0 {
+		return c.Set(key, leaseID, value)
+	}
+	if !validTags(tags) {
+		return false
+	}
+
+	hashKey, l := c.getLeaseList(key)
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	lease, ok := c.toListLease(leaseID)
+	if !ok {
+		return false
+	}
+
+	e := l.findEntry(hashKey)
+	if e.lease != lease {
+		return false
+	}
+
+	// a tag deleted after reading the generation invalidates the value
+	generation := c.tags.getGeneration()
+	if c.tags.invalidatedSince(tags, e.createdAt) {
+		l.deleteLease(hashKey, lease)
+		l.notifyWaiters(hashKey, lease, func(w Waiter) {
+			w.OnLeaseReleased()
+		})
+		return false
+	}
+
+	l.deleteLease(hashKey, lease)
+
+	c.removeKey(key, l)
+	c.cache.Put(key, encodeTagged(generation, tags, value))
+
+	l.notifyWaiters(hashKey, lease, func(w Waiter) {
+		w.OnValue(value)
+	})
+	return true
+}
+
+// validTags returns false if the number or the length of the tags does not fit in the tagged record
+func validTags(tags [][]byte) bool {
+	if len(tags) > math.MaxUint16 {
+		return false
+	}
+	for _, tag := range tags {
+		if len(tag) > math.MaxUint16 {
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteTag invalidates all the entries set with the tag and rejects the outstanding leases
+// of the keys being set with the tag, in constant time by increasing the generation of the tag.
+// At most the number of tags configured by WithMaxDeletedTags are remembered, the entries set
+// before the older half of them are invalidated when it is exceeded
+func (c *Cache) DeleteTag(tag []byte) {
+	c.tags.invalidate(tag, c.getNow())
+}
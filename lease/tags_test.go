@@ -0,0 +1,183 @@
+package lease
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newCacheForTagTest() (*Cache, *fakeClock) {
+	clock := &fakeClock{now: 10 * time.Second}
+	return New(8, 1<<20, WithClock(clock)), clock
+}
+
+func TestCache_SetWithTags_Get(t *testing.T) {
+	m, _ := newCacheForTagTest()
+	key := []byte("key01")
+
+	result := m.Get(key, nil)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	affected := m.SetWithTags(key, result.LeaseID, []byte("value01"), [][]byte{[]byte("user:1"), []byte("team:2")})
+	assert.Equal(t, true, affected)
+
+	data := make([]byte, 100)
+	result = m.Get(key, data)
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+	assertEqualBytes(t, []byte("value01"), data[:result.ValueSize])
+
+	size, ok := m.Lookup(key, data)
+	assert.Equal(t, true, ok)
+	assertEqualBytes(t, []byte("value01"), data[:size])
+
+	info := m.Inspect(key)
+	assert.Equal(t, true, info.Stored)
+	assert.Equal(t, 7, info.ValueSize)
+}
+
+func TestCache_DeleteTag_Invalidates_Entries(t *testing.T) {
+	m, clock := newCacheForTagTest()
+	key1 := []byte("key01")
+	key2 := []byte("key02")
+	key3 := []byte("key03")
+
+	for _, key := range [][]byte{key1, key2, key3} {
+		result := m.Get(key, nil)
+		m.SetWithTags(key, result.LeaseID, []byte("value"), [][]byte{[]byte("tag:" + string(key))})
+	}
+	m.Put(key2, []byte("untagged"))
+
+	clock.advance(time.Second)
+	m.DeleteTag([]byte("tag:key01"))
+	m.DeleteTag([]byte("tag:key02"))
+
+	data := make([]byte, 100)
+	result := m.Get(key1, data)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	result = m.Get(key2, data)
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+	assertEqualBytes(t, []byte("untagged"), data[:result.ValueSize])
+
+	result = m.Get(key3, data)
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+	assertEqualBytes(t, []byte("value"), data[:result.ValueSize])
+
+	assert.Equal(t, uint64(2), m.GetUnsafeInnerCache().GetTotal())
+}
+
+func TestCache_DeleteTag_Rejects_Outstanding_Lease(t *testing.T) {
+	m, clock := newCacheForTagTest()
+	key := []byte("key01")
+	tags := [][]byte{[]byte("user:1")}
+
+	result := m.Get(key, nil)
+	w := &testWaiter{}
+	assertEqualGetStatus(t, GetStatusLeaseWaiting, m.GetOrWait(key, nil, w).Status)
+
+	clock.advance(time.Second)
+	m.DeleteTag(tags[0])
+
+	affected := m.SetWithTags(key, result.LeaseID, []byte("value01"), tags)
+	assert.Equal(t, false, affected)
+	assert.Equal(t, 1, w.released)
+
+	clock.advance(time.Second)
+	result = m.Get(key, nil)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	affected = m.SetWithTags(key, result.LeaseID, []byte("value01"), tags)
+	assert.Equal(t, true, affected)
+}
+
+func TestCache_Tagged_Invalidate_And_MarkStale(t *testing.T) {
+	m, _ := newCacheForTagTest()
+	key := []byte("key01")
+	tags := [][]byte{[]byte("user:1")}
+
+	result := m.Get(key, nil)
+	m.SetWithTags(key, result.LeaseID, []byte("value01"), tags)
+	assert.Equal(t, true, m.MarkStale(key))
+
+	data := make([]byte, 100)
+	result = m.Get(key, data)
+	assertEqualGetStatus(t, GetStatusStaleLeaseGranted, result.Status)
+	assertEqualBytes(t, []byte("value01"), data[:result.ValueSize])
+
+	m.SetWithTags(key, result.LeaseID, []byte("value02"), tags)
+	assert.Equal(t, true, m.Invalidate(key))
+	assert.Equal(t, false, m.Invalidate(key))
+
+	_, ok := m.Lookup(key, data)
+	assert.Equal(t, false, ok)
+}
+
+func TestTagTable_Validate_Malformed(t *testing.T) {
+	var table tagTable
+	data := encodeTagged(0, [][]byte{[]byte("tag01")}, []byte("value"))
+	assert.Equal(t, recordTypeTagged, data[0])
+	data = data[recordHeaderSize:]
+
+	offset, ok := table.validate(data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []byte("value"), data[offset:])
+
+	_, ok = table.validate(data[:10])
+	assert.Equal(t, false, ok)
+	_, ok = table.validate(nil)
+	assert.Equal(t, false, ok)
+}
+
+func TestCache_Tagged_Value_Not_Reachable_By_Other_Keys(t *testing.T) {
+	m, _ := newCacheForTagTest()
+	key := []byte("key01")
+	taggedAlias := []byte("key01\x01")
+
+	result := m.Get(key, nil)
+	m.SetWithTags(key, result.LeaseID, []byte("value01"), [][]byte{[]byte("user:1")})
+
+	data := make([]byte, 100)
+	result = m.Get(taggedAlias, data)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	affected := m.Set(taggedAlias, result.LeaseID, []byte("forged"))
+	assert.Equal(t, true, affected)
+
+	result = m.Get(key, data)
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+	assertEqualBytes(t, []byte("value01"), data[:result.ValueSize])
+
+	result = m.Get(key, data[:3])
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+	assert.Equal(t, 7, result.ValueSize)
+}
+
+func TestCache_DeleteTag_Max_Deleted_Tags(t *testing.T) {
+	clock := &fakeClock{now: 10 * time.Second}
+	m := New(8, 1<<20, WithClock(clock), WithMaxDeletedTags(4))
+	key1 := []byte("key01")
+	key2 := []byte("key02")
+
+	result := m.Get(key1, nil)
+	m.SetWithTags(key1, result.LeaseID, []byte("value01"), [][]byte{[]byte("user:1")})
+
+	clock.advance(time.Second)
+	for i := 0; i < 5; i++ {
+		m.DeleteTag([]byte("tag:" + string(rune('0'+i))))
+	}
+	assert.Equal(t, 3, len(m.tags.tags))
+	assert.Equal(t, uint64(2), m.tags.floor.generation)
+
+	clock.advance(time.Second)
+	result = m.Get(key2, nil)
+	affected := m.SetWithTags(key2, result.LeaseID, []byte("value02"), [][]byte{[]byte("tag:0")})
+	assert.Equal(t, true, affected)
+
+	data := make([]byte, 100)
+	result = m.Get(key1, data)
+	assertEqualGetStatus(t, GetStatusLeaseGranted, result.Status)
+
+	result = m.Get(key2, data)
+	assertEqualGetStatus(t, GetStatusFound, result.Status)
+	assertEqualBytes(t, []byte("value02"), data[:result.ValueSize])
+}
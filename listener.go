@@ -0,0 +1,124 @@
+package kvstore
+
+import (
+	"github.com/QuangTung97/kvstore/lease"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"sync"
+)
+
+const connInitValueSize = 1 << 16 // 64KB
+
+// connListener accepts TCP or Unix stream connections and serves each of them in a separate goroutine
+type connListener struct {
+	listener  net.Listener
+	serveConn func(conn net.Conn) error
+	logger    *zap.Logger
+
+	mut    sync.Mutex
+	closed bool
+	conns  map[net.Conn]struct{}
+	wg     sync.WaitGroup
+}
+
+func newConnListener(listener net.Listener, logger *zap.Logger, serveConn func(conn net.Conn) error) *connListener {
+	return &connListener{
+		listener:  listener,
+		serveConn: serveConn,
+		logger:    logger,
+
+		conns: map[net.Conn]struct{}{},
+	}
+}
+
+func (l *connListener) run() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		l.mut.Lock()
+		if l.closed {
+			l.mut.Unlock()
+			_ = conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.wg.Add(1)
+		l.mut.Unlock()
+
+		go func() {
+			defer l.wg.Done()
+			l.serve(conn)
+		}()
+	}
+}
+
+func (l *connListener) serve(conn net.Conn) {
+	defer func() {
+		l.mut.Lock()
+		delete(l.conns, conn)
+		l.mut.Unlock()
+
+		_ = conn.Close()
+	}()
+
+	err := l.serveConn(conn)
+	if err != nil && err != io.EOF {
+		l.logger.Debug("Connection closed", zap.Error(err))
+	}
+}
+
+// shutdown closes the listener and all the connections
+func (l *connListener) shutdown() {
+	_ = l.listener.Close()
+
+	l.mut.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.mut.Unlock()
+
+	l.wg.Wait()
+}
+
+// lookupValue returns the value of the key without lease, the buffer grows if the value does not fit
+func lookupValue(cache *lease.Cache, key []byte, buf *[]byte) ([]byte, bool) {
+	for {
+		size, ok := cache.Lookup(key, *buf)
+		if !ok {
+			return nil, false
+		}
+		if size <= len(*buf) {
+			return (*buf)[:size], true
+		}
+		*buf = make([]byte, size)
+	}
+}
+
+// getValue gets the key with lease, the returned value is nil if there is no value.
+// A stale value bigger than the buffer is considered as missing
+func getValue(cache *lease.Cache, key []byte, buf *[]byte) (lease.GetResult, []byte) {
+	result := cache.Get(key, *buf)
+
+	switch result.Status {
+	case lease.GetStatusFound:
+		if result.ValueSize > len(*buf) {
+			value, _ := lookupValue(cache, key, buf)
+			return result, value
+		}
+		return result, (*buf)[:result.ValueSize]
+
+	case lease.GetStatusStaleLeaseGranted, lease.GetStatusStaleLeaseRejected:
+		if result.ValueSize > len(*buf) {
+			return result, nil
+		}
+		return result, (*buf)[:result.ValueSize]
+
+	default:
+		return result, nil
+	}
+}
@@ -0,0 +1,293 @@
+package kvstore
+
+import (
+	"bufio"
+	"errors"
+	"github.com/QuangTung97/kvstore/lease"
+	"github.com/QuangTung97/kvstore/parser"
+	"io"
+	"strconv"
+)
+
+const memcachedMaxLineSize = 1 << 16      // 64KB
+const memcachedMaxValueSize = 1 << 20     // 1MB, the same as the default item size limit of memcached
+const memcachedMaxConnectionLeases = 1024 // leases remembered by a connection
+
+var (
+	memcachedValueResponse       = []byte("VALUE ")
+	memcachedStoredResponse      = []byte("STORED\r\n")
+	memcachedNotStoredResponse   = []byte("NOT_STORED\r\n")
+	memcachedDeletedResponse     = []byte("DELETED\r\n")
+	memcachedNotFoundResponse    = []byte("NOT_FOUND\r\n")
+	memcachedErrorResponse       = []byte("ERROR\r\n")
+	memcachedClientErrorResponse = []byte("CLIENT_ERROR ")
+	memcachedTooLargeResponse    = []byte("SERVER_ERROR object too large for cache\r\n")
+	memcachedLineTooLongResponse = []byte("CLIENT_ERROR line too long\r\n")
+)
+
+var errMemcachedValueTooLarge = errors.New("value too large")
+
+// memcachedConn handles the commands of a memcached connection, the keys are routed to the caches of namespaces.
+// When useLeases is true, a get miss acquires a lease for the key like LGET,
+// which is used by the next set of the same key on the connection like LSET.
+// get and stats require the ReadOnly role of the client IP, set and delete require ReadWrite
+type memcachedConn struct {
+	namespaces *namespaces
+	stats      *serverStats
+	useLeases  bool
+	role       AccessRole
+
+	reader *bufio.Reader
+	writer *bufio.Writer
+	parser parser.MemcachedParser
+
+	line    []byte
+	data    []byte
+	value   []byte
+	num     []byte
+	readErr error
+
+	// leases granted to get misses, zero if the lease is rejected
+	leases map[string]uint64
+}
+
+//revive:disable-next-line:flag-parameter
+func newMemcachedConn(
+	namespaces *namespaces, stats *serverStats,
+	r io.Reader, w io.Writer, useLeases bool, role AccessRole,
+) *memcachedConn {
+	c := &memcachedConn{
+		namespaces: namespaces,
+		stats:      stats,
+		useLeases:  useLeases,
+		role:       role,
+
+		reader: bufio.NewReaderSize(r, memcachedMaxLineSize),
+		writer: bufio.NewWriter(w),
+
+		value:  make([]byte, connInitValueSize),
+		leases: map[string]uint64{},
+	}
+	parser.InitMemcachedParser(&c.parser, c)
+	return c
+}
+
+func (c *memcachedConn) serve() error {
+	for {
+		err := c.processNextCommand()
+		if err != nil {
+			_ = c.writer.Flush()
+			return err
+		}
+
+		// flush only when all the pipelined commands are processed
+		if c.reader.Buffered() > 0 {
+			continue
+		}
+		err = c.writer.Flush()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *memcachedConn) processNextCommand() error {
+	line, err := c.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		_, _ = c.writer.Write(memcachedLineTooLongResponse)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// the line must be copied since reading the data block overwrites the buffer of the reader
+	c.line = append(c.line[:0], line...)
+	c.readErr = nil
+
+	err = c.parser.Process(c.line, c.readData)
+	if c.readErr == errMemcachedValueTooLarge {
+		_, _ = c.writer.Write(memcachedTooLargeResponse)
+		return c.readErr
+	}
+	if c.readErr != nil {
+		return c.readErr
+	}
+	if err != nil {
+		c.writeError(err)
+	}
+	return nil
+}
+
+func (c *memcachedConn) readData(size int) ([]byte, error) {
+	if size > memcachedMaxValueSize+len(crlfResponse) {
+		c.readErr = errMemcachedValueTooLarge
+		return nil, c.readErr
+	}
+	if cap(c.data) < size {
+		c.data = make([]byte, size)
+	}
+	data := c.data[:size]
+
+	_, err := io.ReadFull(c.reader, data)
+	if err != nil {
+		c.readErr = err
+		return nil, err
+	}
+	return data, nil
+}
+
+func (c *memcachedConn) writeError(err error) {
+	if err == parser.ErrInvalidCommand || err == parser.ErrMissingCommand {
+		_, _ = c.writer.Write(memcachedErrorResponse)
+		return
+	}
+	_, _ = c.writer.Write(memcachedClientErrorResponse)
+	_, _ = c.writer.WriteString(err.Error())
+	_, _ = c.writer.Write(crlfResponse)
+}
+
+// checkRole replies a permission denied error if the role of the connection is lower than the required role
+func (c *memcachedConn) checkRole(required AccessRole) bool {
+	if c.role >= required {
+		return true
+	}
+	c.writeError(errPermissionDenied)
+	return false
+}
+
+func (c *memcachedConn) writeNumber(n uint64) {
+	c.num = strconv.AppendUint(c.num[:0], n, 10)
+	_, _ = c.writer.Write(c.num)
+}
+
+// lookup returns the value of the key, the result is only valid until the next call
+func (c *memcachedConn) lookup(key []byte) ([]byte, bool) {
+	if !c.useLeases {
+		return lookupValue(c.namespaces.cacheOf(key), key, &c.value)
+	}
+
+	result, value := getValue(c.namespaces.cacheOf(key), key, &c.value)
+	c.rememberLease(key, result)
+
+	switch result.Status {
+	case lease.GetStatusFound, lease.GetStatusStaleLeaseGranted, lease.GetStatusStaleLeaseRejected:
+		return value, value != nil
+	default:
+		return nil, false
+	}
+}
+
+func (c *memcachedConn) rememberLease(key []byte, result lease.GetResult) {
+	switch result.Status {
+	case lease.GetStatusLeaseGranted, lease.GetStatusStaleLeaseGranted:
+	case lease.GetStatusLeaseRejected, lease.GetStatusStaleLeaseRejected:
+		// keeps the lease granted to a previous get of the same connection
+		if _, existed := c.leases[string(key)]; existed {
+			return
+		}
+	default:
+		return
+	}
+
+	if _, existed := c.leases[string(key)]; !existed && len(c.leases) >= memcachedMaxConnectionLeases {
+		c.evictLease()
+	}
+	c.leases[string(key)] = result.LeaseID
+}
+
+// evictLease forgets one of the remembered leases, the next set of its key is stored without lease
+func (c *memcachedConn) evictLease() {
+	for key := range c.leases {
+		delete(c.leases, key)
+		return
+	}
+}
+
+//revive:disable-next-line:flag-parameter
+func (c *memcachedConn) OnGet(keys [][]byte, withCAS bool) {
+	if !c.checkRole(AccessRoleReadOnly) {
+		return
+	}
+	for _, key := range keys {
+		value, ok := c.lookup(key)
+		if !ok {
+			continue
+		}
+
+		_, _ = c.writer.Write(memcachedValueResponse)
+		_, _ = c.writer.Write(key)
+		_, _ = c.writer.WriteString(" 0 ")
+		c.writeNumber(uint64(len(value)))
+		if withCAS {
+			_, _ = c.writer.WriteString(" 0")
+		}
+		_, _ = c.writer.Write(crlfResponse)
+		_, _ = c.writer.Write(value)
+		_, _ = c.writer.Write(crlfResponse)
+	}
+	_, _ = c.writer.Write(endResponse)
+}
+
+//revive:disable-next-line:flag-parameter
+func (c *memcachedConn) OnSet(key []byte, _ uint32, value []byte, noReply bool) {
+	if !c.checkRole(AccessRoleReadWrite) {
+		return
+	}
+	stored := c.store(key, value)
+	if noReply {
+		return
+	}
+	if stored {
+		_, _ = c.writer.Write(memcachedStoredResponse)
+		return
+	}
+	_, _ = c.writer.Write(memcachedNotStoredResponse)
+}
+
+// store uses the lease remembered for the key if there is one, otherwise puts the value without lease
+func (c *memcachedConn) store(key []byte, value []byte) bool {
+	if !c.useLeases {
+		c.namespaces.cacheOf(key).Put(key, value)
+		return true
+	}
+
+	leaseID, ok := c.leases[string(key)]
+	if !ok {
+		c.namespaces.cacheOf(key).Put(key, value)
+		return true
+	}
+	delete(c.leases, string(key))
+
+	if leaseID == 0 {
+		return false
+	}
+	return c.namespaces.cacheOf(key).Set(key, leaseID, value)
+}
+
+//revive:disable-next-line:flag-parameter
+func (c *memcachedConn) OnDelete(key []byte, noReply bool) {
+	if !c.checkRole(AccessRoleReadWrite) {
+		return
+	}
+	deleted := c.namespaces.cacheOf(key).Invalidate(key)
+	if noReply {
+		return
+	}
+	if deleted {
+		_, _ = c.writer.Write(memcachedDeletedResponse)
+		return
+	}
+	_, _ = c.writer.Write(memcachedNotFoundResponse)
+}
+
+func (c *memcachedConn) OnStats() {
+	if !c.checkRole(AccessRoleReadOnly) {
+		return
+	}
+	entries := c.stats.collectStats(c.namespaces.defaultCache)
+
+	data := make([]byte, statsResponseMaxSize(entries))
+	n := buildStatsResponse(data, entries)
+	_, _ = c.writer.Write(data[:n])
+}
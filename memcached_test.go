@@ -0,0 +1,262 @@
+package kvstore
+
+import (
+	"bytes"
+	"github.com/QuangTung97/kvstore/lease"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//revive:disable-next-line:flag-parameter
+func runMemcachedForTest(cache *lease.Cache, useLeases bool, input string) (string, error) {
+	var output bytes.Buffer
+	n := newNamespaces(cache, nil)
+	c := newMemcachedConn(n, newServerStats(), strings.NewReader(input), &output, useLeases, AccessRoleReadWrite)
+	err := c.serve()
+	return output.String(), err
+}
+
+func newConnCacheForTest() *lease.Cache {
+	return lease.New(4, 1<<16, lease.WithLeaseEpoch(0))
+}
+
+// putTaggedAndStaleForTest sets key01 to value01 with the tag user:1 and marks value02 of key02 as stale
+func putTaggedAndStaleForTest(cache *lease.Cache) {
+	result := cache.Get([]byte("key01"), nil)
+	cache.SetWithTags([]byte("key01"), result.LeaseID, []byte("value01"), [][]byte{[]byte("user:1")})
+
+	cache.Put([]byte("key02"), []byte("value02"))
+	cache.MarkStale([]byte("key02"))
+}
+
+func TestMemcachedConn_Set_Get_Delete(t *testing.T) {
+	cache := newConnCacheForTest()
+
+	output, err := runMemcachedForTest(cache, false, ""+
+		"get key01\r\n"+
+		"set key01 12 0 10\r\nsome-value\r\n"+
+		"get key01 key02\r\n"+
+		"gets key01\r\n"+
+		"set key02 0 0 3 noreply\r\nabc\r\n"+
+		"delete key01\r\n"+
+		"delete key01\r\n"+
+		"get key01 key02\r\n",
+	)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, ""+
+		"END\r\n"+
+		"STORED\r\n"+
+		"VALUE key01 0 10\r\nsome-value\r\nEND\r\n"+
+		"VALUE key01 0 10 0\r\nsome-value\r\nEND\r\n"+
+		"DELETED\r\n"+
+		"NOT_FOUND\r\n"+
+		"VALUE key02 0 3\r\nabc\r\nEND\r\n",
+		output)
+}
+
+func TestMemcachedConn_Errors(t *testing.T) {
+	cache := newConnCacheForTest()
+
+	output, err := runMemcachedForTest(cache, false, ""+
+		"GET key01\r\n"+
+		"set key01 abc 0 10\r\n"+
+		"set key01 0 0 3\r\nabcde\r\n",
+	)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, ""+
+		"ERROR\r\n"+
+		"CLIENT_ERROR flags is not number\r\n"+
+		"CLIENT_ERROR bad data chunk\r\n"+
+		"ERROR\r\n",
+		output)
+}
+
+func TestMemcachedConn_Value_Too_Large(t *testing.T) {
+	cache := newConnCacheForTest()
+
+	output, err := runMemcachedForTest(cache, false, "set key01 0 0 2000000\r\n")
+	assert.Equal(t, errMemcachedValueTooLarge, err)
+	assert.Equal(t, "SERVER_ERROR object too large for cache\r\n", output)
+}
+
+func TestMemcachedConn_Get_Big_Value(t *testing.T) {
+	cache := lease.New(4, 1<<20)
+	value := strings.Repeat("A", connInitValueSize+10)
+	cache.Put([]byte("key01"), []byte(value))
+
+	output, err := runMemcachedForTest(cache, false, "get key01\r\n")
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "VALUE key01 0 65546\r\n"+value+"\r\nEND\r\n", output)
+}
+
+func TestMemcachedConn_With_Leases(t *testing.T) {
+	cache := newConnCacheForTest()
+
+	output, err := runMemcachedForTest(cache, true, ""+
+		"get key01\r\n"+
+		"get key01\r\n"+
+		"set key01 0 0 10\r\nsome-value\r\n"+
+		"get key01\r\n",
+	)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, ""+
+		"END\r\n"+
+		"END\r\n"+
+		"STORED\r\n"+
+		"VALUE key01 0 10\r\nsome-value\r\nEND\r\n",
+		output)
+}
+
+func TestMemcachedConn_With_Leases_Rejected(t *testing.T) {
+	cache := newConnCacheForTest()
+	result := cache.Get([]byte("key01"), nil)
+	assert.Equal(t, lease.GetStatusLeaseGranted, result.Status)
+
+	output, err := runMemcachedForTest(cache, true, ""+
+		"get key01\r\n"+
+		"set key01 0 0 10\r\nsome-value\r\n"+
+		"set key01 0 0 10\r\nsome-value\r\n",
+	)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, ""+
+		"END\r\n"+
+		"NOT_STORED\r\n"+
+		"STORED\r\n",
+		output)
+}
+
+func TestMemcachedConn_With_Leases_Invalidated(t *testing.T) {
+	cache := newConnCacheForTest()
+
+	output, err := runMemcachedForTest(cache, true, ""+
+		"get key01\r\n"+
+		"delete key01\r\n"+
+		"set key01 0 0 10\r\nsome-value\r\n",
+	)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, ""+
+		"END\r\n"+
+		"NOT_FOUND\r\n"+
+		"NOT_STORED\r\n",
+		output)
+}
+
+func TestMemcachedConn_Evict_One_Lease(t *testing.T) {
+	n := newNamespaces(newConnCacheForTest(), nil)
+	c := newMemcachedConn(n, newServerStats(), strings.NewReader(""), io.Discard, true, AccessRoleReadWrite)
+
+	granted := lease.GetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 1}
+	for i := 0; i < memcachedMaxConnectionLeases; i++ {
+		c.rememberLease([]byte("key"+strconv.Itoa(i)), granted)
+	}
+	c.rememberLease([]byte("key0"), lease.GetResult{Status: lease.GetStatusLeaseGranted, LeaseID: 2})
+	assert.Equal(t, memcachedMaxConnectionLeases, len(c.leases))
+	assert.Equal(t, uint64(2), c.leases["key0"])
+
+	c.rememberLease([]byte("new-key"), granted)
+	assert.Equal(t, memcachedMaxConnectionLeases, len(c.leases))
+	assert.Equal(t, uint64(1), c.leases["new-key"])
+}
+
+func TestMemcachedConn_Stats(t *testing.T) {
+	cache := newConnCacheForTest()
+
+	output, err := runMemcachedForTest(cache, false, "stats\r\n")
+	assert.Equal(t, io.EOF, err)
+	assert.True(t, strings.HasPrefix(output, "STAT uptime "))
+	assert.True(t, strings.Contains(output, "STAT items 0\r\n"))
+	assert.True(t, strings.HasSuffix(output, "END\r\n"))
+}
+
+func TestMemcachedConn_Keys_With_Record_Type_Suffix(t *testing.T) {
+	cache := newConnCacheForTest()
+	putTaggedAndStaleForTest(cache)
+
+	output, err := runMemcachedForTest(cache, false, ""+
+		"get key01\x01 key02\x01\r\n"+
+		"delete key01\x01\r\n"+
+		"set key02\x01 0 0 6\r\nforged\r\n"+
+		"get key01\r\n",
+	)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, ""+
+		"END\r\n"+
+		"NOT_FOUND\r\n"+
+		"STORED\r\n"+
+		"VALUE key01 0 7\r\nvalue01\r\nEND\r\n",
+		output)
+
+	result := cache.Get([]byte("key02"), make([]byte, 100))
+	assert.Equal(t, lease.GetStatusStaleLeaseGranted, result.Status)
+	assert.Equal(t, 7, result.ValueSize)
+}
+
+func TestMemcachedConn_Read_Only_Role(t *testing.T) {
+	cache := newConnCacheForTest()
+	cache.Put([]byte("key01"), []byte("value01"))
+
+	var output bytes.Buffer
+	input := "" +
+		"set key01 0 0 6\r\nforged\r\n" +
+		"delete key01\r\n" +
+		"get key01\r\n"
+	n := newNamespaces(cache, nil)
+	c := newMemcachedConn(n, newServerStats(), strings.NewReader(input), &output, false, AccessRoleReadOnly)
+	assert.Equal(t, io.EOF, c.serve())
+	assert.Equal(t, ""+
+		"CLIENT_ERROR permission denied\r\n"+
+		"CLIENT_ERROR permission denied\r\n"+
+		"VALUE key01 0 7\r\nvalue01\r\nEND\r\n",
+		output.String())
+}
+
+func TestMemcachedConn_Namespaces(t *testing.T) {
+	n := newNamespacesForSnapshotTest()
+	input := "set users:01 0 0 7\r\nvalue01\r\nset key01 0 0 7\r\nvalue02\r\n"
+
+	var output bytes.Buffer
+	c := newMemcachedConn(n, newServerStats(), strings.NewReader(input), &output, false, AccessRoleReadWrite)
+	assert.Equal(t, io.EOF, c.serve())
+	assert.Equal(t, "STORED\r\nSTORED\r\n", output.String())
+
+	data := make([]byte, 100)
+	size, ok := n.byName["users"].getCache().Lookup([]byte("users:01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:size]))
+
+	_, ok = n.defaultCache.Lookup([]byte("users:01"), data)
+	assert.Equal(t, false, ok)
+	_, ok = n.defaultCache.Lookup([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+}
+
+func TestMemcachedListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.Equal(t, nil, err)
+
+	cache := newConnCacheForTest()
+	stats := newServerStats()
+	l := newConnListener(listener, zap.NewNop(), func(conn net.Conn) error {
+		return newMemcachedConn(newNamespaces(cache, nil), stats, conn, conn, false, AccessRoleReadWrite).serve()
+	})
+	go l.run()
+	defer l.shutdown()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Equal(t, nil, err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte("set key01 0 0 10\r\nsome-value\r\nget key01\r\n"))
+	assert.Equal(t, nil, err)
+
+	expected := "STORED\r\nVALUE key01 0 10\r\nsome-value\r\nEND\r\n"
+	data := make([]byte, len(expected))
+	_, err = io.ReadFull(conn, data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, string(data))
+}
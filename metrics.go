@@ -0,0 +1,231 @@
+package kvstore
+
+import (
+	"github.com/QuangTung97/kvstore/lease"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+)
+
+const metricsNamespace = "kvstore"
+
+// leasesHelp is the help of every counter of leases_total
+const leasesHelp = "Number of lease requests by result, timeout counts the leases expired without LSET, " +
+	"wait_timeout counts the LGETW waits that timed out"
+
+// getStatusOutcomes are the outcome labels of lease.GetStatus values
+var getStatusOutcomes = [...]string{
+	lease.GetStatusFound:              "found",
+	lease.GetStatusLeaseGranted:       "granted",
+	lease.GetStatusLeaseRejected:      "rejected",
+	lease.GetStatusStaleLeaseGranted:  "stale_granted",
+	lease.GetStatusStaleLeaseRejected: "stale_rejected",
+	lease.GetStatusLeaseWaiting:       "waiting",
+}
+
+type getCounters [len(getStatusOutcomes)]prometheus.Counter
+
+type affectedCounters struct {
+	affected    prometheus.Counter
+	notAffected prometheus.Counter
+}
+
+// metrics contains the prometheus collectors of a server,
+// counters are resolved from the label values in advance to avoid lookups in processors
+type metrics struct {
+	registry *prometheus.Registry
+
+	lget     getCounters
+	lgetw    getCounters
+	lset     affectedCounters
+	lrelease affectedCounters
+	lextend  affectedCounters
+	del      affectedCounters
+	delStale affectedCounters
+	flush    affectedCounters
+	deltag   prometheus.Counter
+	snapshot affectedCounters
+	debug    prometheus.Counter
+	stats    prometheus.Counter
+	invalid  prometheus.Counter
+
+	rateLimited      prometheus.Counter
+	permissionDenied prometheus.Counter
+
+	leaseGranted     prometheus.Counter
+	leaseRejected    prometheus.Counter
+	leaseWaitTimeout prometheus.Counter
+
+	unknownVersionFrames prometheus.Counter
+	invalidFrames        prometheus.Counter
+	checksumFailedFrames prometheus.Counter
+	authFailedFrames     prometheus.Counter
+	decryptFailedFrames  prometheus.Counter
+	overloadedFrames     prometheus.Counter
+
+	sendErrors    prometheus.Counter
+	batchDuration prometheus.Histogram
+}
+
+func newMetrics() *metrics {
+	commands := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "commands_total",
+		Help:      "Number of processed commands by command type and outcome",
+	}, []string{"command", "outcome"})
+
+	rejectedFrames := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rejected_frames_total",
+		Help:      "Number of received data frames that are rejected by reason",
+	}, []string{"reason"})
+
+	sendErrors := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "send_errors_total",
+		Help:      "Number of errors returned by the response sender",
+	})
+
+	batchDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "batch_processing_seconds",
+		Help:      "Duration of processing a batch of commands, including sending the responses",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10), // 10us to 2.6s
+	})
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(commands, rejectedFrames, sendErrors, batchDuration)
+
+	return &metrics{
+		registry: registry,
+
+		lget:     newGetCounters(commands, "lget"),
+		lgetw:    newGetCounters(commands, "lgetw"),
+		lset:     newAffectedCounters(commands, "lset"),
+		lrelease: newAffectedCounters(commands, "lrelease"),
+		lextend:  newAffectedCounters(commands, "lextend"),
+		del:      newAffectedCounters(commands, "del"),
+		delStale: newAffectedCounters(commands, "del_stale"),
+		flush:    newAffectedCounters(commands, "flush"),
+		deltag:   commands.WithLabelValues("deltag", "ok"),
+		snapshot: newAffectedCounters(commands, "snapshot"),
+		debug:    commands.WithLabelValues("debug", "ok"),
+		stats:    commands.WithLabelValues("stats", "ok"),
+		invalid:  commands.WithLabelValues("invalid", "error"),
+
+		rateLimited:      commands.WithLabelValues("rate_limited", "error"),
+		permissionDenied: commands.WithLabelValues("permission_denied", "error"),
+
+		leaseGranted:     newLeaseCounter(registry, "granted"),
+		leaseRejected:    newLeaseCounter(registry, "rejected"),
+		leaseWaitTimeout: newLeaseCounter(registry, "wait_timeout"),
+
+		unknownVersionFrames: rejectedFrames.WithLabelValues("unknown_version"),
+		invalidFrames:        rejectedFrames.WithLabelValues("invalid"),
+		checksumFailedFrames: rejectedFrames.WithLabelValues("checksum"),
+		authFailedFrames:     rejectedFrames.WithLabelValues("auth"),
+		decryptFailedFrames:  rejectedFrames.WithLabelValues("decrypt"),
+		overloadedFrames:     rejectedFrames.WithLabelValues("overloaded"),
+
+		sendErrors:    sendErrors,
+		batchDuration: batchDuration,
+	}
+}
+
+// newLeaseCounter registers a counter of leases_total, the results are const labels,
+// so that the expired leases can be counted by a CounterFunc of the same name
+func newLeaseCounter(registry *prometheus.Registry, result string) prometheus.Counter {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   metricsNamespace,
+		Name:        "leases_total",
+		Help:        leasesHelp,
+		ConstLabels: prometheus.Labels{"result": result},
+	})
+	registry.MustRegister(counter)
+	return counter
+}
+
+func newGetCounters(commands *prometheus.CounterVec, command string) getCounters {
+	var result getCounters
+	for i, outcome := range getStatusOutcomes {
+		result[i] = commands.WithLabelValues(command, outcome)
+	}
+	return result
+}
+
+func newAffectedCounters(commands *prometheus.CounterVec, command string) affectedCounters {
+	return affectedCounters{
+		affected:    commands.WithLabelValues(command, "affected"),
+		notAffected: commands.WithLabelValues(command, "not_affected"),
+	}
+}
+
+//revive:disable-next-line:flag-parameter
+func (c affectedCounters) inc(affected bool) {
+	if affected {
+		c.affected.Inc()
+		return
+	}
+	c.notAffected.Inc()
+}
+
+func (m *metrics) recordGet(counters *getCounters, status lease.GetStatus) {
+	counters[status].Inc()
+
+	switch status {
+	case lease.GetStatusLeaseGranted, lease.GetStatusStaleLeaseGranted:
+		m.leaseGranted.Inc()
+	case lease.GetStatusLeaseRejected, lease.GetStatusStaleLeaseRejected:
+		m.leaseRejected.Inc()
+	}
+}
+
+// registerReceiver registers the collectors that read the state of the processors,
+// the fragment reassembly counters of a receiver and the expired leases of the caches
+func (m *metrics) registerReceiver(stats *serverStats) {
+	for i, p := range stats.processors {
+		proc := p
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "processor_ring_utilization",
+			Help:        "Fraction of the command ring buffer of the processor that is in use",
+			ConstLabels: prometheus.Labels{"processor": strconv.Itoa(i)},
+		}, func() float64 {
+			return float64(proc.cmdStore.queueSize()) / float64(len(proc.cmdStore.buffer))
+		}))
+	}
+
+	fragments := []struct {
+		result  string
+		counter *atomicUint64
+	}{
+		{result: "received", counter: &stats.fragments},
+		{result: "reassembled", counter: &stats.completed},
+		{result: "evicted", counter: &stats.evicted},
+		{result: "invalid", counter: &stats.invalidBatches},
+		{result: "checksum_failed", counter: &stats.batchChecksumFailures},
+	}
+	for _, f := range fragments {
+		counter := f.counter
+		m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "fragments_total",
+			Help:        "Number of received fragments and of reassembled, evicted, invalid or corrupted batches",
+			ConstLabels: prometheus.Labels{"result": f.result},
+		}, func() float64 {
+			return float64(counter.load())
+		}))
+	}
+
+	if stats.namespaces == nil {
+		return
+	}
+	namespaces := stats.namespaces
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace:   metricsNamespace,
+		Name:        "leases_total",
+		Help:        leasesHelp,
+		ConstLabels: prometheus.Labels{"result": "timeout"},
+	}, func() float64 {
+		return float64(namespaces.expiredLeases())
+	}))
+}
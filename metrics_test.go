@@ -0,0 +1,125 @@
+package kvstore
+
+import (
+	"github.com/QuangTung97/kvstore/bigcmd"
+	"github.com/QuangTung97/kvstore/lease"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Commands(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender)
+
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET key01\r\n",
+		"LGET key01\r\n",
+		"LSET key01 1 10\r\nsome-value\r\n",
+		"LSET key01 1 10\r\nsome-value\r\n",
+		"LGET key01\r\n",
+		"DEL key01\r\n",
+		"UNKNOWN\r\n",
+	)
+	p.runSingleLoop()
+
+	m := p.metrics
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.lget[lease.GetStatusFound]))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.lget[lease.GetStatusLeaseGranted]))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.lget[lease.GetStatusLeaseRejected]))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.lset.affected))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.lset.notAffected))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.del.affected))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.invalid))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.leaseGranted))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.leaseRejected))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.batchDuration))
+}
+
+func TestMetrics_Send_Errors(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender)
+
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return ErrInvalidResponse }
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200), 213, "LGET key01\r\n")
+	p.runSingleLoop()
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(p.metrics.sendErrors))
+}
+
+func TestMetrics_Register_Receiver(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	r := newReceiver(sender, WithNumProcessors(2))
+
+	r.stats.setStoreStats(bigcmd.Stats{
+		Fragments:        10,
+		Completed:        2,
+		Evicted:          3,
+		Invalid:          4,
+		ChecksumFailures: 5,
+	})
+
+	expected := `
+# HELP kvstore_fragments_total Number of received fragments and of reassembled, evicted, invalid or corrupted batches
+# TYPE kvstore_fragments_total counter
+kvstore_fragments_total{result="checksum_failed"} 5
+kvstore_fragments_total{result="evicted"} 3
+kvstore_fragments_total{result="invalid"} 4
+kvstore_fragments_total{result="reassembled"} 2
+kvstore_fragments_total{result="received"} 10
+`
+	err := testutil.GatherAndCompare(r.stats.metrics.registry, strings.NewReader(expected), "kvstore_fragments_total")
+	assert.Equal(t, nil, err)
+
+	expected = `
+# HELP kvstore_processor_ring_utilization Fraction of the command ring buffer of the processor that is in use
+# TYPE kvstore_processor_ring_utilization gauge
+kvstore_processor_ring_utilization{processor="0"} 0
+kvstore_processor_ring_utilization{processor="1"} 0
+`
+	err = testutil.GatherAndCompare(r.stats.metrics.registry, strings.NewReader(expected),
+		"kvstore_processor_ring_utilization")
+	assert.Equal(t, nil, err)
+}
+
+type clockForMetricsTest struct {
+	now time.Duration
+}
+
+func (c *clockForMetricsTest) Now() time.Duration {
+	return c.now
+}
+
+func TestMetrics_Expired_Leases(t *testing.T) {
+	clock := &clockForMetricsTest{}
+	sender := &ResponseSenderMock{}
+	r := newReceiver(sender, WithNamespace("users", 1, 1<<16, lease.WithNumBuckets(1),
+		lease.WithClock(clock), lease.WithLeaseTimeout(1)))
+
+	data := make([]byte, 100)
+	cacheOf := r.stats.namespaces.cacheOf
+	cacheOf([]byte("users:key01")).Get([]byte("users:key01"), data)
+	cacheOf([]byte("users:key02")).Get([]byte("users:key02"), data)
+
+	clock.now += 2 * time.Second
+	cacheOf([]byte("users:key03")).Get([]byte("users:key03"), data)
+	r.stats.namespaces.flush([]byte("users"))
+
+	expected := `
+# HELP kvstore_leases_total ` + leasesHelp + `
+# TYPE kvstore_leases_total counter
+kvstore_leases_total{result="granted"} 0
+kvstore_leases_total{result="rejected"} 0
+kvstore_leases_total{result="timeout"} 2
+kvstore_leases_total{result="wait_timeout"} 0
+`
+	err := testutil.GatherAndCompare(r.stats.metrics.registry, strings.NewReader(expected), "kvstore_leases_total")
+	assert.Equal(t, nil, err)
+}
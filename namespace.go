@@ -0,0 +1,162 @@
+package kvstore
+
+import (
+	"bytes"
+	"github.com/QuangTung97/kvstore/lease"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// namespaceSeparator separates the namespace from the rest of a key, e.g. users:1234
+const namespaceSeparator = ':'
+
+type namespaceOptions struct {
+	name         string
+	numSegments  int
+	segmentSize  int
+	leaseOptions []lease.Option
+}
+
+// namespace is a keyspace with its own lease cache, flushing replaces the cache with an empty one.
+// Outstanding leases of the old cache are released and not accepted by the new cache, their waiters
+// are woken up to read the key from the new cache
+type namespace struct {
+	options  namespaceOptions
+	cache    atomic.Value // *lease.Cache
+	flushes  atomicUint64
+	flushMut sync.Mutex // serializes the flushes, so that every replaced cache is released
+	expired  uint64     // expired leases of the replaced caches, guarded by flushMut
+}
+
+func newNamespace(options namespaceOptions) *namespace {
+	ns := &namespace{options: options}
+	ns.cache.Store(ns.newCache())
+	return ns
+}
+
+func (ns *namespace) newCache() *lease.Cache {
+	return lease.New(ns.options.numSegments, ns.options.segmentSize, ns.options.leaseOptions...)
+}
+
+func (ns *namespace) getCache() *lease.Cache {
+	return ns.cache.Load().(*lease.Cache)
+}
+
+func (ns *namespace) flush() {
+	ns.flushMut.Lock()
+	defer ns.flushMut.Unlock()
+
+	old := ns.getCache()
+	ns.cache.Store(ns.newCache())
+	old.ReleaseAll()
+	ns.flushes.add(1)
+	ns.expired += expiredLeases(old)
+}
+
+// expiredLeases returns the number of expired leases of the namespace, including the replaced caches
+func (ns *namespace) expiredLeases() uint64 {
+	ns.flushMut.Lock()
+	defer ns.flushMut.Unlock()
+
+	return ns.expired + expiredLeases(ns.getCache())
+}
+
+func expiredLeases(cache *lease.Cache) uint64 {
+	total := uint64(0)
+	for _, stats := range cache.GetBucketStats() {
+		total += stats.Expired
+	}
+	return total
+}
+
+// namespaces routes keys to the caches of the namespaces by the prefix of the keys,
+// keys without a configured namespace are stored in the default cache
+type namespaces struct {
+	defaultCache *lease.Cache
+	byName       map[string]*namespace
+	list         []*namespace // sorted by name
+	snapshots    *snapshotter // nil if no snapshot file is configured
+}
+
+func newNamespaces(defaultCache *lease.Cache, options []namespaceOptions) *namespaces {
+	n := &namespaces{
+		defaultCache: defaultCache,
+		byName:       map[string]*namespace{},
+	}
+	for _, o := range options {
+		n.byName[o.name] = newNamespace(o)
+	}
+	for _, ns := range n.byName {
+		n.list = append(n.list, ns)
+	}
+	sort.Slice(n.list, func(i, j int) bool {
+		return n.list[i].options.name < n.list[j].options.name
+	})
+	return n
+}
+
+func (n *namespaces) cacheOf(key []byte) *lease.Cache {
+	if len(n.byName) == 0 {
+		return n.defaultCache
+	}
+	index := bytes.IndexByte(key, namespaceSeparator)
+	if index <= 0 {
+		return n.defaultCache
+	}
+	ns, ok := n.byName[string(key[:index])]
+	if !ok {
+		return n.defaultCache
+	}
+	return ns.getCache()
+}
+
+// flush removes all the keys and leases of the namespace, returns false if the namespace does not exist
+func (n *namespaces) flush(name []byte) bool {
+	ns, ok := n.byName[string(name)]
+	if !ok {
+		return false
+	}
+	ns.flush()
+	return true
+}
+
+// rangeCaches calls fn with the default cache and the current caches of the namespaces
+func (n *namespaces) rangeCaches(fn func(cache *lease.Cache)) {
+	fn(n.defaultCache)
+	for _, ns := range n.list {
+		fn(ns.getCache())
+	}
+}
+
+// deleteTag invalidates the entries with the tag in all the namespaces
+func (n *namespaces) deleteTag(tag []byte) {
+	n.rangeCaches(func(cache *lease.Cache) {
+		cache.DeleteTag(tag)
+	})
+}
+
+// expiredLeases returns the number of expired leases of all caches
+func (n *namespaces) expiredLeases() uint64 {
+	total := expiredLeases(n.defaultCache)
+	for _, ns := range n.list {
+		total += ns.expiredLeases()
+	}
+	return total
+}
+
+// collectStats returns the STATS entries of the namespaces
+func (n *namespaces) collectStats() []statEntry {
+	entries := make([]statEntry, 0, 2*len(n.list))
+	for _, ns := range n.list {
+		prefix := "namespace_" + ns.options.name + "_"
+		entries = append(entries,
+			statEntry{name: prefix + "items", value: ns.getCache().GetUnsafeInnerCache().GetTotal()},
+			statEntry{name: prefix + "flushes", value: ns.flushes.load()},
+		)
+	}
+	if n.snapshots != nil {
+		entries = append(entries, n.snapshots.collectStats()...)
+	}
+	return entries
+}
@@ -0,0 +1,52 @@
+package kvstore
+
+import (
+	"github.com/QuangTung97/kvstore/lease"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNamespaces_CacheOf(t *testing.T) {
+	defaultCache := lease.New(1, 1<<16)
+	n := newNamespaces(defaultCache, []namespaceOptions{
+		{name: "users", numSegments: 1, segmentSize: 1 << 16},
+		{name: "orders", numSegments: 1, segmentSize: 1 << 16},
+	})
+
+	users := n.cacheOf([]byte("users:1234"))
+	orders := n.cacheOf([]byte("orders:1234"))
+	assert.NotEqual(t, defaultCache, users)
+	assert.NotEqual(t, defaultCache, orders)
+	assert.NotEqual(t, users, orders)
+	assert.Equal(t, users, n.cacheOf([]byte("users:")))
+
+	assert.Equal(t, defaultCache, n.cacheOf([]byte("users")))
+	assert.Equal(t, defaultCache, n.cacheOf([]byte(":users")))
+	assert.Equal(t, defaultCache, n.cacheOf([]byte("products:1234")))
+
+	assert.Equal(t, []string{"orders", "users"}, []string{n.list[0].options.name, n.list[1].options.name})
+}
+
+func TestNamespaces_Flush(t *testing.T) {
+	n := newNamespaces(lease.New(1, 1<<16), []namespaceOptions{
+		{name: "users", numSegments: 1, segmentSize: 1 << 16},
+	})
+
+	key := []byte("users:1234")
+	n.cacheOf(key).Put(key, []byte("value"))
+	result := n.cacheOf([]byte("users:5678")).Get([]byte("users:5678"), nil)
+	assert.Equal(t, lease.GetStatusLeaseGranted, result.Status)
+
+	assert.Equal(t, true, n.flush([]byte("users")))
+	assert.Equal(t, false, n.flush([]byte("orders")))
+
+	_, ok := n.cacheOf(key).Lookup(key, nil)
+	assert.Equal(t, false, ok)
+	affected := n.cacheOf([]byte("users:5678")).Set([]byte("users:5678"), result.LeaseID, []byte("value"))
+	assert.Equal(t, false, affected)
+
+	assert.Equal(t, []statEntry{
+		{name: "namespace_users_items", value: 0},
+		{name: "namespace_users_flushes", value: 1},
+	}, n.collectStats())
+}
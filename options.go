@@ -1,8 +1,28 @@
 package kvstore
 
-import "go.uber.org/zap"
+import (
+	"github.com/QuangTung97/kvstore/lease"
+	"go.uber.org/zap"
+	"time"
+)
 
 type kvstoreOptions struct {
+	address         string
+	tcpAddress      string
+	unixAddress     string
+	unixgramAddress string
+	metricsAddress  string
+
+	memcachedAddress string
+	memcachedLeases  bool
+
+	respAddress string
+
+	cacheNumSegments int
+	cacheSegmentSize int
+	leaseOptions     []lease.Option
+	namespaces       []namespaceOptions
+
 	numProcessors        int
 	bufferSize           int
 	maxResultPackageSize int
@@ -10,6 +30,26 @@ type kvstoreOptions struct {
 	bigCommandStoreSize int
 	maxBatchSize        int
 
+	v0FramesDisabled bool
+	authKeys         map[uint32][]byte
+	encryptionKeys   map[uint32][]byte
+
+	rateLimit      RateLimit
+	cidrRateLimits map[string]RateLimit
+
+	accessRules       map[string]AccessRole
+	defaultAccessRole AccessRole
+
+	snapshotFile     string
+	snapshotInterval time.Duration
+	restoreSnapshot  bool
+
+	warmUpAddress        string
+	warmUpBytesPerSecond int
+	warmUpPeer           string
+	warmUpNode           string
+	warmUpRing           HashRing
+
 	logger *zap.Logger
 }
 
@@ -18,6 +58,11 @@ type Option func(opts *kvstoreOptions)
 
 func computeOptions(options ...Option) kvstoreOptions {
 	opts := kvstoreOptions{
+		address: ":7000",
+
+		cacheNumSegments: 16,
+		cacheSegmentSize: 4 << 20, // 4MB
+
 		numProcessors:        4,
 		bufferSize:           2 << 20, // 2MB
 		maxResultPackageSize: 1 << 15, // 32KB
@@ -25,6 +70,10 @@ func computeOptions(options ...Option) kvstoreOptions {
 		bigCommandStoreSize: 8 << 20, // 8MB
 		maxBatchSize:        1 << 20, // 1MB
 
+		defaultAccessRole: AccessRoleReadWrite,
+
+		warmUpBytesPerSecond: 16 << 20, // 16MB
+
 		logger: zap.NewNop(),
 	}
 	for _, o := range options {
@@ -33,6 +82,94 @@ func computeOptions(options ...Option) kvstoreOptions {
 	return opts
 }
 
+// WithAddress configures the listening address of the server
+func WithAddress(addr string) Option {
+	return func(opts *kvstoreOptions) {
+		opts.address = addr
+	}
+}
+
+// WithTCPAddress enables the TCP listener of the native protocol on the address,
+// the data frames are the same as the UDP packages but prefixed by 4 bytes of length
+func WithTCPAddress(addr string) Option {
+	return func(opts *kvstoreOptions) {
+		opts.tcpAddress = addr
+	}
+}
+
+// WithUnixAddress enables the Unix stream socket of the native protocol on the path,
+// the data frames are prefixed by length like the TCP listener
+func WithUnixAddress(path string) Option {
+	return func(opts *kvstoreOptions) {
+		opts.unixAddress = path
+	}
+}
+
+// WithUnixgramAddress enables the Unix datagram socket of the native protocol on the path
+func WithUnixgramAddress(path string) Option {
+	return func(opts *kvstoreOptions) {
+		opts.unixgramAddress = path
+	}
+}
+
+// WithMetricsAddress enables the HTTP endpoint /metrics for Prometheus on the address
+func WithMetricsAddress(addr string) Option {
+	return func(opts *kvstoreOptions) {
+		opts.metricsAddress = addr
+	}
+}
+
+// WithMemcachedAddress enables the TCP listener of the memcached text protocol on the address
+func WithMemcachedAddress(addr string) Option {
+	return func(opts *kvstoreOptions) {
+		opts.memcachedAddress = addr
+	}
+}
+
+// WithMemcachedLeases makes the get command of the memcached listener acquire leases on misses,
+// the leases are then used by the set command of the same key on the same connection
+func WithMemcachedLeases() Option {
+	return func(opts *kvstoreOptions) {
+		opts.memcachedLeases = true
+	}
+}
+
+// WithRESPAddress enables the TCP listener of the RESP2 (Redis) protocol on the address
+func WithRESPAddress(addr string) Option {
+	return func(opts *kvstoreOptions) {
+		opts.respAddress = addr
+	}
+}
+
+// WithCacheSize configures the number of segments and the size of each segment of the cache
+func WithCacheSize(numSegments int, segmentSize int) Option {
+	return func(opts *kvstoreOptions) {
+		opts.cacheNumSegments = numSegments
+		opts.cacheSegmentSize = segmentSize
+	}
+}
+
+// WithLeaseOptions configures the lease cache
+func WithLeaseOptions(options ...lease.Option) Option {
+	return func(opts *kvstoreOptions) {
+		opts.leaseOptions = append(opts.leaseOptions, options...)
+	}
+}
+
+// WithNamespace adds a namespace with its own cache and lease settings, keys prefixed by the name
+// and a colon, e.g. users:1234, are stored in the namespace, so that the keys of other namespaces
+// are not evicted by it. Namespaces apply to the native protocol, FLUSH removes all keys of a namespace
+func WithNamespace(name string, numSegments int, segmentSize int, leaseOptions ...lease.Option) Option {
+	return func(opts *kvstoreOptions) {
+		opts.namespaces = append(opts.namespaces, namespaceOptions{
+			name:         name,
+			numSegments:  numSegments,
+			segmentSize:  segmentSize,
+			leaseOptions: leaseOptions,
+		})
+	}
+}
+
 // WithNumProcessors ...
 func WithNumProcessors(n int) Option {
 	return func(opts *kvstoreOptions) {
@@ -61,6 +198,120 @@ func WithMaxBatchSize(size int) Option {
 	}
 }
 
+// WithoutV0Frames drops the frames of version 0, which have no magic, so that stray packages
+// are not parsed as commands, only enable after all clients send versioned frames
+func WithoutV0Frames() Option {
+	return func(opts *kvstoreOptions) {
+		opts.v0FramesDisabled = true
+	}
+}
+
+// WithAuthKeys requires frames to be signed with HMAC-SHA256 by one of the keys, indexed by key id,
+// frames of version 0 are always dropped since they can not be signed
+func WithAuthKeys(keys map[uint32][]byte) Option {
+	return func(opts *kvstoreOptions) {
+		opts.authKeys = keys
+	}
+}
+
+// WithEncryptionKeys requires frames to be encrypted with AES-GCM by one of the keys, indexed by key id,
+// the responses are encrypted with the key of the requests. The keys must be 16, 24 or 32 bytes
+func WithEncryptionKeys(keys map[uint32][]byte) Option {
+	return func(opts *kvstoreOptions) {
+		opts.encryptionKeys = keys
+	}
+}
+
+// WithRateLimit configures the default rate limit of each client IP,
+// the commands of a batch exceeding the limit are replied with rate limited errors.
+// TCP clients of IPv6 are limited per connection
+func WithRateLimit(limit RateLimit) Option {
+	return func(opts *kvstoreOptions) {
+		opts.rateLimit = limit
+	}
+}
+
+// WithCIDRRateLimit overrides the rate limit of the client IPs in the CIDR, e.g. 10.0.0.0/8,
+// the most specific CIDR is used if there are overlapping CIDRs. Only IPv4 addresses are matched
+func WithCIDRRateLimit(cidr string, limit RateLimit) Option {
+	return func(opts *kvstoreOptions) {
+		if opts.cidrRateLimits == nil {
+			opts.cidrRateLimits = map[string]RateLimit{}
+		}
+		opts.cidrRateLimits[cidr] = limit
+	}
+}
+
+// WithAccessRule sets the role of the client IPs in the CIDR, e.g. 10.0.0.0/8,
+// the most specific CIDR is used if there are overlapping CIDRs.
+// Commands not allowed by the role are replied with permission denied errors.
+// Only IPv4 addresses are matched, TCP clients of IPv6 are denied
+func WithAccessRule(cidr string, role AccessRole) Option {
+	return func(opts *kvstoreOptions) {
+		if opts.accessRules == nil {
+			opts.accessRules = map[string]AccessRole{}
+		}
+		opts.accessRules[cidr] = role
+	}
+}
+
+// WithDefaultAccessRole sets the role of the client IPs not matching any access rule, default is AccessRoleReadWrite
+func WithDefaultAccessRole(role AccessRole) Option {
+	return func(opts *kvstoreOptions) {
+		opts.defaultAccessRole = role
+	}
+}
+
+// WithSnapshotFile enables the SNAPSHOT command, which writes the keys and values of the caches
+// to the file in background. Tagged and stale values are not included in snapshots
+func WithSnapshotFile(path string) Option {
+	return func(opts *kvstoreOptions) {
+		opts.snapshotFile = path
+	}
+}
+
+// WithSnapshotInterval writes a snapshot to the snapshot file at every interval, default is disabled
+func WithSnapshotInterval(interval time.Duration) Option {
+	return func(opts *kvstoreOptions) {
+		opts.snapshotInterval = interval
+	}
+}
+
+// WithRestoreSnapshot loads the snapshot file into the caches before accepting traffic,
+// the server starts with empty caches if the file does not exist or is corrupted
+func WithRestoreSnapshot() Option {
+	return func(opts *kvstoreOptions) {
+		opts.restoreSnapshot = true
+	}
+}
+
+// WithWarmUpAddress listens on the TCP address for warm-up requests of starting nodes,
+// the values owned by a starting node are streamed to it, except tagged and stale values like snapshots.
+// Requires the ReadOnly role of the client IP
+func WithWarmUpAddress(addr string) Option {
+	return func(opts *kvstoreOptions) {
+		opts.warmUpAddress = addr
+	}
+}
+
+// WithWarmUpRate limits the bytes per second streamed to each starting node, default is 16MB. Zero means unlimited
+func WithWarmUpRate(bytesPerSecond int) Option {
+	return func(opts *kvstoreOptions) {
+		opts.warmUpBytesPerSecond = bytesPerSecond
+	}
+}
+
+// WithWarmUpFrom streams the values owned by the node in the ring from the warm-up address of the peer
+// before accepting traffic, the server starts with the values received so far if the peer fails.
+// The ring must not exceed the limits of HashRing
+func WithWarmUpFrom(peer string, node string, ring HashRing) Option {
+	return func(opts *kvstoreOptions) {
+		opts.warmUpPeer = peer
+		opts.warmUpNode = node
+		opts.warmUpRing = ring
+	}
+}
+
 // WithLogger ...
 func WithLogger(logger *zap.Logger) Option {
 	return func(opts *kvstoreOptions) {
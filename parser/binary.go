@@ -0,0 +1,297 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// BinaryOpcode is the first byte of a command in the binary encoding
+type BinaryOpcode uint8
+
+// The binary encoding of the commands, numbers are little endian:
+//
+//	LGET:      opcode, key length (2 bytes), key
+//	LGETW:     opcode, key length, key, timeout (4 bytes)
+//	LSET:      opcode, key length, key, lease (8 bytes), value length (4 bytes), value
+//	LRELEASE:  opcode, key length, key, lease
+//	LEXTEND:   opcode, key length, key, lease, seconds (4 bytes)
+//	DEL:       opcode, key length, key
+//	DEL STALE: opcode, key length, key
+//	DEBUG:     opcode, key length, key
+//	STATS:     opcode
+//	FLUSH:     opcode, key length, namespace
+//	LSET TAGS: the same as LSET, then number of tags (2 bytes), tag length (2 bytes) and tag of each tag
+//	DELTAG:    opcode, key length, tag
+//	SNAPSHOT:  opcode
+const (
+	BinaryOpcodeLGET BinaryOpcode = iota + 1
+	BinaryOpcodeLGETW
+	BinaryOpcodeLSET
+	BinaryOpcodeLRELEASE
+	BinaryOpcodeLEXTEND
+	BinaryOpcodeDEL
+	BinaryOpcodeDELStale
+	BinaryOpcodeDEBUG
+	BinaryOpcodeSTATS
+	BinaryOpcodeFLUSH
+	BinaryOpcodeLSETTags
+	BinaryOpcodeDELTAG
+	BinaryOpcodeSNAPSHOT
+)
+
+const binaryKeyLengthSize = 2
+const binaryLeaseSize = 8
+const binaryUint32Size = 4
+
+// MaxBinaryKeyLength is the maximum length in bytes of a key, a namespace or a tag in the binary encoding
+const MaxBinaryKeyLength = math.MaxUint16
+
+// ErrInvalidBinaryLength ...
+var ErrInvalidBinaryLength = errors.New("invalid binary command length")
+
+// ErrKeyTooLong is returned when a key is longer than MaxBinaryKeyLength
+var ErrKeyTooLong = errors.New("key too long")
+
+// BinaryCommand is a command in the binary encoding,
+// Number is the timeout of LGETW or the seconds of LEXTEND
+type BinaryCommand struct {
+	Opcode BinaryOpcode
+	Key    []byte
+	Lease  uint64
+	Number uint32
+	Value  []byte
+	Tags   [][]byte
+}
+
+// CheckBinaryKey returns an error if the key does not fit in the key length of the binary encoding
+func CheckBinaryKey(key []byte) error {
+	if len(key) > MaxBinaryKeyLength {
+		return ErrKeyTooLong
+	}
+	return nil
+}
+
+// AppendBinaryCommand appends the binary encoding of the command to data,
+// the key must have been checked by CheckBinaryKey and the tags by CheckTags
+func AppendBinaryCommand(data []byte, cmd BinaryCommand) []byte {
+	var buf [binaryLeaseSize]byte
+
+	data = append(data, byte(cmd.Opcode))
+	if cmd.Opcode == BinaryOpcodeSTATS || cmd.Opcode == BinaryOpcodeSNAPSHOT {
+		return data
+	}
+
+	binary.LittleEndian.PutUint16(buf[:], uint16(len(cmd.Key)))
+	data = append(data, buf[:binaryKeyLengthSize]...)
+	data = append(data, cmd.Key...)
+
+	switch cmd.Opcode {
+	case BinaryOpcodeLSET, BinaryOpcodeLSETTags, BinaryOpcodeLRELEASE, BinaryOpcodeLEXTEND:
+		binary.LittleEndian.PutUint64(buf[:], cmd.Lease)
+		data = append(data, buf[:binaryLeaseSize]...)
+	default:
+	}
+
+	switch cmd.Opcode {
+	case BinaryOpcodeLSET, BinaryOpcodeLSETTags:
+		binary.LittleEndian.PutUint32(buf[:], uint32(len(cmd.Value)))
+		data = append(data, buf[:binaryUint32Size]...)
+		data = append(data, cmd.Value...)
+	case BinaryOpcodeLGETW, BinaryOpcodeLEXTEND:
+		binary.LittleEndian.PutUint32(buf[:], cmd.Number)
+		data = append(data, buf[:binaryUint32Size]...)
+	default:
+	}
+
+	if cmd.Opcode == BinaryOpcodeLSETTags {
+		data = appendBinaryTags(data, cmd.Tags)
+	}
+	return data
+}
+
+func appendBinaryTags(data []byte, tags [][]byte) []byte {
+	var buf [binaryKeyLengthSize]byte
+	binary.LittleEndian.PutUint16(buf[:], uint16(len(tags)))
+	data = append(data, buf[:]...)
+	for _, tag := range tags {
+		binary.LittleEndian.PutUint16(buf[:], uint16(len(tag)))
+		data = append(data, buf[:]...)
+		data = append(data, tag...)
+	}
+	return data
+}
+
+// binaryReader reads the fields of a binary command, ok becomes false when there is not enough data
+type binaryReader struct {
+	data []byte
+	ok   bool
+}
+
+func (r *binaryReader) next(n int) []byte {
+	if !r.ok || len(r.data) < n {
+		r.ok = false
+		return nil
+	}
+	result := r.data[:n]
+	r.data = r.data[n:]
+	return result
+}
+
+func (r *binaryReader) readKey() []byte {
+	lengthData := r.next(binaryKeyLengthSize)
+	if !r.ok {
+		return nil
+	}
+	return r.next(int(binary.LittleEndian.Uint16(lengthData)))
+}
+
+func (r *binaryReader) readLease() uint64 {
+	data := r.next(binaryLeaseSize)
+	if !r.ok {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(data)
+}
+
+func (r *binaryReader) readUint32() uint32 {
+	data := r.next(binaryUint32Size)
+	if !r.ok {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(data)
+}
+
+// finished returns true if all the fields have been read and there is no remaining data
+func (r *binaryReader) finished() bool {
+	return r.ok && len(r.data) == 0
+}
+
+// ProcessBinary parses a command in the binary encoding, in which keys can contain any bytes
+func (p *Parser) ProcessBinary(data []byte) error {
+	if len(data) == 0 {
+		return ErrMissingCommand
+	}
+
+	opcode := BinaryOpcode(data[0])
+	r := binaryReader{data: data[1:], ok: true}
+
+	switch opcode {
+	case BinaryOpcodeLGET, BinaryOpcodeDEL, BinaryOpcodeDELStale, BinaryOpcodeDEBUG,
+		BinaryOpcodeFLUSH, BinaryOpcodeDELTAG:
+		return p.processBinaryKeyCommand(opcode, &r)
+	case BinaryOpcodeLSETTags:
+		return p.processBinaryLSETTags(&r)
+	case BinaryOpcodeLSET, BinaryOpcodeLRELEASE, BinaryOpcodeLEXTEND:
+		return p.processBinaryLeaseCommand(opcode, &r)
+	case BinaryOpcodeLGETW:
+		key := r.readKey()
+		timeout := r.readUint32()
+		if !r.finished() {
+			return ErrInvalidBinaryLength
+		}
+		p.handler.OnLGETW(key, timeout)
+		return nil
+	case BinaryOpcodeSTATS, BinaryOpcodeSNAPSHOT:
+		return p.processBinaryNoArgsCommand(opcode, &r)
+	default:
+		return ErrInvalidCommand
+	}
+}
+
+func (p *Parser) processBinaryNoArgsCommand(opcode BinaryOpcode, r *binaryReader) error {
+	if !r.finished() {
+		return ErrInvalidBinaryLength
+	}
+
+	if opcode == BinaryOpcodeSNAPSHOT {
+		p.handler.OnSNAPSHOT()
+		return nil
+	}
+	p.handler.OnSTATS()
+	return nil
+}
+
+func (p *Parser) processBinaryKeyCommand(opcode BinaryOpcode, r *binaryReader) error {
+	key := r.readKey()
+	if !r.finished() {
+		return ErrInvalidBinaryLength
+	}
+
+	switch opcode {
+	case BinaryOpcodeLGET:
+		p.handler.OnLGET(key)
+	case BinaryOpcodeDEL:
+		p.handler.OnDEL(key)
+	case BinaryOpcodeDELStale:
+		p.handler.OnDELStale(key)
+	case BinaryOpcodeFLUSH:
+		p.handler.OnFLUSH(key)
+	case BinaryOpcodeDELTAG:
+		if len(key) > MaxTagLength {
+			return ErrTagTooLong
+		}
+		p.handler.OnDELTAG(key)
+	default:
+		p.handler.OnDEBUG(key)
+	}
+	return nil
+}
+
+func (p *Parser) processBinaryLeaseCommand(opcode BinaryOpcode, r *binaryReader) error {
+	key := r.readKey()
+	lease := r.readLease()
+
+	var value []byte
+	var seconds uint32
+	switch opcode {
+	case BinaryOpcodeLSET:
+		value = r.next(int(r.readUint32()))
+	case BinaryOpcodeLEXTEND:
+		seconds = r.readUint32()
+	default:
+	}
+
+	if !r.finished() {
+		return ErrInvalidBinaryLength
+	}
+
+	switch opcode {
+	case BinaryOpcodeLSET:
+		p.handler.OnLSET(key, lease, value)
+	case BinaryOpcodeLEXTEND:
+		p.handler.OnLEXTEND(key, lease, seconds)
+	default:
+		p.handler.OnLRELEASE(key, lease)
+	}
+	return nil
+}
+
+func (p *Parser) processBinaryLSETTags(r *binaryReader) error {
+	key := r.readKey()
+	lease := r.readLease()
+	value := r.next(int(r.readUint32()))
+
+	countData := r.next(binaryKeyLengthSize)
+	if !r.ok {
+		return ErrInvalidBinaryLength
+	}
+	count := int(binary.LittleEndian.Uint16(countData))
+	if count > MaxTags {
+		return ErrTooManyTags
+	}
+
+	p.tags = p.tags[:0]
+	for i := 0; i < count; i++ {
+		p.tags = append(p.tags, r.readKey())
+	}
+	if !r.finished() {
+		return ErrInvalidBinaryLength
+	}
+	if err := CheckTags(p.tags); err != nil {
+		return err
+	}
+
+	p.handler.OnLSETTags(key, lease, value, p.tags)
+	return nil
+}
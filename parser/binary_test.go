@@ -0,0 +1,233 @@
+package parser
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newHandlerForBinaryTest() *CommandHandlerMock {
+	return &CommandHandlerMock{
+		OnLGETFunc:     func(key []byte) {},
+		OnLGETWFunc:    func(key []byte, timeout uint32) {},
+		OnLSETFunc:     func(key []byte, lease uint64, value []byte) {},
+		OnLRELEASEFunc: func(key []byte, lease uint64) {},
+		OnLEXTENDFunc:  func(key []byte, lease uint64, seconds uint32) {},
+		OnDELFunc:      func(key []byte) {},
+		OnDELStaleFunc: func(key []byte) {},
+		OnDEBUGFunc:    func(key []byte) {},
+		OnSTATSFunc:    func() {},
+		OnFLUSHFunc:    func(namespace []byte) {},
+		OnLSETTagsFunc: func(key []byte, lease uint64, value []byte, tags [][]byte) {},
+		OnDELTAGFunc:   func(tag []byte) {},
+		OnSNAPSHOTFunc: func() {},
+	}
+}
+
+func TestAppendBinaryCommand(t *testing.T) {
+	data := AppendBinaryCommand(nil, BinaryCommand{
+		Opcode: BinaryOpcodeLSET,
+		Key:    []byte("key 01"),
+		Lease:  0x0102,
+		Value:  []byte("value"),
+	})
+	assert.Equal(t, []byte("\x03\x06\x00key 01\x02\x01\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00value"), data)
+
+	data = AppendBinaryCommand(nil, BinaryCommand{
+		Opcode: BinaryOpcodeLGETW,
+		Key:    []byte("key01"),
+		Number: 500,
+	})
+	assert.Equal(t, []byte("\x02\x05\x00key01\xf4\x01\x00\x00"), data)
+
+	data = AppendBinaryCommand(nil, BinaryCommand{Opcode: BinaryOpcodeSTATS})
+	assert.Equal(t, []byte("\x09"), data)
+}
+
+func TestCheckBinaryKey(t *testing.T) {
+	assert.Equal(t, nil, CheckBinaryKey(make([]byte, MaxBinaryKeyLength)))
+	assert.Equal(t, ErrKeyTooLong, CheckBinaryKey(make([]byte, MaxBinaryKeyLength+1)))
+}
+
+func TestParser_ProcessBinary(t *testing.T) {
+	handler := newHandlerForBinaryTest()
+	p := newParser(handler)
+
+	process := func(cmd BinaryCommand) {
+		err := p.ProcessBinary(AppendBinaryCommand(nil, cmd))
+		assert.Equal(t, nil, err)
+	}
+
+	process(BinaryCommand{Opcode: BinaryOpcodeLGET, Key: []byte("key\r\n01")})
+	process(BinaryCommand{Opcode: BinaryOpcodeLGETW, Key: []byte("key02"), Number: 300})
+	process(BinaryCommand{
+		Opcode: BinaryOpcodeLSET, Key: []byte("key03"),
+		Lease: 12345678901234, Value: []byte("value"),
+	})
+	process(BinaryCommand{Opcode: BinaryOpcodeLSET, Key: []byte("key04"), Lease: 10})
+	process(BinaryCommand{Opcode: BinaryOpcodeLRELEASE, Key: []byte("key05"), Lease: 11})
+	process(BinaryCommand{Opcode: BinaryOpcodeLEXTEND, Key: []byte("key06"), Lease: 12, Number: 60})
+	process(BinaryCommand{Opcode: BinaryOpcodeDEL, Key: []byte("key07")})
+	process(BinaryCommand{Opcode: BinaryOpcodeDELStale, Key: []byte("key08")})
+	process(BinaryCommand{Opcode: BinaryOpcodeDEBUG, Key: []byte("key09")})
+	process(BinaryCommand{Opcode: BinaryOpcodeSTATS})
+	process(BinaryCommand{Opcode: BinaryOpcodeFLUSH, Key: []byte("ns01")})
+	process(BinaryCommand{
+		Opcode: BinaryOpcodeLSETTags, Key: []byte("key10"), Lease: 13,
+		Value: []byte("value"), Tags: [][]byte{[]byte("user:1"), []byte("team:2")},
+	})
+	process(BinaryCommand{Opcode: BinaryOpcodeDELTAG, Key: []byte("user:1")})
+	process(BinaryCommand{Opcode: BinaryOpcodeSNAPSHOT})
+
+	assert.Equal(t, []byte("key\r\n01"), handler.OnLGETCalls()[0].Key)
+	assert.Equal(t, uint32(300), handler.OnLGETWCalls()[0].Timeout)
+
+	assert.Equal(t, 2, len(handler.OnLSETCalls()))
+	assert.Equal(t, []byte("key03"), handler.OnLSETCalls()[0].Key)
+	assert.Equal(t, uint64(12345678901234), handler.OnLSETCalls()[0].Lease)
+	assert.Equal(t, []byte("value"), handler.OnLSETCalls()[0].Value)
+	assert.Equal(t, 0, len(handler.OnLSETCalls()[1].Value))
+
+	assert.Equal(t, uint64(11), handler.OnLRELEASECalls()[0].Lease)
+	assert.Equal(t, uint64(12), handler.OnLEXTENDCalls()[0].Lease)
+	assert.Equal(t, uint32(60), handler.OnLEXTENDCalls()[0].Seconds)
+	assert.Equal(t, []byte("key07"), handler.OnDELCalls()[0].Key)
+	assert.Equal(t, []byte("key08"), handler.OnDELStaleCalls()[0].Key)
+	assert.Equal(t, []byte("key09"), handler.OnDEBUGCalls()[0].Key)
+	assert.Equal(t, 1, len(handler.OnSTATSCalls()))
+	assert.Equal(t, []byte("ns01"), handler.OnFLUSHCalls()[0].Namespace)
+	assert.Equal(t, uint64(13), handler.OnLSETTagsCalls()[0].Lease)
+	assert.Equal(t, []byte("value"), handler.OnLSETTagsCalls()[0].Value)
+	assert.Equal(t, [][]byte{[]byte("user:1"), []byte("team:2")}, handler.OnLSETTagsCalls()[0].Tags)
+	assert.Equal(t, []byte("user:1"), handler.OnDELTAGCalls()[0].Tag)
+	assert.Equal(t, 1, len(handler.OnSNAPSHOTCalls()))
+}
+
+func TestParser_ProcessBinary_Error(t *testing.T) {
+	p := newParser(newHandlerForBinaryTest())
+
+	err := p.ProcessBinary(nil)
+	assert.Equal(t, ErrMissingCommand, err)
+
+	err = p.ProcessBinary([]byte{100})
+	assert.Equal(t, ErrInvalidCommand, err)
+
+	data := AppendBinaryCommand(nil, BinaryCommand{
+		Opcode: BinaryOpcodeLSET,
+		Key:    []byte("key01"),
+		Value:  []byte("value"),
+	})
+	err = p.ProcessBinary(data[:len(data)-1])
+	assert.Equal(t, ErrInvalidBinaryLength, err)
+
+	err = p.ProcessBinary(append(data, 'A'))
+	assert.Equal(t, ErrInvalidBinaryLength, err)
+
+	err = p.ProcessBinary([]byte{byte(BinaryOpcodeLGET), 10, 0, 'a'})
+	assert.Equal(t, ErrInvalidBinaryLength, err)
+
+	err = p.ProcessBinary([]byte{byte(BinaryOpcodeSTATS), 0})
+	assert.Equal(t, ErrInvalidBinaryLength, err)
+
+	data = AppendBinaryCommand(nil, BinaryCommand{
+		Opcode: BinaryOpcodeLSETTags,
+		Key:    []byte("key01"),
+		Tags:   [][]byte{[]byte("tag01")},
+	})
+	err = p.ProcessBinary(data[:len(data)-1])
+	assert.Equal(t, ErrInvalidBinaryLength, err)
+
+	data = AppendBinaryCommand(nil, BinaryCommand{
+		Opcode: BinaryOpcodeLSETTags,
+		Key:    []byte("key01"),
+		Tags:   make([][]byte, MaxTags+1),
+	})
+	err = p.ProcessBinary(data)
+	assert.Equal(t, ErrTooManyTags, err)
+
+	data = AppendBinaryCommand(nil, BinaryCommand{
+		Opcode: BinaryOpcodeLSETTags,
+		Key:    []byte("key01"),
+		Tags:   [][]byte{make([]byte, MaxTagLength+1)},
+	})
+	err = p.ProcessBinary(data)
+	assert.Equal(t, ErrTagTooLong, err)
+
+	data = AppendBinaryCommand(nil, BinaryCommand{
+		Opcode: BinaryOpcodeDELTAG,
+		Key:    make([]byte, MaxTagLength+1),
+	})
+	err = p.ProcessBinary(data)
+	assert.Equal(t, ErrTagTooLong, err)
+}
+
+// nopHandler does not record calls, for measuring only the parsing in benchmarks
+type nopHandler struct {
+}
+
+var _ CommandHandler = nopHandler{}
+
+func (nopHandler) OnLGET([]byte)                               {}
+func (nopHandler) OnLGETW([]byte, uint32)                      {}
+func (nopHandler) OnLSET([]byte, uint64, []byte)               {}
+func (nopHandler) OnLRELEASE([]byte, uint64)                   {}
+func (nopHandler) OnLEXTEND([]byte, uint64, uint32)            {}
+func (nopHandler) OnDEL([]byte)                                {}
+func (nopHandler) OnDELStale([]byte)                           {}
+func (nopHandler) OnDEBUG([]byte)                              {}
+func (nopHandler) OnSTATS()                                    {}
+func (nopHandler) OnFLUSH([]byte)                              {}
+func (nopHandler) OnLSETTags([]byte, uint64, []byte, [][]byte) {}
+func (nopHandler) OnDELTAG([]byte)                             {}
+func (nopHandler) OnSNAPSHOT()                                 {}
+
+func BenchmarkParser_Text_LSET(b *testing.B) {
+	p := newParser(nopHandler{})
+	data := []byte("LSET some-key-for-benchmark 1234567890 32\r\n01234567890123456789012345678901\r\n")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = p.Process(data)
+	}
+}
+
+func BenchmarkParser_Binary_LSET(b *testing.B) {
+	p := newParser(nopHandler{})
+	data := AppendBinaryCommand(nil, BinaryCommand{
+		Opcode: BinaryOpcodeLSET,
+		Key:    []byte("some-key-for-benchmark"),
+		Lease:  1234567890,
+		Value:  []byte("01234567890123456789012345678901"),
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = p.ProcessBinary(data)
+	}
+}
+
+func BenchmarkParser_Text_LGET(b *testing.B) {
+	p := newParser(nopHandler{})
+	data := []byte("LGET some-key-for-benchmark\r\n")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = p.Process(data)
+	}
+}
+
+func BenchmarkParser_Binary_LGET(b *testing.B) {
+	p := newParser(nopHandler{})
+	data := AppendBinaryCommand(nil, BinaryCommand{
+		Opcode: BinaryOpcodeLGET,
+		Key:    []byte("some-key-for-benchmark"),
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = p.ProcessBinary(data)
+	}
+}
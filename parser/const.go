@@ -3,8 +3,26 @@ package parser
 var (
 	// LGET command
 	LGET = []byte("LGET")
+	// LGETW command
+	LGETW = []byte("LGETW")
 	// LSET command
 	LSET = []byte("LSET")
+	// LRELEASE command
+	LRELEASE = []byte("LRELEASE")
+	// LEXTEND command
+	LEXTEND = []byte("LEXTEND")
 	// DEL command
 	DEL = []byte("DEL")
+	// DEBUG command
+	DEBUG = []byte("DEBUG")
+	// STATS command
+	STATS = []byte("STATS")
+	// DELTAG command
+	DELTAG = []byte("DELTAG")
+	// FLUSH command
+	FLUSH = []byte("FLUSH")
+	// SNAPSHOT command
+	SNAPSHOT = []byte("SNAPSHOT")
+	// STALE option of DEL command
+	STALE = []byte("STALE")
 )
@@ -0,0 +1,197 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+)
+
+//go:generate moq -out memcached_mocks_test.go . MemcachedHandler
+
+// MemcachedHandler handles the commands of the memcached text protocol
+type MemcachedHandler interface {
+	OnGet(keys [][]byte, withCAS bool)
+	OnSet(key []byte, flags uint32, value []byte, noReply bool)
+	OnDelete(key []byte, noReply bool)
+	OnStats()
+}
+
+var (
+	memcachedGet     = []byte("get")
+	memcachedGets    = []byte("gets")
+	memcachedSet     = []byte("set")
+	memcachedDelete  = []byte("delete")
+	memcachedStats   = []byte("stats")
+	memcachedNoReply = []byte("noreply")
+
+	crlf = []byte("\r\n")
+)
+
+// ErrMissingFlags ...
+var ErrMissingFlags = errors.New("missing flags")
+
+// ErrFlagsNotNumber ...
+var ErrFlagsNotNumber = errors.New("flags is not number")
+
+// ErrMissingExptime ...
+var ErrMissingExptime = errors.New("missing exptime")
+
+// ErrBadDataChunk ...
+var ErrBadDataChunk = errors.New("bad data chunk")
+
+// MemcachedParser parses the command lines of the memcached text protocol
+type MemcachedParser struct {
+	handler MemcachedHandler
+	scanner scanner
+	keys    [][]byte
+}
+
+// InitMemcachedParser ...
+func InitMemcachedParser(p *MemcachedParser, handler MemcachedHandler) {
+	p.handler = handler
+	initScanner(&p.scanner)
+}
+
+// Process parses a command line ending with CRLF. For storage commands, readData is called
+// to read the data block of the given size, including its CRLF, that follows the command line
+func (p *MemcachedParser) Process(line []byte, readData func(size int) ([]byte, error)) error {
+	p.scanner.reset()
+	p.scanner.scan(line)
+
+	tokens := p.scanner.tokens
+	if len(tokens) == 0 || tokens[0].tokenType == tokenTypeCRLF {
+		return ErrMissingCommand
+	}
+
+	cmd := tokens[0].getData(line)
+	switch {
+	case bytes.Equal(cmd, memcachedGet):
+		return p.processGet(line, false)
+	case bytes.Equal(cmd, memcachedGets):
+		return p.processGet(line, true)
+	case bytes.Equal(cmd, memcachedSet):
+		return p.processSet(line, readData)
+	case bytes.Equal(cmd, memcachedDelete):
+		return p.processDelete(line)
+	case bytes.Equal(cmd, memcachedStats):
+		return p.processStats()
+	default:
+		return ErrInvalidCommand
+	}
+}
+
+// processGet for command: get|gets key*
+//
+//revive:disable-next-line:flag-parameter
+func (p *MemcachedParser) processGet(line []byte, withCAS bool) error {
+	tokens := p.scanner.tokens[1:]
+
+	p.keys = p.keys[:0]
+	for len(tokens) > 0 && tokens[0].tokenType != tokenTypeCRLF {
+		p.keys = append(p.keys, tokens[0].getData(line))
+		tokens = tokens[1:]
+	}
+
+	if len(p.keys) == 0 {
+		return ErrMissingKey
+	}
+	if len(tokens) == 0 {
+		return ErrMissingCRLF
+	}
+
+	p.handler.OnGet(p.keys, withCAS)
+	return nil
+}
+
+// parseNoReply parses the optional noreply argument and the CRLF at the end of the command line
+func parseNoReply(line []byte, tokens []token) (bool, error) {
+	noReply := false
+	if len(tokens) > 0 && bytes.Equal(tokens[0].getData(line), memcachedNoReply) {
+		noReply = true
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 || tokens[0].tokenType != tokenTypeCRLF {
+		return false, ErrMissingCRLF
+	}
+	return noReply, nil
+}
+
+// missingToken returns true if there is no argument at the index before the end of the command line
+func missingToken(tokens []token, index int) bool {
+	return len(tokens) <= index || tokens[index].tokenType == tokenTypeCRLF
+}
+
+func validateSetTokens(tokens []token) error {
+	if missingToken(tokens, 1) {
+		return ErrMissingKey
+	}
+	if missingToken(tokens, 2) {
+		return ErrMissingFlags
+	}
+	if tokens[2].tokenType != tokenTypeInt {
+		return ErrFlagsNotNumber
+	}
+	if missingToken(tokens, 3) {
+		return ErrMissingExptime
+	}
+	if missingToken(tokens, 4) {
+		return ErrMissingSize
+	}
+	if tokens[4].tokenType != tokenTypeInt {
+		return ErrSizeNotNumber
+	}
+	return nil
+}
+
+// processSet for command: set key flags exptime bytes [noreply], exptime is ignored
+func (p *MemcachedParser) processSet(line []byte, readData func(size int) ([]byte, error)) error {
+	tokens := p.scanner.tokens
+	err := validateSetTokens(tokens)
+	if err != nil {
+		return err
+	}
+	noReply, err := parseNoReply(line, tokens[5:])
+	if err != nil {
+		return err
+	}
+
+	key := tokens[1].getData(line)
+	flags := bytesToUint32(tokens[2].getData(line))
+	size := int(bytesToUint32(tokens[4].getData(line)))
+
+	data, err := readData(size + len(crlf))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data[size:], crlf) {
+		return ErrBadDataChunk
+	}
+
+	p.handler.OnSet(key, flags, data[:size], noReply)
+	return nil
+}
+
+// processDelete for command: delete key [noreply]
+func (p *MemcachedParser) processDelete(line []byte) error {
+	tokens := p.scanner.tokens
+	if len(tokens) < 2 || !tokenTypeIsString(tokens[1].tokenType) {
+		return ErrMissingKey
+	}
+	noReply, err := parseNoReply(line, tokens[2:])
+	if err != nil {
+		return err
+	}
+
+	p.handler.OnDelete(tokens[1].getData(line), noReply)
+	return nil
+}
+
+// processStats for command: stats
+func (p *MemcachedParser) processStats() error {
+	tokens := p.scanner.tokens
+	if len(tokens) < 2 || tokens[1].tokenType != tokenTypeCRLF {
+		return ErrMissingCRLF
+	}
+
+	p.handler.OnStats()
+	return nil
+}
@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newMemcachedParser(handler MemcachedHandler) *MemcachedParser {
+	p := &MemcachedParser{}
+	InitMemcachedParser(p, handler)
+	return p
+}
+
+func noDataForTest(_ int) ([]byte, error) {
+	panic("must not read data")
+}
+
+func TestMemcachedParser_Get(t *testing.T) {
+	handler := &MemcachedHandlerMock{}
+	p := newMemcachedParser(handler)
+
+	handler.OnGetFunc = func(keys [][]byte, withCAS bool) {}
+	err := p.Process([]byte("get key01 key02\r\n"), noDataForTest)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnGetCalls()))
+	assert.Equal(t, [][]byte{[]byte("key01"), []byte("key02")}, handler.OnGetCalls()[0].Keys)
+	assert.Equal(t, false, handler.OnGetCalls()[0].WithCAS)
+
+	err = p.Process([]byte("gets 123\r\n"), noDataForTest)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(handler.OnGetCalls()))
+	assert.Equal(t, [][]byte{[]byte("123")}, handler.OnGetCalls()[1].Keys)
+	assert.Equal(t, true, handler.OnGetCalls()[1].WithCAS)
+}
+
+func TestMemcachedParser_Get_Error(t *testing.T) {
+	handler := &MemcachedHandlerMock{}
+	p := newMemcachedParser(handler)
+
+	err := p.Process([]byte("get\r\n"), noDataForTest)
+	assert.Equal(t, errors.New("missing key"), err)
+
+	err = p.Process([]byte("get key01"), noDataForTest)
+	assert.Equal(t, errors.New("missing CRLF"), err)
+
+	err = p.Process([]byte("GET key01\r\n"), noDataForTest)
+	assert.Equal(t, errors.New("invalid command"), err)
+
+	err = p.Process([]byte("\r\n"), noDataForTest)
+	assert.Equal(t, errors.New("missing command"), err)
+}
+
+func TestMemcachedParser_Set(t *testing.T) {
+	handler := &MemcachedHandlerMock{}
+	p := newMemcachedParser(handler)
+
+	var readSize int
+	readData := func(size int) ([]byte, error) {
+		readSize = size
+		return []byte("some-value\r\n"), nil
+	}
+
+	handler.OnSetFunc = func(key []byte, flags uint32, value []byte, noReply bool) {}
+	err := p.Process([]byte("set key01 12 0 10\r\n"), readData)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 12, readSize)
+	assert.Equal(t, 1, len(handler.OnSetCalls()))
+	assert.Equal(t, []byte("key01"), handler.OnSetCalls()[0].Key)
+	assert.Equal(t, uint32(12), handler.OnSetCalls()[0].Flags)
+	assert.Equal(t, []byte("some-value"), handler.OnSetCalls()[0].Value)
+	assert.Equal(t, false, handler.OnSetCalls()[0].NoReply)
+
+	err = p.Process([]byte("set key01 0 -1 10 noreply\r\n"), readData)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(handler.OnSetCalls()))
+	assert.Equal(t, true, handler.OnSetCalls()[1].NoReply)
+}
+
+func TestMemcachedParser_Set_Error(t *testing.T) {
+	handler := &MemcachedHandlerMock{}
+	p := newMemcachedParser(handler)
+
+	err := p.Process([]byte("set key01\r\n"), noDataForTest)
+	assert.Equal(t, errors.New("missing flags"), err)
+
+	err = p.Process([]byte("set key01 abc 0 10\r\n"), noDataForTest)
+	assert.Equal(t, errors.New("flags is not number"), err)
+
+	err = p.Process([]byte("set key01 0 0\r\n"), noDataForTest)
+	assert.Equal(t, errors.New("missing size"), err)
+
+	err = p.Process([]byte("set key01 0 0 abc\r\n"), noDataForTest)
+	assert.Equal(t, errors.New("size is not number"), err)
+
+	err = p.Process([]byte("set key01 0 0 10 other\r\n"), noDataForTest)
+	assert.Equal(t, errors.New("missing CRLF"), err)
+
+	err = p.Process([]byte("set key01 0 0 10\r\n"), func(size int) ([]byte, error) {
+		return []byte("some-valueAB")[:size], nil
+	})
+	assert.Equal(t, errors.New("bad data chunk"), err)
+
+	readErr := errors.New("read error")
+	err = p.Process([]byte("set key01 0 0 10\r\n"), func(_ int) ([]byte, error) {
+		return nil, readErr
+	})
+	assert.Equal(t, readErr, err)
+}
+
+func TestMemcachedParser_Delete(t *testing.T) {
+	handler := &MemcachedHandlerMock{}
+	p := newMemcachedParser(handler)
+
+	handler.OnDeleteFunc = func(key []byte, noReply bool) {}
+	err := p.Process([]byte("delete key01\r\n"), noDataForTest)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnDeleteCalls()))
+	assert.Equal(t, []byte("key01"), handler.OnDeleteCalls()[0].Key)
+	assert.Equal(t, false, handler.OnDeleteCalls()[0].NoReply)
+
+	err = p.Process([]byte("delete key01 noreply\r\n"), noDataForTest)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, handler.OnDeleteCalls()[1].NoReply)
+
+	err = p.Process([]byte("delete\r\n"), noDataForTest)
+	assert.Equal(t, errors.New("missing key"), err)
+}
+
+func TestMemcachedParser_Stats(t *testing.T) {
+	handler := &MemcachedHandlerMock{}
+	p := newMemcachedParser(handler)
+
+	handler.OnStatsFunc = func() {}
+	err := p.Process([]byte("stats\r\n"), noDataForTest)
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnStatsCalls()))
+
+	err = p.Process([]byte("stats items\r\n"), noDataForTest)
+	assert.Equal(t, errors.New("missing CRLF"), err)
+}
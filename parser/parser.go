@@ -9,8 +9,18 @@ import (
 // CommandHandler ...
 type CommandHandler interface {
 	OnLGET(key []byte)
-	OnLSET(key []byte, lease uint32, value []byte)
+	OnLGETW(key []byte, timeout uint32)
+	OnLSET(key []byte, lease uint64, value []byte)
+	OnLSETTags(key []byte, lease uint64, value []byte, tags [][]byte)
+	OnLRELEASE(key []byte, lease uint64)
+	OnLEXTEND(key []byte, lease uint64, seconds uint32)
 	OnDEL(key []byte)
+	OnDELStale(key []byte)
+	OnDELTAG(tag []byte)
+	OnDEBUG(key []byte)
+	OnSTATS()
+	OnFLUSH(namespace []byte)
+	OnSNAPSHOT()
 }
 
 // ErrMissingCommand ...
@@ -22,6 +32,12 @@ var ErrInvalidCommand = errors.New("invalid command")
 // ErrMissingKey ...
 var ErrMissingKey = errors.New("missing key")
 
+// ErrMissingTag ...
+var ErrMissingTag = errors.New("missing tag")
+
+// ErrMissingNamespace ...
+var ErrMissingNamespace = errors.New("missing namespace")
+
 // ErrMissingCRLF ...
 var ErrMissingCRLF = errors.New("missing CRLF")
 
@@ -37,13 +53,51 @@ var ErrMissingSize = errors.New("missing size")
 // ErrSizeNotNumber ...
 var ErrSizeNotNumber = errors.New("size is not number")
 
+// ErrMissingTimeout ...
+var ErrMissingTimeout = errors.New("missing timeout")
+
+// ErrTimeoutNotNumber ...
+var ErrTimeoutNotNumber = errors.New("timeout is not number")
+
+// ErrMissingSeconds ...
+var ErrMissingSeconds = errors.New("missing seconds")
+
+// ErrSecondsNotNumber ...
+var ErrSecondsNotNumber = errors.New("seconds is not number")
+
 // ErrMissingData ...
 var ErrMissingData = errors.New("missing data")
 
+// ErrTooManyTags is returned when an LSET command has more than MaxTags tags
+var ErrTooManyTags = errors.New("too many tags")
+
+// ErrTagTooLong is returned when a tag is longer than MaxTagLength
+var ErrTagTooLong = errors.New("tag too long")
+
+// MaxTags is the maximum number of tags of an LSET command
+const MaxTags = 64
+
+// MaxTagLength is the maximum length of a tag in bytes
+const MaxTagLength = 1024
+
+// CheckTags returns an error if there are more than MaxTags tags or a tag is longer than MaxTagLength
+func CheckTags(tags [][]byte) error {
+	if len(tags) > MaxTags {
+		return ErrTooManyTags
+	}
+	for _, tag := range tags {
+		if len(tag) > MaxTagLength {
+			return ErrTagTooLong
+		}
+	}
+	return nil
+}
+
 // Parser ...
 type Parser struct {
 	handler CommandHandler
 	scanner scanner
+	tags    [][]byte
 }
 
 // InitParser ...
@@ -61,6 +115,15 @@ func bytesToUint32(data []byte) uint32 {
 	return num
 }
 
+func bytesToUint64(data []byte) uint64 {
+	num := uint64(0)
+	for _, n := range data {
+		num *= 10
+		num += uint64(n - '0')
+	}
+	return num
+}
+
 // Process ...
 func (p *Parser) Process(data []byte) error {
 	p.scanner.reset()
@@ -72,12 +135,18 @@ func (p *Parser) Process(data []byte) error {
 	}
 
 	switch tokens[0].tokenType {
-	case tokenTypeLGET:
-		return p.processLGET(data)
-	case tokenTypeLSET:
-		return p.processLSET(data)
+	case tokenTypeLGET, tokenTypeLGETW, tokenTypeLSET, tokenTypeLRELEASE, tokenTypeLEXTEND:
+		return p.processLeaseCommand(tokens[0].tokenType, data)
 	case tokenTypeDEL:
 		return p.processDEL(data)
+	case tokenTypeDELTAG:
+		return p.processDELTAG(data)
+	case tokenTypeDEBUG:
+		return p.processDEBUG(data)
+	case tokenTypeSTATS, tokenTypeSNAPSHOT:
+		return p.processNoArgsCommand(tokens[0].tokenType)
+	case tokenTypeFLUSH:
+		return p.processFLUSH(data)
 	case tokenTypeCRLF:
 		return ErrMissingCommand
 	default:
@@ -85,10 +154,28 @@ func (p *Parser) Process(data []byte) error {
 	}
 }
 
+func (p *Parser) processLeaseCommand(t tokenType, data []byte) error {
+	switch t {
+	case tokenTypeLGET:
+		return p.processLGET(data)
+	case tokenTypeLGETW:
+		return p.processLGETW(data)
+	case tokenTypeLSET:
+		return p.processLSET(data)
+	case tokenTypeLRELEASE:
+		return p.processLRELEASE(data)
+	default:
+		return p.processLEXTEND(data)
+	}
+}
+
 func tokenTypeIsString(t tokenType) bool {
 	switch t {
-	case tokenTypeLGET, tokenTypeLSET,
-		tokenTypeDEL, tokenTypeIdent, tokenTypeInt:
+	case tokenTypeLGET, tokenTypeLGETW, tokenTypeLSET,
+		tokenTypeLRELEASE, tokenTypeLEXTEND,
+		tokenTypeDEL, tokenTypeDELTAG, tokenTypeDEBUG, tokenTypeSTATS, tokenTypeFLUSH, tokenTypeSNAPSHOT,
+		tokenTypeSTALE,
+		tokenTypeIdent, tokenTypeInt:
 		return true
 	default:
 		return false
@@ -108,40 +195,76 @@ func (p *Parser) processLGET(data []byte) error {
 	return nil
 }
 
-func validateLSETControlTokens(tokens []token) error {
-	if len(tokens) < 2 {
+// processLGETW for command: LGETW key timeout, timeout in milliseconds
+func (p *Parser) processLGETW(data []byte) error {
+	tokens := p.scanner.tokens
+	if len(tokens) < 2 || !tokenTypeIsString(tokens[1].tokenType) {
 		return ErrMissingKey
 	}
 	if len(tokens) < 3 {
-		return ErrMissingLease
+		return ErrMissingTimeout
 	}
 	if tokens[2].tokenType != tokenTypeInt {
-		return ErrLeaseNotNumber
+		return ErrTimeoutNotNumber
+	}
+	if len(tokens) < 4 || tokens[3].tokenType != tokenTypeCRLF {
+		return ErrMissingCRLF
+	}
+
+	timeout := bytesToUint32(tokens[2].getData(data))
+	p.handler.OnLGETW(tokens[1].getData(data), timeout)
+	return nil
+}
+
+// validateLSETControlTokens returns the index of the CRLF token, the tokens between the size and the CRLF are tags
+func validateLSETControlTokens(tokens []token) (int, error) {
+	if len(tokens) < 2 {
+		return 0, ErrMissingKey
+	}
+	if len(tokens) < 3 {
+		return 0, ErrMissingLease
+	}
+	if tokens[2].tokenType != tokenTypeInt {
+		return 0, ErrLeaseNotNumber
 	}
 	if len(tokens) < 4 {
-		return ErrMissingSize
+		return 0, ErrMissingSize
 	}
 	if tokens[3].tokenType != tokenTypeInt {
-		return ErrSizeNotNumber
+		return 0, ErrSizeNotNumber
 	}
-	if len(tokens) < 5 || tokens[4].tokenType != tokenTypeCRLF {
-		return ErrMissingCRLF
+	for i := 4; i < len(tokens); i++ {
+		if tokens[i].tokenType == tokenTypeCRLF {
+			return i, nil
+		}
 	}
-	return nil
+	return 0, ErrMissingCRLF
 }
 
+// processLSET for command: LSET key lease size [tag ...]
 func (p *Parser) processLSET(data []byte) error {
 	tokens := p.scanner.tokens
-	err := validateLSETControlTokens(tokens)
+	crlfIndex, err := validateLSETControlTokens(tokens)
 	if err != nil {
 		return err
 	}
 
 	key := tokens[1].getData(data)
-	lease := bytesToUint32(tokens[2].getData(data))
+	lease := bytesToUint64(tokens[2].getData(data))
 	size := bytesToUint32(tokens[3].getData(data))
 
-	beginValueOffset := tokens[4].end
+	if crlfIndex-4 > MaxTags {
+		return ErrTooManyTags
+	}
+	p.tags = p.tags[:0]
+	for _, tag := range tokens[4:crlfIndex] {
+		p.tags = append(p.tags, tag.getData(data))
+	}
+	if err := CheckTags(p.tags); err != nil {
+		return err
+	}
+
+	beginValueOffset := tokens[crlfIndex].end
 	data = data[beginValueOffset:]
 
 	if len(data) < int(size) {
@@ -157,18 +280,142 @@ func (p *Parser) processLSET(data []byte) error {
 	}
 	value := tokens[0].getData(data)
 
+	if len(p.tags) > 0 {
+		p.handler.OnLSETTags(key, lease, value, p.tags)
+		return nil
+	}
 	p.handler.OnLSET(key, lease, value)
 	return nil
 }
 
+func validateLeaseTokens(tokens []token) error {
+	if len(tokens) < 2 || !tokenTypeIsString(tokens[1].tokenType) {
+		return ErrMissingKey
+	}
+	if len(tokens) < 3 {
+		return ErrMissingLease
+	}
+	if tokens[2].tokenType != tokenTypeInt {
+		return ErrLeaseNotNumber
+	}
+	return nil
+}
+
+// processLRELEASE for command: LRELEASE key lease
+func (p *Parser) processLRELEASE(data []byte) error {
+	tokens := p.scanner.tokens
+	err := validateLeaseTokens(tokens)
+	if err != nil {
+		return err
+	}
+	if len(tokens) < 4 || tokens[3].tokenType != tokenTypeCRLF {
+		return ErrMissingCRLF
+	}
+
+	lease := bytesToUint64(tokens[2].getData(data))
+	p.handler.OnLRELEASE(tokens[1].getData(data), lease)
+	return nil
+}
+
+// processLEXTEND for command: LEXTEND key lease seconds
+func (p *Parser) processLEXTEND(data []byte) error {
+	tokens := p.scanner.tokens
+	err := validateLeaseTokens(tokens)
+	if err != nil {
+		return err
+	}
+	if len(tokens) < 4 {
+		return ErrMissingSeconds
+	}
+	if tokens[3].tokenType != tokenTypeInt {
+		return ErrSecondsNotNumber
+	}
+	if len(tokens) < 5 || tokens[4].tokenType != tokenTypeCRLF {
+		return ErrMissingCRLF
+	}
+
+	lease := bytesToUint64(tokens[2].getData(data))
+	seconds := bytesToUint32(tokens[3].getData(data))
+	p.handler.OnLEXTEND(tokens[1].getData(data), lease, seconds)
+	return nil
+}
+
 func (p *Parser) processDEL(data []byte) error {
 	tokens := p.scanner.tokens
 	if len(tokens) < 2 || !tokenTypeIsString(tokens[1].tokenType) {
 		return ErrMissingKey
 	}
+	if len(tokens) >= 3 && tokens[2].tokenType == tokenTypeSTALE {
+		if len(tokens) < 4 || tokens[3].tokenType != tokenTypeCRLF {
+			return ErrMissingCRLF
+		}
+		p.handler.OnDELStale(tokens[1].getData(data))
+		return nil
+	}
 	if len(tokens) < 3 || tokens[2].tokenType != tokenTypeCRLF {
 		return ErrMissingCRLF
 	}
 	p.handler.OnDEL(tokens[1].getData(data))
 	return nil
 }
+
+// processDEBUG for command: DEBUG key
+func (p *Parser) processDEBUG(data []byte) error {
+	tokens := p.scanner.tokens
+	if len(tokens) < 2 || !tokenTypeIsString(tokens[1].tokenType) {
+		return ErrMissingKey
+	}
+	if len(tokens) < 3 || tokens[2].tokenType != tokenTypeCRLF {
+		return ErrMissingCRLF
+	}
+
+	p.handler.OnDEBUG(tokens[1].getData(data))
+	return nil
+}
+
+// processNoArgsCommand for commands: STATS, SNAPSHOT
+func (p *Parser) processNoArgsCommand(t tokenType) error {
+	tokens := p.scanner.tokens
+	if len(tokens) < 2 || tokens[1].tokenType != tokenTypeCRLF {
+		return ErrMissingCRLF
+	}
+
+	if t == tokenTypeSNAPSHOT {
+		p.handler.OnSNAPSHOT()
+		return nil
+	}
+	p.handler.OnSTATS()
+	return nil
+}
+
+// processFLUSH for command: FLUSH namespace
+func (p *Parser) processFLUSH(data []byte) error {
+	tokens := p.scanner.tokens
+	if len(tokens) < 2 || !tokenTypeIsString(tokens[1].tokenType) {
+		return ErrMissingNamespace
+	}
+	if len(tokens) < 3 || tokens[2].tokenType != tokenTypeCRLF {
+		return ErrMissingCRLF
+	}
+
+	p.handler.OnFLUSH(tokens[1].getData(data))
+	return nil
+}
+
+// processDELTAG for command: DELTAG tag
+func (p *Parser) processDELTAG(data []byte) error {
+	tokens := p.scanner.tokens
+	if len(tokens) < 2 || !tokenTypeIsString(tokens[1].tokenType) {
+		return ErrMissingTag
+	}
+	if len(tokens) < 3 || tokens[2].tokenType != tokenTypeCRLF {
+		return ErrMissingCRLF
+	}
+
+	tag := tokens[1].getData(data)
+	if len(tag) > MaxTagLength {
+		return ErrTagTooLong
+	}
+	p.handler.OnDELTAG(tag)
+	return nil
+}
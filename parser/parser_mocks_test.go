@@ -17,15 +17,45 @@ var _ CommandHandler = &CommandHandlerMock{}
 //
 // 		// make and configure a mocked CommandHandler
 // 		mockedCommandHandler := &CommandHandlerMock{
+// 			OnDEBUGFunc: func(key []byte)  {
+// 				panic("mock out the OnDEBUG method")
+// 			},
 // 			OnDELFunc: func(key []byte)  {
 // 				panic("mock out the OnDEL method")
 // 			},
+// 			OnDELStaleFunc: func(key []byte)  {
+// 				panic("mock out the OnDELStale method")
+// 			},
+// 			OnDELTAGFunc: func(tag []byte)  {
+// 				panic("mock out the OnDELTAG method")
+// 			},
+// 			OnFLUSHFunc: func(namespace []byte)  {
+// 				panic("mock out the OnFLUSH method")
+// 			},
+// 			OnLEXTENDFunc: func(key []byte, lease uint64, seconds uint32)  {
+// 				panic("mock out the OnLEXTEND method")
+// 			},
 // 			OnLGETFunc: func(key []byte)  {
 // 				panic("mock out the OnLGET method")
 // 			},
-// 			OnLSETFunc: func(key []byte, lease uint32, value []byte)  {
+// 			OnLGETWFunc: func(key []byte, timeout uint32)  {
+// 				panic("mock out the OnLGETW method")
+// 			},
+// 			OnLRELEASEFunc: func(key []byte, lease uint64)  {
+// 				panic("mock out the OnLRELEASE method")
+// 			},
+// 			OnLSETFunc: func(key []byte, lease uint64, value []byte)  {
 // 				panic("mock out the OnLSET method")
 // 			},
+// 			OnLSETTagsFunc: func(key []byte, lease uint64, value []byte, tags [][]byte)  {
+// 				panic("mock out the OnLSETTags method")
+// 			},
+// 			OnSNAPSHOTFunc: func()  {
+// 				panic("mock out the OnSNAPSHOT method")
+// 			},
+// 			OnSTATSFunc: func()  {
+// 				panic("mock out the OnSTATS method")
+// 			},
 // 		}
 //
 // 		// use mockedCommandHandler in code that requires CommandHandler
@@ -33,40 +63,171 @@ var _ CommandHandler = &CommandHandlerMock{}
 //
 // 	}
 type CommandHandlerMock struct {
+	// OnDEBUGFunc mocks the OnDEBUG method.
+	OnDEBUGFunc func(key []byte)
+
 	// OnDELFunc mocks the OnDEL method.
 	OnDELFunc func(key []byte)
 
+	// OnDELStaleFunc mocks the OnDELStale method.
+	OnDELStaleFunc func(key []byte)
+
+	// OnDELTAGFunc mocks the OnDELTAG method.
+	OnDELTAGFunc func(tag []byte)
+
+	// OnFLUSHFunc mocks the OnFLUSH method.
+	OnFLUSHFunc func(namespace []byte)
+
+	// OnLEXTENDFunc mocks the OnLEXTEND method.
+	OnLEXTENDFunc func(key []byte, lease uint64, seconds uint32)
+
 	// OnLGETFunc mocks the OnLGET method.
 	OnLGETFunc func(key []byte)
 
+	// OnLGETWFunc mocks the OnLGETW method.
+	OnLGETWFunc func(key []byte, timeout uint32)
+
+	// OnLRELEASEFunc mocks the OnLRELEASE method.
+	OnLRELEASEFunc func(key []byte, lease uint64)
+
 	// OnLSETFunc mocks the OnLSET method.
-	OnLSETFunc func(key []byte, lease uint32, value []byte)
+	OnLSETFunc func(key []byte, lease uint64, value []byte)
+
+	// OnLSETTagsFunc mocks the OnLSETTags method.
+	OnLSETTagsFunc func(key []byte, lease uint64, value []byte, tags [][]byte)
+
+	// OnSNAPSHOTFunc mocks the OnSNAPSHOT method.
+	OnSNAPSHOTFunc func()
+
+	// OnSTATSFunc mocks the OnSTATS method.
+	OnSTATSFunc func()
 
 	// calls tracks calls to the methods.
 	calls struct {
+		// OnDEBUG holds details about calls to the OnDEBUG method.
+		OnDEBUG []struct {
+			// Key is the key argument value.
+			Key []byte
+		}
 		// OnDEL holds details about calls to the OnDEL method.
 		OnDEL []struct {
 			// Key is the key argument value.
 			Key []byte
 		}
+		// OnDELStale holds details about calls to the OnDELStale method.
+		OnDELStale []struct {
+			// Key is the key argument value.
+			Key []byte
+		}
+		// OnDELTAG holds details about calls to the OnDELTAG method.
+		OnDELTAG []struct {
+			// Tag is the tag argument value.
+			Tag []byte
+		}
+		// OnFLUSH holds details about calls to the OnFLUSH method.
+		OnFLUSH []struct {
+			// Namespace is the namespace argument value.
+			Namespace []byte
+		}
+		// OnLEXTEND holds details about calls to the OnLEXTEND method.
+		OnLEXTEND []struct {
+			// Key is the key argument value.
+			Key []byte
+			// Lease is the lease argument value.
+			Lease uint64
+			// Seconds is the seconds argument value.
+			Seconds uint32
+		}
 		// OnLGET holds details about calls to the OnLGET method.
 		OnLGET []struct {
 			// Key is the key argument value.
 			Key []byte
 		}
+		// OnLGETW holds details about calls to the OnLGETW method.
+		OnLGETW []struct {
+			// Key is the key argument value.
+			Key []byte
+			// Timeout is the timeout argument value.
+			Timeout uint32
+		}
+		// OnLRELEASE holds details about calls to the OnLRELEASE method.
+		OnLRELEASE []struct {
+			// Key is the key argument value.
+			Key []byte
+			// Lease is the lease argument value.
+			Lease uint64
+		}
 		// OnLSET holds details about calls to the OnLSET method.
 		OnLSET []struct {
 			// Key is the key argument value.
 			Key []byte
 			// Lease is the lease argument value.
-			Lease uint32
+			Lease uint64
+			// Value is the value argument value.
+			Value []byte
+		}
+		// OnLSETTags holds details about calls to the OnLSETTags method.
+		OnLSETTags []struct {
+			// Key is the key argument value.
+			Key []byte
+			// Lease is the lease argument value.
+			Lease uint64
 			// Value is the value argument value.
 			Value []byte
+			// Tags is the tags argument value.
+			Tags [][]byte
+		}
+		// OnSNAPSHOT holds details about calls to the OnSNAPSHOT method.
+		OnSNAPSHOT []struct {
+		}
+		// OnSTATS holds details about calls to the OnSTATS method.
+		OnSTATS []struct {
 		}
 	}
-	lockOnDEL  sync.RWMutex
-	lockOnLGET sync.RWMutex
-	lockOnLSET sync.RWMutex
+	lockOnDEBUG    sync.RWMutex
+	lockOnDEL      sync.RWMutex
+	lockOnDELStale sync.RWMutex
+	lockOnDELTAG   sync.RWMutex
+	lockOnFLUSH    sync.RWMutex
+	lockOnLEXTEND  sync.RWMutex
+	lockOnLGET     sync.RWMutex
+	lockOnLGETW    sync.RWMutex
+	lockOnLRELEASE sync.RWMutex
+	lockOnLSET     sync.RWMutex
+	lockOnLSETTags sync.RWMutex
+	lockOnSNAPSHOT sync.RWMutex
+	lockOnSTATS    sync.RWMutex
+}
+
+// OnDEBUG calls OnDEBUGFunc.
+func (mock *CommandHandlerMock) OnDEBUG(key []byte) {
+	if mock.OnDEBUGFunc == nil {
+		panic("CommandHandlerMock.OnDEBUGFunc: method is nil but CommandHandler.OnDEBUG was just called")
+	}
+	callInfo := struct {
+		Key []byte
+	}{
+		Key: key,
+	}
+	mock.lockOnDEBUG.Lock()
+	mock.calls.OnDEBUG = append(mock.calls.OnDEBUG, callInfo)
+	mock.lockOnDEBUG.Unlock()
+	mock.OnDEBUGFunc(key)
+}
+
+// OnDEBUGCalls gets all the calls that were made to OnDEBUG.
+// Check the length with:
+//     len(mockedCommandHandler.OnDEBUGCalls())
+func (mock *CommandHandlerMock) OnDEBUGCalls() []struct {
+	Key []byte
+} {
+	var calls []struct {
+		Key []byte
+	}
+	mock.lockOnDEBUG.RLock()
+	calls = mock.calls.OnDEBUG
+	mock.lockOnDEBUG.RUnlock()
+	return calls
 }
 
 // OnDEL calls OnDELFunc.
@@ -100,6 +261,138 @@ func (mock *CommandHandlerMock) OnDELCalls() []struct {
 	return calls
 }
 
+// OnDELStale calls OnDELStaleFunc.
+func (mock *CommandHandlerMock) OnDELStale(key []byte) {
+	if mock.OnDELStaleFunc == nil {
+		panic("CommandHandlerMock.OnDELStaleFunc: method is nil but CommandHandler.OnDELStale was just called")
+	}
+	callInfo := struct {
+		Key []byte
+	}{
+		Key: key,
+	}
+	mock.lockOnDELStale.Lock()
+	mock.calls.OnDELStale = append(mock.calls.OnDELStale, callInfo)
+	mock.lockOnDELStale.Unlock()
+	mock.OnDELStaleFunc(key)
+}
+
+// OnDELStaleCalls gets all the calls that were made to OnDELStale.
+// Check the length with:
+//     len(mockedCommandHandler.OnDELStaleCalls())
+func (mock *CommandHandlerMock) OnDELStaleCalls() []struct {
+	Key []byte
+} {
+	var calls []struct {
+		Key []byte
+	}
+	mock.lockOnDELStale.RLock()
+	calls = mock.calls.OnDELStale
+	mock.lockOnDELStale.RUnlock()
+	return calls
+}
+
+// OnDELTAG calls OnDELTAGFunc.
+func (mock *CommandHandlerMock) OnDELTAG(tag []byte) {
+	if mock.OnDELTAGFunc == nil {
+		panic("CommandHandlerMock.OnDELTAGFunc: method is nil but CommandHandler.OnDELTAG was just called")
+	}
+	callInfo := struct {
+		Tag []byte
+	}{
+		Tag: tag,
+	}
+	mock.lockOnDELTAG.Lock()
+	mock.calls.OnDELTAG = append(mock.calls.OnDELTAG, callInfo)
+	mock.lockOnDELTAG.Unlock()
+	mock.OnDELTAGFunc(tag)
+}
+
+// OnDELTAGCalls gets all the calls that were made to OnDELTAG.
+// Check the length with:
+//     len(mockedCommandHandler.OnDELTAGCalls())
+func (mock *CommandHandlerMock) OnDELTAGCalls() []struct {
+	Tag []byte
+} {
+	var calls []struct {
+		Tag []byte
+	}
+	mock.lockOnDELTAG.RLock()
+	calls = mock.calls.OnDELTAG
+	mock.lockOnDELTAG.RUnlock()
+	return calls
+}
+
+// OnFLUSH calls OnFLUSHFunc.
+func (mock *CommandHandlerMock) OnFLUSH(namespace []byte) {
+	if mock.OnFLUSHFunc == nil {
+		panic("CommandHandlerMock.OnFLUSHFunc: method is nil but CommandHandler.OnFLUSH was just called")
+	}
+	callInfo := struct {
+		Namespace []byte
+	}{
+		Namespace: namespace,
+	}
+	mock.lockOnFLUSH.Lock()
+	mock.calls.OnFLUSH = append(mock.calls.OnFLUSH, callInfo)
+	mock.lockOnFLUSH.Unlock()
+	mock.OnFLUSHFunc(namespace)
+}
+
+// OnFLUSHCalls gets all the calls that were made to OnFLUSH.
+// Check the length with:
+//     len(mockedCommandHandler.OnFLUSHCalls())
+func (mock *CommandHandlerMock) OnFLUSHCalls() []struct {
+	Namespace []byte
+} {
+	var calls []struct {
+		Namespace []byte
+	}
+	mock.lockOnFLUSH.RLock()
+	calls = mock.calls.OnFLUSH
+	mock.lockOnFLUSH.RUnlock()
+	return calls
+}
+
+// OnLEXTEND calls OnLEXTENDFunc.
+func (mock *CommandHandlerMock) OnLEXTEND(key []byte, lease uint64, seconds uint32) {
+	if mock.OnLEXTENDFunc == nil {
+		panic("CommandHandlerMock.OnLEXTENDFunc: method is nil but CommandHandler.OnLEXTEND was just called")
+	}
+	callInfo := struct {
+		Key     []byte
+		Lease   uint64
+		Seconds uint32
+	}{
+		Key:     key,
+		Lease:   lease,
+		Seconds: seconds,
+	}
+	mock.lockOnLEXTEND.Lock()
+	mock.calls.OnLEXTEND = append(mock.calls.OnLEXTEND, callInfo)
+	mock.lockOnLEXTEND.Unlock()
+	mock.OnLEXTENDFunc(key, lease, seconds)
+}
+
+// OnLEXTENDCalls gets all the calls that were made to OnLEXTEND.
+// Check the length with:
+//     len(mockedCommandHandler.OnLEXTENDCalls())
+func (mock *CommandHandlerMock) OnLEXTENDCalls() []struct {
+	Key     []byte
+	Lease   uint64
+	Seconds uint32
+} {
+	var calls []struct {
+		Key     []byte
+		Lease   uint64
+		Seconds uint32
+	}
+	mock.lockOnLEXTEND.RLock()
+	calls = mock.calls.OnLEXTEND
+	mock.lockOnLEXTEND.RUnlock()
+	return calls
+}
+
 // OnLGET calls OnLGETFunc.
 func (mock *CommandHandlerMock) OnLGET(key []byte) {
 	if mock.OnLGETFunc == nil {
@@ -131,14 +424,84 @@ func (mock *CommandHandlerMock) OnLGETCalls() []struct {
 	return calls
 }
 
+// OnLGETW calls OnLGETWFunc.
+func (mock *CommandHandlerMock) OnLGETW(key []byte, timeout uint32) {
+	if mock.OnLGETWFunc == nil {
+		panic("CommandHandlerMock.OnLGETWFunc: method is nil but CommandHandler.OnLGETW was just called")
+	}
+	callInfo := struct {
+		Key     []byte
+		Timeout uint32
+	}{
+		Key:     key,
+		Timeout: timeout,
+	}
+	mock.lockOnLGETW.Lock()
+	mock.calls.OnLGETW = append(mock.calls.OnLGETW, callInfo)
+	mock.lockOnLGETW.Unlock()
+	mock.OnLGETWFunc(key, timeout)
+}
+
+// OnLGETWCalls gets all the calls that were made to OnLGETW.
+// Check the length with:
+//     len(mockedCommandHandler.OnLGETWCalls())
+func (mock *CommandHandlerMock) OnLGETWCalls() []struct {
+	Key     []byte
+	Timeout uint32
+} {
+	var calls []struct {
+		Key     []byte
+		Timeout uint32
+	}
+	mock.lockOnLGETW.RLock()
+	calls = mock.calls.OnLGETW
+	mock.lockOnLGETW.RUnlock()
+	return calls
+}
+
+// OnLRELEASE calls OnLRELEASEFunc.
+func (mock *CommandHandlerMock) OnLRELEASE(key []byte, lease uint64) {
+	if mock.OnLRELEASEFunc == nil {
+		panic("CommandHandlerMock.OnLRELEASEFunc: method is nil but CommandHandler.OnLRELEASE was just called")
+	}
+	callInfo := struct {
+		Key   []byte
+		Lease uint64
+	}{
+		Key:   key,
+		Lease: lease,
+	}
+	mock.lockOnLRELEASE.Lock()
+	mock.calls.OnLRELEASE = append(mock.calls.OnLRELEASE, callInfo)
+	mock.lockOnLRELEASE.Unlock()
+	mock.OnLRELEASEFunc(key, lease)
+}
+
+// OnLRELEASECalls gets all the calls that were made to OnLRELEASE.
+// Check the length with:
+//     len(mockedCommandHandler.OnLRELEASECalls())
+func (mock *CommandHandlerMock) OnLRELEASECalls() []struct {
+	Key   []byte
+	Lease uint64
+} {
+	var calls []struct {
+		Key   []byte
+		Lease uint64
+	}
+	mock.lockOnLRELEASE.RLock()
+	calls = mock.calls.OnLRELEASE
+	mock.lockOnLRELEASE.RUnlock()
+	return calls
+}
+
 // OnLSET calls OnLSETFunc.
-func (mock *CommandHandlerMock) OnLSET(key []byte, lease uint32, value []byte) {
+func (mock *CommandHandlerMock) OnLSET(key []byte, lease uint64, value []byte) {
 	if mock.OnLSETFunc == nil {
 		panic("CommandHandlerMock.OnLSETFunc: method is nil but CommandHandler.OnLSET was just called")
 	}
 	callInfo := struct {
 		Key   []byte
-		Lease uint32
+		Lease uint64
 		Value []byte
 	}{
 		Key:   key,
@@ -156,12 +519,12 @@ func (mock *CommandHandlerMock) OnLSET(key []byte, lease uint32, value []byte) {
 //     len(mockedCommandHandler.OnLSETCalls())
 func (mock *CommandHandlerMock) OnLSETCalls() []struct {
 	Key   []byte
-	Lease uint32
+	Lease uint64
 	Value []byte
 } {
 	var calls []struct {
 		Key   []byte
-		Lease uint32
+		Lease uint64
 		Value []byte
 	}
 	mock.lockOnLSET.RLock()
@@ -169,3 +532,99 @@ func (mock *CommandHandlerMock) OnLSETCalls() []struct {
 	mock.lockOnLSET.RUnlock()
 	return calls
 }
+
+// OnLSETTags calls OnLSETTagsFunc.
+func (mock *CommandHandlerMock) OnLSETTags(key []byte, lease uint64, value []byte, tags [][]byte) {
+	if mock.OnLSETTagsFunc == nil {
+		panic("CommandHandlerMock.OnLSETTagsFunc: method is nil but CommandHandler.OnLSETTags was just called")
+	}
+	callInfo := struct {
+		Key   []byte
+		Lease uint64
+		Value []byte
+		Tags  [][]byte
+	}{
+		Key:   key,
+		Lease: lease,
+		Value: value,
+		Tags:  tags,
+	}
+	mock.lockOnLSETTags.Lock()
+	mock.calls.OnLSETTags = append(mock.calls.OnLSETTags, callInfo)
+	mock.lockOnLSETTags.Unlock()
+	mock.OnLSETTagsFunc(key, lease, value, tags)
+}
+
+// OnLSETTagsCalls gets all the calls that were made to OnLSETTags.
+// Check the length with:
+//     len(mockedCommandHandler.OnLSETTagsCalls())
+func (mock *CommandHandlerMock) OnLSETTagsCalls() []struct {
+	Key   []byte
+	Lease uint64
+	Value []byte
+	Tags  [][]byte
+} {
+	var calls []struct {
+		Key   []byte
+		Lease uint64
+		Value []byte
+		Tags  [][]byte
+	}
+	mock.lockOnLSETTags.RLock()
+	calls = mock.calls.OnLSETTags
+	mock.lockOnLSETTags.RUnlock()
+	return calls
+}
+
+// OnSNAPSHOT calls OnSNAPSHOTFunc.
+func (mock *CommandHandlerMock) OnSNAPSHOT() {
+	if mock.OnSNAPSHOTFunc == nil {
+		panic("CommandHandlerMock.OnSNAPSHOTFunc: method is nil but CommandHandler.OnSNAPSHOT was just called")
+	}
+	callInfo := struct {
+	}{
+	}
+	mock.lockOnSNAPSHOT.Lock()
+	mock.calls.OnSNAPSHOT = append(mock.calls.OnSNAPSHOT, callInfo)
+	mock.lockOnSNAPSHOT.Unlock()
+	mock.OnSNAPSHOTFunc()
+}
+
+// OnSNAPSHOTCalls gets all the calls that were made to OnSNAPSHOT.
+// Check the length with:
+//     len(mockedCommandHandler.OnSNAPSHOTCalls())
+func (mock *CommandHandlerMock) OnSNAPSHOTCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockOnSNAPSHOT.RLock()
+	calls = mock.calls.OnSNAPSHOT
+	mock.lockOnSNAPSHOT.RUnlock()
+	return calls
+}
+
+// OnSTATS calls OnSTATSFunc.
+func (mock *CommandHandlerMock) OnSTATS() {
+	if mock.OnSTATSFunc == nil {
+		panic("CommandHandlerMock.OnSTATSFunc: method is nil but CommandHandler.OnSTATS was just called")
+	}
+	callInfo := struct {
+	}{}
+	mock.lockOnSTATS.Lock()
+	mock.calls.OnSTATS = append(mock.calls.OnSTATS, callInfo)
+	mock.lockOnSTATS.Unlock()
+	mock.OnSTATSFunc()
+}
+
+// OnSTATSCalls gets all the calls that were made to OnSTATS.
+// Check the length with:
+//     len(mockedCommandHandler.OnSTATSCalls())
+func (mock *CommandHandlerMock) OnSTATSCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockOnSTATS.RLock()
+	calls = mock.calls.OnSTATS
+	mock.lockOnSTATS.RUnlock()
+	return calls
+}
@@ -3,6 +3,7 @@ package parser
 import (
 	"errors"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 )
 
@@ -20,6 +21,14 @@ func TestBytesToUint32(t *testing.T) {
 	assert.Equal(t, uint32(0), n)
 }
 
+func TestBytesToUint64(t *testing.T) {
+	n := bytesToUint64([]byte("12345678901234567890"))
+	assert.Equal(t, uint64(12345678901234567890), n)
+
+	n = bytesToUint64([]byte("0"))
+	assert.Equal(t, uint64(0), n)
+}
+
 func TestParser_LGET(t *testing.T) {
 	handler := &CommandHandlerMock{}
 	p := newParser(handler)
@@ -32,20 +41,151 @@ func TestParser_LGET(t *testing.T) {
 	assert.Equal(t, []byte("some-key"), handler.OnLGETCalls()[0].Key)
 }
 
+func TestParser_LGETW(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	handler.OnLGETWFunc = func(key []byte, timeout uint32) {}
+	err := p.Process([]byte("LGETW some-key 500\r\n"))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnLGETWCalls()))
+	assert.Equal(t, []byte("some-key"), handler.OnLGETWCalls()[0].Key)
+	assert.Equal(t, uint32(500), handler.OnLGETWCalls()[0].Timeout)
+}
+
+func TestParser_LGETW_Missing_Timeout(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	err := p.Process([]byte("LGETW some-key"))
+	assert.Equal(t, errors.New("missing timeout"), err)
+
+	err = p.Process([]byte("LGETW some-key abcd\r\n"))
+	assert.Equal(t, errors.New("timeout is not number"), err)
+
+	err = p.Process([]byte("LGETW some-key 100"))
+	assert.Equal(t, errors.New("missing CRLF"), err)
+}
+
 func TestParser_LSET(t *testing.T) {
 	handler := &CommandHandlerMock{}
 	p := newParser(handler)
 
-	handler.OnLSETFunc = func(key []byte, lease uint32, value []byte) {}
+	handler.OnLSETFunc = func(key []byte, lease uint64, value []byte) {}
 	err := p.Process([]byte("LSET some-key 1234 10\r\nsome-value\r\n"))
 
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 1, len(handler.OnLSETCalls()))
 	assert.Equal(t, []byte("some-key"), handler.OnLSETCalls()[0].Key)
-	assert.Equal(t, uint32(1234), handler.OnLSETCalls()[0].Lease)
+	assert.Equal(t, uint64(1234), handler.OnLSETCalls()[0].Lease)
 	assert.Equal(t, []byte("some-value"), handler.OnLSETCalls()[0].Value)
 }
 
+func TestParser_LSET_Tags(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	handler.OnLSETTagsFunc = func(key []byte, lease uint64, value []byte, tags [][]byte) {}
+	err := p.Process([]byte("LSET some-key 1234 10 user:1 team:2\r\nsome-value\r\n"))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnLSETTagsCalls()))
+	assert.Equal(t, []byte("some-key"), handler.OnLSETTagsCalls()[0].Key)
+	assert.Equal(t, uint64(1234), handler.OnLSETTagsCalls()[0].Lease)
+	assert.Equal(t, []byte("some-value"), handler.OnLSETTagsCalls()[0].Value)
+	assert.Equal(t, [][]byte{[]byte("user:1"), []byte("team:2")}, handler.OnLSETTagsCalls()[0].Tags)
+
+	err = p.Process([]byte("LSET some-key 1234 10 user:1"))
+	assert.Equal(t, errors.New("missing CRLF"), err)
+
+	err = p.Process([]byte("LSET some-key 1234 10" + strings.Repeat(" tag", MaxTags+1) + "\r\nsome-value\r\n"))
+	assert.Equal(t, ErrTooManyTags, err)
+
+	err = p.Process([]byte("LSET some-key 1234 10 " + strings.Repeat("A", MaxTagLength+1) + "\r\nsome-value\r\n"))
+	assert.Equal(t, ErrTagTooLong, err)
+	assert.Equal(t, 1, len(handler.OnLSETTagsCalls()))
+}
+
+func TestParser_DELTAG(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	handler.OnDELTAGFunc = func(tag []byte) {}
+	err := p.Process([]byte("DELTAG user:1\r\n"))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnDELTAGCalls()))
+	assert.Equal(t, []byte("user:1"), handler.OnDELTAGCalls()[0].Tag)
+
+	err = p.Process([]byte("DELTAG\r\n"))
+	assert.Equal(t, errors.New("missing tag"), err)
+
+	err = p.Process([]byte("DELTAG user:1"))
+	assert.Equal(t, errors.New("missing CRLF"), err)
+
+	err = p.Process([]byte("DELTAG " + strings.Repeat("A", MaxTagLength+1) + "\r\n"))
+	assert.Equal(t, ErrTagTooLong, err)
+}
+
+func TestParser_LRELEASE(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	handler.OnLRELEASEFunc = func(key []byte, lease uint64) {}
+	err := p.Process([]byte("LRELEASE some-key 1234\r\n"))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnLRELEASECalls()))
+	assert.Equal(t, []byte("some-key"), handler.OnLRELEASECalls()[0].Key)
+	assert.Equal(t, uint64(1234), handler.OnLRELEASECalls()[0].Lease)
+}
+
+func TestParser_LRELEASE_Errors(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	err := p.Process([]byte("LRELEASE\r\n"))
+	assert.Equal(t, errors.New("missing key"), err)
+
+	err = p.Process([]byte("LRELEASE some-key"))
+	assert.Equal(t, errors.New("missing lease"), err)
+
+	err = p.Process([]byte("LRELEASE some-key abcd\r\n"))
+	assert.Equal(t, errors.New("lease is not number"), err)
+
+	err = p.Process([]byte("LRELEASE some-key 1234"))
+	assert.Equal(t, errors.New("missing CRLF"), err)
+}
+
+func TestParser_LEXTEND(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	handler.OnLEXTENDFunc = func(key []byte, lease uint64, seconds uint32) {}
+	err := p.Process([]byte("LEXTEND some-key 1234 60\r\n"))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnLEXTENDCalls()))
+	assert.Equal(t, []byte("some-key"), handler.OnLEXTENDCalls()[0].Key)
+	assert.Equal(t, uint64(1234), handler.OnLEXTENDCalls()[0].Lease)
+	assert.Equal(t, uint32(60), handler.OnLEXTENDCalls()[0].Seconds)
+}
+
+func TestParser_LEXTEND_Errors(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	err := p.Process([]byte("LEXTEND some-key 1234"))
+	assert.Equal(t, errors.New("missing seconds"), err)
+
+	err = p.Process([]byte("LEXTEND some-key 1234 abc\r\n"))
+	assert.Equal(t, errors.New("seconds is not number"), err)
+
+	err = p.Process([]byte("LEXTEND some-key 1234 60"))
+	assert.Equal(t, errors.New("missing CRLF"), err)
+}
+
 func TestParser_DEL(t *testing.T) {
 	handler := &CommandHandlerMock{}
 	p := newParser(handler)
@@ -58,6 +198,75 @@ func TestParser_DEL(t *testing.T) {
 	assert.Equal(t, []byte("some-key"), handler.OnDELCalls()[0].Key)
 }
 
+func TestParser_DEBUG(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	handler.OnDEBUGFunc = func(key []byte) {}
+	err := p.Process([]byte("DEBUG some-key\r\n"))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnDEBUGCalls()))
+	assert.Equal(t, []byte("some-key"), handler.OnDEBUGCalls()[0].Key)
+}
+
+func TestParser_DEBUG_Missing_Key(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	err := p.Process([]byte("DEBUG \r\n"))
+	assert.Equal(t, errors.New("missing key"), err)
+
+	err = p.Process([]byte("DEBUG some-key"))
+	assert.Equal(t, errors.New("missing CRLF"), err)
+}
+
+func TestParser_STATS(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	handler.OnSTATSFunc = func() {}
+	err := p.Process([]byte("STATS\r\n"))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnSTATSCalls()))
+
+	err = p.Process([]byte("STATS"))
+	assert.Equal(t, errors.New("missing CRLF"), err)
+}
+
+func TestParser_SNAPSHOT(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	handler.OnSNAPSHOTFunc = func() {}
+	err := p.Process([]byte("SNAPSHOT\r\n"))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnSNAPSHOTCalls()))
+
+	err = p.Process([]byte("SNAPSHOT now\r\n"))
+	assert.Equal(t, errors.New("missing CRLF"), err)
+}
+
+func TestParser_FLUSH(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	handler.OnFLUSHFunc = func(namespace []byte) {}
+	err := p.Process([]byte("FLUSH users\r\n"))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnFLUSHCalls()))
+	assert.Equal(t, []byte("users"), handler.OnFLUSHCalls()[0].Namespace)
+
+	err = p.Process([]byte("FLUSH\r\n"))
+	assert.Equal(t, errors.New("missing namespace"), err)
+
+	err = p.Process([]byte("FLUSH users"))
+	assert.Equal(t, errors.New("missing CRLF"), err)
+}
+
 func TestParser_Missing_Token(t *testing.T) {
 	handler := &CommandHandlerMock{}
 	p := newParser(handler)
@@ -255,3 +464,37 @@ func TestParser_DEL_Missing_CRLF_Replaced_By_Ident(t *testing.T) {
 
 	assert.Equal(t, errors.New("missing CRLF"), err)
 }
+
+func TestParser_DEL_Stale(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	handler.OnDELStaleFunc = func(key []byte) {}
+	err := p.Process([]byte("DEL some-key STALE\r\n"))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(handler.OnDELCalls()))
+	assert.Equal(t, 1, len(handler.OnDELStaleCalls()))
+	assert.Equal(t, []byte("some-key"), handler.OnDELStaleCalls()[0].Key)
+}
+
+func TestParser_DEL_Stale_Missing_CRLF(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	err := p.Process([]byte("DEL some-key STALE another"))
+
+	assert.Equal(t, errors.New("missing CRLF"), err)
+}
+
+func TestParser_DEL_Key_Named_STALE(t *testing.T) {
+	handler := &CommandHandlerMock{}
+	p := newParser(handler)
+
+	handler.OnDELFunc = func(key []byte) {}
+	err := p.Process([]byte("DEL STALE\r\n"))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(handler.OnDELCalls()))
+	assert.Equal(t, []byte("STALE"), handler.OnDELCalls()[0].Key)
+}
@@ -0,0 +1,274 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+//go:generate moq -out resp_mocks_test.go . RESPHandler
+
+// RESPHandler handles the commands of the RESP2 (Redis) protocol
+type RESPHandler interface {
+	OnGET(key []byte)
+	OnMGET(keys [][]byte)
+	OnSET(key []byte, value []byte)
+	OnDEL(keys [][]byte)
+	OnLGET(key []byte)
+	OnLSET(key []byte, lease uint64, value []byte)
+	OnPING()
+}
+
+const respMaxArgs = 1 << 16
+const respMaxBulkSize = 1 << 24    // 16MB
+const respMaxCommandSize = 1 << 24 // 16MB, the total size of the arguments of a command
+
+var (
+	respGET  = []byte("GET")
+	respMGET = []byte("MGET")
+	respSET  = []byte("SET")
+	respPING = []byte("PING")
+)
+
+// ErrInvalidMultiBulkLength ...
+var ErrInvalidMultiBulkLength = errors.New("invalid multibulk length")
+
+// ErrInvalidBulkLength ...
+var ErrInvalidBulkLength = errors.New("invalid bulk length")
+
+// ErrExpectedBulkString ...
+var ErrExpectedBulkString = errors.New("expected '$'")
+
+// ErrCommandTooLarge is returned when the total size of the arguments of a command exceeds the limit
+var ErrCommandTooLarge = errors.New("command too large")
+
+// ErrWrongNumberOfArguments ...
+var ErrWrongNumberOfArguments = errors.New("wrong number of arguments")
+
+// IsRESPProtocolError returns true if the error is returned by ReadCommand for input that is not valid RESP,
+// after which the connection can not be used anymore
+func IsRESPProtocolError(err error) bool {
+	switch err {
+	case ErrInvalidMultiBulkLength, ErrInvalidBulkLength, ErrExpectedBulkString, ErrCommandTooLarge,
+		ErrMissingCRLF, bufio.ErrBufferFull:
+		return true
+	default:
+		return false
+	}
+}
+
+// RESPParser reads the commands of the RESP2 protocol, either arrays of bulk strings or inline commands
+type RESPParser struct {
+	handler RESPHandler
+	reader  *bufio.Reader
+
+	args    [][]byte
+	offsets []int
+	data    []byte
+}
+
+// InitRESPParser ...
+func InitRESPParser(p *RESPParser, reader *bufio.Reader, handler RESPHandler) {
+	p.handler = handler
+	p.reader = reader
+}
+
+// ReadCommand reads the arguments of the next command,
+// the result is only valid until the next call
+func (p *RESPParser) ReadCommand() ([][]byte, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	p.data = p.data[:0]
+	p.offsets = p.offsets[:0]
+
+	if len(line) > 0 && line[0] == '*' {
+		err = p.readMultiBulk(line[1:])
+	} else {
+		p.readInline(line)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.args = p.args[:0]
+	begin := 0
+	for _, end := range p.offsets {
+		p.args = append(p.args, p.data[begin:end])
+		begin = end
+	}
+	return p.args, nil
+}
+
+// readLine returns a line without CRLF, only valid until the next read
+func (p *RESPParser) readLine() ([]byte, error) {
+	line, err := p.reader.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < len(crlf) || line[len(line)-2] != '\r' {
+		return nil, ErrMissingCRLF
+	}
+	return line[:len(line)-len(crlf)], nil
+}
+
+func (p *RESPParser) readInline(line []byte) {
+	for _, arg := range bytes.Fields(line) {
+		p.appendArg(arg)
+	}
+}
+
+func (p *RESPParser) appendArg(arg []byte) {
+	p.data = append(p.data, arg...)
+	p.offsets = append(p.offsets, len(p.data))
+}
+
+func parseRESPLength(data []byte, maxLength int) (int, bool) {
+	if len(data) == 0 || len(data) > 10 {
+		return 0, false
+	}
+	num := 0
+	for _, c := range data {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		num = num*10 + int(c-'0')
+	}
+	return num, num <= maxLength
+}
+
+func (p *RESPParser) readMultiBulk(lengthData []byte) error {
+	numArgs, ok := parseRESPLength(lengthData, respMaxArgs)
+	if !ok {
+		return ErrInvalidMultiBulkLength
+	}
+
+	for i := 0; i < numArgs; i++ {
+		err := p.readBulkString()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *RESPParser) readBulkString() error {
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return ErrExpectedBulkString
+	}
+
+	size, ok := parseRESPLength(line[1:], respMaxBulkSize)
+	if !ok {
+		return ErrInvalidBulkLength
+	}
+	if len(p.data)+size > respMaxCommandSize {
+		return ErrCommandTooLarge
+	}
+
+	begin := len(p.data)
+	end := begin + size + len(crlf)
+	if cap(p.data) < end {
+		newData := make([]byte, begin, 2*end)
+		copy(newData, p.data)
+		p.data = newData
+	}
+	p.data = p.data[:end]
+
+	_, err = io.ReadFull(p.reader, p.data[begin:end])
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(p.data[end-len(crlf):end], crlf) {
+		return ErrMissingCRLF
+	}
+
+	p.data = p.data[:end-len(crlf)]
+	p.offsets = append(p.offsets, len(p.data))
+	return nil
+}
+
+// Process dispatches the command to the handler, the command names are case-insensitive
+func (p *RESPParser) Process(args [][]byte) error {
+	if len(args) == 0 {
+		return ErrMissingCommand
+	}
+
+	cmd := args[0]
+	switch {
+	case bytes.EqualFold(cmd, respGET):
+		return processKeyCommand(args, p.handler.OnGET)
+	case bytes.EqualFold(cmd, LGET):
+		return processKeyCommand(args, p.handler.OnLGET)
+	case bytes.EqualFold(cmd, respMGET):
+		return processKeysCommand(args, p.handler.OnMGET)
+	case bytes.EqualFold(cmd, DEL):
+		return processKeysCommand(args, p.handler.OnDEL)
+	case bytes.EqualFold(cmd, respSET):
+		return p.processSET(args)
+	case bytes.EqualFold(cmd, LSET):
+		return p.processLSET(args)
+	case bytes.EqualFold(cmd, respPING):
+		p.handler.OnPING()
+		return nil
+	default:
+		return ErrInvalidCommand
+	}
+}
+
+// processKeyCommand for commands: GET|LGET key
+func processKeyCommand(args [][]byte, fn func(key []byte)) error {
+	if len(args) != 2 {
+		return ErrWrongNumberOfArguments
+	}
+	fn(args[1])
+	return nil
+}
+
+// processKeysCommand for commands: MGET|DEL key [key ...]
+func processKeysCommand(args [][]byte, fn func(keys [][]byte)) error {
+	if len(args) < 2 {
+		return ErrWrongNumberOfArguments
+	}
+	fn(args[1:])
+	return nil
+}
+
+// processSET for command: SET key value
+func (p *RESPParser) processSET(args [][]byte) error {
+	if len(args) != 3 {
+		return ErrWrongNumberOfArguments
+	}
+	p.handler.OnSET(args[1], args[2])
+	return nil
+}
+
+// processLSET for command: LSET key lease value
+func (p *RESPParser) processLSET(args [][]byte) error {
+	if len(args) != 4 {
+		return ErrWrongNumberOfArguments
+	}
+	if !isNumber(args[2]) {
+		return ErrLeaseNotNumber
+	}
+	p.handler.OnLSET(args[1], bytesToUint64(args[2]), args[3])
+	return nil
+}
+
+func isNumber(data []byte) bool {
+	if len(data) == 0 || len(data) > 20 {
+		return false
+	}
+	for _, c := range data {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newRESPParser(input string, handler RESPHandler) *RESPParser {
+	p := &RESPParser{}
+	InitRESPParser(p, bufio.NewReader(strings.NewReader(input)), handler)
+	return p
+}
+
+func readRESPCommandForTest(input string) ([]string, error) {
+	p := newRESPParser(input, &RESPHandlerMock{})
+	args, err := p.ReadCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, arg := range args {
+		result = append(result, string(arg))
+	}
+	return result, nil
+}
+
+func TestRESPParser_ReadCommand(t *testing.T) {
+	args, err := readRESPCommandForTest("*3\r\n$3\r\nSET\r\n$5\r\nkey01\r\n$12\r\nsome\r\nvalue!\r\n")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"SET", "key01", "some\r\nvalue!"}, args)
+
+	args, err = readRESPCommandForTest("*2\r\n$3\r\nGET\r\n$0\r\n\r\n")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"GET", ""}, args)
+
+	args, err = readRESPCommandForTest("GET  key01\r\n")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"GET", "key01"}, args)
+}
+
+func TestRESPParser_ReadCommand_Multiple(t *testing.T) {
+	p := newRESPParser("*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET\r\n$5\r\nkey01\r\n", &RESPHandlerMock{})
+
+	args, err := p.ReadCommand()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]byte{[]byte("PING")}, args)
+
+	args, err = p.ReadCommand()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]byte{[]byte("GET"), []byte("key01")}, args)
+
+	_, err = p.ReadCommand()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRESPParser_ReadCommand_Error(t *testing.T) {
+	_, err := readRESPCommandForTest("*abc\r\n")
+	assert.Equal(t, ErrInvalidMultiBulkLength, err)
+	assert.Equal(t, true, IsRESPProtocolError(err))
+
+	_, err = readRESPCommandForTest("*1\r\n+GET\r\n")
+	assert.Equal(t, ErrExpectedBulkString, err)
+
+	_, err = readRESPCommandForTest("*1\r\n$99999999999\r\n")
+	assert.Equal(t, ErrInvalidBulkLength, err)
+
+	_, err = readRESPCommandForTest("*1\r\n$3\r\nGETAB")
+	assert.Equal(t, ErrMissingCRLF, err)
+
+	_, err = readRESPCommandForTest("GET key01\n")
+	assert.Equal(t, ErrMissingCRLF, err)
+
+	_, err = readRESPCommandForTest("*1\r\n$3\r\nGE")
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, false, IsRESPProtocolError(err))
+}
+
+func TestRESPParser_ReadCommand_Too_Large(t *testing.T) {
+	value := strings.Repeat("A", respMaxCommandSize/2)
+	input := "*3\r\n$3\r\nSET\r\n$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n" +
+		"$" + strconv.Itoa(len(value)) + "\r\n"
+
+	_, err := readRESPCommandForTest(input)
+	assert.Equal(t, ErrCommandTooLarge, err)
+	assert.Equal(t, true, IsRESPProtocolError(err))
+}
+
+func TestRESPParser_Process(t *testing.T) {
+	handler := &RESPHandlerMock{
+		OnGETFunc:  func(key []byte) {},
+		OnLGETFunc: func(key []byte) {},
+		OnMGETFunc: func(keys [][]byte) {},
+		OnDELFunc:  func(keys [][]byte) {},
+		OnSETFunc:  func(key []byte, value []byte) {},
+		OnLSETFunc: func(key []byte, lease uint64, value []byte) {},
+		OnPINGFunc: func() {},
+	}
+	p := newRESPParser("", handler)
+
+	process := func(args ...string) error {
+		var input [][]byte
+		for _, arg := range args {
+			input = append(input, []byte(arg))
+		}
+		return p.Process(input)
+	}
+
+	assert.Equal(t, nil, process("get", "key01"))
+	assert.Equal(t, nil, process("LGET", "key02"))
+	assert.Equal(t, nil, process("MGET", "key01", "key02"))
+	assert.Equal(t, nil, process("DEL", "key03"))
+	assert.Equal(t, nil, process("SET", "key01", "value"))
+	assert.Equal(t, nil, process("lset", "key01", "18446744073709551615", "value"))
+	assert.Equal(t, nil, process("PING"))
+
+	assert.Equal(t, []byte("key01"), handler.OnGETCalls()[0].Key)
+	assert.Equal(t, []byte("key02"), handler.OnLGETCalls()[0].Key)
+	assert.Equal(t, [][]byte{[]byte("key01"), []byte("key02")}, handler.OnMGETCalls()[0].Keys)
+	assert.Equal(t, [][]byte{[]byte("key03")}, handler.OnDELCalls()[0].Keys)
+	assert.Equal(t, []byte("value"), handler.OnSETCalls()[0].Value)
+	assert.Equal(t, uint64(18446744073709551615), handler.OnLSETCalls()[0].Lease)
+	assert.Equal(t, 1, len(handler.OnPINGCalls()))
+}
+
+func TestRESPParser_Process_Error(t *testing.T) {
+	p := newRESPParser("", &RESPHandlerMock{})
+
+	err := p.Process(nil)
+	assert.Equal(t, errors.New("missing command"), err)
+
+	err = p.Process([][]byte{[]byte("INCR"), []byte("key01")})
+	assert.Equal(t, errors.New("invalid command"), err)
+
+	err = p.Process([][]byte{[]byte("GET")})
+	assert.Equal(t, errors.New("wrong number of arguments"), err)
+
+	err = p.Process([][]byte{[]byte("MGET")})
+	assert.Equal(t, errors.New("wrong number of arguments"), err)
+
+	err = p.Process([][]byte{[]byte("LSET"), []byte("key01"), []byte("abc"), []byte("value")})
+	assert.Equal(t, errors.New("lease is not number"), err)
+}
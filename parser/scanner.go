@@ -8,8 +8,17 @@ type tokenType int
 
 const (
 	tokenTypeLGET tokenType = iota
+	tokenTypeLGETW
 	tokenTypeLSET
+	tokenTypeLRELEASE
+	tokenTypeLEXTEND
 	tokenTypeDEL
+	tokenTypeDELTAG
+	tokenTypeDEBUG
+	tokenTypeSTATS
+	tokenTypeFLUSH
+	tokenTypeSNAPSHOT
+	tokenTypeSTALE
 	tokenTypeIdent
 
 	tokenTypeInt
@@ -151,18 +160,60 @@ func (s *scanner) handleChar(data []byte, index int, c byte) {
 	s.gotoInitState(index, c)
 }
 
+func computeLeaseTokenType(data []byte) tokenType {
+	switch {
+	case bytes.Equal(data, LGET):
+		return tokenTypeLGET
+	case bytes.Equal(data, LGETW):
+		return tokenTypeLGETW
+	case bytes.Equal(data, LSET):
+		return tokenTypeLSET
+	case bytes.Equal(data, LRELEASE):
+		return tokenTypeLRELEASE
+	case bytes.Equal(data, LEXTEND):
+		return tokenTypeLEXTEND
+	default:
+		return tokenTypeIdent
+	}
+}
+
+func computeDTokenType(data []byte) tokenType {
+	switch {
+	case bytes.Equal(data, DEL):
+		return tokenTypeDEL
+	case bytes.Equal(data, DELTAG):
+		return tokenTypeDELTAG
+	case bytes.Equal(data, DEBUG):
+		return tokenTypeDEBUG
+	default:
+		return tokenTypeIdent
+	}
+}
+
+func computeSTokenType(data []byte) tokenType {
+	switch {
+	case bytes.Equal(data, STALE):
+		return tokenTypeSTALE
+	case bytes.Equal(data, STATS):
+		return tokenTypeSTATS
+	case bytes.Equal(data, SNAPSHOT):
+		return tokenTypeSNAPSHOT
+	default:
+		return tokenTypeIdent
+	}
+}
+
 func computeTokenType(data []byte) tokenType {
 	switch data[0] {
 	case 'L':
-		if bytes.Equal(data, LGET) {
-			return tokenTypeLGET
-		}
-		if bytes.Equal(data, LSET) {
-			return tokenTypeLSET
-		}
+		return computeLeaseTokenType(data)
 	case 'D':
-		if bytes.Equal(data, DEL) {
-			return tokenTypeDEL
+		return computeDTokenType(data)
+	case 'S':
+		return computeSTokenType(data)
+	case 'F':
+		if bytes.Equal(data, FLUSH) {
+			return tokenTypeFLUSH
 		}
 	}
 	return tokenTypeIdent
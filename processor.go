@@ -4,13 +4,14 @@ import (
 	"github.com/QuangTung97/kvstore/lease"
 	"github.com/QuangTung97/kvstore/parser"
 	"go.uber.org/zap"
+	"time"
 )
 
 //go:generate moq -out processor_mocks_test.go . ResponseSender
 
 // ResponseSender ...
 type ResponseSender interface {
-	Send(ip IPAddr, port uint16, data []byte) error
+	Send(addr ClientAddr, data []byte) error
 }
 
 type processor struct {
@@ -19,11 +20,18 @@ type processor struct {
 	cmdStore commandListStore
 	parser   parser.Parser
 
-	cache  *lease.Cache
-	sender ResponseSender
+	namespaces *namespaces
+	sender     ResponseSender
+	keys       *frameKeys
+	acl        *accessControl
 
-	currentIP        IPAddr
-	currentPort      uint16
+	stats       processorStats
+	serverStats *serverStats
+	metrics     *metrics
+
+	currentAddr      ClientAddr
+	currentFormat    commandFormat
+	currentRole      AccessRole
 	currentRequestID uint64
 
 	resultData []byte
@@ -33,34 +41,49 @@ type processor struct {
 
 	sendFrame      []byte
 	currentBatchID uint64
+
+	waiters waiterQueue
 }
 
 func newProcessor(
-	cache *lease.Cache,
-	sender ResponseSender, options kvstoreOptions,
+	namespaces *namespaces, serverStats *serverStats,
+	sender ResponseSender, keys *frameKeys, acl *accessControl, options kvstoreOptions,
 ) *processor {
 	p := &processor{
 		options: options,
 
-		cache:  cache,
-		sender: sender,
+		namespaces: namespaces,
+		sender:     sender,
+		keys:       keys,
+		acl:        acl,
+
+		serverStats: serverStats,
+		metrics:     serverStats.metrics,
 
 		resultData:     make([]byte, options.bufferSize),
 		sendData:       make([]byte, options.bufferSize),
 		sendFrame:      make([]byte, options.maxResultPackageSize),
 		currentBatchID: 0,
 	}
-	initCommandListStore(&p.cmdStore, options.bufferSize)
+	initCommandListStore(&p.cmdStore, options.bufferSize, maxCommandListSize(options))
 	parser.InitParser(&p.parser, p)
 	return p
 }
 
+// maxCommandListSize is the size of the largest command list, either a reassembled batch or a single frame
+func maxCommandListSize(options kvstoreOptions) int {
+	if options.maxBatchSize > streamMaxFrameSize {
+		return options.maxBatchSize
+	}
+	return streamMaxFrameSize
+}
+
 func (p *processor) isCommandAppendable(dataSize int) bool {
 	return p.cmdStore.isCommandAppendable(dataSize)
 }
 
-func (p *processor) appendCommands(ip IPAddr, port uint16, data []byte) {
-	p.cmdStore.appendCommands(ip, port, data)
+func (p *processor) appendCommands(addr ClientAddr, format commandFormat, data []byte) {
+	p.cmdStore.appendCommands(addr, format, data)
 }
 
 func (p *processor) run() {
@@ -82,11 +105,22 @@ func (p *processor) runSingleLoop() bool {
 		return false
 	}
 
+	if p.cmdStore.isAvailable() {
+		p.processNextCommandList()
+	}
+	p.sendWaiterResponses()
+	return true
+}
+
+func (p *processor) processNextCommandList() {
+	startedAt := time.Now()
+
 	cmdList, committedOffset := p.cmdStore.getNextRawCommandList()
 	defer p.cmdStore.commitProcessedOffset(committedOffset)
 
-	p.currentIP = cmdList.ip
-	p.currentPort = cmdList.port
+	p.currentAddr = cmdList.addr
+	p.currentFormat = cmdList.format
+	p.currentRole = p.acl.roleOf(cmdList.addr)
 	p.sendOffset = 0
 
 	data := cmdList.data
@@ -94,13 +128,14 @@ func (p *processor) runSingleLoop() bool {
 		requestID, content, nextOffset := parseDataFrameEntry(data)
 		if len(content) == 0 {
 			p.options.logger.Error("Invalid data frame entry")
-			return true
+			return
 		}
 
 		p.currentRequestID = requestID
 
-		err := p.parser.Process(content)
+		err := p.processCommand(cmdList.format, content)
 		if err != nil {
+			p.recordCommandError(err)
 			p.onCommand(func(data []byte) int {
 				return buildErrorResponse(data, err.Error())
 			})
@@ -109,58 +144,124 @@ func (p *processor) runSingleLoop() bool {
 	}
 
 	p.sendResponse()
-	return true
+	p.metrics.batchDuration.Observe(time.Since(startedAt).Seconds())
+}
+
+func (p *processor) processCommand(format commandFormat, content []byte) error {
+	if format.rateLimited {
+		return errRateLimited
+	}
+	if format.binary {
+		return p.parser.ProcessBinary(content)
+	}
+	return p.parser.Process(content)
+}
+
+func (p *processor) recordCommandError(err error) {
+	switch err {
+	case errRateLimited:
+		p.metrics.rateLimited.Inc()
+	case errPermissionDenied:
+		p.metrics.permissionDenied.Inc()
+	default:
+		p.metrics.invalid.Inc()
+	}
+}
+
+// checkRole replies a permission denied error if the role of the current client is lower than the required role
+func (p *processor) checkRole(required AccessRole) bool {
+	if p.currentRole >= required {
+		return true
+	}
+	p.recordCommandError(errPermissionDenied)
+	p.onCommand(func(data []byte) int {
+		return buildErrorResponse(data, errPermissionDenied.Error())
+	})
+	return false
+}
+
+func (p *processor) sendWaiterResponses() {
+	for _, w := range p.waiters.popAll() {
+		w.timer.Stop()
+
+		p.currentAddr = w.addr
+		p.currentFormat = w.format
+		p.currentRole = p.acl.roleOf(w.addr)
+		p.currentRequestID = w.requestID
+		p.sendOffset = 0
+
+		switch w.status {
+		case waiterStatusValue:
+			p.onCommand(func(data []byte) int {
+				return buildGetResponse(data, lease.GetResult{
+					Status:    lease.GetStatusFound,
+					ValueSize: len(w.value),
+				}, w.value)
+			})
+
+		case waiterStatusReleased:
+			p.OnLGET(w.key)
+
+		default:
+			p.metrics.leaseWaitTimeout.Inc()
+			p.onCommand(func(data []byte) int {
+				return buildGetResponse(data, lease.GetResult{
+					Status: lease.GetStatusLeaseRejected,
+				}, nil)
+			})
+		}
+
+		p.sendResponse()
+	}
 }
 
 func (p *processor) sendResultFrame(data []byte) {
-	err := p.sender.Send(p.currentIP, p.currentPort, data)
+	err := p.sender.Send(p.currentAddr, data)
 	if err != nil {
+		p.metrics.sendErrors.Inc()
 		p.options.logger.Error("Send response error", zap.Error(err))
 		return
 	}
 }
 
 func (p *processor) sendResponse() {
-	p.currentBatchID++
-
-	length := p.sendOffset
-	data := p.sendData[:length]
-	offset := uint32(0)
-	sendFrameLen := len(p.sendFrame)
-
-	if length+dataFrameLengthOffset <= sendFrameLen {
-		nextOffset := buildDataFrameHeader(p.sendFrame, dataFrameHeader{
-			batchID:    p.currentBatchID,
-			fragmented: false,
-		})
-
-		copy(p.sendFrame[nextOffset:], data)
-		nextOffset += length
-		p.sendResultFrame(p.sendFrame[:nextOffset])
+	if p.sendOffset == 0 {
 		return
 	}
 
-	for len(data) > 0 {
-		nextOffset := buildDataFrameHeader(p.sendFrame, dataFrameHeader{
-			batchID:    p.currentBatchID,
-			fragmented: true,
-			length:     uint32(length),
-			offset:     offset,
-		})
-
-		dataLen := len(data)
-		if nextOffset+len(data) > sendFrameLen {
-			dataLen = sendFrameLen - nextOffset
+	p.currentBatchID++
+	header := dataFrameHeader{
+		version:  p.currentFormat.version,
+		batchID:  p.currentBatchID,
+		checksum: p.currentFormat.checksum,
+	}
+	if p.currentFormat.encrypted {
+		aead, ok := p.keys.encryption.get(p.currentFormat.encryptionKeyID)
+		if !ok {
+			p.metrics.sendErrors.Inc()
+			p.options.logger.Error("Encryption key of response not found")
+			return
 		}
+		header.encrypted = true
+		header.encryptionKeyID = p.currentFormat.encryptionKeyID
+		header.aead = aead
+	}
+	err := buildDataFrames(p.sendFrame, header, p.sendData[:p.sendOffset], p.sendResultFrame)
+	if err != nil {
+		p.metrics.sendErrors.Inc()
+		p.options.logger.Error("Encrypt response error", zap.Error(err))
+		p.sendRejectFrame(errEncryptFailed)
+	}
+}
 
-		copy(p.sendFrame[nextOffset:], data)
-		nextOffset += dataLen
-
-		p.sendResultFrame(p.sendFrame[:nextOffset])
-
-		data = data[dataLen:]
-		offset += uint32(dataLen)
+// sendRejectFrame replies a rejected frame with the error, which is not encrypted
+func (p *processor) sendRejectFrame(reason error) {
+	header := dataFrameHeader{
+		version:  frameVersionLatest,
+		batchID:  p.currentBatchID,
+		rejected: true,
 	}
+	_ = buildDataFrames(p.sendFrame, header, []byte(reason.Error()), p.sendResultFrame)
 }
 
 func buildResponseNumber(data []byte, num uint64) int {
@@ -186,8 +287,12 @@ func buildResponseNumber(data []byte, num uint64) int {
 var okResponse = []byte("OK ")
 var grantedResponse = []byte("GRANTED ")
 var rejectedResponse = []byte("REJECTED")
+var staleResponse = []byte("STALE ")
+var spaceResponse = []byte(" ")
 var crlfResponse = []byte("\r\n")
 var errorResponse = []byte("ERROR ")
+var statResponse = []byte("STAT ")
+var endResponse = []byte("END\r\n")
 
 func buildGetResponse(data []byte, result lease.GetResult, value []byte) int {
 	offset := 0
@@ -197,12 +302,29 @@ func buildGetResponse(data []byte, result lease.GetResult, value []byte) int {
 		copy(data, grantedResponse)
 		offset = len(grantedResponse)
 
-		offset += buildResponseNumber(data[offset:], uint64(result.LeaseID))
+		offset += buildResponseNumber(data[offset:], result.LeaseID)
 
 	case lease.GetStatusLeaseRejected:
 		copy(data, rejectedResponse)
 		offset = len(rejectedResponse)
 
+	case lease.GetStatusStaleLeaseGranted, lease.GetStatusStaleLeaseRejected:
+		copy(data, staleResponse)
+		offset = len(staleResponse)
+
+		offset += buildResponseNumber(data[offset:], uint64(result.ValueSize))
+
+		copy(data[offset:], spaceResponse)
+		offset += len(spaceResponse)
+
+		offset += buildResponseNumber(data[offset:], result.LeaseID)
+
+		copy(data[offset:], crlfResponse)
+		offset += len(crlfResponse)
+
+		copy(data[offset:], value)
+		offset += len(value)
+
 	default:
 		copy(data, okResponse)
 		offset = len(okResponse)
@@ -251,6 +373,69 @@ func buildErrorResponse(data []byte, errMsg string) int {
 	return offset
 }
 
+type statEntry struct {
+	name  string
+	value uint64
+}
+
+// maxNumberSize is the number of digits of the max uint64
+const maxNumberSize = 20
+
+// statsResponseMaxSize returns the max size of the response built by buildStatsResponse
+func statsResponseMaxSize(entries []statEntry) int {
+	size := len(endResponse)
+	for _, e := range entries {
+		size += len(statResponse) + len(e.name) + len(spaceResponse) + maxNumberSize + len(crlfResponse)
+	}
+	return size
+}
+
+// buildStatsResponse builds a list of lines: STAT name value, ended by the line: END
+func buildStatsResponse(data []byte, entries []statEntry) int {
+	offset := 0
+	for _, e := range entries {
+		copy(data[offset:], statResponse)
+		offset += len(statResponse)
+
+		copy(data[offset:], e.name)
+		offset += len(e.name)
+
+		copy(data[offset:], spaceResponse)
+		offset += len(spaceResponse)
+
+		offset += buildResponseNumber(data[offset:], e.value)
+
+		copy(data[offset:], crlfResponse)
+		offset += len(crlfResponse)
+	}
+
+	copy(data[offset:], endResponse)
+	offset += len(endResponse)
+	return offset
+}
+
+//revive:disable-next-line:flag-parameter
+func boolToUint64(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func keyInfoToStats(info lease.KeyInfo) []statEntry {
+	return []statEntry{
+		{name: "stored", value: boolToUint64(info.Stored)},
+		{name: "value_size", value: uint64(info.ValueSize)},
+		{name: "stale", value: boolToUint64(info.Stale)},
+		{name: "stale_size", value: uint64(info.StaleSize)},
+		{name: "lease", value: info.LeaseID},
+		{name: "lease_age_ms", value: uint64(info.LeaseAge / time.Millisecond)},
+		{name: "bucket", value: uint64(info.BucketIndex)},
+		{name: "bucket_leases", value: uint64(info.BucketLeases)},
+		{name: "bucket_size", value: uint64(info.BucketSize)},
+	}
+}
+
 func (p *processor) onCommand(builder func(data []byte) int) {
 	offset := p.sendOffset + entryDataOffset
 
@@ -263,15 +448,116 @@ func (p *processor) onCommand(builder func(data []byte) int) {
 }
 
 func (p *processor) OnLGET(key []byte) {
-	result := p.cache.Get(key, p.resultData)
+	if !p.checkRole(AccessRoleReadOnly) {
+		return
+	}
+
+	result := p.namespaces.cacheOf(key).Get(key, p.resultData)
+	p.stats.recordGet(result.Status)
+	p.metrics.recordGet(&p.metrics.lget, result.Status)
+
+	p.onCommand(func(data []byte) int {
+		return buildGetResponse(data, result, p.resultData[:result.ValueSize])
+	})
+}
+
+func (p *processor) OnLGETW(key []byte, timeout uint32) {
+	if !p.checkRole(AccessRoleReadOnly) {
+		return
+	}
+
+	if timeout == 0 {
+		p.OnLGET(key)
+		return
+	}
+
+	w := &processorWaiter{
+		proc:  p,
+		cache: p.namespaces.cacheOf(key),
+
+		addr:      p.currentAddr,
+		format:    p.currentFormat,
+		requestID: p.currentRequestID,
+		key:       cloneBytes(key),
+	}
+
+	result := w.cache.GetOrWait(w.key, p.resultData, w)
+	p.stats.recordGet(result.Status)
+	p.metrics.recordGet(&p.metrics.lgetw, result.Status)
+	switch result.Status {
+	case lease.GetStatusLeaseWaiting:
+		w.timer = time.AfterFunc(waitTimeout(timeout), w.onTimeout)
+		return
+
+	case lease.GetStatusLeaseRejected:
+		p.onCommand(func(data []byte) int {
+			return buildErrorResponse(data, errTooManyWaiters.Error())
+		})
+		return
+	}
 
 	p.onCommand(func(data []byte) int {
 		return buildGetResponse(data, result, p.resultData[:result.ValueSize])
 	})
 }
 
-func (p *processor) OnLSET(key []byte, leaseID uint32, value []byte) {
-	affected := p.cache.Set(key, leaseID, value)
+// waitTimeout converts the timeout of LGETW in millisecond, at most maxWaitTimeout
+func waitTimeout(timeout uint32) time.Duration {
+	d := time.Duration(timeout) * time.Millisecond
+	if d > maxWaitTimeout {
+		return maxWaitTimeout
+	}
+	return d
+}
+
+func (p *processor) OnLSET(key []byte, leaseID uint64, value []byte) {
+	if !p.checkRole(AccessRoleReadWrite) {
+		return
+	}
+
+	affected := p.namespaces.cacheOf(key).Set(key, leaseID, value)
+	recordAffected(affected, &p.stats.setAffected, &p.stats.setNotAffected)
+	p.metrics.lset.inc(affected)
+
+	p.onCommand(func(data []byte) int {
+		return buildOKResponse(data, affected)
+	})
+}
+
+func (p *processor) OnLSETTags(key []byte, leaseID uint64, value []byte, tags [][]byte) {
+	if !p.checkRole(AccessRoleReadWrite) {
+		return
+	}
+
+	affected := p.namespaces.cacheOf(key).SetWithTags(key, leaseID, value, tags)
+	recordAffected(affected, &p.stats.setAffected, &p.stats.setNotAffected)
+	p.metrics.lset.inc(affected)
+
+	p.onCommand(func(data []byte) int {
+		return buildOKResponse(data, affected)
+	})
+}
+
+func (p *processor) OnLRELEASE(key []byte, leaseID uint64) {
+	if !p.checkRole(AccessRoleReadWrite) {
+		return
+	}
+
+	affected := p.namespaces.cacheOf(key).Release(key, leaseID)
+	p.metrics.lrelease.inc(affected)
+
+	p.onCommand(func(data []byte) int {
+		return buildOKResponse(data, affected)
+	})
+}
+
+func (p *processor) OnLEXTEND(key []byte, leaseID uint64, seconds uint32) {
+	if !p.checkRole(AccessRoleReadWrite) {
+		return
+	}
+
+	affected := p.namespaces.cacheOf(key).Extend(key, leaseID, time.Duration(seconds)*time.Second)
+	p.metrics.lextend.inc(affected)
 
 	p.onCommand(func(data []byte) int {
 		return buildOKResponse(data, affected)
@@ -279,7 +565,101 @@ func (p *processor) OnLSET(key []byte, leaseID uint32, value []byte) {
 }
 
 func (p *processor) OnDEL(key []byte) {
-	affected := p.cache.Invalidate(key)
+	if !p.checkRole(AccessRoleReadWrite) {
+		return
+	}
+
+	affected := p.namespaces.cacheOf(key).Invalidate(key)
+	recordAffected(affected, &p.stats.delAffected, &p.stats.delNotAffected)
+	p.metrics.del.inc(affected)
+
+	p.onCommand(func(data []byte) int {
+		return buildOKResponse(data, affected)
+	})
+}
+
+func (p *processor) OnDELStale(key []byte) {
+	if !p.checkRole(AccessRoleReadWrite) {
+		return
+	}
+
+	affected := p.namespaces.cacheOf(key).MarkStale(key)
+	recordAffected(affected, &p.stats.delAffected, &p.stats.delNotAffected)
+	p.metrics.delStale.inc(affected)
+
+	p.onCommand(func(data []byte) int {
+		return buildOKResponse(data, affected)
+	})
+}
+
+func (p *processor) OnDELTAG(tag []byte) {
+	if !p.checkRole(AccessRoleReadWrite) {
+		return
+	}
+
+	p.namespaces.deleteTag(tag)
+	p.metrics.deltag.Inc()
+
+	p.onCommand(func(data []byte) int {
+		return buildOKResponse(data, true)
+	})
+}
+
+func (p *processor) OnDEBUG(key []byte) {
+	if !p.checkRole(AccessRoleReadOnly) {
+		return
+	}
+
+	info := p.namespaces.cacheOf(key).Inspect(key)
+	p.metrics.debug.Inc()
+
+	p.onCommand(func(data []byte) int {
+		return buildStatsResponse(data, keyInfoToStats(info))
+	})
+}
+
+func (p *processor) OnSTATS() {
+	if !p.checkRole(AccessRoleReadOnly) {
+		return
+	}
+
+	entries := p.serverStats.collectStats(p.namespaces.defaultCache)
+	p.metrics.stats.Inc()
+
+	p.onCommand(func(data []byte) int {
+		return buildStatsResponse(data, entries)
+	})
+}
+
+func (p *processor) OnSNAPSHOT() {
+	if !p.checkRole(AccessRoleReadWrite) {
+		return
+	}
+
+	snapshots := p.namespaces.snapshots
+	if snapshots == nil {
+		p.recordCommandError(errSnapshotNotConfigured)
+		p.onCommand(func(data []byte) int {
+			return buildErrorResponse(data, errSnapshotNotConfigured.Error())
+		})
+		return
+	}
+
+	started := snapshots.start()
+	p.metrics.snapshot.inc(started)
+
+	p.onCommand(func(data []byte) int {
+		return buildOKResponse(data, started)
+	})
+}
+
+func (p *processor) OnFLUSH(namespace []byte) {
+	if !p.checkRole(AccessRoleReadWrite) {
+		return
+	}
+
+	affected := p.namespaces.flush(namespace)
+	p.metrics.flush.inc(affected)
 
 	p.onCommand(func(data []byte) int {
 		return buildOKResponse(data, affected)
@@ -17,7 +17,7 @@ var _ ResponseSender = &ResponseSenderMock{}
 //
 // 		// make and configure a mocked ResponseSender
 // 		mockedResponseSender := &ResponseSenderMock{
-// 			SendFunc: func(ip IPAddr, port uint16, data []byte) error {
+// 			SendFunc: func(addr ClientAddr, data []byte) error {
 // 				panic("mock out the Send method")
 // 			},
 // 		}
@@ -28,16 +28,14 @@ var _ ResponseSender = &ResponseSenderMock{}
 // 	}
 type ResponseSenderMock struct {
 	// SendFunc mocks the Send method.
-	SendFunc func(ip IPAddr, port uint16, data []byte) error
+	SendFunc func(addr ClientAddr, data []byte) error
 
 	// calls tracks calls to the methods.
 	calls struct {
 		// Send holds details about calls to the Send method.
 		Send []struct {
-			// IP is the ip argument value.
-			IP IPAddr
-			// Port is the port argument value.
-			Port uint16
+			// Addr is the addr argument value.
+			Addr ClientAddr
 			// Data is the data argument value.
 			Data []byte
 		}
@@ -46,36 +44,32 @@ type ResponseSenderMock struct {
 }
 
 // Send calls SendFunc.
-func (mock *ResponseSenderMock) Send(ip IPAddr, port uint16, data []byte) error {
+func (mock *ResponseSenderMock) Send(addr ClientAddr, data []byte) error {
 	if mock.SendFunc == nil {
 		panic("ResponseSenderMock.SendFunc: method is nil but ResponseSender.Send was just called")
 	}
 	callInfo := struct {
-		IP   IPAddr
-		Port uint16
+		Addr ClientAddr
 		Data []byte
 	}{
-		IP:   ip,
-		Port: port,
+		Addr: addr,
 		Data: data,
 	}
 	mock.lockSend.Lock()
 	mock.calls.Send = append(mock.calls.Send, callInfo)
 	mock.lockSend.Unlock()
-	return mock.SendFunc(ip, port, data)
+	return mock.SendFunc(addr, data)
 }
 
 // SendCalls gets all the calls that were made to Send.
 // Check the length with:
 //     len(mockedResponseSender.SendCalls())
 func (mock *ResponseSenderMock) SendCalls() []struct {
-	IP   IPAddr
-	Port uint16
+	Addr ClientAddr
 	Data []byte
 } {
 	var calls []struct {
-		IP   IPAddr
-		Port uint16
+		Addr ClientAddr
 		Data []byte
 	}
 	mock.lockSend.RLock()
@@ -2,16 +2,37 @@ package kvstore
 
 import (
 	"github.com/QuangTung97/kvstore/lease"
+	"github.com/QuangTung97/kvstore/parser"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+	"math"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func newProcessorForTest(sender ResponseSender, options ...Option) *processor {
-	cache := lease.New(4, 1<<16)
+	cache := lease.New(4, 1<<16, lease.WithLeaseEpoch(0))
 	options = append(options, WithBufferSize(1000))
-	return newProcessor(cache, sender, computeOptions(options...))
+
+	stats := newServerStats()
+	opts := computeOptions(options...)
+	keys, err := newFrameKeys(opts)
+	if err != nil {
+		panic(err)
+	}
+	policies, err := newClientPolicies(opts)
+	if err != nil {
+		panic(err)
+	}
+	p := newProcessor(newNamespaces(cache, opts.namespaces), stats, sender, keys, policies.acl, opts)
+	stats.processors = []*processor{p}
+	stats.namespaces = p.namespaces
+	return p
 }
 
 func TestBuildGetResponse_Found(t *testing.T) {
@@ -41,8 +62,36 @@ func TestBuildGetResponse_Granted_Normal(t *testing.T) {
 	assert.Equal(t, []byte("GRANTED 12340\r\n"), data[:offset])
 }
 
+func TestBuildGetResponse_Granted_With_Epoch(t *testing.T) {
+	data := make([]byte, 1000)
+	offset := buildGetResponse(data, lease.GetResult{
+		Status:  lease.GetStatusLeaseGranted,
+		LeaseID: 0xffffffff00000001,
+	}, nil)
+	assert.Equal(t, []byte("GRANTED 18446744069414584321\r\n"), data[:offset])
+}
+
+func TestBuildGetResponse_Stale_Granted(t *testing.T) {
+	data := make([]byte, 1000)
+	offset := buildGetResponse(data, lease.GetResult{
+		Status:    lease.GetStatusStaleLeaseGranted,
+		LeaseID:   12,
+		ValueSize: 10,
+	}, []byte("some value"))
+	assert.Equal(t, []byte("STALE 10 12\r\nsome value\r\n"), data[:offset])
+}
+
+func TestBuildGetResponse_Stale_Rejected(t *testing.T) {
+	data := make([]byte, 1000)
+	offset := buildGetResponse(data, lease.GetResult{
+		Status:    lease.GetStatusStaleLeaseRejected,
+		ValueSize: 10,
+	}, []byte("some value"))
+	assert.Equal(t, []byte("STALE 10 0\r\nsome value\r\n"), data[:offset])
+}
+
 func (p *processor) perform(
-	ip IPAddr, port uint16, startRequestID uint64,
+	addr ClientAddr, startRequestID uint64,
 	actionList ...string,
 ) {
 	data := make([]byte, 1000)
@@ -57,7 +106,7 @@ func (p *processor) perform(
 
 		startRequestID++
 	}
-	p.appendCommands(ip, port, data[:offset])
+	p.appendCommands(addr, commandFormat{}, data[:offset])
 }
 
 func checkAndGetSendData(t *testing.T, data []byte, batchID uint64) []byte {
@@ -71,16 +120,15 @@ func TestProcessor_RunSingleLoop_Single_Command(t *testing.T) {
 	sender := &ResponseSenderMock{}
 	p := newProcessorForTest(sender)
 
-	p.perform(newIPAddr(192, 168, 1, 23),
-		7200, 213, "LGET key01\r\n")
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213, "LGET key01\r\n")
 
-	sender.SendFunc = func(ip IPAddr, port uint16, data []byte) error { return nil }
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
 	continued := p.runSingleLoop()
 	assert.Equal(t, true, continued)
 
 	assert.Equal(t, 1, len(sender.SendCalls()))
-	assert.Equal(t, newIPAddr(192, 168, 1, 23), sender.SendCalls()[0].IP)
-	assert.Equal(t, uint16(7200), sender.SendCalls()[0].Port)
+	assert.Equal(t, NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200), sender.SendCalls()[0].Addr)
 
 	sendData := checkAndGetSendData(t, sender.SendCalls()[0].Data, 1)
 
@@ -93,18 +141,17 @@ func TestProcessor_RunSingleLoop_Multi_Commands(t *testing.T) {
 	sender := &ResponseSenderMock{}
 	p := newProcessorForTest(sender)
 
-	p.perform(newIPAddr(192, 168, 1, 23),
-		7200, 213,
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
 		"LGET key01\r\n",
 		"LGET key02\r\n",
 	)
 
-	sender.SendFunc = func(ip IPAddr, port uint16, data []byte) error { return nil }
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
 	p.runSingleLoop()
 
 	assert.Equal(t, 1, len(sender.SendCalls()))
-	assert.Equal(t, newIPAddr(192, 168, 1, 23), sender.SendCalls()[0].IP)
-	assert.Equal(t, uint16(7200), sender.SendCalls()[0].Port)
+	assert.Equal(t, NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200), sender.SendCalls()[0].Addr)
 
 	sendData := checkAndGetSendData(t, sender.SendCalls()[0].Data, 1)
 
@@ -122,18 +169,17 @@ func TestProcessor_RunSingleLoop_LGET_Rejected(t *testing.T) {
 	sender := &ResponseSenderMock{}
 	p := newProcessorForTest(sender)
 
-	p.perform(newIPAddr(192, 168, 1, 23),
-		7200, 213,
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
 		"LGET key01\r\n",
 		"LGET key01\r\n",
 	)
 
-	sender.SendFunc = func(ip IPAddr, port uint16, data []byte) error { return nil }
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
 	p.runSingleLoop()
 
 	assert.Equal(t, 1, len(sender.SendCalls()))
-	assert.Equal(t, newIPAddr(192, 168, 1, 23), sender.SendCalls()[0].IP)
-	assert.Equal(t, uint16(7200), sender.SendCalls()[0].Port)
+	assert.Equal(t, NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200), sender.SendCalls()[0].Addr)
 
 	sendData := checkAndGetSendData(t, sender.SendCalls()[0].Data, 1)
 
@@ -151,10 +197,10 @@ func TestProcessor_RunSingleLoop_SET_OK(t *testing.T) {
 	sender := &ResponseSenderMock{}
 	p := newProcessorForTest(sender)
 
-	sender.SendFunc = func(ip IPAddr, port uint16, data []byte) error { return nil }
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
 
-	p.perform(newIPAddr(192, 168, 1, 23),
-		7200, 213,
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
 		"LGET key01\r\n",
 	)
 	p.runSingleLoop()
@@ -167,8 +213,8 @@ func TestProcessor_RunSingleLoop_SET_OK(t *testing.T) {
 	assert.Equal(t, uint64(213), requestID)
 	assert.Equal(t, string(data), "GRANTED 1\r\n")
 
-	p.perform(newIPAddr(192, 168, 1, 23),
-		7200, 220,
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		220,
 		"LSET key01 1 10\r\nsome-value\r\n",
 	)
 	p.runSingleLoop()
@@ -181,14 +227,62 @@ func TestProcessor_RunSingleLoop_SET_OK(t *testing.T) {
 	assert.Equal(t, "OK 1\r\n", string(data))
 }
 
+func TestProcessor_RunSingleLoop_Access_Rules(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender,
+		WithDefaultAccessRole(AccessRoleReadOnly),
+		WithAccessRule("10.0.0.0/8", AccessRoleReadWrite),
+		WithAccessRule("10.1.0.0/16", AccessRoleNone),
+	)
+
+	var sendDataList [][]byte
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
+		sendDataList = append(sendDataList, cloneBytes(data))
+		return nil
+	}
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET key01\r\n",
+		"LSET key01 1 10\r\nsome-value\r\n",
+		"DEL key01\r\n",
+	)
+	p.runSingleLoop()
+
+	p.perform(NewUDPClientAddr(newIPAddr(10, 2, 0, 5), 7200),
+		220,
+		"LSET key01 1 10\r\nsome-value\r\n",
+	)
+	p.runSingleLoop()
+
+	p.perform(NewUDPClientAddr(newIPAddr(10, 1, 0, 5), 7200),
+		230,
+		"LGET key01\r\n",
+	)
+	p.runSingleLoop()
+
+	assert.Equal(t, 3, len(sendDataList))
+	assert.Equal(t, []string{
+		"GRANTED 1\r\n",
+		"ERROR permission denied\r\n",
+		"ERROR permission denied\r\n",
+	}, parseAllResponses(checkAndGetSendData(t, sendDataList[0], 1)))
+	assert.Equal(t, []string{
+		"OK 1\r\n",
+	}, parseAllResponses(checkAndGetSendData(t, sendDataList[1], 2)))
+	assert.Equal(t, []string{
+		"ERROR permission denied\r\n",
+	}, parseAllResponses(checkAndGetSendData(t, sendDataList[2], 3)))
+}
+
 func TestProcessor_RunSingleLoop_SET_Not_Affected(t *testing.T) {
 	sender := &ResponseSenderMock{}
 	p := newProcessorForTest(sender)
 
-	sender.SendFunc = func(ip IPAddr, port uint16, data []byte) error { return nil }
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
 
-	p.perform(newIPAddr(192, 168, 1, 23),
-		7200, 213,
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
 		"LGET key01\r\n",
 	)
 	p.runSingleLoop()
@@ -201,8 +295,8 @@ func TestProcessor_RunSingleLoop_SET_Not_Affected(t *testing.T) {
 	assert.Equal(t, uint64(213), requestID)
 	assert.Equal(t, string(data), "GRANTED 1\r\n")
 
-	p.perform(newIPAddr(192, 168, 1, 23),
-		7200, 220,
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		220,
 		"LSET key01 2 10\r\nsome-value\r\n",
 	)
 	p.runSingleLoop()
@@ -219,11 +313,11 @@ func TestProcessor_RunSingleLoop_DEL_OK(t *testing.T) {
 	sender := &ResponseSenderMock{}
 	p := newProcessorForTest(sender)
 
-	sender.SendFunc = func(ip IPAddr, port uint16, data []byte) error { return nil }
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
 
 	// LGET
-	p.perform(newIPAddr(192, 168, 1, 23),
-		7200, 213,
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
 		"LGET key01\r\n",
 	)
 	p.runSingleLoop()
@@ -234,8 +328,8 @@ func TestProcessor_RunSingleLoop_DEL_OK(t *testing.T) {
 	assert.Equal(t, string(data), "GRANTED 1\r\n")
 
 	// LSET
-	p.perform(newIPAddr(192, 168, 1, 23),
-		7200, 220,
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		220,
 		"LSET key01 1 10\r\nsome-value\r\n",
 	)
 	p.runSingleLoop()
@@ -246,8 +340,8 @@ func TestProcessor_RunSingleLoop_DEL_OK(t *testing.T) {
 	assert.Equal(t, "OK 1\r\n", string(data))
 
 	// DEL
-	p.perform(newIPAddr(192, 168, 1, 23),
-		7200, 230,
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		230,
 		"DEL key01\r\n",
 	)
 	p.runSingleLoop()
@@ -260,24 +354,18 @@ func TestProcessor_RunSingleLoop_DEL_OK(t *testing.T) {
 	assert.Equal(t, "OK 1\r\n", string(data))
 }
 
-func cloneBytes(a []byte) []byte {
-	result := make([]byte, len(a))
-	copy(result, a)
-	return result
-}
-
 func TestProcessor_RunSingleLoop_LGET_OK_Exceed_ResultPackageSize(t *testing.T) {
 	sender := &ResponseSenderMock{}
 	p := newProcessorForTest(sender, WithMaxResultPackageSize(32))
-	p.cache.GetUnsafeInnerCache().Put([]byte("key01"), []byte(strings.Repeat("A", 9)))
+	p.namespaces.defaultCache.Put([]byte("key01"), []byte(strings.Repeat("A", 9)))
 
-	p.perform(newIPAddr(192, 168, 1, 23),
-		7200, 213,
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
 		"LGET key01\r\n",
 	)
 
 	var sendDataList [][]byte
-	sender.SendFunc = func(ip IPAddr, port uint16, data []byte) error {
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
 		sendDataList = append(sendDataList, cloneBytes(data))
 		return nil
 	}
@@ -316,17 +404,17 @@ func TestProcessor_RunSingleLoop_ParseDataFrameEntry_Error(t *testing.T) {
 	p := newProcessorForTest(sender, WithLogger(logger))
 
 	ip := newIPAddr(192, 168, 1, 12)
-	p.appendCommands(ip, 7200, []byte{1, 2})
+	p.appendCommands(NewUDPClientAddr(ip, 7200), commandFormat{}, []byte{1, 2})
 
 	p.runSingleLoop()
 }
 
 func TestProcessor_RunSingleLoop_LGET_Missing_Key(t *testing.T) {
 	sender := &ResponseSenderMock{}
-	sender.SendFunc = func(ip IPAddr, port uint16, data []byte) error { return nil }
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
 
 	p := newProcessorForTest(sender)
-	p.perform(newIPAddr(192, 168, 1, 2), 8200, 10, "LGET\r\n")
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 2), 8200), 10, "LGET\r\n")
 	p.runSingleLoop()
 
 	assert.Equal(t, 1, len(sender.SendCalls()))
@@ -336,3 +424,519 @@ func TestProcessor_RunSingleLoop_LGET_Missing_Key(t *testing.T) {
 	assert.Equal(t, uint64(10), requestID)
 	assert.Equal(t, "ERROR missing key\r\n", string(data))
 }
+
+func TestProcessor_RunSingleLoop_DEL_Stale(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender)
+
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET key01\r\n",
+		"LSET key01 1 10\r\nsome-value\r\n",
+		"DEL key01 STALE\r\n",
+		"LGET key01\r\n",
+		"LGET key01\r\n",
+	)
+	p.runSingleLoop()
+
+	assert.Equal(t, 1, len(sender.SendCalls()))
+	sendData := checkAndGetSendData(t, sender.SendCalls()[0].Data, 1)
+	assert.Equal(t, []string{
+		"GRANTED 1\r\n",
+		"OK 1\r\n",
+		"OK 1\r\n",
+		"STALE 10 2\r\nsome-value\r\n",
+		"STALE 10 0\r\nsome-value\r\n",
+	}, parseAllResponses(sendData))
+}
+
+func parseAllResponses(data []byte) []string {
+	var responses []string
+	for len(data) > 0 {
+		_, content, offset := parseDataFrameEntry(data)
+		if offset == 0 {
+			return append(responses, "invalid entry")
+		}
+		responses = append(responses, string(content))
+		data = data[offset:]
+	}
+	return responses
+}
+
+func TestProcessor_RunSingleLoop_LGETW_Notified_By_LSET(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender)
+
+	var sendDataList [][]byte
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
+		sendDataList = append(sendDataList, cloneBytes(data))
+		return nil
+	}
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET key01\r\n",
+	)
+	p.runSingleLoop()
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 24), 7300),
+		300,
+		"LGETW key01 10000\r\n",
+	)
+	p.runSingleLoop()
+	assert.Equal(t, 1, len(sender.SendCalls()))
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		220,
+		"LSET key01 1 10\r\nsome-value\r\n",
+	)
+	p.runSingleLoop()
+
+	assert.Equal(t, 3, len(sender.SendCalls()))
+
+	sendData := checkAndGetSendData(t, sendDataList[1], 2)
+	assert.Equal(t, []string{"OK 1\r\n"}, parseAllResponses(sendData))
+
+	assert.Equal(t, NewUDPClientAddr(newIPAddr(192, 168, 1, 24), 7300), sender.SendCalls()[2].Addr)
+
+	sendData = checkAndGetSendData(t, sendDataList[2], 3)
+	requestID, data, _ := parseDataFrameEntry(sendData)
+	assert.Equal(t, uint64(300), requestID)
+	assert.Equal(t, "OK 10\r\nsome-value\r\n", string(data))
+}
+
+func TestProcessor_RunSingleLoop_LGETW_Timeout(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender)
+
+	var sendDataList [][]byte
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
+		sendDataList = append(sendDataList, cloneBytes(data))
+		return nil
+	}
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET key01\r\n",
+		"LGETW key01 20\r\n",
+	)
+	p.runSingleLoop()
+
+	assert.Equal(t, 1, len(sender.SendCalls()))
+	sendData := checkAndGetSendData(t, sendDataList[0], 1)
+	assert.Equal(t, []string{"GRANTED 1\r\n"}, parseAllResponses(sendData))
+
+	p.runSingleLoop()
+
+	assert.Equal(t, 2, len(sender.SendCalls()))
+	sendData = checkAndGetSendData(t, sendDataList[1], 2)
+	requestID, data, _ := parseDataFrameEntry(sendData)
+	assert.Equal(t, uint64(214), requestID)
+	assert.Equal(t, "REJECTED\r\n", string(data))
+	assert.Equal(t, 1.0, testutil.ToFloat64(p.metrics.leaseWaitTimeout))
+
+	affected := p.namespaces.defaultCache.CancelWait([]byte("key01"), nil)
+	assert.False(t, affected)
+}
+
+func TestProcessor_RunSingleLoop_LGETW_Too_Many_Waiters(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender, WithNamespace("users", 1, 1<<16, lease.WithLeaseEpoch(0), lease.WithMaxWaiters(1)))
+
+	var sendDataList [][]byte
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
+		sendDataList = append(sendDataList, cloneBytes(data))
+		return nil
+	}
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET users:01\r\n",
+		"LGETW users:01 10000\r\n",
+		"LGETW users:01 10000\r\n",
+	)
+	p.runSingleLoop()
+
+	assert.Equal(t, 1, len(sender.SendCalls()))
+	sendData := checkAndGetSendData(t, sendDataList[0], 1)
+	assert.Equal(t, []string{"GRANTED 1\r\n", "ERROR too many waiters\r\n"}, parseAllResponses(sendData))
+}
+
+func TestWaitTimeout(t *testing.T) {
+	assert.Equal(t, 20*time.Millisecond, waitTimeout(20))
+	assert.Equal(t, maxWaitTimeout, waitTimeout(math.MaxUint32))
+}
+
+func TestProcessor_RunSingleLoop_LGETW_Released_By_DEL(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender)
+
+	var sendDataList [][]byte
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
+		sendDataList = append(sendDataList, cloneBytes(data))
+		return nil
+	}
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET key01\r\n",
+		"LGETW key01 10000\r\n",
+		"DEL key01\r\n",
+	)
+	p.runSingleLoop()
+
+	assert.Equal(t, 2, len(sender.SendCalls()))
+	sendData := checkAndGetSendData(t, sendDataList[0], 1)
+	assert.Equal(t, []string{"GRANTED 1\r\n", "OK 0\r\n"}, parseAllResponses(sendData))
+
+	sendData = checkAndGetSendData(t, sendDataList[1], 2)
+	requestID, data, _ := parseDataFrameEntry(sendData)
+	assert.Equal(t, uint64(214), requestID)
+	assert.Equal(t, "GRANTED 2\r\n", string(data))
+}
+
+func TestProcessor_RunSingleLoop_LRELEASE_And_LEXTEND(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender)
+
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET key01\r\n",
+		"LEXTEND key01 1 60\r\n",
+		"LRELEASE key01 2\r\n",
+		"LRELEASE key01 1\r\n",
+		"LEXTEND key01 1 60\r\n",
+		"LGET key01\r\n",
+	)
+	p.runSingleLoop()
+
+	assert.Equal(t, 1, len(sender.SendCalls()))
+	sendData := checkAndGetSendData(t, sender.SendCalls()[0].Data, 1)
+	assert.Equal(t, []string{
+		"GRANTED 1\r\n",
+		"OK 1\r\n",
+		"OK 0\r\n",
+		"OK 1\r\n",
+		"OK 0\r\n",
+		"GRANTED 2\r\n",
+	}, parseAllResponses(sendData))
+}
+
+func TestBuildStatsResponse(t *testing.T) {
+	data := make([]byte, 1000)
+	offset := buildStatsResponse(data, []statEntry{
+		{name: "hits", value: 12},
+		{name: "misses", value: 0},
+	})
+	assert.Equal(t, "STAT hits 12\r\nSTAT misses 0\r\nEND\r\n", string(data[:offset]))
+
+	offset = buildStatsResponse(data, nil)
+	assert.Equal(t, "END\r\n", string(data[:offset]))
+}
+
+func TestStatsResponseMaxSize(t *testing.T) {
+	entries := []statEntry{
+		{name: "namespace_" + strings.Repeat("A", 200) + "_items", value: math.MaxUint64},
+		{name: "hits", value: 0},
+	}
+	data := make([]byte, statsResponseMaxSize(entries))
+	offset := buildStatsResponse(data, entries)
+	assert.Equal(t, len(data)-19, offset)
+}
+
+func TestKeyInfoToStats(t *testing.T) {
+	data := make([]byte, 1000)
+	offset := buildStatsResponse(data, keyInfoToStats(lease.KeyInfo{
+		Stored:       true,
+		ValueSize:    10,
+		LeaseID:      21,
+		LeaseAge:     1500 * time.Millisecond,
+		BucketIndex:  3,
+		BucketLeases: 2,
+		BucketSize:   16,
+	}))
+	assert.Equal(t, "STAT stored 1\r\n"+
+		"STAT value_size 10\r\n"+
+		"STAT stale 0\r\n"+
+		"STAT stale_size 0\r\n"+
+		"STAT lease 21\r\n"+
+		"STAT lease_age_ms 1500\r\n"+
+		"STAT bucket 3\r\n"+
+		"STAT bucket_leases 2\r\n"+
+		"STAT bucket_size 16\r\n"+
+		"END\r\n", string(data[:offset]))
+}
+
+func TestProcessor_RunSingleLoop_DEBUG(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender)
+
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET key01\r\n",
+		"DEBUG key01\r\n",
+	)
+	p.runSingleLoop()
+
+	sendData := checkAndGetSendData(t, sender.SendCalls()[0].Data, 1)
+	responses := parseAllResponses(sendData)
+	assert.Equal(t, 2, len(responses))
+	assert.True(t, strings.HasPrefix(responses[1], "STAT stored 0\r\n"))
+	assert.True(t, strings.Contains(responses[1], "STAT lease 1\r\n"))
+	assert.True(t, strings.Contains(responses[1], "STAT bucket_leases 1\r\n"))
+	assert.True(t, strings.HasSuffix(responses[1], "END\r\n"))
+}
+
+func TestProcessor_RunSingleLoop_STATS(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender)
+
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET key01\r\n",
+		"LGET key01\r\n",
+		"LSET key01 1 10\r\nsome-value\r\n",
+		"LSET key01 1 10\r\nsome-value\r\n",
+		"LGET key01\r\n",
+		"DEL key01\r\n",
+		"STATS\r\n",
+	)
+	p.runSingleLoop()
+
+	sendData := checkAndGetSendData(t, sender.SendCalls()[0].Data, 1)
+	responses := parseAllResponses(sendData)
+	assert.Equal(t, 7, len(responses))
+
+	stats := responses[6]
+	assert.True(t, strings.HasPrefix(stats, "STAT uptime 0\r\n"), stats)
+	assert.True(t, strings.Contains(stats, "STAT items 0\r\n"+
+		"STAT get_hits 1\r\n"+
+		"STAT get_misses 2\r\n"+
+		"STAT leases_granted 1\r\n"+
+		"STAT leases_rejected 1\r\n"+
+		"STAT lset_affected 1\r\n"+
+		"STAT lset_not_affected 1\r\n"+
+		"STAT del_affected 1\r\n"+
+		"STAT del_not_affected 0\r\n"), stats)
+	// the command list being processed is still in the queue
+	assert.True(t, strings.Contains(stats, "STAT processor_0_queue_bytes 224\r\n"), stats)
+	assert.True(t, strings.HasSuffix(stats, "END\r\n"), stats)
+}
+
+func TestProcessor_RunSingleLoop_Namespaces(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender, WithNamespace("users", 1, 1<<16, lease.WithLeaseEpoch(0)))
+
+	var sendDataList [][]byte
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
+		sendDataList = append(sendDataList, cloneBytes(data))
+		return nil
+	}
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET users:01\r\n",
+		"LSET users:01 1 7\r\nvalue01\r\n",
+		"LGET other:01\r\n",
+		"LSET other:01 1 7\r\nvalue02\r\n",
+	)
+	p.runSingleLoop()
+
+	users := p.namespaces.cacheOf([]byte("users:01"))
+	assert.NotEqual(t, p.namespaces.defaultCache, users)
+	_, ok := users.Lookup([]byte("users:01"), nil)
+	assert.Equal(t, true, ok)
+	_, ok = p.namespaces.defaultCache.Lookup([]byte("users:01"), nil)
+	assert.Equal(t, false, ok)
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		220,
+		"FLUSH users\r\n",
+		"FLUSH unknown\r\n",
+		"LGET users:01\r\n",
+		"LGET other:01\r\n",
+		"STATS\r\n",
+	)
+	p.runSingleLoop()
+
+	assert.Equal(t, 2, len(sendDataList))
+	responses := parseAllResponses(checkAndGetSendData(t, sendDataList[1], 2))
+	assert.Equal(t, 5, len(responses))
+	assert.Equal(t, []string{
+		"OK 1\r\n",
+		"OK 0\r\n",
+		"GRANTED 1\r\n",
+		"OK 7\r\nvalue02\r\n",
+	}, responses[:4])
+
+	stats := responses[4]
+	assert.True(t, strings.Contains(stats, "STAT items 1\r\n"), stats)
+	assert.True(t, strings.Contains(stats,
+		"STAT namespace_users_items 0\r\n"+
+			"STAT namespace_users_flushes 1\r\n"), stats)
+}
+
+func TestProcessor_RunSingleLoop_LGETW_Released_By_FLUSH(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender, WithNamespace("users", 1, 1<<16, lease.WithLeaseEpoch(0)))
+
+	var sendDataList [][]byte
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
+		sendDataList = append(sendDataList, cloneBytes(data))
+		return nil
+	}
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET users:01\r\n",
+		"LGETW users:01 10000\r\n",
+		"FLUSH users\r\n",
+	)
+	p.runSingleLoop()
+
+	assert.Equal(t, 2, len(sender.SendCalls()))
+	sendData := checkAndGetSendData(t, sendDataList[0], 1)
+	assert.Equal(t, []string{"GRANTED 1\r\n", "OK 1\r\n"}, parseAllResponses(sendData))
+
+	sendData = checkAndGetSendData(t, sendDataList[1], 2)
+	requestID, data, _ := parseDataFrameEntry(sendData)
+	assert.Equal(t, uint64(214), requestID)
+	assert.Equal(t, "GRANTED 1\r\n", string(data))
+}
+
+func TestProcessor_RunSingleLoop_Snapshot(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender)
+
+	var sendDataList [][]byte
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
+		sendDataList = append(sendDataList, cloneBytes(data))
+		return nil
+	}
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200), 213, "SNAPSHOT\r\n")
+	p.runSingleLoop()
+
+	path := filepath.Join(t.TempDir(), "snapshot")
+	snapshots := newSnapshotter(path, p.namespaces, p.options.logger)
+	p.namespaces.snapshots = snapshots
+	atomic.StoreUint32(&snapshots.running, 1)
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200), 220, "SNAPSHOT\r\n")
+	p.runSingleLoop()
+
+	atomic.StoreUint32(&snapshots.running, 0)
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200), 221, "SNAPSHOT\r\n", "STATS\r\n")
+	p.runSingleLoop()
+
+	assert.Equal(t, 3, len(sendDataList))
+	assert.Equal(t, []string{"ERROR snapshot file not configured\r\n"},
+		parseAllResponses(checkAndGetSendData(t, sendDataList[0], 1)))
+	assert.Equal(t, []string{"OK 0\r\n"}, parseAllResponses(checkAndGetSendData(t, sendDataList[1], 2)))
+
+	responses := parseAllResponses(checkAndGetSendData(t, sendDataList[2], 3))
+	assert.Equal(t, "OK 1\r\n", responses[0])
+	assert.True(t, strings.Contains(responses[1], "STAT snapshots_failed 0\r\n"), responses[1])
+
+	for atomic.LoadUint32(&snapshots.running) == 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, uint64(1), snapshots.completed.load())
+	_, err := os.Stat(path)
+	assert.Equal(t, nil, err)
+}
+
+func TestProcessor_RunSingleLoop_Tags(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender, WithNamespace("users", 1, 1<<16, lease.WithLeaseEpoch(0)))
+
+	var sendDataList [][]byte
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
+		sendDataList = append(sendDataList, cloneBytes(data))
+		return nil
+	}
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET key01\r\n",
+		"LSET key01 1 7 user:1 team:2\r\nvalue01\r\n",
+		"LGET users:02\r\n",
+		"LSET users:02 1 7 user:1\r\nvalue02\r\n",
+		"LGET key03\r\n",
+		"LSET key03 1 7 user:3\r\nvalue03\r\n",
+		"LGET key01\r\n",
+		"DELTAG user:1\r\n",
+		"LGET key01\r\n",
+		"LGET users:02\r\n",
+		"LGET key03\r\n",
+	)
+	p.runSingleLoop()
+
+	assert.Equal(t, 1, len(sendDataList))
+	assert.Equal(t, []string{
+		"GRANTED 1\r\n",
+		"OK 1\r\n",
+		"GRANTED 1\r\n",
+		"OK 1\r\n",
+		"GRANTED 1\r\n",
+		"OK 1\r\n",
+		"OK 7\r\nvalue01\r\n",
+		"OK 1\r\n",
+		"GRANTED 2\r\n",
+		"GRANTED 2\r\n",
+		"OK 7\r\nvalue03\r\n",
+	}, parseAllResponses(checkAndGetSendData(t, sendDataList[0], 1)))
+}
+
+func TestProcessor_RunSingleLoop_Keys_With_Record_Type_Suffix(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	p := newProcessorForTest(sender)
+	putTaggedAndStaleForTest(p.namespaces.defaultCache)
+
+	var sendDataList [][]byte
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
+		sendDataList = append(sendDataList, cloneBytes(data))
+		return nil
+	}
+
+	p.perform(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200),
+		213,
+		"LGET key01\x01\r\n",
+		"DEL key01\x01\r\n",
+		"LGET key01\r\n",
+	)
+
+	var data []byte
+	for i, key := range []string{"key02\x00", "key02\x01"} {
+		cmd := parser.AppendBinaryCommand(nil, parser.BinaryCommand{Opcode: parser.BinaryOpcodeDEL, Key: []byte(key)})
+		data = append(data, make([]byte, entryDataOffset)...)
+		buildDataFrameEntryHeader(data[len(data)-entryDataOffset:], uint64(220+i), len(cmd))
+		data = append(data, cmd...)
+	}
+	p.appendCommands(NewUDPClientAddr(newIPAddr(192, 168, 1, 23), 7200), commandFormat{binary: true}, data)
+
+	p.runSingleLoop()
+	p.runSingleLoop()
+
+	assert.Equal(t, 2, len(sendDataList))
+	responses := parseAllResponses(checkAndGetSendData(t, sendDataList[0], 1))
+	assert.Equal(t, 3, len(responses))
+	assert.True(t, strings.HasPrefix(responses[0], "GRANTED "))
+	assert.Equal(t, []string{"OK 0\r\n", "OK 7\r\nvalue01\r\n"}, responses[1:])
+
+	assert.Equal(t, []string{
+		"OK 0\r\n",
+		"OK 0\r\n",
+	}, parseAllResponses(checkAndGetSendData(t, sendDataList[1], 2)))
+
+	result := p.namespaces.defaultCache.Get([]byte("key02"), make([]byte, 100))
+	assert.Equal(t, lease.GetStatusStaleLeaseGranted, result.Status)
+}
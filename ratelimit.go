@@ -0,0 +1,185 @@
+package kvstore
+
+import (
+	"container/list"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RateLimit is the limit of commands and bytes per second received from a client IP,
+// or from a TCP connection of IPv6, the bursts are equal to the limits per second. Zero means unlimited
+type RateLimit struct {
+	CommandsPerSecond int
+	BytesPerSecond    int
+}
+
+const rateLimiterMaxClients = 1 << 16
+
+// errRateLimited is replied for each command of a rate limited batch
+var errRateLimited = errors.New("rate limited")
+
+type cidrRateLimit struct {
+	network *net.IPNet
+	limit   RateLimit
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket and takes cost tokens, costs bigger than the burst
+// are allowed when the bucket is full, and repaid by the later refills
+func (b *tokenBucket) take(rate int, cost int, now time.Time) bool {
+	if rate == 0 {
+		return true
+	}
+
+	burst := float64(rate)
+	b.tokens += now.Sub(b.last).Seconds() * burst
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	required := float64(cost)
+	if required > burst {
+		required = burst
+	}
+	return b.tokens >= required
+}
+
+// rateLimitKey identifies the buckets of a client, by the IP for clients of IPv4,
+// by the connection id for TCP clients of IPv6
+type rateLimitKey struct {
+	ip IPAddr
+	id uint32
+}
+
+func rateLimitKeyOf(addr ClientAddr) rateLimitKey {
+	if addr.hasIPv4() {
+		return rateLimitKey{ip: addr.IP}
+	}
+	return rateLimitKey{id: addr.ID}
+}
+
+type clientBuckets struct {
+	key      rateLimitKey
+	limit    RateLimit
+	commands tokenBucket
+	bytes    tokenBucket
+}
+
+// rateLimiter limits the commands and bytes per second of clients using token buckets.
+// At most maxClients buckets are kept, the least recently used one is removed to add a new client
+type rateLimiter struct {
+	defaultLimit RateLimit
+	overrides    []cidrRateLimit // sorted by prefix length, the most specific first
+
+	mut        sync.Mutex
+	clients    map[rateLimitKey]*list.Element // elements of lru
+	lru        *list.List                     // of *clientBuckets, the most recently used first
+	maxClients int
+	now        func() time.Time
+}
+
+func newRateLimiter(defaultLimit RateLimit, overrides map[string]RateLimit) (*rateLimiter, error) {
+	l := &rateLimiter{
+		defaultLimit: defaultLimit,
+		clients:      map[rateLimitKey]*list.Element{},
+		lru:          list.New(),
+		maxClients:   rateLimiterMaxClients,
+		now:          time.Now,
+	}
+
+	for cidr, limit := range overrides {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		l.overrides = append(l.overrides, cidrRateLimit{network: network, limit: limit})
+	}
+	sort.Slice(l.overrides, func(i, j int) bool {
+		a, _ := l.overrides[i].network.Mask.Size()
+		b, _ := l.overrides[j].network.Mask.Size()
+		return a > b
+	})
+	return l, nil
+}
+
+func (l *rateLimiter) enabled() bool {
+	return l.defaultLimit != RateLimit{} || len(l.overrides) > 0
+}
+
+// limitOf returns the limit of the client IP, the CIDR overrides only match IPv4 addresses
+func (l *rateLimiter) limitOf(ip IPAddr) RateLimit {
+	netIP := net.IPv4(ip[0], ip[1], ip[2], ip[3])
+	for _, o := range l.overrides {
+		if o.network.Contains(netIP) {
+			return o.limit
+		}
+	}
+	return l.defaultLimit
+}
+
+// allow returns true if the client can send a batch of numCommands commands and size bytes
+func (l *rateLimiter) allow(addr ClientAddr, numCommands int, size int) bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	now := l.now()
+	buckets := l.getBuckets(rateLimitKeyOf(addr), now)
+
+	commandsOK := buckets.commands.take(buckets.limit.CommandsPerSecond, numCommands, now)
+	bytesOK := buckets.bytes.take(buckets.limit.BytesPerSecond, size, now)
+	if !commandsOK || !bytesOK {
+		return false
+	}
+	buckets.commands.tokens -= float64(numCommands)
+	buckets.bytes.tokens -= float64(size)
+	return true
+}
+
+// getBuckets returns the buckets of the client and marks them as the most recently used
+func (l *rateLimiter) getBuckets(key rateLimitKey, now time.Time) *clientBuckets {
+	if e, ok := l.clients[key]; ok {
+		l.lru.MoveToFront(e)
+		return e.Value.(*clientBuckets)
+	}
+
+	if l.lru.Len() >= l.maxClients {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.clients, oldest.Value.(*clientBuckets).key)
+	}
+
+	limit := l.defaultLimit
+	if key.ip != (IPAddr{}) {
+		limit = l.limitOf(key.ip)
+	}
+	buckets := &clientBuckets{
+		key:      key,
+		limit:    limit,
+		commands: tokenBucket{tokens: float64(limit.CommandsPerSecond), last: now},
+		bytes:    tokenBucket{tokens: float64(limit.BytesPerSecond), last: now},
+	}
+	l.clients[key] = l.lru.PushFront(buckets)
+	return buckets
+}
+
+// countEntries returns the number of entries in the entry list of a batch
+func countEntries(data []byte) int {
+	count := 0
+	for len(data) > 0 {
+		_, _, nextOffset := parseDataFrameEntry(data)
+		if nextOffset == 0 {
+			return count
+		}
+		data = data[nextOffset:]
+		count++
+	}
+	return count
+}
@@ -0,0 +1,116 @@
+package kvstore
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newRateLimiterForTest(defaultLimit RateLimit, overrides map[string]RateLimit) (*rateLimiter, *time.Time) {
+	l, err := newRateLimiter(defaultLimit, overrides)
+	if err != nil {
+		panic(err)
+	}
+	now := time.Date(2021, 5, 10, 8, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestRateLimiter_Commands(t *testing.T) {
+	l, now := newRateLimiterForTest(RateLimit{CommandsPerSecond: 10}, nil)
+	ip := newIPAddr(192, 168, 1, 10)
+
+	assert.Equal(t, true, l.allow(NewUDPClientAddr(ip, 7000), 6, 1000))
+	assert.Equal(t, true, l.allow(NewUDPClientAddr(ip, 7000), 4, 1000))
+	assert.Equal(t, false, l.allow(NewUDPClientAddr(ip, 7000), 1, 1000))
+
+	assert.Equal(t, true, l.allow(NewUDPClientAddr(newIPAddr(192, 168, 1, 11), 7000), 10, 1000))
+
+	*now = now.Add(300 * time.Millisecond)
+	assert.Equal(t, true, l.allow(NewUDPClientAddr(ip, 7000), 3, 1000))
+	assert.Equal(t, false, l.allow(NewUDPClientAddr(ip, 7000), 1, 1000))
+}
+
+func TestRateLimiter_Bytes_Bigger_Than_Burst(t *testing.T) {
+	l, now := newRateLimiterForTest(RateLimit{BytesPerSecond: 1000}, nil)
+	ip := newIPAddr(192, 168, 1, 10)
+
+	assert.Equal(t, true, l.allow(NewUDPClientAddr(ip, 7000), 1, 3000))
+
+	*now = now.Add(2 * time.Second)
+	assert.Equal(t, false, l.allow(NewUDPClientAddr(ip, 7000), 1, 10))
+
+	*now = now.Add(2 * time.Second)
+	assert.Equal(t, true, l.allow(NewUDPClientAddr(ip, 7000), 1, 10))
+}
+
+func TestRateLimiter_Max_Clients_Evict_Least_Recently_Used(t *testing.T) {
+	l, _ := newRateLimiterForTest(RateLimit{CommandsPerSecond: 10}, nil)
+	l.maxClients = 2
+	ip1 := newIPAddr(192, 168, 1, 10)
+	ip2 := newIPAddr(192, 168, 1, 11)
+	ip3 := newIPAddr(192, 168, 1, 12)
+
+	assert.Equal(t, true, l.allow(NewUDPClientAddr(ip1, 7000), 10, 1000))
+	assert.Equal(t, true, l.allow(NewUDPClientAddr(ip2, 7000), 10, 1000))
+	assert.Equal(t, false, l.allow(NewUDPClientAddr(ip1, 7000), 1, 1000))
+
+	assert.Equal(t, true, l.allow(NewUDPClientAddr(ip3, 7000), 10, 1000))
+	assert.Equal(t, 2, len(l.clients))
+
+	assert.Equal(t, false, l.allow(NewUDPClientAddr(ip1, 7000), 1, 1000))
+	assert.Equal(t, false, l.allow(NewUDPClientAddr(ip3, 7000), 1, 1000))
+	assert.Equal(t, true, l.allow(NewUDPClientAddr(ip2, 7000), 10, 1000))
+}
+
+func TestRateLimiter_IPv6_Connections(t *testing.T) {
+	l, _ := newRateLimiterForTest(RateLimit{CommandsPerSecond: 10}, map[string]RateLimit{
+		"0.0.0.0/0": {},
+	})
+	conn1 := ClientAddr{Transport: TransportStream, ID: 1}
+	conn2 := ClientAddr{Transport: TransportStream, ID: 2}
+
+	assert.Equal(t, true, l.allow(conn1, 10, 1000))
+	assert.Equal(t, false, l.allow(conn1, 1, 1000))
+	assert.Equal(t, true, l.allow(conn2, 10, 1000))
+
+	ipv4 := ClientAddr{Transport: TransportStream, IP: newIPAddr(192, 168, 1, 10), ID: 1}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, true, l.allow(ipv4, 100, 100000))
+	}
+}
+
+func TestRateLimiter_CIDR_Overrides(t *testing.T) {
+	l, _ := newRateLimiterForTest(RateLimit{CommandsPerSecond: 1}, map[string]RateLimit{
+		"10.0.0.0/8":  {CommandsPerSecond: 5},
+		"10.1.0.0/16": {},
+	})
+	assert.Equal(t, true, l.enabled())
+
+	assert.Equal(t, RateLimit{CommandsPerSecond: 1}, l.limitOf(newIPAddr(192, 168, 1, 10)))
+	assert.Equal(t, RateLimit{CommandsPerSecond: 5}, l.limitOf(newIPAddr(10, 2, 0, 1)))
+	assert.Equal(t, RateLimit{}, l.limitOf(newIPAddr(10, 1, 0, 1)))
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, true, l.allow(NewUDPClientAddr(newIPAddr(10, 1, 0, 1), 7000), 100, 100000))
+	}
+
+	_, err := newRateLimiter(RateLimit{}, map[string]RateLimit{"10.0.0.0": {}})
+	assert.NotEqual(t, nil, err)
+
+	l, _ = newRateLimiterForTest(RateLimit{}, nil)
+	assert.Equal(t, false, l.enabled())
+}
+
+func TestCountEntries(t *testing.T) {
+	data := make([]byte, 100)
+	offset := 0
+	for _, cmd := range []string{"LGET key01\r\n", "DEL key02\r\n"} {
+		buildDataFrameEntryHeader(data[offset:], 1, len(cmd))
+		offset += entryDataOffset
+		offset += copy(data[offset:], cmd)
+	}
+	assert.Equal(t, 2, countEntries(data[:offset]))
+	assert.Equal(t, 1, countEntries(data[:offset-1]))
+	assert.Equal(t, 0, countEntries(nil))
+}
@@ -2,54 +2,205 @@ package kvstore
 
 import (
 	"github.com/QuangTung97/kvstore/bigcmd"
-	"github.com/QuangTung97/kvstore/lease"
+	"go.uber.org/zap"
+	"strconv"
 	"sync"
 )
 
 type receiver struct {
+	// mut protects the store, the sequence and the reject buffers, since recv is called concurrently by TCP connections
+	mut sync.Mutex
+
 	processors []*processor
 	store      bigcmd.Store
+	stats      *serverStats
 	sequence   uint64 // for selecting next processor
 	wg         sync.WaitGroup
+
+	sender       ResponseSender
+	keys         *frameKeys
+	limiter      *rateLimiter
+	logger       *zap.Logger
+	v0Disabled   bool
+	rejectFrame  []byte
+	rejectedData []byte
 }
 
 func initReceiver(
-	r *receiver, cache *lease.Cache,
-	sender ResponseSender, options kvstoreOptions,
+	r *receiver, namespaces *namespaces,
+	sender ResponseSender, keys *frameKeys, policies *clientPolicies, options kvstoreOptions,
 ) {
+	stats := newServerStats()
 	processors := make([]*processor, 0, options.numProcessors)
 	for i := 0; i < options.numProcessors; i++ {
-		processors = append(processors, newProcessor(cache, sender, options))
+		processors = append(processors, newProcessor(namespaces, stats, sender, keys, policies.acl, options))
 	}
+	stats.processors = processors
+	stats.namespaces = namespaces
+	stats.metrics.registerReceiver(stats)
+
 	r.processors = processors
+	r.stats = stats
 	r.sequence = 0
 
+	r.sender = sender
+	r.keys = keys
+	r.limiter = policies.limiter
+	r.logger = options.logger
+	r.v0Disabled = options.v0FramesDisabled
+	r.rejectFrame = make([]byte, options.maxResultPackageSize)
+
 	bigcmd.InitStore(&r.store, options.bigCommandStoreSize, options.maxBatchSize)
 }
 
-func (r *receiver) recv(ip IPAddr, port uint16, data []byte) {
+// recv checks and decrypts the frame without holding the lock, so that the frames
+// of different connections are authenticated and decrypted concurrently
+func (r *receiver) recv(addr ClientAddr, data []byte) {
 	header, nextOffset := parseDataFrameHeader(data)
-	data = data[nextOffset:]
+	if nextOffset == 0 {
+		r.rejectInvalidFrame(addr, header)
+		return
+	}
+	if !r.acceptFrame(data, header) {
+		return
+	}
+	data, ok := r.framePayload(data, header, nextOffset)
+	if !ok {
+		return
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
 
 	if header.fragmented {
-		filled := r.store.Put(header.batchID, header.length, header.offset, data)
+		filled := r.putFragment(header, data)
+		r.stats.setStoreStats(r.store.GetStats())
 		if !filled {
 			return
 		}
 		data = r.store.Get(header.batchID)
 	}
 
-	for {
+	format := commandFormat{
+		version:  header.version,
+		binary:   header.binary,
+		checksum: header.checksum,
+
+		encrypted:       header.encrypted,
+		encryptionKeyID: header.encryptionKeyID,
+
+		rateLimited: !r.allowBatch(addr, data),
+	}
+
+	if !r.appendCommands(addr, format, data) {
+		r.stats.metrics.overloadedFrames.Inc()
+		r.rejectedData = append(r.rejectedData[:0], "server overloaded"...)
+		r.sendRejectFrame(addr, header.batchID)
+	}
+}
+
+// appendCommands appends the commands to the next processor with enough space in its queue,
+// returns false if the queues of all processors are full
+func (r *receiver) appendCommands(addr ClientAddr, format commandFormat, data []byte) bool {
+	for range r.processors {
 		seq := r.sequence
 		r.sequence++
-		index := seq % uint64(len(r.processors))
-		p := r.processors[index]
-		if !p.isCommandAppendable(len(data)) {
-			continue
+		p := r.processors[seq%uint64(len(r.processors))]
+		if p.isCommandAppendable(len(data)) {
+			p.appendCommands(addr, format, data)
+			return true
+		}
+	}
+	return false
+}
+
+// allowBatch checks the rate limit of the client, clients of Unix sockets are not limited.
+// TCP clients of IPv6 are limited per connection by the default limit
+func (r *receiver) allowBatch(addr ClientAddr, data []byte) bool {
+	if !r.limiter.enabled() || addr.Unix {
+		return true
+	}
+	if r.limiter.allow(addr, countEntries(data), len(data)) {
+		return true
+	}
+	r.stats.rateLimitedBatches.add(1)
+	return false
+}
+
+// acceptFrame checks the version, the checksum and the authentication of the frame
+func (r *receiver) acceptFrame(frame []byte, header dataFrameHeader) bool {
+	if header.version == frameVersion0 && r.v0Disabled {
+		r.stats.metrics.invalidFrames.Inc()
+		return false
+	}
+	if !validFrameChecksum(frame, header) {
+		r.stats.frameChecksumFailures.add(1)
+		r.stats.metrics.checksumFailedFrames.Inc()
+		return false
+	}
+	if r.keys.auth.enabled() && !r.keys.auth.verify(frame, header) {
+		r.stats.frameAuthFailures.add(1)
+		r.stats.metrics.authFailedFrames.Inc()
+		return false
+	}
+	return true
+}
+
+// framePayload returns the entry list of the frame, decrypted if the frame is encrypted
+func (r *receiver) framePayload(frame []byte, header dataFrameHeader, payloadOffset int) ([]byte, bool) {
+	if !header.encrypted && !r.keys.encryption.enabled() {
+		return frame[payloadOffset:], true
+	}
+	if header.encrypted {
+		payload, err := r.keys.encryption.decrypt(frame, header, payloadOffset)
+		if err == nil {
+			return payload, true
 		}
-		p.appendCommands(ip, port, data)
+	}
+	r.stats.frameDecryptFailures.add(1)
+	r.stats.metrics.decryptFailedFrames.Inc()
+	return nil, false
+}
+
+func (r *receiver) putFragment(header dataFrameHeader, data []byte) bool {
+	if header.checksum {
+		return r.store.PutChecked(header.batchID, header.length, header.offset, header.batchChecksum, data)
+	}
+	return r.store.Put(header.batchID, header.length, header.offset, data)
+}
+
+// rejectInvalidFrame replies an error frame if the frame has an unknown version,
+// other invalid frames are dropped since they may not come from a client
+func (r *receiver) rejectInvalidFrame(addr ClientAddr, header dataFrameHeader) {
+	if header.version <= frameVersionLatest {
+		r.stats.metrics.invalidFrames.Inc()
 		return
 	}
+	r.stats.metrics.unknownVersionFrames.Inc()
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.rejectedData = append(r.rejectedData[:0], "unsupported frame version "...)
+	r.rejectedData = strconv.AppendUint(r.rejectedData, uint64(header.version), 10)
+	r.sendRejectFrame(addr, header.batchID)
+}
+
+// sendRejectFrame replies a rejected frame with the message in rejectedData, must be called while holding mut
+func (r *receiver) sendRejectFrame(addr ClientAddr, batchID uint64) {
+	rejectHeader := dataFrameHeader{
+		version:  frameVersionLatest,
+		batchID:  batchID,
+		rejected: true,
+	}
+	// rejected frames are not encrypted, building them does not fail
+	_ = buildDataFrames(r.rejectFrame, rejectHeader, r.rejectedData, func(frame []byte) {
+		err := r.sender.Send(addr, frame)
+		if err != nil {
+			r.stats.metrics.sendErrors.Inc()
+			r.logger.Error("Send reject frame error", zap.Error(err))
+		}
+	})
 }
 
 func (r *receiver) runInBackground() {
@@ -1,9 +1,13 @@
 package kvstore
 
 import (
+	"crypto/rand"
+	"errors"
 	"github.com/QuangTung97/kvstore/lease"
+	"github.com/QuangTung97/kvstore/parser"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+	"net"
 	"testing"
 )
 
@@ -18,8 +22,18 @@ func newReceiver(sender ResponseSender, options ...Option) *receiver {
 
 	opts := computeOptions(options...)
 
-	cache := lease.New(4, 1<<16)
-	initReceiver(r, cache, sender, opts)
+	keys, err := newFrameKeys(opts)
+	if err != nil {
+		panic(err)
+	}
+
+	policies, err := newClientPolicies(opts)
+	if err != nil {
+		panic(err)
+	}
+
+	cache := lease.New(4, 1<<16, lease.WithLeaseEpoch(0))
+	initReceiver(r, newNamespaces(cache, opts.namespaces), sender, keys, policies, opts)
 	return r
 }
 
@@ -41,18 +55,17 @@ func TestReceiver_Not_Fragmented(t *testing.T) {
 	offset += len(cmd)
 
 	var sendDataList [][]byte
-	sender.SendFunc = func(ip IPAddr, port uint16, data []byte) error {
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
 		sendDataList = append(sendDataList, cloneBytes(data))
 		return nil
 	}
 
-	r.recv(newIPAddr(192, 168, 10, 12), 7200, data[:offset])
+	r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), data[:offset])
 
 	r.shutdown()
 
 	assert.Equal(t, 1, len(sender.SendCalls()))
-	assert.Equal(t, newIPAddr(192, 168, 10, 12), sender.SendCalls()[0].IP)
-	assert.Equal(t, uint16(7200), sender.SendCalls()[0].Port)
+	assert.Equal(t, NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), sender.SendCalls()[0].Addr)
 
 	sendData := checkAndGetSendData(t, sendDataList[0], 1)
 	requestID, content, nextOffset := parseDataFrameEntry(sendData)
@@ -61,6 +74,335 @@ func TestReceiver_Not_Fragmented(t *testing.T) {
 	assert.Equal(t, len(sendData), nextOffset)
 }
 
+func TestReceiver_Binary_Commands(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	r := newReceiver(sender)
+
+	r.runInBackground()
+
+	var cmdData []byte
+	cmd := parser.AppendBinaryCommand(nil, parser.BinaryCommand{
+		Opcode: parser.BinaryOpcodeLGET,
+		Key:    []byte("key 01"),
+	})
+	cmdData = append(cmdData, make([]byte, entryDataOffset)...)
+	buildDataFrameEntryHeader(cmdData, 50, len(cmd))
+	cmdData = append(cmdData, cmd...)
+
+	var sendDataList [][]byte
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
+		sendDataList = append(sendDataList, cloneBytes(data))
+		return nil
+	}
+
+	header := dataFrameHeader{version: frameVersion1, batchID: 10, binary: true}
+	_ = buildDataFrames(make([]byte, 1000), header, cmdData, func(frame []byte) {
+		r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), frame)
+	})
+
+	r.shutdown()
+
+	assert.Equal(t, 1, len(sender.SendCalls()))
+
+	respHeader, nextOffset := parseDataFrameHeader(sendDataList[0])
+	assert.Equal(t, dataFrameHeader{version: frameVersion1, batchID: 1}, respHeader)
+
+	requestID, content, _ := parseDataFrameEntry(sendDataList[0][nextOffset:])
+	assert.Equal(t, uint64(50), requestID)
+	assert.Equal(t, "GRANTED 1\r\n", string(content))
+}
+
+func TestReceiver_Unknown_Version(t *testing.T) {
+	sender := &ResponseSenderMock{
+		SendFunc: func(addr ClientAddr, data []byte) error { return nil },
+	}
+	r := newReceiver(sender)
+
+	data := make([]byte, 100)
+	offset := buildDataFrameHeader(data, dataFrameHeader{version: 7, batchID: 25})
+
+	r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), data[:offset])
+
+	assert.Equal(t, 1, len(sender.SendCalls()))
+	assert.Equal(t, NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), sender.SendCalls()[0].Addr)
+
+	frame := sender.SendCalls()[0].Data
+	header, nextOffset := parseDataFrameHeader(frame)
+	assert.Equal(t, dataFrameHeader{version: frameVersion1, batchID: 25, rejected: true}, header)
+	assert.Equal(t, "unsupported frame version 7", string(frame[nextOffset:]))
+}
+
+func TestReceiver_Overloaded(t *testing.T) {
+	sender := &ResponseSenderMock{
+		SendFunc: func(addr ClientAddr, data []byte) error { return nil },
+	}
+	r := newReceiver(sender, WithNumProcessors(2), WithBufferSize(1<<10))
+
+	data := make([]byte, 1000)
+	offset := buildDataFrameHeader(data, dataFrameHeader{batchID: 30})
+	cmd := "LGET key01\r\n"
+	buildDataFrameEntryHeader(data[offset:], 50, len(cmd))
+	offset += entryDataOffset
+	copy(data[offset:], cmd)
+	offset += len(cmd)
+
+	for i := 0; i < 100; i++ {
+		r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), data[:offset])
+	}
+
+	calls := sender.SendCalls()
+	assert.True(t, len(calls) > 0 && len(calls) < 100, len(calls))
+
+	header, nextOffset := parseDataFrameHeader(calls[0].Data)
+	assert.Equal(t, dataFrameHeader{version: frameVersion1, batchID: 30, rejected: true}, header)
+	assert.Equal(t, "server overloaded", string(calls[0].Data[nextOffset:]))
+}
+
+func TestReceiver_Checksum(t *testing.T) {
+	sender := &ResponseSenderMock{
+		SendFunc: func(addr ClientAddr, data []byte) error { return nil },
+	}
+	r := newReceiver(sender)
+
+	var cmdData []byte
+	cmd := "LGET key01\r\n"
+	cmdData = append(cmdData, make([]byte, entryDataOffset)...)
+	buildDataFrameEntryHeader(cmdData, 50, len(cmd))
+	cmdData = append(cmdData, cmd...)
+
+	var frames [][]byte
+	header := dataFrameHeader{version: frameVersion1, batchID: 10, checksum: true}
+	_ = buildDataFrames(make([]byte, 1000), header, cmdData, func(frame []byte) {
+		frames = append(frames, cloneBytes(frame))
+	})
+
+	corrupted := cloneBytes(frames[0])
+	corrupted[len(corrupted)-3] ^= 0x01
+	r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), corrupted)
+
+	assert.Equal(t, uint64(1), r.stats.frameChecksumFailures.load())
+	for _, p := range r.processors {
+		assert.Equal(t, false, p.cmdStore.isAvailable())
+	}
+
+	r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), frames[0])
+
+	r.runInBackground()
+	r.shutdown()
+
+	assert.Equal(t, 1, len(sender.SendCalls()))
+
+	frame := sender.SendCalls()[0].Data
+	respHeader, nextOffset := parseDataFrameHeader(frame)
+	assert.Equal(t, true, respHeader.checksum)
+	assert.Equal(t, true, validFrameChecksum(frame, respHeader))
+
+	_, content, _ := parseDataFrameEntry(frame[nextOffset:])
+	assert.Equal(t, "GRANTED 1\r\n", string(content))
+}
+
+func TestReceiver_Auth_Keys(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	r := newReceiver(sender, WithAuthKeys(map[uint32][]byte{5: []byte("secret")}))
+
+	var cmdData []byte
+	cmd := "LGET key01\r\n"
+	cmdData = append(cmdData, make([]byte, entryDataOffset)...)
+	buildDataFrameEntryHeader(cmdData, 50, len(cmd))
+	cmdData = append(cmdData, cmd...)
+
+	addr := NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200)
+	headers := []dataFrameHeader{
+		{batchID: 10},
+		{version: frameVersion1, batchID: 11},
+		{version: frameVersion1, batchID: 12, auth: true, keyID: 5, authKey: []byte("wrong")},
+	}
+	for _, header := range headers {
+		_ = buildDataFrames(make([]byte, 1000), header, cmdData, func(frame []byte) {
+			r.recv(addr, frame)
+		})
+	}
+	assert.Equal(t, uint64(3), r.stats.frameAuthFailures.load())
+	for _, p := range r.processors {
+		assert.Equal(t, false, p.cmdStore.isAvailable())
+	}
+
+	header := dataFrameHeader{version: frameVersion1, batchID: 13, auth: true, keyID: 5, authKey: []byte("secret")}
+	_ = buildDataFrames(make([]byte, 1000), header, cmdData, func(frame []byte) {
+		r.recv(addr, frame)
+	})
+	assert.Equal(t, true, r.processors[0].cmdStore.isAvailable())
+}
+
+func TestReceiver_Encryption(t *testing.T) {
+	sender := &ResponseSenderMock{
+		SendFunc: func(addr ClientAddr, data []byte) error { return nil },
+	}
+	r := newReceiver(sender, WithEncryptionKeys(map[uint32][]byte{3: encryptionKeyForTest}))
+	aead, _ := r.keys.encryption.get(3)
+
+	var cmdData []byte
+	cmd := "LGET key01\r\n"
+	cmdData = append(cmdData, make([]byte, entryDataOffset)...)
+	buildDataFrameEntryHeader(cmdData, 50, len(cmd))
+	cmdData = append(cmdData, cmd...)
+
+	addr := NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200)
+	_ = buildDataFrames(make([]byte, 1000), dataFrameHeader{version: frameVersion1, batchID: 10}, cmdData,
+		func(frame []byte) {
+			r.recv(addr, frame)
+		},
+	)
+	assert.Equal(t, uint64(1), r.stats.frameDecryptFailures.load())
+
+	header := dataFrameHeader{
+		version: frameVersion1, batchID: 11,
+		encrypted: true, encryptionKeyID: 3, aead: aead,
+	}
+	_ = buildDataFrames(make([]byte, 1000), header, cmdData, func(frame []byte) {
+		r.recv(addr, frame)
+	})
+
+	r.runInBackground()
+	r.shutdown()
+
+	assert.Equal(t, 1, len(sender.SendCalls()))
+
+	frame := sender.SendCalls()[0].Data
+	respHeader, offset := parseDataFrameHeader(frame)
+	assert.Equal(t, true, respHeader.encrypted)
+	assert.Equal(t, uint32(3), respHeader.encryptionKeyID)
+
+	payload, err := r.keys.encryption.decrypt(frame, respHeader, offset)
+	assert.Equal(t, nil, err)
+
+	_, content, _ := parseDataFrameEntry(payload)
+	assert.Equal(t, "GRANTED 1\r\n", string(content))
+}
+
+func TestReceiver_Encryption_Nonce_Error(t *testing.T) {
+	sender := &ResponseSenderMock{
+		SendFunc: func(addr ClientAddr, data []byte) error { return nil },
+	}
+	r := newReceiver(sender, WithEncryptionKeys(map[uint32][]byte{3: encryptionKeyForTest}))
+	aead, _ := r.keys.encryption.get(3)
+
+	var cmdData []byte
+	cmd := "LGET key01\r\n"
+	cmdData = append(cmdData, make([]byte, entryDataOffset)...)
+	buildDataFrameEntryHeader(cmdData, 50, len(cmd))
+	cmdData = append(cmdData, cmd...)
+
+	header := dataFrameHeader{
+		version: frameVersion1, batchID: 11,
+		encrypted: true, encryptionKeyID: 3, aead: aead,
+	}
+	addr := NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200)
+	_ = buildDataFrames(make([]byte, 1000), header, cmdData, func(frame []byte) {
+		r.recv(addr, frame)
+	})
+
+	randRead = func([]byte) (int, error) { return 0, errors.New("rand error") }
+	defer func() { randRead = rand.Read }()
+
+	r.runInBackground()
+	r.shutdown()
+
+	assert.Equal(t, 1, len(sender.SendCalls()))
+
+	frame := sender.SendCalls()[0].Data
+	respHeader, offset := parseDataFrameHeader(frame)
+	assert.Equal(t, dataFrameHeader{version: frameVersion1, batchID: 1, rejected: true}, respHeader)
+	assert.Equal(t, errEncryptFailed.Error(), string(frame[offset:]))
+}
+
+func TestReceiver_Rate_Limited(t *testing.T) {
+	sender := &ResponseSenderMock{
+		SendFunc: func(addr ClientAddr, data []byte) error { return nil },
+	}
+	r := newReceiver(sender, WithRateLimit(RateLimit{CommandsPerSecond: 2}))
+
+	data := make([]byte, 1000)
+	offset := buildDataFrameHeader(data, dataFrameHeader{batchID: 10})
+	for i, cmd := range []string{"LGET key01\r\n", "LGET key02\r\n", "LGET key03\r\n"} {
+		buildDataFrameEntryHeader(data[offset:], uint64(50+i), len(cmd))
+		offset += entryDataOffset
+		offset += copy(data[offset:], cmd)
+	}
+
+	r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), data[:offset])
+	r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), data[:offset])
+	r.recv(ClientAddr{Transport: TransportStream, ID: 1, Unix: true}, data[:offset])
+
+	r.runInBackground()
+	r.shutdown()
+
+	assert.Equal(t, uint64(1), r.stats.rateLimitedBatches.load())
+	assert.Equal(t, 3, len(sender.SendCalls()))
+
+	limited := 0
+	for _, call := range sender.SendCalls() {
+		responses := parseAllResponses(checkAndGetSendData(t, call.Data, 1))
+		if responses[0] != "ERROR rate limited\r\n" {
+			continue
+		}
+		limited++
+		assert.Equal(t, TransportUDP, call.Addr.Transport)
+		assert.Equal(t, []string{
+			"ERROR rate limited\r\n",
+			"ERROR rate limited\r\n",
+			"ERROR rate limited\r\n",
+		}, responses)
+	}
+	assert.Equal(t, 1, limited)
+}
+
+func TestReceiver_Rate_Limited_IPv6(t *testing.T) {
+	sender := &ResponseSenderMock{
+		SendFunc: func(addr ClientAddr, data []byte) error { return nil },
+	}
+	r := newReceiver(sender, WithRateLimit(RateLimit{CommandsPerSecond: 2}))
+
+	data := make([]byte, 1000)
+	offset := buildDataFrameHeader(data, dataFrameHeader{batchID: 10})
+	cmd := "LGET key01\r\n"
+	buildDataFrameEntryHeader(data[offset:], 50, len(cmd))
+	offset += entryDataOffset
+	offset += copy(data[offset:], cmd)
+
+	ipv6Addr := newStreamClientAddr(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 7000}, 4)
+	for i := 0; i < 3; i++ {
+		r.recv(ipv6Addr, data[:offset])
+	}
+
+	r.runInBackground()
+	r.shutdown()
+
+	assert.Equal(t, uint64(1), r.stats.rateLimitedBatches.load())
+	assert.Equal(t, 3, len(sender.SendCalls()))
+}
+
+func TestReceiver_Without_V0_Frames(t *testing.T) {
+	sender := &ResponseSenderMock{}
+	r := newReceiver(sender, WithoutV0Frames())
+
+	data := make([]byte, 100)
+	offset := buildDataFrameHeader(data, dataFrameHeader{batchID: 10})
+	cmd := "LGET key01\r\n"
+	buildDataFrameEntryHeader(data[offset:], 50, len(cmd))
+	offset += entryDataOffset
+	copy(data[offset:], cmd)
+	offset += len(cmd)
+
+	r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), data[:offset])
+	r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), []byte("KVST"))
+
+	for _, p := range r.processors {
+		assert.Equal(t, false, p.cmdStore.isAvailable())
+	}
+	assert.Equal(t, 0, len(sender.SendCalls()))
+}
+
 func TestReceiver_Fragmented_Collecting_Not_Yet_Called(t *testing.T) {
 	sender := &ResponseSenderMock{}
 	r := newReceiver(sender)
@@ -81,9 +423,9 @@ func TestReceiver_Fragmented_Collecting_Not_Yet_Called(t *testing.T) {
 	copy(data[offset:], cmd[:8])
 	offset += 8
 
-	sender.SendFunc = func(ip IPAddr, port uint16, data []byte) error { return nil }
+	sender.SendFunc = func(addr ClientAddr, data []byte) error { return nil }
 
-	r.recv(newIPAddr(192, 168, 10, 12), 7200, data[:offset])
+	r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), data[:offset])
 
 	r.shutdown()
 
@@ -95,7 +437,7 @@ func TestReceiver_Fragmented_Collect_Completed(t *testing.T) {
 	r := newReceiver(sender)
 
 	var sendDataList [][]byte
-	sender.SendFunc = func(ip IPAddr, port uint16, data []byte) error {
+	sender.SendFunc = func(addr ClientAddr, data []byte) error {
 		sendDataList = append(sendDataList, cloneBytes(data))
 		return nil
 	}
@@ -117,7 +459,7 @@ func TestReceiver_Fragmented_Collect_Completed(t *testing.T) {
 	copy(data[offset:], cmd[:8])
 	offset += 8
 
-	r.recv(newIPAddr(192, 168, 10, 12), 7200, data[:offset])
+	r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), data[:offset])
 
 	// second message
 	offset = buildDataFrameHeader(data, dataFrameHeader{
@@ -129,18 +471,20 @@ func TestReceiver_Fragmented_Collect_Completed(t *testing.T) {
 	copy(data[offset:], cmd[8:])
 	offset += len(cmd) - 8
 
-	r.recv(newIPAddr(192, 168, 10, 12), 7200, data[:offset])
+	r.recv(NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), data[:offset])
 
 	// Wait completed
 	r.shutdown()
 
 	assert.Equal(t, 1, len(sender.SendCalls()))
-	assert.Equal(t, newIPAddr(192, 168, 10, 12), sender.SendCalls()[0].IP)
-	assert.Equal(t, uint16(7200), sender.SendCalls()[0].Port)
+	assert.Equal(t, NewUDPClientAddr(newIPAddr(192, 168, 10, 12), 7200), sender.SendCalls()[0].Addr)
 
 	sendData := checkAndGetSendData(t, sendDataList[0], 1)
 	requestID, content, nextOffset := parseDataFrameEntry(sendData)
 	assert.Equal(t, uint64(30), requestID)
 	assert.Equal(t, "GRANTED 1\r\n", string(content))
 	assert.Equal(t, len(sendData), nextOffset)
+
+	assert.Equal(t, uint64(2), r.stats.fragments.load())
+	assert.Equal(t, uint64(1), r.stats.completed.load())
 }
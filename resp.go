@@ -0,0 +1,201 @@
+package kvstore
+
+import (
+	"bufio"
+	"github.com/QuangTung97/kvstore/parser"
+	"io"
+	"strconv"
+)
+
+const respReaderSize = 1 << 16 // 64KB
+
+var (
+	respOKResponse       = []byte("+OK\r\n")
+	respPongResponse     = []byte("+PONG\r\n")
+	respNilResponse      = []byte("$-1\r\n")
+	respErrorResponse    = []byte("-ERR ")
+	respProtocolResponse = []byte("-ERR Protocol error: ")
+)
+
+// respConn handles the commands of a RESP2 connection, the keys are routed to the caches of namespaces.
+// GET, MGET, SET and DEL do not use leases, while LGET and LSET behave like the commands of the native protocol,
+// the response of LGET is an array of the status, the lease id as a bulk string, since it can exceed the range of
+// RESP integers, and the value (nil if there is no value).
+// GET, MGET, LGET and PING require the ReadOnly role of the client IP, the other commands require ReadWrite
+type respConn struct {
+	namespaces *namespaces
+	role       AccessRole
+
+	writer *bufio.Writer
+	reader *bufio.Reader
+	parser parser.RESPParser
+
+	value    []byte
+	num      []byte
+	leaseNum []byte
+}
+
+func newRESPConn(namespaces *namespaces, r io.Reader, w io.Writer, role AccessRole) *respConn {
+	c := &respConn{
+		namespaces: namespaces,
+		role:       role,
+
+		writer: bufio.NewWriter(w),
+		reader: bufio.NewReaderSize(r, respReaderSize),
+
+		value: make([]byte, connInitValueSize),
+	}
+	parser.InitRESPParser(&c.parser, c.reader, c)
+	return c
+}
+
+func (c *respConn) serve() error {
+	for {
+		err := c.processNextCommand()
+		if err != nil {
+			_ = c.writer.Flush()
+			return err
+		}
+
+		// flush only when all the pipelined commands are processed
+		if c.reader.Buffered() > 0 {
+			continue
+		}
+		err = c.writer.Flush()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *respConn) processNextCommand() error {
+	args, err := c.parser.ReadCommand()
+	if parser.IsRESPProtocolError(err) {
+		_, _ = c.writer.Write(respProtocolResponse)
+		_, _ = c.writer.WriteString(err.Error())
+		_, _ = c.writer.Write(crlfResponse)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// empty inline commands are ignored
+	if len(args) == 0 {
+		return nil
+	}
+
+	err = c.parser.Process(args)
+	if err == parser.ErrInvalidCommand {
+		_, _ = c.writer.Write(respErrorResponse)
+		_, _ = c.writer.WriteString("unknown command '")
+		_, _ = c.writer.Write(args[0])
+		_, _ = c.writer.WriteString("'\r\n")
+		return nil
+	}
+	if err != nil {
+		c.writeError(err)
+	}
+	return nil
+}
+
+func (c *respConn) writeError(err error) {
+	_, _ = c.writer.Write(respErrorResponse)
+	_, _ = c.writer.WriteString(err.Error())
+	_, _ = c.writer.Write(crlfResponse)
+}
+
+// checkRole replies a permission denied error if the role of the connection is lower than the required role
+func (c *respConn) checkRole(required AccessRole) bool {
+	if c.role >= required {
+		return true
+	}
+	c.writeError(errPermissionDenied)
+	return false
+}
+
+func (c *respConn) writePrefixedNumber(prefix byte, n uint64) {
+	_ = c.writer.WriteByte(prefix)
+	c.num = strconv.AppendUint(c.num[:0], n, 10)
+	_, _ = c.writer.Write(c.num)
+	_, _ = c.writer.Write(crlfResponse)
+}
+
+//revive:disable-next-line:flag-parameter
+func (c *respConn) writeBulk(value []byte, ok bool) {
+	if !ok {
+		_, _ = c.writer.Write(respNilResponse)
+		return
+	}
+	c.writePrefixedNumber('$', uint64(len(value)))
+	_, _ = c.writer.Write(value)
+	_, _ = c.writer.Write(crlfResponse)
+}
+
+func (c *respConn) OnGET(key []byte) {
+	if !c.checkRole(AccessRoleReadOnly) {
+		return
+	}
+	c.writeBulk(lookupValue(c.namespaces.cacheOf(key), key, &c.value))
+}
+
+func (c *respConn) OnMGET(keys [][]byte) {
+	if !c.checkRole(AccessRoleReadOnly) {
+		return
+	}
+	c.writePrefixedNumber('*', uint64(len(keys)))
+	for _, key := range keys {
+		c.writeBulk(lookupValue(c.namespaces.cacheOf(key), key, &c.value))
+	}
+}
+
+func (c *respConn) OnSET(key []byte, value []byte) {
+	if !c.checkRole(AccessRoleReadWrite) {
+		return
+	}
+	c.namespaces.cacheOf(key).Put(key, value)
+	_, _ = c.writer.Write(respOKResponse)
+}
+
+func (c *respConn) OnDEL(keys [][]byte) {
+	if !c.checkRole(AccessRoleReadWrite) {
+		return
+	}
+	count := uint64(0)
+	for _, key := range keys {
+		if c.namespaces.cacheOf(key).Invalidate(key) {
+			count++
+		}
+	}
+	c.writePrefixedNumber(':', count)
+}
+
+func (c *respConn) OnLGET(key []byte) {
+	if !c.checkRole(AccessRoleReadOnly) {
+		return
+	}
+	result, value := getValue(c.namespaces.cacheOf(key), key, &c.value)
+
+	c.writePrefixedNumber('*', 3)
+	_ = c.writer.WriteByte('+')
+	_, _ = c.writer.WriteString(getStatusOutcomes[result.Status])
+	_, _ = c.writer.Write(crlfResponse)
+	c.leaseNum = strconv.AppendUint(c.leaseNum[:0], result.LeaseID, 10)
+	c.writeBulk(c.leaseNum, true)
+	c.writeBulk(value, value != nil)
+}
+
+func (c *respConn) OnLSET(key []byte, leaseID uint64, value []byte) {
+	if !c.checkRole(AccessRoleReadWrite) {
+		return
+	}
+	affected := c.namespaces.cacheOf(key).Set(key, leaseID, value)
+	c.writePrefixedNumber(':', boolToUint64(affected))
+}
+
+func (c *respConn) OnPING() {
+	if !c.checkRole(AccessRoleReadOnly) {
+		return
+	}
+	_, _ = c.writer.Write(respPongResponse)
+}
@@ -0,0 +1,149 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"github.com/QuangTung97/kvstore/lease"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func runRESPForTest(cache *lease.Cache, input string) (string, error) {
+	return runRESPWithRoleForTest(cache, input, AccessRoleReadWrite)
+}
+
+func runRESPWithRoleForTest(cache *lease.Cache, input string, role AccessRole) (string, error) {
+	var output bytes.Buffer
+	err := newRESPConn(newNamespaces(cache, nil), strings.NewReader(input), &output, role).serve()
+	return output.String(), err
+}
+
+func TestRESPConn_Set_Get_Del(t *testing.T) {
+	cache := newConnCacheForTest()
+
+	output, err := runRESPForTest(cache, ""+
+		"*2\r\n$3\r\nGET\r\n$5\r\nkey01\r\n"+
+		"*3\r\n$3\r\nSET\r\n$5\r\nkey01\r\n$10\r\nsome-value\r\n"+
+		"*2\r\n$3\r\nget\r\n$5\r\nkey01\r\n"+
+		"*3\r\n$4\r\nMGET\r\n$5\r\nkey01\r\n$5\r\nkey02\r\n"+
+		"*3\r\n$3\r\nDEL\r\n$5\r\nkey01\r\n$5\r\nkey02\r\n"+
+		"PING\r\n",
+	)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, ""+
+		"$-1\r\n"+
+		"+OK\r\n"+
+		"$10\r\nsome-value\r\n"+
+		"*2\r\n$10\r\nsome-value\r\n$-1\r\n"+
+		":1\r\n"+
+		"+PONG\r\n",
+		output)
+}
+
+func TestRESPConn_LGET_LSET(t *testing.T) {
+	cache := newConnCacheForTest()
+
+	output, err := runRESPForTest(cache, ""+
+		"LGET key01\r\n"+
+		"LGET key01\r\n"+
+		"LSET key01 1 some-value\r\n"+
+		"LSET key01 1 some-value\r\n"+
+		"LGET key01\r\n",
+	)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, ""+
+		"*3\r\n+granted\r\n$1\r\n1\r\n$-1\r\n"+
+		"*3\r\n+rejected\r\n$1\r\n0\r\n$-1\r\n"+
+		":1\r\n"+
+		":0\r\n"+
+		"*3\r\n+found\r\n$1\r\n0\r\n$10\r\nsome-value\r\n",
+		output)
+}
+
+func TestRESPConn_LGET_Stale(t *testing.T) {
+	cache := newConnCacheForTest()
+	cache.Put([]byte("key01"), []byte("old-value"))
+	cache.MarkStale([]byte("key01"))
+
+	output, err := runRESPForTest(cache, "LGET key01\r\n")
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "*3\r\n+stale_granted\r\n$1\r\n1\r\n$9\r\nold-value\r\n", output)
+}
+
+func TestRESPConn_Keys_With_Record_Type_Suffix(t *testing.T) {
+	cache := newConnCacheForTest()
+	putTaggedAndStaleForTest(cache)
+
+	output, err := runRESPForTest(cache, ""+
+		"*3\r\n$4\r\nMGET\r\n$6\r\nkey01\x01\r\n$6\r\nkey02\x00\r\n"+
+		"*2\r\n$3\r\nDEL\r\n$6\r\nkey01\x01\r\n"+
+		"*3\r\n$3\r\nSET\r\n$6\r\nkey02\x00\r\n$6\r\nforged\r\n"+
+		"*2\r\n$3\r\nGET\r\n$5\r\nkey01\r\n",
+	)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, ""+
+		"*2\r\n$-1\r\n$-1\r\n"+
+		":0\r\n"+
+		"+OK\r\n"+
+		"$7\r\nvalue01\r\n",
+		output)
+
+	result := cache.Get([]byte("key02"), make([]byte, 100))
+	assert.Equal(t, lease.GetStatusStaleLeaseGranted, result.Status)
+	assert.Equal(t, 7, result.ValueSize)
+}
+
+func TestRESPConn_Role(t *testing.T) {
+	cache := newConnCacheForTest()
+	cache.Put([]byte("key01"), []byte("value01"))
+
+	output, err := runRESPWithRoleForTest(cache, ""+
+		"SET key01 forged\r\n"+
+		"DEL key01\r\n"+
+		"GET key01\r\n",
+		AccessRoleReadOnly,
+	)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, ""+
+		"-ERR permission denied\r\n"+
+		"-ERR permission denied\r\n"+
+		"$7\r\nvalue01\r\n",
+		output)
+
+	output, err = runRESPWithRoleForTest(cache, "GET key01\r\n", AccessRoleNone)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "-ERR permission denied\r\n", output)
+}
+
+func TestRESPConn_Errors(t *testing.T) {
+	cache := newConnCacheForTest()
+
+	output, err := runRESPForTest(cache, ""+
+		"INCR key01\r\n"+
+		"GET\r\n"+
+		"LSET key01 abc value\r\n"+
+		"\r\n"+
+		"*1\r\n+PING\r\n"+
+		"PING\r\n",
+	)
+	assert.Equal(t, errors.New("expected '$'"), err)
+	assert.Equal(t, ""+
+		"-ERR unknown command 'INCR'\r\n"+
+		"-ERR wrong number of arguments\r\n"+
+		"-ERR lease is not number\r\n"+
+		"-ERR Protocol error: expected '$'\r\n",
+		output)
+}
+
+func TestRESPConn_Namespaces(t *testing.T) {
+	n := newNamespacesForSnapshotTest()
+	n.byName["users"].getCache().Put([]byte("users:01"), []byte("value01"))
+
+	var output bytes.Buffer
+	input := "*2\r\n$3\r\nGET\r\n$8\r\nusers:01\r\n*2\r\n$3\r\nDEL\r\n$8\r\nusers:01\r\n"
+	err := newRESPConn(n, strings.NewReader(input), &output, AccessRoleReadWrite).serve()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "$7\r\nvalue01\r\n:1\r\n", output.String())
+}
@@ -0,0 +1,101 @@
+package kvstore
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// HashRing describes the consistent hash ring of a cluster. Each node is placed at VirtualNodes points,
+// the hash of a point is the hash of the node name, a dash and the index of the point, e.g. node-1-0.
+// A key is owned by the node of the first point whose hash is not less than the hash of the key.
+// The hash is the 64 bit FNV-1a followed by the 64 bit finalizer of MurmurHash3.
+// A ring has at most MaxHashRingNodes nodes and MaxHashRingVirtualNodes points per node
+type HashRing struct {
+	Nodes        []string
+	VirtualNodes int
+}
+
+// Limits of the size of a hash ring, so that a warm-up request can not make a peer build a huge ring
+const (
+	MaxHashRingNodes        = 1024
+	MaxHashRingVirtualNodes = 1024
+)
+
+// errInvalidHashRing is returned when the hash ring has no points or exceeds the limits
+var errInvalidHashRing = errors.New("invalid hash ring")
+
+func (desc HashRing) validate() error {
+	if len(desc.Nodes) == 0 || len(desc.Nodes) > MaxHashRingNodes {
+		return errInvalidHashRing
+	}
+	if desc.VirtualNodes <= 0 || desc.VirtualNodes > MaxHashRingVirtualNodes {
+		return errInvalidHashRing
+	}
+	return nil
+}
+
+type ringPoint struct {
+	hash uint64
+	node string
+}
+
+// hashRing finds the owners of keys, the points are sorted by hash
+type hashRing struct {
+	points []ringPoint
+}
+
+func hashRingKey(data []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+
+	// FNV-1a alone does not spread the points of similar names, e.g. node-1-0 and node-1-1
+	k := h.Sum64()
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+// newHashRing returns a ring without points if desc is not valid
+func newHashRing(desc HashRing) *hashRing {
+	if desc.validate() != nil {
+		return &hashRing{}
+	}
+	r := &hashRing{
+		points: make([]ringPoint, 0, len(desc.Nodes)*desc.VirtualNodes),
+	}
+	for _, node := range desc.Nodes {
+		for i := 0; i < desc.VirtualNodes; i++ {
+			r.points = append(r.points, ringPoint{
+				hash: hashRingKey([]byte(node + "-" + strconv.Itoa(i))),
+				node: node,
+			})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		return r.points[i].node < r.points[j].node
+	})
+	return r
+}
+
+// owner returns the node owning the key, empty if the ring has no points
+func (r *hashRing) owner(key []byte) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashRingKey(key)
+	index := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if index == len(r.points) {
+		index = 0
+	}
+	return r.points[index].node
+}
@@ -0,0 +1,58 @@
+package kvstore
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+func TestHashRing_Owner(t *testing.T) {
+	ring := newHashRing(HashRing{Nodes: []string{"node-a", "node-b", "node-c"}, VirtualNodes: 64})
+	assert.Equal(t, 3*64, len(ring.points))
+
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		key := []byte("key" + strconv.Itoa(i))
+		owner := ring.owner(key)
+		assert.Equal(t, owner, ring.owner(key))
+		counts[owner]++
+	}
+	assert.Equal(t, 3, len(counts))
+	for node, count := range counts {
+		assert.True(t, count > 500, node, count)
+	}
+
+	same := newHashRing(HashRing{Nodes: []string{"node-c", "node-a", "node-b"}, VirtualNodes: 64})
+	for i := 0; i < 100; i++ {
+		key := []byte("key" + strconv.Itoa(i))
+		assert.Equal(t, ring.owner(key), same.owner(key))
+	}
+}
+
+func TestHashRing_Owner_Adding_Node(t *testing.T) {
+	before := newHashRing(HashRing{Nodes: []string{"node-a", "node-b"}, VirtualNodes: 64})
+	after := newHashRing(HashRing{Nodes: []string{"node-a", "node-b", "node-c"}, VirtualNodes: 64})
+
+	for i := 0; i < 1000; i++ {
+		key := []byte("key" + strconv.Itoa(i))
+		owner := after.owner(key)
+		if owner != "node-c" {
+			assert.Equal(t, before.owner(key), owner)
+		}
+	}
+}
+
+func TestHashRing_Owner_Empty(t *testing.T) {
+	ring := newHashRing(HashRing{VirtualNodes: 64})
+	assert.Equal(t, "", ring.owner([]byte("key01")))
+}
+
+func TestHashRing_Invalid(t *testing.T) {
+	ring := newHashRing(HashRing{Nodes: []string{"node-a"}, VirtualNodes: MaxHashRingVirtualNodes + 1})
+	assert.Equal(t, 0, len(ring.points))
+	assert.Equal(t, "", ring.owner([]byte("key01")))
+
+	assert.Equal(t, errInvalidHashRing, HashRing{Nodes: []string{"node-a"}}.validate())
+	assert.Equal(t, errInvalidHashRing, HashRing{VirtualNodes: 1}.validate())
+	assert.Equal(t, nil, HashRing{Nodes: []string{"node-a"}, VirtualNodes: MaxHashRingVirtualNodes}.validate())
+}
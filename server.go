@@ -1,26 +1,146 @@
 package kvstore
 
 import (
-	"fmt"
+	"errors"
+	"github.com/QuangTung97/kvstore/lease"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
 	"net"
+	"net/http"
+	"os"
+	"sync"
 )
 
 // Server ...
 type Server struct {
-	packageData []byte
-	conn        *net.UDPConn
+	options    kvstoreOptions
+	cache      *lease.Cache
+	namespaces *namespaces
+	keys       *frameKeys
+	policies   *clientPolicies
+	initErr    error // the error of the options, returned by Run
+
+	packageData   []byte
+	recv          receiver
+	streamConns   *streamConnRegistry
+	unixgramPeers *unixgramPeers
+
+	mut           sync.Mutex
+	conn          *net.UDPConn
+	unixgramConn  *net.UnixConn
+	metricsServer *http.Server
+	listeners     []*connListener
+	snapshotStop  chan struct{}
+}
+
+var errUnknownClientAddr = errors.New("unknown client address")
+
+// errUnauthenticatedListener is returned when frame authentication or encryption is enabled together with
+// a listener whose protocol can not be authenticated or encrypted, i.e. memcached, RESP and warm-up
+var errUnauthenticatedListener = errors.New("memcached, RESP and warm-up listeners do not support auth and encryption")
+
+type udpSender struct {
+	conn *net.UDPConn
+}
+
+func (s *udpSender) Send(addr ClientAddr, data []byte) error {
+	ip := addr.IP
+	_, err := s.conn.WriteToUDP(data, &net.UDPAddr{
+		IP:   net.IPv4(ip[0], ip[1], ip[2], ip[3]),
+		Port: int(addr.Port),
+	})
+	return err
+}
+
+// transportSender sends the responses through the transport of the client address
+type transportSender struct {
+	udp      ResponseSender
+	stream   *streamConnRegistry
+	unixgram ResponseSender
+}
+
+func (s *transportSender) Send(addr ClientAddr, data []byte) error {
+	switch addr.Transport {
+	case TransportUDP:
+		return s.udp.Send(addr, data)
+
+	case TransportStream:
+		conn, ok := s.stream.get(addr.ID)
+		if !ok {
+			return errUnknownClientAddr
+		}
+		return conn.writeFrame(data)
+
+	case TransportUnixgram:
+		if s.unixgram == nil {
+			return errUnknownClientAddr
+		}
+		return s.unixgram.Send(addr, data)
+
+	default:
+		return errUnknownClientAddr
+	}
 }
 
 // NewServer ...
-func NewServer() *Server {
+func NewServer(options ...Option) *Server {
+	opts := computeOptions(options...)
+	keys, err := newFrameKeys(opts)
+	policies, policiesErr := newClientPolicies(opts)
+	if err == nil {
+		err = policiesErr
+	}
+	if err == nil {
+		err = validateListeners(opts)
+	}
+	if opts.snapshotFile != "" || opts.warmUpAddress != "" {
+		opts = withKeyTracking(opts)
+	}
+	cache := lease.New(opts.cacheNumSegments, opts.cacheSegmentSize, opts.leaseOptions...)
+	ns := newNamespaces(cache, opts.namespaces)
+	if opts.snapshotFile != "" {
+		ns.snapshots = newSnapshotter(opts.snapshotFile, ns, opts.logger)
+	}
 	return &Server{
-		packageData: make([]byte, 10000),
+		options:       opts,
+		cache:         cache,
+		namespaces:    ns,
+		keys:          keys,
+		policies:      policies,
+		initErr:       err,
+		packageData:   make([]byte, 1<<16),
+		streamConns:   newStreamConnRegistry(),
+		unixgramPeers: newUnixgramPeers(),
 	}
 }
 
+// SetAuthKeys replaces the active keys for authenticating frames, keeping both the old
+// and the new keys active until all clients are updated allows rotating keys without downtime.
+// Authentication is disabled if keys is empty
+func (s *Server) SetAuthKeys(keys map[uint32][]byte) {
+	s.keys.auth.setKeys(keys)
+}
+
+// SetEncryptionKeys replaces the active keys for encrypting frames, similar to SetAuthKeys,
+// the responses are encrypted with the key of the requests
+func (s *Server) SetEncryptionKeys(keys map[uint32][]byte) error {
+	return s.keys.encryption.setKeys(keys)
+}
+
+// GetCache returns the lease cache of the server
+func (s *Server) GetCache() *lease.Cache {
+	return s.cache
+}
+
 // Run ...
 func (s *Server) Run() error {
-	addr, err := net.ResolveUDPAddr("udp", ":7000")
+	if s.initErr != nil {
+		return s.initErr
+	}
+	s.restoreSnapshot()
+	s.warmUp()
+
+	addr, err := net.ResolveUDPAddr("udp", s.options.address)
 	if err != nil {
 		return err
 	}
@@ -29,22 +149,273 @@ func (s *Server) Run() error {
 	if err != nil {
 		return err
 	}
+	s.mut.Lock()
 	s.conn = conn
+	s.mut.Unlock()
 
+	sender := &transportSender{
+		udp:    &udpSender{conn: conn},
+		stream: s.streamConns,
+	}
+	unixgramConn, err := s.listenUnixgram()
+	if err != nil {
+		_ = s.Shutdown()
+		return err
+	}
+	if unixgramConn != nil {
+		sender.unixgram = &unixgramSender{conn: unixgramConn, peers: s.unixgramPeers}
+	}
+
+	initReceiver(&s.recv, s.namespaces, sender, s.keys, s.policies, s.options)
+	s.recv.runInBackground()
+	defer s.recv.shutdown()
+
+	s.runMetricsServer()
+	s.runSnapshots()
+
+	err = s.runListeners()
+	if err != nil {
+		_ = s.Shutdown()
+		return err
+	}
+
+	if unixgramConn != nil {
+		go func() {
+			_ = runUnixgramLoop(unixgramConn, s.unixgramPeers, &s.recv)
+		}()
+	}
+
+	return s.runUDPLoop(conn)
+}
+
+func (s *Server) runUDPLoop(conn *net.UDPConn) error {
 	for {
 		size, addr, err := conn.ReadFromUDP(s.packageData)
 		if err != nil {
 			return err
 		}
-		fmt.Println("ReadFrom", size, addr, string(s.packageData[:size]))
-		fmt.Println("IP ADDR SIZE:", len(addr.IP.To4()))
 
-		size, err = conn.WriteToUDP([]byte("Response Data"), addr)
-		fmt.Println("WriteTo", size, err)
+		ip := addr.IP.To4()
+		if ip == nil {
+			continue
+		}
+
+		var ipAddr IPAddr
+		copy(ipAddr[:], ip)
+		s.recv.recv(NewUDPClientAddr(ipAddr, uint16(addr.Port)), s.packageData[:size])
 	}
 }
 
+// restoreSnapshot loads the snapshot file if configured, errors are only logged
+func (s *Server) restoreSnapshot() {
+	if !s.options.restoreSnapshot || s.options.snapshotFile == "" {
+		return
+	}
+
+	path := s.options.snapshotFile
+	items, err := restoreSnapshotFile(path, s.namespaces)
+	if os.IsNotExist(err) {
+		s.options.logger.Info("Snapshot file not found", zap.String("path", path))
+		return
+	}
+	if err != nil {
+		s.options.logger.Error("Restore snapshot", zap.String("path", path), zap.Error(err))
+		return
+	}
+	s.options.logger.Info("Snapshot restored", zap.String("path", path), zap.Uint64("items", items))
+}
+
+// warmUp streams the values owned by the node from the peer if configured, errors are only logged
+func (s *Server) warmUp() {
+	if s.options.warmUpPeer == "" {
+		return
+	}
+
+	peer := s.options.warmUpPeer
+	items, err := warmUpFrom(peer, warmUpRequest{node: s.options.warmUpNode, ring: s.options.warmUpRing}, s.namespaces)
+	if err != nil {
+		s.options.logger.Error("Warm up", zap.String("peer", peer), zap.Error(err))
+		return
+	}
+	s.options.logger.Info("Warmed up", zap.String("peer", peer), zap.Uint64("items", items))
+}
+
+func (s *Server) runSnapshots() {
+	if s.namespaces.snapshots == nil || s.options.snapshotInterval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	s.mut.Lock()
+	s.snapshotStop = stop
+	s.mut.Unlock()
+
+	go s.namespaces.snapshots.runPeriodically(s.options.snapshotInterval, stop)
+}
+
+func (s *Server) runMetricsServer() {
+	if s.options.metricsAddress == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(s.recv.stats.metrics.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:    s.options.metricsAddress,
+		Handler: mux,
+	}
+
+	s.mut.Lock()
+	s.metricsServer = server
+	s.mut.Unlock()
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			s.options.logger.Error("Metrics server error", zap.Error(err))
+		}
+	}()
+}
+
+func (s *Server) listenUnixgram() (*net.UnixConn, error) {
+	if s.options.unixgramAddress == "" {
+		return nil, nil
+	}
+
+	err := removeStaleSocket(s.options.unixgramAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: s.options.unixgramAddress, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mut.Lock()
+	s.unixgramConn = conn
+	s.mut.Unlock()
+	return conn, nil
+}
+
+// validateListeners returns an error if the options of the listeners and the warm-up are incompatible
+func validateListeners(opts kvstoreOptions) error {
+	if opts.warmUpPeer != "" {
+		if err := opts.warmUpRing.validate(); err != nil {
+			return err
+		}
+	}
+	if (len(opts.authKeys) > 0 || len(opts.encryptionKeys) > 0) && hasUnauthenticatedListener(opts) {
+		return errUnauthenticatedListener
+	}
+	return nil
+}
+
+func hasUnauthenticatedListener(opts kvstoreOptions) bool {
+	return opts.memcachedAddress != "" || opts.respAddress != "" || opts.warmUpAddress != ""
+}
+
+// serveUnauthenticated serves the connection of a memcached, RESP or warm-up listener,
+// the connection is closed if frame authentication or encryption has been enabled by SetAuthKeys
+// or SetEncryptionKeys. The role of the client IP is checked by the commands
+func (s *Server) serveUnauthenticated(serveConn func(conn net.Conn, role AccessRole) error) func(conn net.Conn) error {
+	return func(conn net.Conn) error {
+		if s.keys.auth.enabled() || s.keys.encryption.enabled() {
+			return errUnauthenticatedListener
+		}
+		return serveConn(conn, s.policies.acl.roleOf(newStreamClientAddr(conn.RemoteAddr(), 0)))
+	}
+}
+
+func (s *Server) runListeners() error {
+	handler := &streamHandler{recv: &s.recv, conns: s.streamConns}
+	warmUp := &warmUpHandler{
+		namespaces:     s.namespaces,
+		bytesPerSecond: s.options.warmUpBytesPerSecond,
+		logger:         s.options.logger,
+	}
+	listeners := []struct {
+		network   string
+		addr      string
+		serveConn func(conn net.Conn) error
+	}{
+		{network: "tcp", addr: s.options.tcpAddress, serveConn: handler.serve},
+		{network: "unix", addr: s.options.unixAddress, serveConn: handler.serve},
+		{network: "tcp", addr: s.options.memcachedAddress, serveConn: s.serveUnauthenticated(
+			func(conn net.Conn, role AccessRole) error {
+				return newMemcachedConn(s.namespaces, s.recv.stats, conn, conn, s.options.memcachedLeases, role).serve()
+			},
+		)},
+		{network: "tcp", addr: s.options.respAddress, serveConn: s.serveUnauthenticated(
+			func(conn net.Conn, role AccessRole) error {
+				return newRESPConn(s.namespaces, conn, conn, role).serve()
+			},
+		)},
+		{network: "tcp", addr: s.options.warmUpAddress, serveConn: s.serveUnauthenticated(warmUp.serve)},
+	}
+
+	for _, l := range listeners {
+		if l.addr == "" {
+			continue
+		}
+		err := s.listen(l.network, l.addr, l.serveConn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) listen(network string, addr string, serveConn func(conn net.Conn) error) error {
+	if network == "unix" {
+		err := removeStaleSocket(addr)
+		if err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	l := newConnListener(listener, s.options.logger, serveConn)
+
+	s.mut.Lock()
+	s.listeners = append(s.listeners, l)
+	s.mut.Unlock()
+
+	go l.run()
+	return nil
+}
+
 // Shutdown ...
 func (s *Server) Shutdown() error {
-	return s.conn.Close()
+	s.mut.Lock()
+	conn := s.conn
+	metricsServer := s.metricsServer
+	unixgramConn := s.unixgramConn
+	listeners := s.listeners
+	s.listeners = nil
+	snapshotStop := s.snapshotStop
+	s.snapshotStop = nil
+	s.mut.Unlock()
+
+	if snapshotStop != nil {
+		close(snapshotStop)
+	}
+
+	if metricsServer != nil {
+		_ = metricsServer.Close()
+	}
+	for _, l := range listeners {
+		l.shutdown()
+	}
+	if unixgramConn != nil {
+		_ = unixgramConn.Close()
+		_ = os.Remove(s.options.unixgramAddress)
+	}
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
 }
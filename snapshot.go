@@ -0,0 +1,365 @@
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/QuangTung97/kvstore/lease"
+	"go.uber.org/zap"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// The snapshot file format, numbers are little endian:
+//
+//	header:  magic "KVSN", version (1 byte), reserved (3 bytes)
+//	records: key length (2 bytes, not zero), value length (4 bytes), key, value
+//	end:     zero key length (2 bytes), CRC32C of all the bytes before it (4 bytes)
+//
+// Values of the cache do not expire, so no expiration time is stored in version 1.
+// Stale and tagged values are not written, since the generations of the tags are not stored,
+// a restored tagged value could not be invalidated by the tags deleted before the restart.
+// Keys longer than the key length field are skipped
+const (
+	snapshotMagic   = "KVSN"
+	snapshotVersion = 1
+
+	snapshotHeaderSize      = 8
+	snapshotKeyLengthSize   = 2
+	snapshotValueLengthSize = 4
+	snapshotChecksumSize    = 4
+
+	snapshotMaxKeySize = math.MaxUint16
+)
+
+// errSnapshotNotConfigured is replied to SNAPSHOT when the server has no snapshot file
+var errSnapshotNotConfigured = errors.New("snapshot file not configured")
+
+// errInvalidSnapshot is returned when restoring a truncated or corrupted snapshot file
+var errInvalidSnapshot = errors.New("invalid snapshot file")
+
+// errUnsupportedSnapshotVersion is returned when restoring a snapshot file of an unknown version
+var errUnsupportedSnapshotVersion = errors.New("unsupported snapshot version")
+
+// snapshotter writes the values of all the namespaces to the snapshot file in background,
+// at most one snapshot is written at a time
+type snapshotter struct {
+	path       string
+	namespaces *namespaces
+	logger     *zap.Logger
+
+	running uint32
+
+	completed     atomicUint64
+	failed        atomicUint64
+	lastItems     atomicUint64
+	lastDuration  atomicUint64 // in millisecond
+	lastCompleted atomicUint64 // unix timestamp in second
+}
+
+func newSnapshotter(path string, namespaces *namespaces, logger *zap.Logger) *snapshotter {
+	return &snapshotter{
+		path:       path,
+		namespaces: namespaces,
+		logger:     logger,
+	}
+}
+
+// start writes a snapshot in background, returns false if a snapshot is being written
+func (s *snapshotter) start() bool {
+	if !atomic.CompareAndSwapUint32(&s.running, 0, 1) {
+		return false
+	}
+	go func() {
+		defer atomic.StoreUint32(&s.running, 0)
+		_ = s.write()
+	}()
+	return true
+}
+
+// runPeriodically starts a snapshot at every interval until stopped
+func (s *snapshotter) runPeriodically(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.start()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// write writes the snapshot to a temporary file then renames it to the snapshot file,
+// so that the previous snapshot is kept if the server stops while writing
+func (s *snapshotter) write() error {
+	begin := time.Now()
+	items, err := writeSnapshotFile(s.path, s.namespaces)
+	if err != nil {
+		s.failed.add(1)
+		s.logger.Error("Write snapshot", zap.String("path", s.path), zap.Error(err))
+		return err
+	}
+
+	duration := time.Since(begin)
+	s.completed.add(1)
+	s.lastItems.store(items)
+	s.lastDuration.store(uint64(duration / time.Millisecond))
+	s.lastCompleted.store(uint64(time.Now().Unix()))
+	s.logger.Info("Snapshot written", zap.String("path", s.path),
+		zap.Uint64("items", items), zap.Duration("duration", duration))
+	return nil
+}
+
+// collectStats returns the STATS entries of the snapshots
+func (s *snapshotter) collectStats() []statEntry {
+	return []statEntry{
+		{name: "snapshots_completed", value: s.completed.load()},
+		{name: "snapshots_failed", value: s.failed.load()},
+		{name: "snapshot_last_items", value: s.lastItems.load()},
+		{name: "snapshot_last_duration_ms", value: s.lastDuration.load()},
+		{name: "snapshot_last_completed", value: s.lastCompleted.load()},
+	}
+}
+
+func writeSnapshotFile(path string, n *namespaces) (uint64, error) {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+
+	items, err := writeSnapshot(file, n, nil)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return 0, err
+	}
+	return items, os.Rename(tmpPath, path)
+}
+
+// snapshotWriter writes the records of a snapshot and computes the checksum of the written bytes
+type snapshotWriter struct {
+	w        *bufio.Writer
+	checksum hash.Hash32
+	err      error
+}
+
+func (w *snapshotWriter) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(data)
+	_, _ = w.checksum.Write(data)
+}
+
+// writeRecord returns false without writing if the key is empty or longer than snapshotMaxKeySize
+func (w *snapshotWriter) writeRecord(key []byte, value []byte) bool {
+	if len(key) == 0 || len(key) > snapshotMaxKeySize {
+		return false
+	}
+	var buf [snapshotKeyLengthSize + snapshotValueLengthSize]byte
+	binary.LittleEndian.PutUint16(buf[:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(buf[snapshotKeyLengthSize:], uint32(len(value)))
+	w.write(buf[:])
+	w.write(key)
+	w.write(value)
+	return true
+}
+
+// writeSnapshot writes the values of the namespaces whose keys are selected by filter, all values if filter is nil
+func writeSnapshot(dest io.Writer, n *namespaces, filter func(key []byte) bool) (uint64, error) {
+	w := &snapshotWriter{
+		w:        bufio.NewWriter(dest),
+		checksum: crc32.New(castagnoliTable),
+	}
+
+	var header [snapshotHeaderSize]byte
+	copy(header[:], snapshotMagic)
+	header[len(snapshotMagic)] = snapshotVersion
+	w.write(header[:])
+
+	items := uint64(0)
+	n.rangeCaches(func(cache *lease.Cache) {
+		cache.Range(func(kv lease.KeyValue) bool {
+			if filter != nil && !filter(kv.Key) {
+				return true
+			}
+			if w.writeRecord(kv.Key, kv.Value) {
+				items++
+			}
+			return w.err == nil
+		})
+	})
+
+	var end [snapshotKeyLengthSize + snapshotChecksumSize]byte
+	w.write(end[:snapshotKeyLengthSize])
+	binary.LittleEndian.PutUint32(end[snapshotKeyLengthSize:], w.checksum.Sum32())
+	w.write(end[snapshotKeyLengthSize:])
+	if w.err != nil {
+		return 0, w.err
+	}
+	return items, w.w.Flush()
+}
+
+// snapshotReader reads the records of a snapshot and computes the checksum of the read bytes
+type snapshotReader struct {
+	r        *bufio.Reader
+	checksum hash.Hash32
+}
+
+func newSnapshotReader(src io.Reader) *snapshotReader {
+	return &snapshotReader{
+		r:        bufio.NewReader(src),
+		checksum: crc32.New(castagnoliTable),
+	}
+}
+
+func (r *snapshotReader) read(data []byte) error {
+	_, err := io.ReadFull(r.r, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errInvalidSnapshot
+	}
+	if err != nil {
+		return err
+	}
+	_, _ = r.checksum.Write(data)
+	return nil
+}
+
+func (r *snapshotReader) readHeader() error {
+	var header [snapshotHeaderSize]byte
+	if err := r.read(header[:]); err != nil {
+		return err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return errInvalidSnapshot
+	}
+	if header[len(snapshotMagic)] != snapshotVersion {
+		return errUnsupportedSnapshotVersion
+	}
+	return nil
+}
+
+// readRecord returns false at the end of the records, after checking the checksum
+func (r *snapshotReader) readRecord() (key []byte, value []byte, ok bool, err error) {
+	var lengths [snapshotKeyLengthSize + snapshotValueLengthSize]byte
+	if err := r.read(lengths[:snapshotKeyLengthSize]); err != nil {
+		return nil, nil, false, err
+	}
+
+	keyLen := binary.LittleEndian.Uint16(lengths[:])
+	if keyLen == 0 {
+		return nil, nil, false, r.readChecksum()
+	}
+
+	if err := r.read(lengths[snapshotKeyLengthSize:]); err != nil {
+		return nil, nil, false, err
+	}
+	valueLen := binary.LittleEndian.Uint32(lengths[snapshotKeyLengthSize:])
+
+	data, err := r.readData(int64(keyLen) + int64(valueLen))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return data[:keyLen], data[keyLen:], true, nil
+}
+
+// readData reads size bytes, the buffer grows with the read data instead of being allocated
+// by the size, so that a corrupted length does not allocate a huge buffer before the checksum is checked
+func (r *snapshotReader) readData(size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, r.r, size)
+	if err == io.EOF {
+		return nil, errInvalidSnapshot
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, _ = r.checksum.Write(buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+func (r *snapshotReader) readChecksum() error {
+	expected := r.checksum.Sum32()
+
+	var buf [snapshotChecksumSize]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return errInvalidSnapshot
+	}
+	if binary.LittleEndian.Uint32(buf[:]) != expected {
+		return errInvalidSnapshot
+	}
+	return nil
+}
+
+// readSnapshot calls fn with the records of the snapshot, returns the number of records
+func readSnapshot(src io.Reader, fn func(key []byte, value []byte)) (uint64, error) {
+	r := newSnapshotReader(src)
+	if err := r.readHeader(); err != nil {
+		return 0, err
+	}
+
+	items := uint64(0)
+	for {
+		key, value, ok, err := r.readRecord()
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return items, nil
+		}
+		fn(key, value)
+		items++
+	}
+}
+
+// restoreSnapshotFile puts the values of the snapshot file into the caches of the namespaces.
+// The whole file is checked before putting any value, so a corrupted file does not restore partially
+func restoreSnapshotFile(path string, n *namespaces) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = readSnapshot(file, func(key []byte, value []byte) {})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return readSnapshot(file, func(key []byte, value []byte) {
+		n.cacheOf(key).Put(key, value)
+	})
+}
+
+// withKeyTracking enables the key tracking of the caches, needed for iterating their values
+// when writing snapshots or serving warm-up requests
+func withKeyTracking(opts kvstoreOptions) kvstoreOptions {
+	opts.leaseOptions = append(append([]lease.Option(nil), opts.leaseOptions...), lease.WithKeyTracking())
+
+	namespaces := make([]namespaceOptions, 0, len(opts.namespaces))
+	for _, o := range opts.namespaces {
+		o.leaseOptions = append(append([]lease.Option(nil), o.leaseOptions...), lease.WithKeyTracking())
+		namespaces = append(namespaces, o)
+	}
+	opts.namespaces = namespaces
+	return opts
+}
@@ -0,0 +1,178 @@
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/QuangTung97/kvstore/lease"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newNamespacesForSnapshotTest() *namespaces {
+	opts := withKeyTracking(computeOptions(WithNamespace("users", 1, 1<<16)))
+	return newNamespaces(lease.New(1, 1<<16, opts.leaseOptions...), opts.namespaces)
+}
+
+func TestSnapshot_Write_Read(t *testing.T) {
+	n := newNamespacesForSnapshotTest()
+	n.cacheOf([]byte("key01")).Put([]byte("key01"), []byte("value01"))
+	n.cacheOf([]byte("users:01")).Put([]byte("users:01"), []byte("value02"))
+	n.cacheOf([]byte("key03")).Put([]byte("key03"), nil)
+
+	var buf bytes.Buffer
+	items, err := writeSnapshot(&buf, n, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(3), items)
+
+	data := buf.Bytes()
+	assert.Equal(t, []byte("KVSN\x01\x00\x00\x00"), data[:snapshotHeaderSize])
+
+	result := map[string]string{}
+	items, err = readSnapshot(bytes.NewReader(data), func(key []byte, value []byte) {
+		result[string(key)] = string(value)
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(3), items)
+	assert.Equal(t, map[string]string{
+		"key01":    "value01",
+		"users:01": "value02",
+		"key03":    "",
+	}, result)
+}
+
+func TestSnapshot_Skip_Tagged_And_Stale_Values(t *testing.T) {
+	n := newNamespacesForSnapshotTest()
+	cache := n.cacheOf([]byte("key01"))
+	cache.Put([]byte("key01"), []byte("value01"))
+
+	cache.Put([]byte("key02"), []byte("value02"))
+	cache.MarkStale([]byte("key02"))
+
+	result := cache.Get([]byte("key03"), nil)
+	cache.SetWithTags([]byte("key03"), result.LeaseID, []byte("value03"), [][]byte{[]byte("tag01")})
+
+	var buf bytes.Buffer
+	items, err := writeSnapshot(&buf, n, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(1), items)
+
+	restored := newNamespacesForSnapshotTest()
+	items, err = readSnapshot(bytes.NewReader(buf.Bytes()), func(key []byte, value []byte) {
+		restored.cacheOf(key).Put(key, value)
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(1), items)
+
+	data := make([]byte, 100)
+	size, ok := restored.cacheOf([]byte("key01")).Lookup([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:size]))
+
+	for _, key := range []string{"key02", "key03"} {
+		_, ok := restored.cacheOf([]byte(key)).Lookup([]byte(key), data)
+		assert.Equal(t, false, ok, key)
+	}
+}
+
+func TestSnapshotWriter_Skip_Long_Keys(t *testing.T) {
+	var buf bytes.Buffer
+	w := &snapshotWriter{
+		w:        bufio.NewWriter(&buf),
+		checksum: crc32.New(castagnoliTable),
+	}
+
+	assert.Equal(t, false, w.writeRecord(make([]byte, snapshotMaxKeySize+1), []byte("value01")))
+	assert.Equal(t, false, w.writeRecord(nil, []byte("value02")))
+	assert.Equal(t, true, w.writeRecord(make([]byte, snapshotMaxKeySize), []byte("value03")))
+	assert.Equal(t, nil, w.w.Flush())
+
+	assert.Equal(t, snapshotKeyLengthSize+snapshotValueLengthSize+snapshotMaxKeySize+7, buf.Len())
+	assert.Equal(t, []byte{0xff, 0xff, 7, 0, 0, 0}, buf.Bytes()[:6])
+}
+
+func TestSnapshot_Read_Invalid(t *testing.T) {
+	n := newNamespacesForSnapshotTest()
+	n.cacheOf([]byte("key01")).Put([]byte("key01"), []byte("value01"))
+
+	var buf bytes.Buffer
+	_, err := writeSnapshot(&buf, n, nil)
+	assert.Equal(t, nil, err)
+	data := buf.Bytes()
+
+	read := func(data []byte) error {
+		_, err := readSnapshot(bytes.NewReader(data), func(key []byte, value []byte) {})
+		return err
+	}
+
+	assert.Equal(t, errInvalidSnapshot, read(data[:len(data)-1]))
+	assert.Equal(t, errInvalidSnapshot, read(data[:10]))
+	assert.Equal(t, errInvalidSnapshot, read([]byte("KVS")))
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-8] ^= 0xff
+	assert.Equal(t, errInvalidSnapshot, read(corrupted))
+
+	unknownVersion := append([]byte(nil), data...)
+	unknownVersion[len(snapshotMagic)] = 2
+	assert.Equal(t, errUnsupportedSnapshotVersion, read(unknownVersion))
+}
+
+func TestSnapshot_Write_Restore_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+
+	n := newNamespacesForSnapshotTest()
+	n.snapshots = newSnapshotter(path, n, computeOptions().logger)
+	n.cacheOf([]byte("key01")).Put([]byte("key01"), []byte("value01"))
+	n.cacheOf([]byte("users:01")).Put([]byte("users:01"), []byte("value02"))
+
+	err := n.snapshots.write()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(1), n.snapshots.completed.load())
+	assert.Equal(t, uint64(2), n.snapshots.lastItems.load())
+
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	restored := newNamespacesForSnapshotTest()
+	items, err := restoreSnapshotFile(path, restored)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(2), items)
+
+	data := make([]byte, 100)
+	size, ok := restored.defaultCache.Lookup([]byte("key01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value01", string(data[:size]))
+
+	size, ok = restored.cacheOf([]byte("users:01")).Lookup([]byte("users:01"), data)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "value02", string(data[:size]))
+	_, ok = restored.defaultCache.Lookup([]byte("users:01"), data)
+	assert.Equal(t, false, ok)
+}
+
+func TestSnapshot_Restore_Corrupted_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+
+	n := newNamespacesForSnapshotTest()
+	n.cacheOf([]byte("key01")).Put([]byte("key01"), []byte("value01"))
+	_, err := writeSnapshotFile(path, n)
+	assert.Equal(t, nil, err)
+
+	data, err := os.ReadFile(path)
+	assert.Equal(t, nil, err)
+	err = os.WriteFile(path, data[:len(data)-1], 0644)
+	assert.Equal(t, nil, err)
+
+	restored := newNamespacesForSnapshotTest()
+	_, err = restoreSnapshotFile(path, restored)
+	assert.Equal(t, errInvalidSnapshot, err)
+
+	_, ok := restored.defaultCache.Lookup([]byte("key01"), nil)
+	assert.Equal(t, false, ok)
+
+	_, err = restoreSnapshotFile(path+".missing", restored)
+	assert.True(t, os.IsNotExist(err))
+}
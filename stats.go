@@ -0,0 +1,135 @@
+package kvstore
+
+import (
+	"github.com/QuangTung97/kvstore/bigcmd"
+	"github.com/QuangTung97/kvstore/lease"
+	"strconv"
+	"time"
+)
+
+// processorStats contains the counters of a processor,
+// written by the processor and read by any processor handling STATS
+type processorStats struct {
+	getHits   atomicUint64
+	getMisses atomicUint64
+
+	leasesGranted  atomicUint64
+	leasesRejected atomicUint64
+
+	setAffected    atomicUint64
+	setNotAffected atomicUint64
+
+	delAffected    atomicUint64
+	delNotAffected atomicUint64
+}
+
+// serverStats contains the statistics shared by all processors of a receiver
+type serverStats struct {
+	startedAt  time.Time
+	processors []*processor
+	namespaces *namespaces
+	metrics    *metrics
+
+	// copied from the bigcmd store of the receiver
+	fragments      atomicUint64
+	completed      atomicUint64
+	evicted        atomicUint64
+	invalidBatches atomicUint64
+
+	batchChecksumFailures atomicUint64
+	frameChecksumFailures atomicUint64
+	frameAuthFailures     atomicUint64
+	frameDecryptFailures  atomicUint64
+
+	rateLimitedBatches atomicUint64
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{
+		startedAt: time.Now(),
+		metrics:   newMetrics(),
+	}
+}
+
+func (s *serverStats) setStoreStats(st bigcmd.Stats) {
+	s.fragments.store(st.Fragments)
+	s.completed.store(st.Completed)
+	s.evicted.store(st.Evicted)
+	s.invalidBatches.store(st.Invalid)
+	s.batchChecksumFailures.store(st.ChecksumFailures)
+}
+
+func (s *processorStats) recordGet(status lease.GetStatus) {
+	switch status {
+	case lease.GetStatusFound:
+		s.getHits.add(1)
+		return
+	case lease.GetStatusLeaseGranted, lease.GetStatusStaleLeaseGranted:
+		s.leasesGranted.add(1)
+	case lease.GetStatusLeaseRejected, lease.GetStatusStaleLeaseRejected:
+		s.leasesRejected.add(1)
+	}
+	s.getMisses.add(1)
+}
+
+//revive:disable-next-line:flag-parameter
+func recordAffected(affected bool, yes *atomicUint64, no *atomicUint64) {
+	if affected {
+		yes.add(1)
+		return
+	}
+	no.add(1)
+}
+
+func (s *processorStats) addTo(total *processorStats) {
+	total.getHits.add(s.getHits.load())
+	total.getMisses.add(s.getMisses.load())
+	total.leasesGranted.add(s.leasesGranted.load())
+	total.leasesRejected.add(s.leasesRejected.load())
+	total.setAffected.add(s.setAffected.load())
+	total.setNotAffected.add(s.setNotAffected.load())
+	total.delAffected.add(s.delAffected.load())
+	total.delNotAffected.add(s.delNotAffected.load())
+}
+
+// collectStats returns the entries of the STATS command
+func (s *serverStats) collectStats(cache *lease.Cache) []statEntry {
+	var total processorStats
+	for _, p := range s.processors {
+		p.stats.addTo(&total)
+	}
+
+	entries := []statEntry{
+		{name: "uptime", value: uint64(time.Since(s.startedAt) / time.Second)},
+		{name: "items", value: cache.GetUnsafeInnerCache().GetTotal()},
+		{name: "get_hits", value: total.getHits.load()},
+		{name: "get_misses", value: total.getMisses.load()},
+		{name: "leases_granted", value: total.leasesGranted.load()},
+		{name: "leases_rejected", value: total.leasesRejected.load()},
+		{name: "lset_affected", value: total.setAffected.load()},
+		{name: "lset_not_affected", value: total.setNotAffected.load()},
+		{name: "del_affected", value: total.delAffected.load()},
+		{name: "del_not_affected", value: total.delNotAffected.load()},
+		{name: "fragments_received", value: s.fragments.load()},
+		{name: "batches_reassembled", value: s.completed.load()},
+		{name: "batches_evicted", value: s.evicted.load()},
+		{name: "batches_invalid", value: s.invalidBatches.load()},
+		{name: "batches_checksum_failed", value: s.batchChecksumFailures.load()},
+		{name: "frames_checksum_failed", value: s.frameChecksumFailures.load()},
+		{name: "frames_auth_failed", value: s.frameAuthFailures.load()},
+		{name: "frames_decrypt_failed", value: s.frameDecryptFailures.load()},
+		{name: "batches_rate_limited", value: s.rateLimitedBatches.load()},
+	}
+
+	if s.namespaces != nil {
+		entries = append(entries, s.namespaces.collectStats()...)
+	}
+
+	for i, p := range s.processors {
+		entries = append(entries, statEntry{
+			name:  "processor_" + strconv.Itoa(i) + "_queue_bytes",
+			value: p.cmdStore.queueSize(),
+		})
+	}
+	return entries
+}
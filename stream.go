@@ -0,0 +1,130 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream frames are data frames prefixed by 4 bytes of length
+const streamFrameLengthSize = 4
+const streamMaxFrameSize = 1 << 16 // 64KB, the same as the max size of UDP packages
+const streamWriteTimeout = 5 * time.Second
+
+var errStreamFrameTooLarge = errors.New("stream frame too large")
+
+// readStreamFrame reads a length prefixed frame into buf
+func readStreamFrame(r io.Reader, buf []byte) ([]byte, error) {
+	var lengthData [streamFrameLengthSize]byte
+	_, err := io.ReadFull(r, lengthData[:])
+	if err != nil {
+		return nil, err
+	}
+
+	length := int(binary.LittleEndian.Uint32(lengthData[:]))
+	if length > len(buf) {
+		return nil, errStreamFrameTooLarge
+	}
+
+	_, err = io.ReadFull(r, buf[:length])
+	if err != nil {
+		return nil, err
+	}
+	return buf[:length], nil
+}
+
+// appendStreamFrame appends the length prefix and the frame to data
+func appendStreamFrame(data []byte, frame []byte) []byte {
+	var lengthData [streamFrameLengthSize]byte
+	binary.LittleEndian.PutUint32(lengthData[:], uint32(len(frame)))
+	data = append(data, lengthData[:]...)
+	return append(data, frame...)
+}
+
+type streamConn struct {
+	conn net.Conn
+
+	mut  sync.Mutex
+	data []byte
+}
+
+func (c *streamConn) writeFrame(frame []byte) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	err := c.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	if err != nil {
+		return err
+	}
+
+	c.data = appendStreamFrame(c.data[:0], frame)
+	_, err = c.conn.Write(c.data)
+	return err
+}
+
+// streamConnRegistry keeps the stream connections of the native protocol for sending responses
+type streamConnRegistry struct {
+	mut    sync.Mutex
+	nextID uint32
+	conns  map[uint32]*streamConn
+}
+
+func newStreamConnRegistry() *streamConnRegistry {
+	return &streamConnRegistry{
+		conns: map[uint32]*streamConn{},
+	}
+}
+
+func (r *streamConnRegistry) add(conn net.Conn) uint32 {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	for {
+		r.nextID++
+		if _, existed := r.conns[r.nextID]; !existed {
+			break
+		}
+	}
+	r.conns[r.nextID] = &streamConn{conn: conn}
+	return r.nextID
+}
+
+func (r *streamConnRegistry) remove(id uint32) {
+	r.mut.Lock()
+	delete(r.conns, id)
+	r.mut.Unlock()
+}
+
+func (r *streamConnRegistry) get(id uint32) (*streamConn, bool) {
+	r.mut.Lock()
+	conn, ok := r.conns[id]
+	r.mut.Unlock()
+	return conn, ok
+}
+
+// streamHandler receives the length prefixed data frames of TCP and Unix stream connections
+type streamHandler struct {
+	recv  *receiver
+	conns *streamConnRegistry
+}
+
+func (h *streamHandler) serve(conn net.Conn) error {
+	id := h.conns.add(conn)
+	defer h.conns.remove(id)
+
+	addr := newStreamClientAddr(conn.RemoteAddr(), id)
+
+	reader := bufio.NewReader(conn)
+	buf := make([]byte, streamMaxFrameSize)
+	for {
+		frame, err := readStreamFrame(reader, buf)
+		if err != nil {
+			return err
+		}
+		h.recv.recv(addr, frame)
+	}
+}
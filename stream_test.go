@@ -0,0 +1,95 @@
+package kvstore
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestStreamFrame(t *testing.T) {
+	var data []byte
+	data = appendStreamFrame(data, []byte("frame01"))
+	data = appendStreamFrame(data, []byte("frame-02"))
+	assert.Equal(t, []byte("\x07\x00\x00\x00frame01\x08\x00\x00\x00frame-02"), data)
+
+	reader := bytes.NewReader(data)
+	buf := make([]byte, 100)
+
+	frame, err := readStreamFrame(reader, buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("frame01"), frame)
+
+	frame, err = readStreamFrame(reader, buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("frame-02"), frame)
+
+	_, err = readStreamFrame(reader, buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestStreamFrame_Error(t *testing.T) {
+	data := appendStreamFrame(nil, []byte("frame01"))
+
+	_, err := readStreamFrame(bytes.NewReader(data), make([]byte, 6))
+	assert.Equal(t, errStreamFrameTooLarge, err)
+
+	_, err = readStreamFrame(bytes.NewReader(data[:8]), make([]byte, 100))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestStreamConnRegistry(t *testing.T) {
+	registry := newStreamConnRegistry()
+
+	conn1, _ := net.Pipe()
+	conn2, _ := net.Pipe()
+
+	id1 := registry.add(conn1)
+	id2 := registry.add(conn2)
+	assert.Equal(t, uint32(1), id1)
+	assert.Equal(t, uint32(2), id2)
+
+	c, ok := registry.get(id2)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, conn2, c.conn)
+
+	registry.remove(id2)
+	_, ok = registry.get(id2)
+	assert.Equal(t, false, ok)
+}
+
+func TestTransportSender(t *testing.T) {
+	udp := &ResponseSenderMock{
+		SendFunc: func(addr ClientAddr, data []byte) error { return nil },
+	}
+	registry := newStreamConnRegistry()
+	sender := &transportSender{udp: udp, stream: registry}
+
+	server, client := net.Pipe()
+	id := registry.add(server)
+	streamAddr := ClientAddr{Transport: TransportStream, ID: id}
+
+	go func() {
+		err := sender.Send(streamAddr, []byte("stream-data"))
+		assert.Equal(t, nil, err)
+	}()
+
+	frame, err := readStreamFrame(client, make([]byte, 100))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("stream-data"), frame)
+
+	udpAddr := NewUDPClientAddr(newIPAddr(192, 168, 1, 20), 7101)
+	err = sender.Send(udpAddr, []byte("udp-data"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(udp.SendCalls()))
+	assert.Equal(t, udpAddr, udp.SendCalls()[0].Addr)
+	assert.Equal(t, []byte("udp-data"), udp.SendCalls()[0].Data)
+
+	registry.remove(id)
+	err = sender.Send(streamAddr, []byte("stream-data"))
+	assert.Equal(t, errUnknownClientAddr, err)
+
+	err = sender.Send(ClientAddr{Transport: TransportUnixgram, ID: 1}, []byte("unixgram-data"))
+	assert.Equal(t, errUnknownClientAddr, err)
+}
@@ -0,0 +1,103 @@
+package kvstore
+
+import (
+	"net"
+	"os"
+	"sync"
+)
+
+// unixgramMaxPeers limits the number of remembered Unix datagram peers,
+// the peers are forgotten when the limit is reached
+const unixgramMaxPeers = 1 << 16
+
+// unixgramPeers maps the socket paths of Unix datagram clients to ids
+type unixgramPeers struct {
+	mut    sync.Mutex
+	nextID uint32
+	ids    map[string]uint32
+	addrs  map[uint32]*net.UnixAddr
+}
+
+func newUnixgramPeers() *unixgramPeers {
+	return &unixgramPeers{
+		ids:   map[string]uint32{},
+		addrs: map[uint32]*net.UnixAddr{},
+	}
+}
+
+func (p *unixgramPeers) getID(addr *net.UnixAddr) uint32 {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	id, ok := p.ids[addr.Name]
+	if ok {
+		return id
+	}
+
+	if len(p.ids) >= unixgramMaxPeers {
+		p.ids = map[string]uint32{}
+		p.addrs = map[uint32]*net.UnixAddr{}
+	}
+
+	p.nextID++
+	p.ids[addr.Name] = p.nextID
+	p.addrs[p.nextID] = addr
+	return p.nextID
+}
+
+func (p *unixgramPeers) getAddr(id uint32) (*net.UnixAddr, bool) {
+	p.mut.Lock()
+	addr, ok := p.addrs[id]
+	p.mut.Unlock()
+	return addr, ok
+}
+
+type unixgramSender struct {
+	conn  *net.UnixConn
+	peers *unixgramPeers
+}
+
+func (s *unixgramSender) Send(addr ClientAddr, data []byte) error {
+	unixAddr, ok := s.peers.getAddr(addr.ID)
+	if !ok {
+		return errUnknownClientAddr
+	}
+	_, err := s.conn.WriteToUnix(data, unixAddr)
+	return err
+}
+
+// runUnixgramLoop receives packages from Unix datagram clients,
+// clients must bind their sockets to paths for receiving responses
+func runUnixgramLoop(conn *net.UnixConn, peers *unixgramPeers, recv *receiver) error {
+	data := make([]byte, streamMaxFrameSize)
+	for {
+		size, addr, err := conn.ReadFromUnix(data)
+		if err != nil {
+			return err
+		}
+		if addr == nil || addr.Name == "" {
+			continue
+		}
+
+		recv.recv(ClientAddr{
+			Transport: TransportUnixgram,
+			ID:        peers.getID(addr),
+			Unix:      true,
+		}, data[:size])
+	}
+}
+
+// removeStaleSocket removes the socket file of a previous run
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	return os.Remove(path)
+}
@@ -1,34 +1,108 @@
 package kvstore
 
 import (
+	"crypto/cipher"
 	"encoding/binary"
+	"hash/crc32"
 )
 
 type dataFrameHeader struct {
+	version    uint8
 	batchID    uint64
 	fragmented bool
+	binary     bool // commands of the batch are in the binary encoding
+	rejected   bool // the server rejects the frame, the entry list is replaced by an error message
 	length     uint32
 	offset     uint32
+
+	// checksum is true if the frame carries the CRC32C of the frame and, if fragmented, of the batch
+	checksum      bool
+	frameChecksum uint32
+	batchChecksum uint32
+
+	// auth is true if the frame carries the id of the key and the HMAC-SHA256 tag of the frame,
+	// authKey is only used for signing when building frames
+	auth    bool
+	keyID   uint32
+	tag     []byte
+	authKey []byte
+
+	// encrypted is true if the entry list is encrypted, with the nonce and the id of the key
+	// in the header, aead is only used for encrypting when building frames
+	encrypted       bool
+	encryptionKeyID uint32
+	nonce           []byte
+	aead            cipher.AEAD
 }
 
+// Version 0 frames start with the batch id, in which the top bits are the flags,
+// frames of the later versions start with the magic:
+//
+//	magic (4 bytes), version (1 byte), flags (1 byte), reserved (2 bytes), batch id (8 bytes)
+//
+// followed by the total length and the offset (4 bytes each) if the frame is fragmented,
+// then the CRC32C of the frame, computed without this field, and the CRC32C of the whole batch
+// if the frame is fragmented (4 bytes each) if the checksum flag is set,
+// then the key id (4 bytes) and the HMAC-SHA256 tag (32 bytes) if the auth flag is set,
+// then the key id (4 bytes) and the nonce (12 bytes) if the encrypted flag is set
+const (
+	frameVersion0      uint8 = 0
+	frameVersion1      uint8 = 1
+	frameVersionLatest       = frameVersion1
+)
+
+var frameMagic = [4]byte{'K', 'V', 'S', 'T'}
+
 const fragmentedBitMask uint64 = 1 << 63
-const batchIDMask = ^fragmentedBitMask
+const binaryBitMask uint64 = 1 << 62
+const batchIDMask = ^(fragmentedBitMask | binaryBitMask)
 
 const dataFrameLengthOffset = 8
 const dataFrameOffsetFieldOffset = dataFrameLengthOffset + 4
 const dataFrameEntryListOffset = dataFrameOffsetFieldOffset + 4
 
-// nextOffset will be zero if error occurs
+const (
+	frameFlagFragmented uint8 = 1 << iota
+	frameFlagBinary
+	frameFlagRejected
+	frameFlagChecksum
+	frameFlagAuth
+	frameFlagEncrypted
+)
+
+const frameChecksumSize = 4
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+const frameVersionOffset = len(frameMagic)
+const frameFlagsOffset = frameVersionOffset + 1
+const frameBatchIDOffset = frameFlagsOffset + 3
+const frameLengthOffset = frameBatchIDOffset + 8
+const frameOffsetFieldOffset = frameLengthOffset + 4
+const frameEntryListOffset = frameOffsetFieldOffset + 4
+
+func hasFrameMagic(data []byte) bool {
+	return len(data) >= len(frameMagic) && string(data[:len(frameMagic)]) == string(frameMagic[:])
+}
+
+// nextOffset will be zero if error occurs, in which case the version
+// and the batch id are still set if the frame has an unknown version
 func parseDataFrameHeader(data []byte) (header dataFrameHeader, nextOffset int) {
+	if hasFrameMagic(data) {
+		return parseVersionedFrameHeader(data)
+	}
+
 	if len(data) < dataFrameLengthOffset {
 		return dataFrameHeader{}, 0
 	}
 
 	batchID := binary.LittleEndian.Uint64(data)
+	isBinary := batchID&binaryBitMask != 0
 	if batchID&fragmentedBitMask == 0 {
 		return dataFrameHeader{
-			batchID:    batchID,
+			batchID:    batchID & batchIDMask,
 			fragmented: false,
+			binary:     isBinary,
 		}, dataFrameLengthOffset
 	}
 
@@ -42,23 +116,266 @@ func parseDataFrameHeader(data []byte) (header dataFrameHeader, nextOffset int)
 	return dataFrameHeader{
 		batchID:    batchID & batchIDMask,
 		fragmented: true,
+		binary:     isBinary,
 		length:     length,
 		offset:     offset,
 	}, dataFrameEntryListOffset
 }
 
+func parseVersionedFrameHeader(data []byte) (dataFrameHeader, int) {
+	if len(data) < frameLengthOffset {
+		return dataFrameHeader{}, 0
+	}
+
+	flags := data[frameFlagsOffset]
+	header := dataFrameHeader{
+		version:    data[frameVersionOffset],
+		batchID:    binary.LittleEndian.Uint64(data[frameBatchIDOffset:]),
+		fragmented: flags&frameFlagFragmented != 0,
+		binary:     flags&frameFlagBinary != 0,
+		rejected:   flags&frameFlagRejected != 0,
+		checksum:   flags&frameFlagChecksum != 0,
+		auth:       flags&frameFlagAuth != 0,
+		encrypted:  flags&frameFlagEncrypted != 0,
+	}
+	if header.version == frameVersion0 || header.version > frameVersionLatest {
+		return dataFrameHeader{version: header.version, batchID: header.batchID}, 0
+	}
+
+	nextOffset := dataFrameHeaderSize(header)
+	if len(data) < nextOffset {
+		return dataFrameHeader{}, 0
+	}
+
+	offset := frameLengthOffset
+	if header.fragmented {
+		header.length = binary.LittleEndian.Uint32(data[offset:])
+		header.offset = binary.LittleEndian.Uint32(data[offset+4:])
+		offset += 8
+	}
+	if header.checksum {
+		header.frameChecksum = binary.LittleEndian.Uint32(data[offset:])
+		if header.fragmented {
+			header.batchChecksum = binary.LittleEndian.Uint32(data[offset+frameChecksumSize:])
+		}
+	}
+	if header.auth {
+		offset = frameAuthOffset(header)
+		header.keyID = binary.LittleEndian.Uint32(data[offset:])
+		header.tag = data[offset+frameKeyIDSize : offset+frameAuthSize]
+	}
+	if header.encrypted {
+		offset = frameEncryptionOffset(header)
+		header.encryptionKeyID = binary.LittleEndian.Uint32(data[offset:])
+		header.nonce = data[offset+frameKeyIDSize : offset+frameEncryptionSize]
+	}
+	return header, nextOffset
+}
+
+func dataFrameHeaderSize(header dataFrameHeader) int {
+	if header.version == frameVersion0 {
+		if header.fragmented {
+			return dataFrameEntryListOffset
+		}
+		return dataFrameLengthOffset
+	}
+
+	size := frameEncryptionOffset(header)
+	if header.encrypted {
+		size += frameEncryptionSize
+	}
+	return size
+}
+
+// frameChecksumOffset returns the offset of the frame checksum field of a versioned frame
+func frameChecksumOffset(header dataFrameHeader) int {
+	if header.fragmented {
+		return frameEntryListOffset
+	}
+	return frameLengthOffset
+}
+
+// frameAuthOffset returns the offset of the key id field of a versioned frame
+func frameAuthOffset(header dataFrameHeader) int {
+	offset := frameChecksumOffset(header)
+	if !header.checksum {
+		return offset
+	}
+	if header.fragmented {
+		return offset + 2*frameChecksumSize
+	}
+	return offset + frameChecksumSize
+}
+
+// computeFrameChecksum returns the CRC32C of the frame, excluding the frame checksum field
+func computeFrameChecksum(frame []byte, header dataFrameHeader) uint32 {
+	offset := frameChecksumOffset(header)
+	checksum := crc32.Checksum(frame[:offset], castagnoliTable)
+	return crc32.Update(checksum, castagnoliTable, frame[offset+frameChecksumSize:])
+}
+
+// validFrameChecksum returns true if the frame has no checksum or the checksum is matched
+func validFrameChecksum(frame []byte, header dataFrameHeader) bool {
+	if !header.checksum || header.version == frameVersion0 {
+		return true
+	}
+	return computeFrameChecksum(frame, header) == header.frameChecksum
+}
+
 func buildDataFrameHeader(data []byte, header dataFrameHeader) int {
+	if header.version != frameVersion0 {
+		return buildVersionedFrameHeader(data, header)
+	}
+
+	batchID := header.batchID
+	if header.binary {
+		batchID |= binaryBitMask
+	}
+
 	if !header.fragmented {
-		binary.LittleEndian.PutUint64(data, header.batchID)
+		binary.LittleEndian.PutUint64(data, batchID)
 		return dataFrameLengthOffset
 	}
-	batchID := header.batchID | fragmentedBitMask
+	batchID |= fragmentedBitMask
 	binary.LittleEndian.PutUint64(data, batchID)
 	binary.LittleEndian.PutUint32(data[dataFrameLengthOffset:], header.length)
 	binary.LittleEndian.PutUint32(data[dataFrameOffsetFieldOffset:], header.offset)
 	return dataFrameEntryListOffset
 }
 
+func buildFrameFlags(header dataFrameHeader) uint8 {
+	flags := uint8(0)
+	if header.fragmented {
+		flags |= frameFlagFragmented
+	}
+	if header.binary {
+		flags |= frameFlagBinary
+	}
+	if header.rejected {
+		flags |= frameFlagRejected
+	}
+	if header.checksum {
+		flags |= frameFlagChecksum
+	}
+	if header.auth {
+		flags |= frameFlagAuth
+	}
+	if header.encrypted {
+		flags |= frameFlagEncrypted
+	}
+	return flags
+}
+
+// buildVersionedFrameHeader writes the header with the nonce, the tag and the frame checksum fields as is,
+// the fields are filled by finishDataFrame after the entry list is written
+func buildVersionedFrameHeader(data []byte, header dataFrameHeader) int {
+	copy(data, frameMagic[:])
+	data[frameVersionOffset] = header.version
+	data[frameFlagsOffset] = buildFrameFlags(header)
+	data[frameFlagsOffset+1] = 0
+	data[frameFlagsOffset+2] = 0
+	binary.LittleEndian.PutUint64(data[frameBatchIDOffset:], header.batchID)
+
+	offset := frameLengthOffset
+	if header.fragmented {
+		binary.LittleEndian.PutUint32(data[offset:], header.length)
+		binary.LittleEndian.PutUint32(data[offset+4:], header.offset)
+		offset += 8
+	}
+	if header.checksum {
+		binary.LittleEndian.PutUint32(data[offset:], header.frameChecksum)
+		offset += frameChecksumSize
+		if header.fragmented {
+			binary.LittleEndian.PutUint32(data[offset:], header.batchChecksum)
+			offset += frameChecksumSize
+		}
+	}
+	if header.auth {
+		binary.LittleEndian.PutUint32(data[offset:], header.keyID)
+		offset += frameKeyIDSize
+		copy(data[offset:offset+frameTagSize], header.tag)
+		offset += frameTagSize
+	}
+	if header.encrypted {
+		binary.LittleEndian.PutUint32(data[offset:], header.encryptionKeyID)
+		offset += frameEncryptionSize
+	}
+	return offset
+}
+
+func fillFrameChecksum(frame []byte, header dataFrameHeader) {
+	if !header.checksum || header.version == frameVersion0 {
+		return
+	}
+	checksum := computeFrameChecksum(frame, header)
+	binary.LittleEndian.PutUint32(frame[frameChecksumOffset(header):], checksum)
+}
+
+// buildDataFrames splits data into frames of at most len(frame) bytes and calls fn for each frame,
+// the version, batch id and flags of the frames are taken from header.
+// It stops at the first frame that can not be encrypted and returns the error
+func buildDataFrames(frame []byte, header dataFrameHeader, data []byte, fn func(frame []byte)) error {
+	length := len(data)
+	offset := uint32(0)
+	frameLen := len(frame) - frameEncryptionOverhead(header)
+
+	header.fragmented = false
+	if length+dataFrameHeaderSize(header) <= frameLen {
+		nextOffset := buildDataFrameHeader(frame, header)
+
+		copy(frame[nextOffset:], data)
+		result, err := finishDataFrame(frame, header, nextOffset, length)
+		if err != nil {
+			return err
+		}
+		fn(result)
+		return nil
+	}
+
+	header.fragmented = true
+	header.length = uint32(length)
+	if header.checksum {
+		header.batchChecksum = crc32.Checksum(data, castagnoliTable)
+	}
+	for len(data) > 0 {
+		header.offset = offset
+		nextOffset := buildDataFrameHeader(frame, header)
+
+		dataLen := len(data)
+		if nextOffset+len(data) > frameLen {
+			dataLen = frameLen - nextOffset
+		}
+
+		copy(frame[nextOffset:], data[:dataLen])
+		result, err := finishDataFrame(frame, header, nextOffset, dataLen)
+		if err != nil {
+			return err
+		}
+		fn(result)
+
+		data = data[dataLen:]
+		offset += uint32(dataLen)
+	}
+	return nil
+}
+
+// finishDataFrame encrypts, signs and fills the checksum of the frame, in that order,
+// the entry list of size dataLen is at the offset payloadOffset
+func finishDataFrame(frame []byte, header dataFrameHeader, payloadOffset int, dataLen int) ([]byte, error) {
+	frameLen := payloadOffset + dataLen
+	if header.encrypted {
+		var err error
+		frameLen, err = encryptFramePayload(frame, header, payloadOffset, dataLen)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signFrame(frame[:frameLen], header)
+	fillFrameChecksum(frame[:frameLen], header)
+	return frame[:frameLen], nil
+}
+
 // 8 byte request id
 // 4 byte data size
 // follow by actual data
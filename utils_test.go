@@ -2,6 +2,7 @@ package kvstore
 
 import (
 	"github.com/stretchr/testify/assert"
+	"hash/crc32"
 	"strings"
 	"testing"
 )
@@ -36,6 +37,28 @@ func TestParseHeader_With_Fragmented(t *testing.T) {
 	}, result)
 }
 
+func TestParseHeader_Binary(t *testing.T) {
+	data := []byte{
+		0x22, 0, 0, 0,
+		0, 0, 0, 0xc0,
+		0x15, 0, 0, 0,
+		0x07, 0, 0, 0,
+	}
+	result, offset := parseDataFrameHeader(data)
+	assert.Equal(t, 16, offset)
+	assert.Equal(t, dataFrameHeader{
+		batchID:    0x22,
+		fragmented: true,
+		binary:     true,
+		length:     0x15,
+		offset:     0x07,
+	}, result)
+
+	result, offset = parseDataFrameHeader([]byte{0x12, 0, 0, 0, 0, 0, 0, 0x40})
+	assert.Equal(t, 8, offset)
+	assert.Equal(t, dataFrameHeader{batchID: 0x12, binary: true}, result)
+}
+
 func TestParseHeader_Error_Missing_Batch_ID_Data(t *testing.T) {
 	data := []byte{
 		0x22, 0, 0,
@@ -101,6 +124,140 @@ func TestBuildDataFrameHeader_Fragmented(t *testing.T) {
 	}, data)
 }
 
+func TestBuildDataFrameHeader_Binary(t *testing.T) {
+	data := make([]byte, dataFrameEntryListOffset)
+	offset := buildDataFrameHeader(data, dataFrameHeader{
+		batchID: 0x28,
+		binary:  true,
+	})
+	assert.Equal(t, 8, offset)
+	assert.Equal(t, []byte{0x28, 0, 0, 0, 0, 0, 0, 0x40}, data[:offset])
+}
+
+func TestDataFrameHeader_Version1(t *testing.T) {
+	data := make([]byte, frameEntryListOffset)
+	offset := buildDataFrameHeader(data, dataFrameHeader{
+		version: frameVersion1,
+		batchID: 0x28,
+		binary:  true,
+	})
+	assert.Equal(t, 16, offset)
+	assert.Equal(t, []byte{
+		'K', 'V', 'S', 'T',
+		1, 0x02, 0, 0,
+		0x28, 0, 0, 0,
+		0, 0, 0, 0,
+	}, data[:offset])
+
+	header := dataFrameHeader{
+		version:    frameVersion1,
+		batchID:    0x28,
+		fragmented: true,
+		rejected:   true,
+		length:     0x0258,
+		offset:     0x36,
+	}
+	offset = buildDataFrameHeader(data, header)
+	assert.Equal(t, 24, offset)
+	assert.Equal(t, []byte{
+		'K', 'V', 'S', 'T',
+		1, 0x05, 0, 0,
+		0x28, 0, 0, 0,
+		0, 0, 0, 0,
+		0x58, 0x02, 0, 0,
+		0x36, 0, 0, 0,
+	}, data)
+
+	result, nextOffset := parseDataFrameHeader(data)
+	assert.Equal(t, 24, nextOffset)
+	assert.Equal(t, header, result)
+
+	result, nextOffset = parseDataFrameHeader(data[:20])
+	assert.Equal(t, 0, nextOffset)
+	assert.Equal(t, dataFrameHeader{}, result)
+}
+
+func TestParseHeader_Unknown_Version(t *testing.T) {
+	data := []byte{
+		'K', 'V', 'S', 'T',
+		2, 0, 0, 0,
+		0x28, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+	result, offset := parseDataFrameHeader(data)
+	assert.Equal(t, 0, offset)
+	assert.Equal(t, dataFrameHeader{version: 2, batchID: 0x28}, result)
+
+	result, offset = parseDataFrameHeader(data[:12])
+	assert.Equal(t, 0, offset)
+	assert.Equal(t, dataFrameHeader{}, result)
+}
+
+func TestBuildDataFrames_Version1(t *testing.T) {
+	var frames [][]byte
+	header := dataFrameHeader{version: frameVersion1, batchID: 3}
+	_ = buildDataFrames(make([]byte, 26), header, []byte("0123456789abcdef"), func(frame []byte) {
+		frames = append(frames, cloneBytes(frame))
+	})
+
+	assert.Equal(t, 8, len(frames))
+	for i, frame := range frames {
+		result, offset := parseDataFrameHeader(frame)
+		assert.Equal(t, dataFrameHeader{
+			version:    frameVersion1,
+			batchID:    3,
+			fragmented: true,
+			length:     16,
+			offset:     uint32(2 * i),
+		}, result)
+		assert.Equal(t, "0123456789abcdef"[2*i:2*i+2], string(frame[offset:]))
+	}
+}
+
+func TestBuildDataFrames_Checksum(t *testing.T) {
+	var frames [][]byte
+	header := dataFrameHeader{version: frameVersion1, batchID: 3, checksum: true}
+	_ = buildDataFrames(make([]byte, 100), header, []byte("0123456789"), func(frame []byte) {
+		frames = append(frames, cloneBytes(frame))
+	})
+
+	assert.Equal(t, 1, len(frames))
+	result, offset := parseDataFrameHeader(frames[0])
+	assert.Equal(t, 20, offset)
+	assert.Equal(t, true, result.checksum)
+	assert.Equal(t, true, validFrameChecksum(frames[0], result))
+	assert.Equal(t, "0123456789", string(frames[0][offset:]))
+
+	frames[0][offset+3] ^= 0x10
+	assert.Equal(t, false, validFrameChecksum(frames[0], result))
+
+	frames[0][offset+3] ^= 0x10
+	frames[0][frameFlagsOffset] |= frameFlagBinary
+	result, _ = parseDataFrameHeader(frames[0])
+	assert.Equal(t, false, validFrameChecksum(frames[0], result))
+}
+
+func TestBuildDataFrames_Checksum_Fragmented(t *testing.T) {
+	var frames [][]byte
+	header := dataFrameHeader{version: frameVersion1, batchID: 3, checksum: true}
+	_ = buildDataFrames(make([]byte, 40), header, []byte("0123456789abcdefghijklmnop"), func(frame []byte) {
+		frames = append(frames, cloneBytes(frame))
+	})
+
+	assert.Equal(t, 4, len(frames))
+
+	var data []byte
+	for _, frame := range frames {
+		result, offset := parseDataFrameHeader(frame)
+		assert.Equal(t, 32, offset)
+		assert.Equal(t, true, result.fragmented)
+		assert.Equal(t, crc32.Checksum([]byte("0123456789abcdefghijklmnop"), castagnoliTable), result.batchChecksum)
+		assert.Equal(t, true, validFrameChecksum(frame, result))
+		data = append(data, frame[offset:]...)
+	}
+	assert.Equal(t, "0123456789abcdefghijklmnop", string(data))
+}
+
 func TestParseDataFrameEntry(t *testing.T) {
 	data := make([]byte, 1000)
 
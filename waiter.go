@@ -0,0 +1,87 @@
+package kvstore
+
+import (
+	"errors"
+	"github.com/QuangTung97/kvstore/lease"
+	"sync"
+	"time"
+)
+
+// maxWaitTimeout is the maximum timeout of LGETW, longer timeouts are shortened to it
+const maxWaitTimeout = 60 * time.Second
+
+// errTooManyWaiters is replied to LGETW when the lease bucket of the key has too many waiters
+var errTooManyWaiters = errors.New("too many waiters")
+
+type waiterStatus int
+
+const (
+	waiterStatusWaiting waiterStatus = iota
+	waiterStatusValue
+	waiterStatusReleased
+	waiterStatusTimeout
+)
+
+// processorWaiter is a parked LGETW request, waiting for the lease holder's LSET
+type processorWaiter struct {
+	proc  *processor
+	cache *lease.Cache
+
+	addr      ClientAddr
+	format    commandFormat
+	requestID uint64
+	key       []byte
+
+	timer  *time.Timer
+	status waiterStatus
+	value  []byte
+}
+
+var _ lease.Waiter = &processorWaiter{}
+
+type waiterQueue struct {
+	mut       sync.Mutex
+	completed []*processorWaiter
+}
+
+func (q *waiterQueue) push(w *processorWaiter) {
+	q.mut.Lock()
+	q.completed = append(q.completed, w)
+	q.mut.Unlock()
+}
+
+func (q *waiterQueue) popAll() []*processorWaiter {
+	q.mut.Lock()
+	result := q.completed
+	q.completed = nil
+	q.mut.Unlock()
+	return result
+}
+
+func cloneBytes(data []byte) []byte {
+	result := make([]byte, len(data))
+	copy(result, data)
+	return result
+}
+
+func (w *processorWaiter) complete(status waiterStatus) {
+	w.status = status
+	w.proc.waiters.push(w)
+	w.proc.cmdStore.notify()
+}
+
+func (w *processorWaiter) OnValue(value []byte) {
+	w.value = cloneBytes(value)
+	w.complete(waiterStatusValue)
+}
+
+func (w *processorWaiter) OnLeaseReleased() {
+	w.complete(waiterStatusReleased)
+}
+
+func (w *processorWaiter) onTimeout() {
+	if !w.cache.CancelWait(w.key, w) {
+		return
+	}
+	w.complete(waiterStatusTimeout)
+}
@@ -0,0 +1,225 @@
+package kvstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"go.uber.org/zap"
+	"net"
+	"time"
+)
+
+// The warm-up request is a stream frame sent by the starting node, numbers are little endian:
+//
+//	version (1 byte), virtual nodes (2 bytes), node name length (2 bytes), node name,
+//	number of nodes of the ring (2 bytes), then name length (2 bytes) and name of each node
+//
+// The peer replies with the values owned by the node in the snapshot format, then closes the connection
+const (
+	warmUpRequestVersion = 1
+
+	warmUpDialTimeout = 5 * time.Second
+	warmUpReadTimeout = 30 * time.Second
+)
+
+// errInvalidWarmUpRequest is returned when the warm-up request is malformed, the node is not in the ring
+// or the ring exceeds the limits of HashRing
+var errInvalidWarmUpRequest = errors.New("invalid warm-up request")
+
+type warmUpRequest struct {
+	node string
+	ring HashRing
+}
+
+func appendWarmUpString(data []byte, s string) []byte {
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], uint16(len(s)))
+	data = append(data, buf[:]...)
+	return append(data, s...)
+}
+
+func encodeWarmUpRequest(req warmUpRequest) []byte {
+	var buf [2]byte
+	data := []byte{warmUpRequestVersion}
+
+	binary.LittleEndian.PutUint16(buf[:], uint16(req.ring.VirtualNodes))
+	data = append(data, buf[:]...)
+	data = appendWarmUpString(data, req.node)
+
+	binary.LittleEndian.PutUint16(buf[:], uint16(len(req.ring.Nodes)))
+	data = append(data, buf[:]...)
+	for _, node := range req.ring.Nodes {
+		data = appendWarmUpString(data, node)
+	}
+	return data
+}
+
+// warmUpReader reads the fields of a warm-up request, ok is false after reading past the end
+type warmUpReader struct {
+	data []byte
+	ok   bool
+}
+
+func (r *warmUpReader) readUint16() int {
+	if len(r.data) < 2 {
+		r.ok = false
+		return 0
+	}
+	n := binary.LittleEndian.Uint16(r.data)
+	r.data = r.data[2:]
+	return int(n)
+}
+
+func (r *warmUpReader) readString() string {
+	n := r.readUint16()
+	if !r.ok || len(r.data) < n {
+		r.ok = false
+		return ""
+	}
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+
+// readNodes reads the nodes of the ring, found is true if the node is one of them
+func (r *warmUpReader) readNodes(node string) (nodes []string, found bool) {
+	count := r.readUint16()
+	if count > MaxHashRingNodes {
+		r.ok = false
+		return nil, false
+	}
+	for i := 0; i < count && r.ok; i++ {
+		name := r.readString()
+		nodes = append(nodes, name)
+		found = found || name == node
+	}
+	return nodes, found
+}
+
+func decodeWarmUpRequest(data []byte) (warmUpRequest, error) {
+	if len(data) == 0 || data[0] != warmUpRequestVersion {
+		return warmUpRequest{}, errInvalidWarmUpRequest
+	}
+
+	r := warmUpReader{data: data[1:], ok: true}
+	req := warmUpRequest{}
+	req.ring.VirtualNodes = r.readUint16()
+	req.node = r.readString()
+
+	nodes, found := r.readNodes(req.node)
+	req.ring.Nodes = nodes
+
+	if !r.ok || len(r.data) > 0 || !found || req.ring.validate() != nil {
+		return warmUpRequest{}, errInvalidWarmUpRequest
+	}
+	return req, nil
+}
+
+// throttledConn writes to the connection at most bytesPerSecond on average, so that streaming
+// the values to a starting node does not take the CPU and the bandwidth of the processors
+type throttledConn struct {
+	conn           net.Conn
+	bytesPerSecond int
+
+	startedAt time.Time
+	written   int64
+}
+
+func (c *throttledConn) Write(data []byte) (int, error) {
+	err := c.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.conn.Write(data)
+	if err != nil {
+		return n, err
+	}
+
+	c.written += int64(n)
+	if c.bytesPerSecond > 0 {
+		expected := time.Duration(c.written * int64(time.Second) / int64(c.bytesPerSecond))
+		if elapsed := time.Since(c.startedAt); elapsed < expected {
+			time.Sleep(expected - elapsed)
+		}
+	}
+	return n, nil
+}
+
+// warmUpHandler serves the warm-up requests of starting nodes with the values they own
+type warmUpHandler struct {
+	namespaces     *namespaces
+	bytesPerSecond int
+	logger         *zap.Logger
+}
+
+// serve requires the ReadOnly role of the client IP, checked before reading the request
+func (h *warmUpHandler) serve(conn net.Conn, role AccessRole) error {
+	if role < AccessRoleReadOnly {
+		return errPermissionDenied
+	}
+
+	err := conn.SetReadDeadline(time.Now().Add(warmUpReadTimeout))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, streamMaxFrameSize)
+	frame, err := readStreamFrame(conn, buf)
+	if err != nil {
+		return err
+	}
+	req, err := decodeWarmUpRequest(frame)
+	if err != nil {
+		return err
+	}
+
+	begin := time.Now()
+	ring := newHashRing(req.ring)
+	dest := &throttledConn{conn: conn, bytesPerSecond: h.bytesPerSecond, startedAt: begin}
+	items, err := writeSnapshot(dest, h.namespaces, func(key []byte) bool {
+		return ring.owner(key) == req.node
+	})
+	if err != nil {
+		return err
+	}
+
+	h.logger.Info("Warm-up served", zap.String("node", req.node),
+		zap.Uint64("items", items), zap.Duration("duration", time.Since(begin)))
+	return nil
+}
+
+// deadlineConn resets the read deadline of the connection before each read
+type deadlineConn struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Read(data []byte) (int, error) {
+	err := c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return 0, err
+	}
+	return c.conn.Read(data)
+}
+
+// warmUpFrom puts the values owned by the node into the caches of the namespaces, streamed from the peer.
+// The values received before an error are kept
+func warmUpFrom(peer string, req warmUpRequest, n *namespaces) (uint64, error) {
+	conn, err := net.DialTimeout("tcp", peer, warmUpDialTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	err = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	if err != nil {
+		return 0, err
+	}
+	_, err = conn.Write(appendStreamFrame(nil, encodeWarmUpRequest(req)))
+	if err != nil {
+		return 0, err
+	}
+
+	reader := &deadlineConn{conn: conn, timeout: warmUpReadTimeout}
+	return readSnapshot(reader, func(key []byte, value []byte) {
+		n.cacheOf(key).Put(key, value)
+	})
+}
@@ -0,0 +1,166 @@
+package kvstore
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWarmUpRequest_Encode_Decode(t *testing.T) {
+	req := warmUpRequest{
+		node: "node-b",
+		ring: HashRing{Nodes: []string{"node-a", "node-b"}, VirtualNodes: 64},
+	}
+	data := encodeWarmUpRequest(req)
+
+	result, err := decodeWarmUpRequest(data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, req, result)
+
+	_, err = decodeWarmUpRequest(data[:len(data)-1])
+	assert.Equal(t, errInvalidWarmUpRequest, err)
+
+	_, err = decodeWarmUpRequest(append(data, 0))
+	assert.Equal(t, errInvalidWarmUpRequest, err)
+
+	_, err = decodeWarmUpRequest(encodeWarmUpRequest(warmUpRequest{node: "node-c", ring: req.ring}))
+	assert.Equal(t, errInvalidWarmUpRequest, err)
+
+	_, err = decodeWarmUpRequest(encodeWarmUpRequest(warmUpRequest{
+		node: "node-a", ring: HashRing{Nodes: []string{"node-a"}},
+	}))
+	assert.Equal(t, errInvalidWarmUpRequest, err)
+
+	_, err = decodeWarmUpRequest(encodeWarmUpRequest(warmUpRequest{
+		node: "node-a", ring: HashRing{Nodes: []string{"node-a"}, VirtualNodes: MaxHashRingVirtualNodes + 1},
+	}))
+	assert.Equal(t, errInvalidWarmUpRequest, err)
+
+	nodes := make([]string, MaxHashRingNodes+1)
+	for i := range nodes {
+		nodes[i] = "node-" + strconv.Itoa(i)
+	}
+	_, err = decodeWarmUpRequest(encodeWarmUpRequest(warmUpRequest{
+		node: "node-0", ring: HashRing{Nodes: nodes, VirtualNodes: 1},
+	}))
+	assert.Equal(t, errInvalidWarmUpRequest, err)
+}
+
+func runWarmUpListenerForTest(t *testing.T, handler *warmUpHandler, role AccessRole) string {
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.Equal(t, nil, err)
+
+	l := newConnListener(listener, zap.NewNop(), func(conn net.Conn) error {
+		return handler.serve(conn, role)
+	})
+	go l.run()
+	t.Cleanup(l.shutdown)
+
+	return listener.Addr().String()
+}
+
+func TestWarmUp_From_Peer(t *testing.T) {
+	donor := newNamespacesForSnapshotTest()
+	for i := 0; i < 200; i++ {
+		key := []byte("key" + strconv.Itoa(i))
+		donor.cacheOf(key).Put(key, []byte("value"+strconv.Itoa(i)))
+	}
+	donor.cacheOf([]byte("users:01")).Put([]byte("users:01"), []byte("user01"))
+
+	req := warmUpRequest{
+		node: "node-b",
+		ring: HashRing{Nodes: []string{"node-a", "node-b"}, VirtualNodes: 64},
+	}
+	ring := newHashRing(req.ring)
+
+	// tagged and stale values are not streamed
+	var excluded [][]byte
+	for i := 0; len(excluded) < 2; i++ {
+		key := []byte("excluded" + strconv.Itoa(i))
+		if ring.owner(key) == "node-b" {
+			excluded = append(excluded, key)
+		}
+	}
+	donor.cacheOf(excluded[0]).Put(excluded[0], []byte("stale"))
+	donor.cacheOf(excluded[0]).MarkStale(excluded[0])
+	result := donor.cacheOf(excluded[1]).Get(excluded[1], nil)
+	donor.cacheOf(excluded[1]).SetWithTags(excluded[1], result.LeaseID, []byte("tagged"), [][]byte{[]byte("tag01")})
+
+	addr := runWarmUpListenerForTest(t, &warmUpHandler{
+		namespaces: donor, logger: zap.NewNop(),
+	}, AccessRoleReadOnly)
+
+	joining := newNamespacesForSnapshotTest()
+	items, err := warmUpFrom(addr, req, joining)
+	assert.Equal(t, nil, err)
+
+	expected := uint64(0)
+	data := make([]byte, 100)
+	for i := 0; i < 200; i++ {
+		key := []byte("key" + strconv.Itoa(i))
+		size, ok := joining.cacheOf(key).Lookup(key, data)
+		owned := ring.owner(key) == "node-b"
+		assert.Equal(t, owned, ok, string(key))
+		if owned {
+			expected++
+			assert.Equal(t, "value"+strconv.Itoa(i), string(data[:size]))
+		}
+	}
+	if ring.owner([]byte("users:01")) == "node-b" {
+		expected++
+		_, ok := joining.cacheOf([]byte("users:01")).Lookup([]byte("users:01"), data)
+		assert.Equal(t, true, ok)
+	}
+	for _, key := range excluded {
+		_, ok := joining.cacheOf(key).Lookup(key, data)
+		assert.Equal(t, false, ok, string(key))
+	}
+	assert.True(t, expected > 50)
+	assert.Equal(t, expected, items)
+}
+
+func TestWarmUp_Permission_Denied(t *testing.T) {
+	donor := newNamespacesForSnapshotTest()
+	donor.cacheOf([]byte("key01")).Put([]byte("key01"), []byte("value01"))
+
+	addr := runWarmUpListenerForTest(t, &warmUpHandler{
+		namespaces: donor, logger: zap.NewNop(),
+	}, AccessRoleNone)
+
+	joining := newNamespacesForSnapshotTest()
+	_, err := warmUpFrom(addr, warmUpRequest{
+		node: "node-a",
+		ring: HashRing{Nodes: []string{"node-a"}, VirtualNodes: 1},
+	}, joining)
+	assert.Equal(t, errInvalidSnapshot, err)
+}
+
+type fakeConnForThrottleTest struct {
+	net.Conn
+	written int
+}
+
+func (*fakeConnForThrottleTest) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *fakeConnForThrottleTest) Write(data []byte) (int, error) {
+	c.written += len(data)
+	return len(data), nil
+}
+
+func TestThrottledConn_Write(t *testing.T) {
+	conn := &fakeConnForThrottleTest{}
+	begin := time.Now()
+	c := &throttledConn{conn: conn, bytesPerSecond: 100000, startedAt: begin}
+
+	for i := 0; i < 5; i++ {
+		n, err := c.Write(make([]byte, 1000))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 1000, n)
+	}
+
+	assert.Equal(t, 5000, conn.written)
+	assert.True(t, time.Since(begin) >= 50*time.Millisecond)
+}